@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+)
+
+type RejectBody struct {
+	Reason string `form:"reason" json:"reason" binding:"required,max=500"`
+}
+
+// liste des enseignants en attente de validation
+// filtres optionnels: status (pending par défaut) et subject (nom de la matière)
+func (s *StoreRequest) ListWaitingTeachers(ctx *gin.Context) {
+	status := ctx.DefaultQuery("status", models.TEACHER_STATUS_PENDING)
+	if !slices.Contains([]string{models.TEACHER_STATUS_PENDING, models.TEACHER_STATUS_APPROVED, models.TEACHER_STATUS_REJECTED}, status) {
+		httpErr := utils.HttpErrors{Status: http.StatusBadRequest, Message: "état de demande invalide"}
+		ctx.Error(&httpErr)
+		return
+	}
+
+	teachers, err := s.Store.ListWaitingTeachers(ctx.Request.Context(), status, ctx.Query("subject"))
+	if err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		ctx.Error(&httpErr)
+		return
+	}
+
+	data := make([]gin.H, 0, len(teachers))
+	for _, teacher := range teachers {
+		data = append(data, gin.H{
+			"id":            teacher.ID,
+			"name":          teacher.UserName,
+			"email":         teacher.Email,
+			"subject":       teacher.SubjectName,
+			"status":        teacher.Status,
+			"reason":        teacher.Reason,
+			"decided_at":    teacher.DecidedAt,
+			"decided_by_id": teacher.DecidedByID,
+			"created_at":    teacher.CreatedAt,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "liste des enseignants en attente",
+		"success": true,
+		"data":    data,
+	})
+}
+
+// validation d'un enseignant par l'administrateur du jeton
+func (s *StoreRequest) ApproveTeacher(ctx *gin.Context) {
+	adminID, ok := s.claimsUserID(ctx)
+	if !ok {
+		return
+	}
+
+	teacherID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusBadRequest, Message: "identifiant d'enseignant invalide"}
+		ctx.Error(&httpErr)
+		return
+	}
+
+	teacher, user, err := s.Store.ApproveTeacher(ctx.Request.Context(), teacherID, adminID)
+	if err != nil {
+		if errors.Is(err, fosite.ErrNotFound) {
+			httpErr := utils.HttpErrors{Status: http.StatusNotFound, Message: "enseignant en attente introuvable"}
+			ctx.Error(&httpErr)
+			return
+		}
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		ctx.Error(&httpErr)
+		return
+	}
+
+	go notifyTeacher(teacher, true)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "enseignant validé",
+		"success": true,
+		"id_user": user.ID.String(),
+	})
+}
+
+// refus d'un enseignant avec le motif de l'administrateur du jeton
+func (s *StoreRequest) RejectTeacher(ctx *gin.Context) {
+	adminID, ok := s.claimsUserID(ctx)
+	if !ok {
+		return
+	}
+
+	teacherID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusBadRequest, Message: "identifiant d'enseignant invalide"}
+		ctx.Error(&httpErr)
+		return
+	}
+
+	var body RejectBody
+	if err := ctx.ShouldBind(&body); err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusBadRequest, Message: err.Error()}
+		ctx.Error(&httpErr)
+		return
+	}
+
+	teacher, err := s.Store.RejectTeacher(ctx.Request.Context(), teacherID, adminID, body.Reason)
+	if err != nil {
+		if errors.Is(err, fosite.ErrNotFound) {
+			httpErr := utils.HttpErrors{Status: http.StatusNotFound, Message: "enseignant en attente introuvable"}
+			ctx.Error(&httpErr)
+			return
+		}
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		ctx.Error(&httpErr)
+		return
+	}
+
+	go notifyTeacher(teacher, false)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "enseignant refusé",
+		"success": true,
+	})
+}
+
+// envoi de la décision à l'enseignant sans bloquer la réponse
+func notifyTeacher(teacher *models.TeacherWaiting, approved bool) {
+	if err := utils.SendTeacherDecision(teacher.Email, teacher.UserName, teacher.SubjectName, approved, teacher.Reason); err != nil {
+		log.Printf("warning: failed to send teacher decision to %s: %v", teacher.Email, err)
+	}
+}
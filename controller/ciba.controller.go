@@ -0,0 +1,220 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/db/service"
+	"github.com/dylEasydev/go-oauth2-easyclass/provider"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/ory/fosite"
+)
+
+// back-channel authentication end-point (CIBA)
+// le client obtient un auth_req_id, l'utilisateur reçoit le lien d'approbation
+func (a *Auth) BackchannelAuthHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	request, user, err := a.provider.NewBackchannelAuthRequest(ctx, c.Request)
+	if err != nil {
+		a.provider.WriteAccessError(ctx, c.Writer, nil, err)
+		return
+	}
+
+	response, err := a.provider.CIBA.NewBackchannelAuthResponse(ctx, request, user, a.provider.CIBAApprovalURI)
+	if err != nil {
+		a.provider.WriteAccessError(ctx, c.Writer, nil, err)
+		return
+	}
+
+	a.provider.WriteBackchannelAuthResponse(ctx, c.Writer, response)
+}
+
+// page d'approbation ouverte depuis le lien envoyé à l'utilisateur
+func (a *Auth) BackchannelApprovalHandler(c *gin.Context) {
+	if c.Request.Method == http.MethodPost {
+		a.backchannelApprovalSubmit(c)
+		return
+	}
+
+	ctx := c.Request.Context()
+	lang := utils.PreferredLanguage(c.Query("ui_locales"), c.GetHeader("Accept-Language"))
+
+	ticket := c.Query("ticket")
+	auth, err := a.store.GetPendingBackchannelAuth(ctx, utils.GenerateHash(ticket))
+	if err != nil {
+		if !errors.Is(err, fosite.ErrNotFound) {
+			httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+			c.Error(&httpErr)
+			return
+		}
+		a.writePage(c, http.StatusBadRequest, "ciba.html", lang, nil, authPage{Error: "invalid_ciba_request"})
+		return
+	}
+
+	csrfToken, err := utils.GenerateToken(32)
+	if err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		c.Error(&httpErr)
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(CSRFCookie, csrfToken, int(provider.CIBALifespan.Seconds()), "/", "", true, true)
+
+	page := authPage{CSRFToken: csrfToken, Ticket: ticket, BindingMessage: auth.BindingMessage}
+	if login := a.currentLoginSession(c); login != nil {
+		userService := service.InitUserService(&ctx, a.store.GetDb())
+		if user, err := userService.FindUserById(login.UserID); err == nil {
+			page.UserName = user.UserName
+		}
+	}
+
+	a.writePage(c, http.StatusOK, "ciba.html", lang, &auth.Client.InfoClient, page)
+}
+
+// soumission de la page d'approbation
+// authentifie l'utilisateur désigné par le login_hint, affiche les scopes
+// demandés puis enregistre l'approbation ou le refus et notifie le client en mode ping
+func (a *Auth) backchannelApprovalSubmit(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	lang := utils.PreferredLanguage(c.PostForm("lang"), c.GetHeader("Accept-Language"))
+	page := authPage{CSRFToken: c.PostForm("csrf_token"), Ticket: c.PostForm("ticket")}
+
+	cookie, err := c.Cookie(CSRFCookie)
+	if err != nil || page.CSRFToken == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(page.CSRFToken)) != 1 {
+		a.writePage(c, http.StatusForbidden, "ciba.html", lang, nil, authPage{Error: "expired"})
+		return
+	}
+
+	auth, err := a.store.GetPendingBackchannelAuth(ctx, utils.GenerateHash(page.Ticket))
+	if err != nil {
+		if !errors.Is(err, fosite.ErrNotFound) {
+			httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+			c.Error(&httpErr)
+			return
+		}
+		a.writePage(c, http.StatusBadRequest, "ciba.html", lang, nil, authPage{Error: "invalid_ciba_request"})
+		return
+	}
+	info := &auth.Client.InfoClient
+	page.BindingMessage = auth.BindingMessage
+
+	//session de connexion du navigateur ou connexion depuis la page
+	var user *models.User
+	login := a.currentLoginSession(c)
+	if login != nil {
+		userService := service.InitUserService(&ctx, a.store.GetDb())
+		if user, err = userService.FindUserById(login.UserID); err != nil {
+			login = nil
+		}
+	}
+	if login == nil {
+		name, password := c.PostForm("name"), c.PostForm("password")
+		if name == "" || password == "" {
+			page.Error = "invalid_form"
+			a.writePage(c, http.StatusBadRequest, "ciba.html", lang, info, page)
+			return
+		}
+		if _, err := a.store.Authenticate(ctx, name, password); err != nil {
+			status, message := authenticationError(err)
+			page.Error = message
+			a.writePage(c, status, "ciba.html", lang, info, page)
+			return
+		}
+		if user, err = a.store.GetUser(ctx, name); err != nil {
+			httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+			c.Error(&httpErr)
+			return
+		}
+		if login, err = a.startLoginSession(c, user); err != nil {
+			httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+			c.Error(&httpErr)
+			return
+		}
+	}
+	page.UserName = user.UserName
+
+	//seul l'utilisateur désigné par le client peut répondre à la demande
+	if user.ID != auth.UserID {
+		page.Error = "ciba_wrong_user"
+		page.CSRFToken = ""
+		a.writePage(c, http.StatusForbidden, "ciba.html", lang, info, page)
+		return
+	}
+	scopes := utils.IntersectScopes(auth.RequestedScopes, roleScopes(user))
+
+	switch c.PostForm("consent") {
+	case "":
+		//confirmation du message et des scopes avant approbation
+		if page.Scopes, err = a.pageScopes(ctx, lang, scopes); err != nil {
+			httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+			c.Error(&httpErr)
+			return
+		}
+		page.Confirm = true
+		a.writePage(c, http.StatusOK, "ciba.html", lang, info, page)
+		return
+
+	case "approve":
+		//seuls les scopes présentés peuvent être approuvés, openid est toujours accordé
+		granted := []string{"openid"}
+		for _, scope := range c.PostFormArray("scopes") {
+			if slices.Contains(scopes, scope) && !slices.Contains(granted, scope) {
+				granted = append(granted, scope)
+			}
+		}
+
+		subject, err := a.store.SubjectFor(ctx, &auth.Client, user.ID, user.UserName)
+		if err != nil {
+			httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+			c.Error(&httpErr)
+			return
+		}
+
+		extra := map[string]any{
+			"scopes":  granted,
+			"user_id": user.ID,
+		}
+		session, err := models.NewSession(ctx, auth.ClientID.String(), user.ID.String(), user.UserName, subject, extra)
+		if err != nil {
+			httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+			c.Error(&httpErr)
+			return
+		}
+		session.SetClient(&auth.Client)
+		session.SetLoginSession(login, auth.RequestedAt)
+
+		if err := a.store.ApproveBackchannelAuth(ctx, auth.ID, session, granted); err != nil {
+			if errors.Is(err, fosite.ErrNotFound) {
+				a.writePage(c, http.StatusBadRequest, "ciba.html", lang, info, authPage{Error: "invalid_ciba_request"})
+				return
+			}
+			httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+			c.Error(&httpErr)
+			return
+		}
+		page.Message = "ciba_approved"
+
+	default:
+		if err := a.store.DenyBackchannelAuth(ctx, auth.ID); err != nil {
+			if errors.Is(err, fosite.ErrNotFound) {
+				a.writePage(c, http.StatusBadRequest, "ciba.html", lang, info, authPage{Error: "invalid_ciba_request"})
+				return
+			}
+			httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+			c.Error(&httpErr)
+			return
+		}
+		page.Message = "ciba_denied"
+	}
+
+	//notification du client en mode ping sans bloquer la réponse
+	a.provider.CIBA.NotifyClient(auth)
+
+	a.writePage(c, http.StatusOK, "ciba.html", lang, info, page)
+}
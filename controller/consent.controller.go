@@ -0,0 +1,298 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/db/service"
+	"github.com/dylEasydev/go-oauth2-easyclass/provider"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+)
+
+// étape de consentement de l'utilisateur authentifié
+// les scopes sont accordés sans page si le client est interne ou si
+// l'utilisateur a déjà approuvé le même ensemble de scopes pour ce client
+// challenge et csrfToken désignent la demande en attente (vides après une connexion SSO)
+func (a *Auth) authorize(c *gin.Context, authorizeRequest fosite.AuthorizeRequester, login *models.LoginSession, user *models.User, challenge string, csrfToken string) {
+	ctx := c.Request.Context()
+
+	scopes := utils.IntersectScopes(authorizeRequest.GetRequestedScopes(), roleScopes(user))
+
+	prompt := fosite.Arguments(fosite.RemoveEmpty(strings.Split(authorizeRequest.GetRequestForm().Get("prompt"), " ")))
+	client, ok := authorizeRequest.GetClient().(*models.Client)
+	if !ok {
+		a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, fosite.ErrServerError.WithHint("client invalide"))
+		return
+	}
+	if !client.IsFirstParty() || prompt.Has("consent") {
+		consent, err := a.store.GetConsent(ctx, user.ID, client.ID, utils.ScopeSet(scopes))
+		if err != nil && !errors.Is(err, fosite.ErrNotFound) {
+			a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, fosite.ErrServerError.WithWrap(err))
+			return
+		}
+
+		//les authorization_details ne sont pas couverts par un consentement mémorisé
+		if consent == nil || prompt.Has("consent") || authorizeRequest.GetRequestForm().Get("authorization_details") != "" {
+			if prompt.Has("none") {
+				a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, fosite.ErrConsentRequired.WithHint("le consentement de l'utilisateur est requis"))
+				return
+			}
+			a.renderConsentPage(c, authorizeRequest, login, user, scopes, challenge, csrfToken)
+			return
+		}
+		scopes = consent.GrantedScopes
+	}
+
+	//la demande en attente ne peut être terminée qu'une fois
+	if challenge != "" {
+		if err := a.store.UseLoginRequest(ctx, challenge); err != nil {
+			if errors.Is(err, fosite.ErrNotFound) {
+				lang := utils.PreferredLanguage(authorizeRequest.GetRequestForm().Get("ui_locales"), c.GetHeader("Accept-Language"))
+				a.writePage(c, http.StatusBadRequest, "login.html", lang, nil, authPage{Error: "expired"})
+				return
+			}
+			a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, fosite.ErrServerError.WithWrap(err))
+			return
+		}
+	}
+
+	a.grant(c, authorizeRequest, login, user, scopes)
+}
+
+// affichage de la page de consentement avec la description des scopes demandés
+func (a *Auth) renderConsentPage(c *gin.Context, authorizeRequest fosite.AuthorizeRequester, login *models.LoginSession, user *models.User, scopes []string, challenge string, csrfToken string) {
+	ctx := c.Request.Context()
+
+	var err error
+	if challenge == "" {
+		if challenge, csrfToken, err = a.newLoginRequest(c, authorizeRequest); err != nil {
+			a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, fosite.ErrServerError.WithWrap(err))
+			return
+		}
+	}
+	if err := a.store.SetLoginRequestConsent(ctx, challenge, user.ID, login.ID, scopes); err != nil {
+		a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+
+	info, err := a.store.GetClientInfo(ctx, authorizeRequest.GetClient().GetID())
+	if err != nil {
+		a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+
+	lang := utils.PreferredLanguage(authorizeRequest.GetRequestForm().Get("ui_locales"), c.GetHeader("Accept-Language"))
+	pageScopes, err := a.pageScopes(ctx, lang, scopes)
+	if err != nil {
+		a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+
+	details, err := provider.ParseAuthorizationDetails(authorizeRequest.GetClient(), authorizeRequest.GetRequestForm())
+	if err != nil {
+		a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, err)
+		return
+	}
+	pageDetails, err := pageAuthorizationDetails(lang, details)
+	if err != nil {
+		a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+
+	page := authPage{Challenge: challenge, CSRFToken: csrfToken, UserName: user.UserName, Scopes: pageScopes, Details: pageDetails}
+	a.writePage(c, http.StatusOK, "consent.html", lang, info, page)
+}
+
+// description des scopes affichés sur les pages de consentement
+// (description du scope en base, sinon texte de la langue, sinon son nom)
+func (a *Auth) pageScopes(ctx context.Context, lang string, scopes []string) ([]pageScope, error) {
+	texts, err := utils.ReadLocale(lang)
+	if err != nil {
+		return nil, err
+	}
+
+	descriptions, err := a.store.GetScopesByName(ctx, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []pageScope{}
+	for _, name := range fosite.RemoveEmpty(scopes) {
+		scope := pageScope{Name: name, Description: name}
+		if text, ok := texts["scope_"+name]; ok {
+			scope.Description = text
+		}
+		for _, description := range descriptions {
+			if description.ScopeName == name && description.ScopeDescript != "" {
+				scope.Description = description.ScopeDescript
+			}
+		}
+		result = append(result, scope)
+	}
+	return result, nil
+}
+
+// description des authorization_details affichés sur la page de consentement
+// (libellé du type dans la langue, ressource puis actions demandées)
+func pageAuthorizationDetails(lang string, details models.AuthorizationDetails) ([]string, error) {
+	texts, err := utils.ReadLocale(lang)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+	for _, detail := range details {
+		label := detail.Type
+		if text, ok := texts["detail_"+detail.Type]; ok {
+			label = text
+		}
+		if resource := detail.CourseID + detail.Identifier; resource != "" {
+			label += " " + resource
+		}
+
+		actions := []string{}
+		for _, action := range detail.Actions {
+			if text, ok := texts["action_"+action]; ok {
+				action = text
+			}
+			actions = append(actions, action)
+		}
+		if len(actions) > 0 {
+			label += " : " + strings.Join(actions, ", ")
+		}
+		result = append(result, label)
+	}
+	return result, nil
+}
+
+// soumission de la page de consentement
+// enregistre la décision de l'utilisateur puis termine la demande d'autorisation
+func (a *Auth) ConsentHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	lang := utils.PreferredLanguage(c.PostForm("lang"), c.GetHeader("Accept-Language"))
+	request, authorizeRequest, ok := a.pendingLoginRequest(c, "consent.html", lang)
+	if !ok {
+		return
+	}
+
+	//le consentement est donné depuis la session de connexion qui s'est authentifiée
+	login := a.currentLoginSession(c)
+	if login == nil || request.UserID == nil || request.LoginSessionID == nil || *request.LoginSessionID != login.ID {
+		a.writePage(c, http.StatusForbidden, "consent.html", lang, &request.Client.InfoClient, authPage{Error: "expired"})
+		return
+	}
+
+	if err := a.store.UseLoginRequest(ctx, request.Challenge); err != nil {
+		if errors.Is(err, fosite.ErrNotFound) {
+			a.writePage(c, http.StatusBadRequest, "consent.html", lang, &request.Client.InfoClient, authPage{Error: "expired"})
+			return
+		}
+		a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+
+	if c.PostForm("consent") != "approve" {
+		a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, fosite.ErrAccessDenied.WithHint("l'utilisateur a refusé l'accès"))
+		return
+	}
+
+	//seuls les scopes présentés peuvent être approuvés
+	scopes := []string{}
+	for _, scope := range c.PostFormArray("scopes") {
+		if slices.Contains(request.ConsentScopes, scope) && !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	consent := &models.Consent{
+		ScopeSet:      utils.ScopeSet(request.ConsentScopes),
+		GrantedScopes: scopes,
+		UserID:        *request.UserID,
+		ClientID:      request.ClientID,
+	}
+	if err := a.store.SaveConsent(ctx, consent); err != nil {
+		a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+
+	userService := service.InitUserService(&ctx, a.store.GetDb())
+	user, err := userService.FindUserById(*request.UserID)
+	if err != nil {
+		a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, fosite.ErrLoginRequired.WithHint("utilisateur introuvable"))
+		return
+	}
+
+	a.grant(c, authorizeRequest, login, user, scopes)
+}
+
+// liste des consentements de l'utilisateur du jeton
+func (s *StoreRequest) ListConsents(ctx *gin.Context) {
+	userID, ok := s.claimsUserID(ctx)
+	if !ok {
+		return
+	}
+
+	consents, err := s.Store.ListConsents(ctx.Request.Context(), userID)
+	if err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		ctx.Error(&httpErr)
+		return
+	}
+
+	data := make([]gin.H, 0, len(consents))
+	for _, consent := range consents {
+		data = append(data, gin.H{
+			"id":         consent.ID,
+			"client_id":  consent.ClientID,
+			"client":     consent.Client.InfoClient.NameOrganization,
+			"scopes":     consent.GrantedScopes,
+			"created_at": consent.CreatedAt,
+			"updated_at": consent.UpdatedAt,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "liste des consentements",
+		"success": true,
+		"data":    data,
+	})
+}
+
+// révocation d'un consentement de l'utilisateur du jeton
+func (s *StoreRequest) RevokeConsent(ctx *gin.Context) {
+	userID, ok := s.claimsUserID(ctx)
+	if !ok {
+		return
+	}
+
+	consentID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusBadRequest, Message: "identifiant de consentement invalide"}
+		ctx.Error(&httpErr)
+		return
+	}
+
+	if err := s.Store.RevokeConsent(ctx.Request.Context(), userID, consentID); err != nil {
+		if errors.Is(err, fosite.ErrNotFound) {
+			httpErr := utils.HttpErrors{Status: http.StatusNotFound, Message: "consentement introuvable"}
+			ctx.Error(&httpErr)
+			return
+		}
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		ctx.Error(&httpErr)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "consentement révoqué",
+		"success": true,
+	})
+}
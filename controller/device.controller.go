@@ -0,0 +1,214 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/db/service"
+	"github.com/dylEasydev/go-oauth2-easyclass/provider"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/ory/fosite"
+)
+
+// device authorization end-point (RFC 8628)
+// l'appareil obtient un device_code et le user_code à afficher
+func (a *Auth) DeviceCodeHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	request, err := a.provider.NewDeviceRequest(ctx, c.Request)
+	if err != nil {
+		a.provider.WriteAccessError(ctx, c.Writer, nil, err)
+		return
+	}
+
+	response, err := a.provider.Device.NewDeviceResponse(ctx, request, a.provider.DeviceVerificationURI)
+	if err != nil {
+		a.provider.WriteAccessError(ctx, c.Writer, nil, err)
+		return
+	}
+
+	a.provider.WriteDeviceResponse(ctx, c.Writer, response)
+}
+
+// page de vérification où l'utilisateur saisit le user_code affiché par l'appareil
+func (a *Auth) DeviceVerificationHandler(c *gin.Context) {
+	if c.Request.Method == http.MethodPost {
+		a.deviceVerificationSubmit(c)
+		return
+	}
+
+	lang := utils.PreferredLanguage(c.Query("ui_locales"), c.GetHeader("Accept-Language"))
+
+	csrfToken, err := utils.GenerateToken(32)
+	if err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		c.Error(&httpErr)
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(CSRFCookie, csrfToken, int(provider.DeviceCodeLifespan.Seconds()), "/", "", true, true)
+
+	page := authPage{CSRFToken: csrfToken, UserCode: c.Query("user_code")}
+	if login := a.currentLoginSession(c); login != nil {
+		ctx := c.Request.Context()
+		userService := service.InitUserService(&ctx, a.store.GetDb())
+		if user, err := userService.FindUserById(login.UserID); err == nil {
+			page.UserName = user.UserName
+		}
+	}
+
+	a.writePage(c, http.StatusOK, "device.html", lang, nil, page)
+}
+
+// soumission de la page de vérification
+// authentifie l'utilisateur si besoin, affiche les scopes demandés puis
+// enregistre l'approbation ou le refus de l'appareil
+func (a *Auth) deviceVerificationSubmit(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	lang := utils.PreferredLanguage(c.PostForm("lang"), c.GetHeader("Accept-Language"))
+	page := authPage{CSRFToken: c.PostForm("csrf_token"), UserCode: c.PostForm("user_code")}
+
+	cookie, err := c.Cookie(CSRFCookie)
+	if err != nil || page.CSRFToken == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(page.CSRFToken)) != 1 {
+		a.writePage(c, http.StatusForbidden, "device.html", lang, nil, authPage{Error: "expired"})
+		return
+	}
+
+	//session de connexion du navigateur ou connexion depuis la page
+	var user *models.User
+	login := a.currentLoginSession(c)
+	if login != nil {
+		userService := service.InitUserService(&ctx, a.store.GetDb())
+		if user, err = userService.FindUserById(login.UserID); err != nil {
+			login = nil
+		}
+	}
+	if login == nil {
+		name, password := c.PostForm("name"), c.PostForm("password")
+		if name == "" || password == "" {
+			page.Error = "invalid_form"
+			a.writePage(c, http.StatusBadRequest, "device.html", lang, nil, page)
+			return
+		}
+		if _, err := a.store.Authenticate(ctx, name, password); err != nil {
+			status, message := authenticationError(err)
+			page.Error = message
+			a.writePage(c, status, "device.html", lang, nil, page)
+			return
+		}
+		if user, err = a.store.GetUser(ctx, name); err != nil {
+			httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+			c.Error(&httpErr)
+			return
+		}
+		if login, err = a.startLoginSession(c, user); err != nil {
+			httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+			c.Error(&httpErr)
+			return
+		}
+	}
+	page.UserName = user.UserName
+
+	//les essais de user_code sont limités par adresse et par navigateur (RFC 8628 section 5.1)
+	identifiers := []string{"ip:" + c.ClientIP(), "login:" + login.ID.String()}
+	blocked, err := a.store.UserCodeGuessesBlocked(ctx, identifiers)
+	if err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		c.Error(&httpErr)
+		return
+	}
+	if blocked {
+		page.Error = "too_many_user_codes"
+		a.writePage(c, http.StatusTooManyRequests, "device.html", lang, nil, page)
+		return
+	}
+
+	device, err := a.store.GetPendingDeviceCode(ctx, provider.NormalizeUserCode(page.UserCode))
+	if err != nil {
+		if !errors.Is(err, fosite.ErrNotFound) {
+			httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+			c.Error(&httpErr)
+			return
+		}
+		if err := a.store.RecordUserCodeFailure(ctx, identifiers); err != nil {
+			httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+			c.Error(&httpErr)
+			return
+		}
+		page.Error = "invalid_user_code"
+		a.writePage(c, http.StatusBadRequest, "device.html", lang, nil, page)
+		return
+	}
+	info := &device.Client.InfoClient
+	scopes := utils.IntersectScopes(device.RequestedScopes, roleScopes(user))
+
+	switch c.PostForm("consent") {
+	case "":
+		//confirmation du code et des scopes avant approbation
+		if page.Scopes, err = a.pageScopes(ctx, lang, scopes); err != nil {
+			httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+			c.Error(&httpErr)
+			return
+		}
+		page.UserCode = provider.FormatUserCode(device.UserCode)
+		page.Confirm = true
+		a.writePage(c, http.StatusOK, "device.html", lang, info, page)
+		return
+
+	case "approve":
+		//seuls les scopes présentés peuvent être approuvés
+		granted := []string{}
+		for _, scope := range c.PostFormArray("scopes") {
+			if slices.Contains(scopes, scope) && !slices.Contains(granted, scope) {
+				granted = append(granted, scope)
+			}
+		}
+
+		subject, err := a.store.SubjectFor(ctx, &device.Client, user.ID, user.UserName)
+		if err != nil {
+			httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+			c.Error(&httpErr)
+			return
+		}
+
+		extra := map[string]any{
+			"scopes":  granted,
+			"user_id": user.ID,
+		}
+		session, err := models.NewSession(ctx, device.ClientID.String(), user.ID.String(), user.UserName, subject, extra)
+		if err != nil {
+			httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+			c.Error(&httpErr)
+			return
+		}
+		session.SetClient(&device.Client)
+		session.SetLoginSession(login, device.RequestedAt)
+
+		if err := a.store.ApproveDeviceCode(ctx, device.ID, session, granted); err != nil {
+			if errors.Is(err, fosite.ErrNotFound) {
+				page.Error = "invalid_user_code"
+				a.writePage(c, http.StatusBadRequest, "device.html", lang, nil, page)
+				return
+			}
+			httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+			c.Error(&httpErr)
+			return
+		}
+		page.Message = "device_approved"
+
+	default:
+		if err := a.store.DenyDeviceCode(ctx, device.ID); err != nil {
+			httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+			c.Error(&httpErr)
+			return
+		}
+		page.Message = "device_denied"
+	}
+
+	a.writePage(c, http.StatusOK, "device.html", lang, info, page)
+}
@@ -0,0 +1,42 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/provider"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/gin-gonic/gin"
+)
+
+type Discovery struct {
+	provider  *provider.Provider
+	endpoints map[string]string
+}
+
+func NewDiscovery(provider *provider.Provider, endpoints map[string]string) *Discovery {
+	return &Discovery{
+		provider:  provider,
+		endpoints: endpoints,
+	}
+}
+
+// document de découverte OpenID Connect
+func (d *Discovery) OpenIDConfigurationHandler(c *gin.Context) {
+	d.writeMetadata(c, true)
+}
+
+// document de métadonnées du serveur d'autorisation (RFC 8414)
+func (d *Discovery) AuthorizationServerHandler(c *gin.Context) {
+	d.writeMetadata(c, false)
+}
+
+func (d *Discovery) writeMetadata(c *gin.Context, openID bool) {
+	metadata, err := d.provider.Metadata(c.Request.Context(), d.endpoints, openID)
+	if err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		c.Error(&httpErr)
+		return
+	}
+
+	c.JSON(http.StatusOK, metadata)
+}
@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db"
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/db/service"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+	"gorm.io/gorm"
+)
+
+// chemin du lien de téléchargement des exports
+const ExportDownloadPath = "/exports/download"
+
+// export des données personnelles de l'utilisateur du jeton
+// l'archive est générée en arrière-plan et le lien envoyé par mail
+func (s *StoreRequest) RequestDataExport(ctx *gin.Context) {
+	userID, ok := s.claimsUserID(ctx)
+	if !ok {
+		return
+	}
+	s.startDataExport(ctx, userID, nil)
+}
+
+// export des données d'un utilisateur demandé par un administrateur
+// la demande est journalisée et le lien envoyé à l'utilisateur
+func (s *StoreRequest) AdminDataExport(ctx *gin.Context) {
+	adminID, ok := s.claimsUserID(ctx)
+	if !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusBadRequest, Message: "identifiant d'utilisateur invalide"}
+		ctx.Error(&httpErr)
+		return
+	}
+	s.startDataExport(ctx, userID, &adminID)
+}
+
+// création de l'export et lancement de sa génération
+// les comptes supprimés encore dans leur délai de grâce peuvent être exportés
+func (s *StoreRequest) startDataExport(ctx *gin.Context, userID uuid.UUID, requestedBy *uuid.UUID) {
+	context := ctx.Request.Context()
+
+	user, err := service.FindUserById[models.User](&context, s.Store.GetDb().Unscoped(), userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			httpErr := utils.HttpErrors{Status: http.StatusNotFound, Message: "utilisateur introuvable"}
+			ctx.Error(&httpErr)
+			return
+		}
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		ctx.Error(&httpErr)
+		return
+	}
+
+	token, export, err := s.Store.CreateDataExport(context, user.ID, requestedBy)
+	if err != nil {
+		if errors.Is(err, db.ErrExportThrottled) {
+			httpErr := utils.HttpErrors{Status: http.StatusTooManyRequests, Message: "un export est déjà en cours ou disponible, utilisez le lien reçu par mail"}
+			ctx.Error(&httpErr)
+			return
+		}
+		if errors.Is(err, fosite.ErrNotFound) {
+			httpErr := utils.HttpErrors{Status: http.StatusNotFound, Message: "utilisateur introuvable"}
+			ctx.Error(&httpErr)
+			return
+		}
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		ctx.Error(&httpErr)
+		return
+	}
+
+	go s.runDataExport(export, user.Email, user.UserName, token)
+
+	ctx.JSON(http.StatusAccepted, gin.H{
+		"message":    fmt.Sprintf("export en cours, le lien sera envoyé à %s", user.Email),
+		"success":    true,
+		"id":         export.ID,
+		"expires_at": export.ExpiresAt,
+	})
+}
+
+// génération de l'archive puis envoi du lien à l'utilisateur
+func (s *StoreRequest) runDataExport(export *models.DataExport, email string, name string, token string) {
+	if err := s.Store.RunDataExport(context.Background(), export.ID, export.UserID); err != nil {
+		log.Printf("warning: export des données de %s impossible: %v", export.UserID, err)
+		return
+	}
+
+	link := utils.URL_Host + ExportDownloadPath + "?token=" + url.QueryEscape(token)
+	if err := utils.SendDataExport(email, name, link, export.ExpiresAt); err != nil {
+		log.Printf("warning: failed to send data export link to %s: %v", email, err)
+	}
+}
+
+// téléchargement de l'archive avec le jeton du lien
+func (s *StoreRequest) DownloadDataExport(ctx *gin.Context) {
+	export, err := s.Store.GetDataExport(ctx.Request.Context(), ctx.Query("token"))
+	if err != nil {
+		if errors.Is(err, fosite.ErrNotFound) {
+			httpErr := utils.HttpErrors{Status: http.StatusNotFound, Message: "lien d'export invalide ou expiré"}
+			ctx.Error(&httpErr)
+			return
+		}
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		ctx.Error(&httpErr)
+		return
+	}
+
+	switch export.State {
+	case models.EXPORT_STATE_READY:
+		ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="export-%s.json"`, export.UserID))
+		ctx.Header("Cache-Control", "no-store")
+		ctx.Data(http.StatusOK, "application/json", export.Archive)
+	case models.EXPORT_STATE_FAILED:
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: "l'export a échoué, faites une nouvelle demande"}
+		ctx.Error(&httpErr)
+	default:
+		ctx.JSON(http.StatusAccepted, gin.H{
+			"message": "export en cours de génération",
+			"success": true,
+		})
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
 	"github.com/dylEasydev/go-oauth2-easyclass/utils"
 	"github.com/gin-gonic/gin"
-	"github.com/go-jose/go-jose/v3"
 	"github.com/ory/fosite"
 )
 
@@ -46,19 +45,16 @@ func (s *StoreRequest) ClientJWKHanler(c *gin.Context) {
 	c.JSON(http.StatusOK, clientJWK.GetJSONWebKeys())
 }
 
-func JWKHandler(c *gin.Context) {
-	publickey, err := utils.LoadPublicKey("public")
+// JWKS du serveur: toutes les clés de signature encore publiées
+// (prochaine, active et retirées non expirées)
+func (d *Discovery) JWKHandler(c *gin.Context) {
+	keys, err := d.provider.Keys.PublicKeys(c.Request.Context())
 	if err != nil {
 		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
 		c.Error(&httpErr)
 		return
 	}
 
-	jwKey := jose.JSONWebKey{
-		Key:       publickey,
-		KeyID:     "easy-class",
-		Algorithm: "RS256",
-		Use:       "sig",
-	}
-	c.JSON(http.StatusOK, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{jwKey}})
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, keys)
 }
@@ -0,0 +1,192 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db"
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+)
+
+const (
+	//durée de vie d'une demande d'autorisation en attente de connexion
+	LoginRequestLifespan = 10 * time.Minute
+	//cookie du jeton CSRF des pages de connexion et de consentement
+	CSRFCookie = "easyclass_csrf"
+)
+
+// scope affiché sur la page de consentement
+type pageScope struct {
+	Name        string
+	Description string
+}
+
+// données des pages de connexion et de consentement
+type authPage struct {
+	Lang           string
+	T              map[string]string
+	ClientName     string
+	LogoURL        string
+	Action         string
+	Challenge      string
+	CSRFToken      string
+	UserName       string
+	Scopes         []pageScope
+	Details        []string
+	UserCode       string
+	Ticket         string
+	BindingMessage string
+	Confirm        bool
+	ClientID       string
+	RedirectURI    string
+	State          string
+	Message        string
+	Error          string
+}
+
+// enregistrement de la demande d'autorisation en attente et du jeton CSRF du navigateur
+func (a *Auth) newLoginRequest(c *gin.Context, authorizeRequest fosite.AuthorizeRequester) (string, string, error) {
+	challenge := uuid.NewString()
+	csrfToken, err := utils.GenerateToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := a.store.CreateLoginRequest(c.Request.Context(), challenge, csrfToken, LoginRequestLifespan, authorizeRequest); err != nil {
+		return "", "", err
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(CSRFCookie, csrfToken, int(LoginRequestLifespan.Seconds()), "/", "", true, true)
+	return challenge, csrfToken, nil
+}
+
+// affichage de la page de connexion pour une nouvelle demande d'autorisation
+func (a *Auth) renderLoginPage(c *gin.Context, authorizeRequest fosite.AuthorizeRequester) {
+	ctx := c.Request.Context()
+
+	challenge, csrfToken, err := a.newLoginRequest(c, authorizeRequest)
+	if err != nil {
+		a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+
+	info, err := a.store.GetClientInfo(ctx, authorizeRequest.GetClient().GetID())
+	if err != nil {
+		a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+
+	lang := utils.PreferredLanguage(authorizeRequest.GetRequestForm().Get("ui_locales"), c.GetHeader("Accept-Language"))
+	a.writePage(c, http.StatusOK, "login.html", lang, info, authPage{Challenge: challenge, CSRFToken: csrfToken})
+}
+
+// soumission de la page de connexion
+// authentifie l'utilisateur puis poursuit la demande d'autorisation en attente
+func (a *Auth) LoginHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	lang := utils.PreferredLanguage(c.PostForm("lang"), c.GetHeader("Accept-Language"))
+	request, authorizeRequest, ok := a.pendingLoginRequest(c, "login.html", lang)
+	if !ok {
+		return
+	}
+	info := &request.Client.InfoClient
+
+	page := authPage{Challenge: request.Challenge, CSRFToken: c.PostForm("csrf_token"), UserName: c.PostForm("name")}
+
+	form := Authorize{}
+	if err := c.ShouldBindWith(&form, binding.Form); err != nil {
+		page.Error = "invalid_form"
+		a.writePage(c, http.StatusBadRequest, "login.html", lang, info, page)
+		return
+	}
+
+	if _, err := a.store.Authenticate(ctx, form.UserName, form.Password); err != nil {
+		//la demande en attente est invalidée après trop d'échecs
+		if err := a.store.FailLoginRequest(ctx, request.Challenge); err != nil {
+			a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, fosite.ErrServerError.WithWrap(err))
+			return
+		}
+		status, message := authenticationError(err)
+		page.Error = message
+		a.writePage(c, status, "login.html", lang, info, page)
+		return
+	}
+
+	user, err := a.store.GetUser(ctx, form.UserName)
+	if err != nil {
+		a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, err)
+		return
+	}
+
+	login, err := a.startLoginSession(c, user)
+	if err != nil {
+		a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+
+	a.authorize(c, authorizeRequest, login, user, request.Challenge, page.CSRFToken)
+}
+
+// statut et message des pages de connexion après un échec d'authentification
+func authenticationError(err error) (int, string) {
+	if errors.Is(err, db.ErrAccountLocked) {
+		return http.StatusTooManyRequests, "account_locked"
+	}
+	return http.StatusUnauthorized, "invalid_credentials"
+}
+
+// demande d'autorisation en attente désignée par le formulaire soumis
+// le jeton CSRF du formulaire doit correspondre au cookie et à la demande
+func (a *Auth) pendingLoginRequest(c *gin.Context, template string, lang string) (*models.LoginRequest, fosite.AuthorizeRequester, bool) {
+	request, authorizeRequest, err := a.store.GetLoginRequest(c.Request.Context(), c.PostForm("challenge"))
+	if err != nil {
+		a.writePage(c, http.StatusBadRequest, template, lang, nil, authPage{Error: "expired"})
+		return nil, nil, false
+	}
+
+	csrfToken := c.PostForm("csrf_token")
+	cookie, err := c.Cookie(CSRFCookie)
+	if err != nil || csrfToken == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(csrfToken)) != 1 || !utils.CompareHash(csrfToken, request.CSRFHash) {
+		a.writePage(c, http.StatusForbidden, template, lang, &request.Client.InfoClient, authPage{Error: "expired"})
+		return nil, nil, false
+	}
+
+	return request, authorizeRequest, true
+}
+
+// rendu d'une page dans la langue choisie et aux couleurs du client
+func (a *Auth) writePage(c *gin.Context, status int, template string, lang string, info *models.InfoClient, page authPage) {
+	texts, err := utils.ReadLocale(lang)
+	if err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		c.Error(&httpErr)
+		return
+	}
+
+	page.Lang = lang
+	page.T = texts
+	page.Action = c.Request.URL.Path
+	if page.Error != "" {
+		page.Error = texts[page.Error]
+	}
+	if page.Message != "" {
+		page.Message = texts[page.Message]
+	}
+	if info != nil {
+		page.ClientName = info.NameOrganization
+		page.LogoURL = info.Image.UrlPictures
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Frame-Options", "DENY")
+	c.HTML(status, template, page)
+}
@@ -0,0 +1,257 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/token/jwt"
+)
+
+// paramètres de la déconnexion initiée par le client (RP-Initiated Logout 1.0)
+type Logout struct {
+	IDTokenHint           string `form:"id_token_hint" json:"id_token_hint"`
+	ClientID              string `form:"client_id" json:"client_id"`
+	PostLogoutRedirectURI string `form:"post_logout_redirect_uri" json:"post_logout_redirect_uri"`
+	State                 string `form:"state" json:"state"`
+	UILocales             string `form:"ui_locales" json:"ui_locales"`
+
+	//confirmation de la déconnexion sans id_token_hint
+	Confirm   string `form:"confirm" json:"confirm"`
+	CSRFToken string `form:"csrf_token" json:"csrf_token"`
+	Lang      string `form:"lang" json:"lang"`
+}
+
+// end-point de déconnexion
+// révoque la session désignée par l'id_token_hint (ou le cookie de connexion après confirmation),
+// les sessions ouvertes depuis la même connexion et les jetons émis pour elles
+func (a *Auth) LogoutHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var form Logout
+	if err := c.ShouldBind(&form); err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusBadRequest, Message: err.Error()}
+		c.Error(&httpErr)
+		return
+	}
+
+	//session désignée par l'id_token_hint, sinon par le cookie du navigateur
+	var loginID *uuid.UUID
+	var sessionID *uuid.UUID
+	if form.IDTokenHint != "" {
+		claims, err := a.decodeIDTokenHint(c, form.IDTokenHint)
+		if err != nil {
+			httpErr := utils.HttpErrors{Status: http.StatusBadRequest, Message: "id_token_hint invalide"}
+			c.Error(&httpErr)
+			return
+		}
+
+		//le client est le destinataire de l'id_token
+		if form.ClientID == "" {
+			switch aud := claims["aud"].(type) {
+			case string:
+				form.ClientID = aud
+			case []interface{}:
+				if len(aud) > 0 {
+					form.ClientID, _ = aud[0].(string)
+				}
+			}
+		}
+		if form.ClientID == "" || !claims.VerifyAudience(form.ClientID, true) {
+			httpErr := utils.HttpErrors{Status: http.StatusBadRequest, Message: "client_id ne correspond pas à l'id_token_hint"}
+			c.Error(&httpErr)
+			return
+		}
+
+		sid, _ := claims["sid"].(string)
+		id, err := uuid.Parse(sid)
+		if err != nil {
+			httpErr := utils.HttpErrors{Status: http.StatusBadRequest, Message: "l'id_token_hint ne désigne aucune session"}
+			c.Error(&httpErr)
+			return
+		}
+		sessionID = &id
+	} else if login := a.currentLoginSession(c); login != nil {
+		//sans id_token_hint l'utilisateur confirme la déconnexion:
+		// une image ou un formulaire d'un autre site ne peut pas le déconnecter
+		if !logoutConfirmed(c, form) {
+			a.renderLogoutPage(c, form)
+			return
+		}
+		loginID = &login.ID
+	}
+
+	if sessionID == nil && loginID == nil {
+		httpErr := utils.HttpErrors{Status: http.StatusBadRequest, Message: "id_token_hint non fourni"}
+		c.Error(&httpErr)
+		return
+	}
+
+	//l'url de redirection doit être enregistrée par le client
+	if form.PostLogoutRedirectURI != "" {
+		if form.ClientID == "" {
+			httpErr := utils.HttpErrors{Status: http.StatusBadRequest, Message: "client_id requis avec post_logout_redirect_uri"}
+			c.Error(&httpErr)
+			return
+		}
+		fositeClient, err := a.store.GetClient(ctx, form.ClientID)
+		if err != nil {
+			httpErr := utils.HttpErrors{Status: http.StatusBadRequest, Message: "client inconnu"}
+			c.Error(&httpErr)
+			return
+		}
+		if !slices.Contains(fositeClient.(*models.Client).GetPostLogoutRedirectURIs(), form.PostLogoutRedirectURI) {
+			httpErr := utils.HttpErrors{Status: http.StatusBadRequest, Message: "post_logout_redirect_uri non enregistré pour ce client"}
+			c.Error(&httpErr)
+			return
+		}
+	}
+
+	//la déconnexion termine toutes les sessions ouvertes depuis la session de connexion
+	sessions := []models.Session{}
+	if sessionID != nil {
+		session, err := a.store.GetSession(ctx, *sessionID)
+		if err != nil && !errors.Is(err, fosite.ErrNotFound) {
+			httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+			c.Error(&httpErr)
+			return
+		}
+		if session != nil {
+			sessions = append(sessions, *session)
+			loginID = session.LoginSessionID
+		}
+	}
+	if loginID != nil {
+		linked, err := a.store.GetLoginSessionSessions(ctx, *loginID)
+		if err != nil {
+			httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+			c.Error(&httpErr)
+			return
+		}
+		for _, session := range linked {
+			if sessionID == nil || session.ID != *sessionID {
+				sessions = append(sessions, session)
+			}
+		}
+	}
+
+	if err := a.endSessions(c, sessions); err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		c.Error(&httpErr)
+		return
+	}
+
+	if loginID != nil {
+		if err := a.store.RevokeLoginSession(ctx, *loginID); err != nil {
+			httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+			c.Error(&httpErr)
+			return
+		}
+	}
+	clearLoginSession(c)
+
+	if form.PostLogoutRedirectURI == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "déconnexion réussie",
+			"success": true,
+		})
+		return
+	}
+
+	redirect, err := url.Parse(form.PostLogoutRedirectURI)
+	if err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusBadRequest, Message: "post_logout_redirect_uri invalide"}
+		c.Error(&httpErr)
+		return
+	}
+	if form.State != "" {
+		query := redirect.Query()
+		query.Set("state", form.State)
+		redirect.RawQuery = query.Encode()
+	}
+
+	c.Redirect(http.StatusFound, redirect.String())
+}
+
+// la déconnexion par le cookie est confirmée par le formulaire de la page de déconnexion
+func logoutConfirmed(c *gin.Context, form Logout) bool {
+	if c.Request.Method != http.MethodPost || form.Confirm == "" || form.CSRFToken == "" {
+		return false
+	}
+	cookie, err := c.Cookie(CSRFCookie)
+	return err == nil && subtle.ConstantTimeCompare([]byte(cookie), []byte(form.CSRFToken)) == 1
+}
+
+// page de confirmation de la déconnexion
+// les paramètres de la demande sont repris par le formulaire
+func (a *Auth) renderLogoutPage(c *gin.Context, form Logout) {
+	lang := form.Lang
+	if lang == "" {
+		lang = form.UILocales
+	}
+	lang = utils.PreferredLanguage(lang, c.GetHeader("Accept-Language"))
+
+	csrfToken, err := utils.GenerateToken(32)
+	if err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		c.Error(&httpErr)
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(CSRFCookie, csrfToken, int(LoginRequestLifespan.Seconds()), "/", "", true, true)
+
+	var info *models.InfoClient
+	if form.ClientID != "" {
+		if client, err := a.store.GetClientInfo(c.Request.Context(), form.ClientID); err == nil {
+			info = client
+		}
+	}
+
+	a.writePage(c, http.StatusOK, "logout.html", lang, info, authPage{
+		CSRFToken:   csrfToken,
+		ClientID:    form.ClientID,
+		RedirectURI: form.PostLogoutRedirectURI,
+		State:       form.State,
+	})
+}
+
+// notification des clients (back-channel) puis révocation des sessions
+// et des jetons émis pour elles
+func (a *Auth) endSessions(c *gin.Context, sessions []models.Session) error {
+	ctx := c.Request.Context()
+	for _, session := range sessions {
+		//les clients de la session sont notifiés avant sa révocation
+		if err := a.provider.Backchannel.Notify(ctx, &session); err != nil {
+			return err
+		}
+		if err := a.store.RevokeSession(ctx, session.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// vérification de l'id_token_hint signé par le serveur
+// un id_token expiré reste accepté pour la déconnexion
+func (a *Auth) decodeIDTokenHint(c *gin.Context, token string) (jwt.MapClaims, error) {
+	parsed, err := a.provider.Signer.Decode(c.Request.Context(), token)
+	if err != nil {
+		var ve *jwt.ValidationError
+		if !errors.As(err, &ve) || ve.Errors != jwt.ValidationErrorExpired || parsed == nil {
+			return nil, err
+		}
+	}
+
+	if !parsed.Claims.VerifyIssuer(a.provider.Config.GetIDTokenIssuer(c.Request.Context()), true) {
+		return nil, fosite.ErrInvalidRequest.WithHint("émetteur de l'id_token_hint invalide")
+	}
+
+	return parsed.Claims, nil
+}
@@ -0,0 +1,292 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db"
+	"github.com/dylEasydev/go-oauth2-easyclass/db/service"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/token/jwt"
+	"gorm.io/gorm"
+)
+
+type UserNameBody struct {
+	UserName string `form:"name" json:"name" binding:"required,name"`
+}
+
+type PasswordBody struct {
+	CurrentPassword string `form:"current_password" json:"current_password" binding:"required"`
+	Password        string `form:"password" json:"password" binding:"required,min=8,password"`
+}
+
+type EmailBody struct {
+	Email string `form:"email" json:"email" binding:"required,email"`
+}
+
+// identifiant de l'utilisateur du jeton vérifié par AuthMiddleware
+// les jetons des clients pairwise n'exposent que leur sub, résolu côté serveur
+func (s *StoreRequest) claimsUserID(ctx *gin.Context) (uuid.UUID, bool) {
+	claims, ok := ctx.Get("claims")
+	if !ok {
+		httpErr := utils.HttpErrors{Status: http.StatusUnauthorized, Message: "vous n'avez pas fourni de jeton JWT "}
+		ctx.Error(&httpErr)
+		return uuid.Nil, false
+	}
+	convertClaims := claims.(jwt.JWTClaims)
+
+	value, _ := convertClaims.Extra["user_id"].(string)
+	userID, err := uuid.Parse(value)
+	if err != nil && convertClaims.Subject != "" {
+		userID, err = s.Store.GetPairwiseUserID(ctx.Request.Context(), convertClaims.Subject)
+	}
+	if err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusForbidden, Message: "le jeton n'est associé à aucun utilisateur "}
+		ctx.Error(&httpErr)
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+// profil de l'utilisateur du jeton
+func (s *StoreRequest) GetProfile(ctx *gin.Context) {
+	userID, ok := s.claimsUserID(ctx)
+	if !ok {
+		return
+	}
+	context := ctx.Request.Context()
+
+	userService := service.InitUserService(&context, s.Store.GetDb())
+	user, err := userService.FindUserById(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			httpErr := utils.HttpErrors{Status: http.StatusNotFound, Message: "utilisateur introuvable"}
+			ctx.Error(&httpErr)
+			return
+		}
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		ctx.Error(&httpErr)
+		return
+	}
+
+	pendingEmail, err := s.Store.GetPendingEmail(context, userID)
+	if err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		ctx.Error(&httpErr)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "profil de l'utilisateur",
+		"success": true,
+		"data": gin.H{
+			"id":            user.ID,
+			"name":          user.UserName,
+			"email":         user.Email,
+			"pending_email": pendingEmail,
+			"role":          user.Role.RoleName,
+			"picture":       user.Image.UrlPictures,
+			"created_at":    user.CreatedAt,
+			"updated_at":    user.UpdatedAt,
+		},
+	})
+}
+
+// changement du nom d'utilisateur
+func (s *StoreRequest) UpdateUserName(ctx *gin.Context) {
+	userID, ok := s.claimsUserID(ctx)
+	if !ok {
+		return
+	}
+	context := ctx.Request.Context()
+
+	var body UserNameBody
+	if err := ctx.ShouldBind(&body); err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusBadRequest, Message: err.Error()}
+		ctx.Error(&httpErr)
+		return
+	}
+
+	exists, err := s.accountExists(context, body.UserName, "")
+	if err != nil {
+		httpErr := utils.HttpErrors{Message: err.Error(), Status: http.StatusInternalServerError}
+		ctx.Error(&httpErr)
+		return
+	}
+	if exists {
+		httpErr := utils.HttpErrors{Message: "nom d'utilisateur déjà utilisé ", Status: http.StatusConflict}
+		ctx.Error(&httpErr)
+		return
+	}
+
+	if err := s.Store.UpdateUserName(context, userID, body.UserName); err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		ctx.Error(&httpErr)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "nom d'utilisateur mis à jour",
+		"success": true,
+	})
+}
+
+// changement du mot de passe avec le mot de passe actuel
+func (s *StoreRequest) ChangePassword(ctx *gin.Context) {
+	userID, ok := s.claimsUserID(ctx)
+	if !ok {
+		return
+	}
+
+	var body PasswordBody
+	if err := ctx.ShouldBind(&body); err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusBadRequest, Message: err.Error()}
+		ctx.Error(&httpErr)
+		return
+	}
+
+	if err := s.Store.ChangePassword(ctx.Request.Context(), userID, body.CurrentPassword, body.Password); err != nil {
+		if errors.Is(err, fosite.ErrNotFound) {
+			httpErr := utils.HttpErrors{Status: http.StatusUnauthorized, Message: "mot de passe actuel incorrect"}
+			ctx.Error(&httpErr)
+			return
+		}
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		ctx.Error(&httpErr)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "mot de passe mis à jour",
+		"success": true,
+	})
+}
+
+// demande de changement d'adresse mail
+// un code de vérification est envoyé à la nouvelle adresse
+func (s *StoreRequest) ChangeEmail(ctx *gin.Context) {
+	userID, ok := s.claimsUserID(ctx)
+	if !ok {
+		return
+	}
+	context := ctx.Request.Context()
+
+	var body EmailBody
+	if err := ctx.ShouldBind(&body); err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusBadRequest, Message: err.Error()}
+		ctx.Error(&httpErr)
+		return
+	}
+
+	exists, err := s.accountExists(context, "", body.Email)
+	if err != nil {
+		httpErr := utils.HttpErrors{Message: err.Error(), Status: http.StatusInternalServerError}
+		ctx.Error(&httpErr)
+		return
+	}
+	if exists {
+		httpErr := utils.HttpErrors{Message: "adresse mail déjà utilisée ", Status: http.StatusConflict}
+		ctx.Error(&httpErr)
+		return
+	}
+
+	userService := service.InitUserService(&context, s.Store.GetDb())
+	user, err := userService.FindUserById(userID)
+	if err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		ctx.Error(&httpErr)
+		return
+	}
+
+	if _, err := s.Store.CreateEmailChange(context, user, body.Email); err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		ctx.Error(&httpErr)
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, gin.H{
+		"message": "verifier votre mail " + body.Email,
+		"success": true,
+	})
+}
+
+// confirmation de la nouvelle adresse mail avec le code reçu
+func (s *StoreRequest) ConfirmEmail(ctx *gin.Context) {
+	userID, ok := s.claimsUserID(ctx)
+	if !ok {
+		return
+	}
+
+	var body CodeBody
+	if err := ctx.ShouldBind(&body); err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusBadRequest, Message: err.Error()}
+		ctx.Error(&httpErr)
+		return
+	}
+
+	email, err := s.Store.ConfirmEmailChange(ctx.Request.Context(), userID, body.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, fosite.ErrNotFound):
+			httpErr := utils.HttpErrors{Status: http.StatusUnauthorized, Message: "code de vérification non valide"}
+			ctx.Error(&httpErr)
+		case errors.Is(err, db.ErrEmailTaken):
+			httpErr := utils.HttpErrors{Status: http.StatusConflict, Message: "adresse mail déjà utilisée "}
+			ctx.Error(&httpErr)
+		default:
+			httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+			ctx.Error(&httpErr)
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "adresse mail mise à jour",
+		"success": true,
+		"email":   email,
+	})
+}
+
+// suppression du compte de l'utilisateur du jeton
+// une nouvelle connexion avant la fin du délai de grâce restaure le compte
+func (a *Auth) DeleteAccount(c *gin.Context) {
+	userID, ok := (&StoreRequest{Store: a.store}).claimsUserID(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	//les clients des sessions sont notifiés par back-channel avant la suppression
+	sessions, err := a.store.GetUserSessions(ctx, userID)
+	if err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		c.Error(&httpErr)
+		return
+	}
+	if err := a.endSessions(c, sessions); err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		c.Error(&httpErr)
+		return
+	}
+
+	deletedAt, err := a.store.DeleteAccount(ctx, userID)
+	if err != nil {
+		if errors.Is(err, fosite.ErrNotFound) {
+			httpErr := utils.HttpErrors{Status: http.StatusNotFound, Message: "utilisateur introuvable"}
+			c.Error(&httpErr)
+			return
+		}
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		c.Error(&httpErr)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "compte supprimé, reconnectez-vous avant la date limite pour le restaurer",
+		"success":    true,
+		"restore_by": deletedAt.Add(db.DeletionGrace()).UTC(),
+	})
+}
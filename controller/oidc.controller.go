@@ -1,26 +1,32 @@
 package controller
 
 import (
+	"net/http"
+
 	"github.com/dylEasydev/go-oauth2-easyclass/db"
 	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/db/service"
+	"github.com/dylEasydev/go-oauth2-easyclass/provider"
 	"github.com/dylEasydev/go-oauth2-easyclass/utils"
 	"github.com/gin-gonic/gin"
-	"github.com/gin-gonic/gin/binding"
 	"github.com/ory/fosite"
 )
 
 type Auth struct {
-	provider fosite.OAuth2Provider
+	provider *provider.Provider
 	store    *db.Store
 }
 
+// formulaire de la page de connexion
 type Authorize struct {
-	UserName string   `form:"name" json:"name" binding:"required,name"`
-	Password string   `form:"password" json:"password" binding:"required,min=8,password"`
-	Scopes   []string `form:"scopes" json:"scopes"`
+	Challenge string `form:"challenge" json:"challenge" binding:"required"`
+	CSRFToken string `form:"csrf_token" json:"csrf_token" binding:"required"`
+	UserName  string `form:"name" json:"name" binding:"required,name"`
+	Password  string `form:"password" json:"password" binding:"required,min=8,password"`
+	Lang      string `form:"lang" json:"lang"`
 }
 
-func NewAuth(provider fosite.OAuth2Provider, store *db.Store) *Auth {
+func NewAuth(provider *provider.Provider, store *db.Store) *Auth {
 	return &Auth{
 		provider: provider,
 		store:    store,
@@ -28,6 +34,16 @@ func NewAuth(provider fosite.OAuth2Provider, store *db.Store) *Auth {
 }
 
 func (a *Auth) AuthorizeHandler(c *gin.Context) {
+	//soumission des pages de connexion et de consentement
+	if c.Request.Method == http.MethodPost && c.PostForm("challenge") != "" {
+		if c.PostForm("consent") != "" {
+			a.ConsentHandler(c)
+			return
+		}
+		a.LoginHandler(c)
+		return
+	}
+
 	ctx := c.Request.Context()
 
 	authorizeRequest, err := a.provider.NewAuthorizeRequest(ctx, c.Request)
@@ -36,45 +52,67 @@ func (a *Auth) AuthorizeHandler(c *gin.Context) {
 		return
 	}
 
-	form := Authorize{}
-	if err := c.ShouldBindWith(&form, binding.Form); err != nil {
-		a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, fosite.ErrInvalidRequest.WithHint(err.Error()))
-		return
-	}
+	//réutilisation de la session de connexion du navigateur si possible
+	login := a.currentLoginSession(c)
+	if !loginSessionUsable(login, authorizeRequest) {
+		//prompt=none interdit toute interaction avec l'utilisateur
+		if authorizeRequest.GetRequestForm().Get("prompt") == "none" {
+			a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, fosite.ErrLoginRequired.WithHint("aucune session de connexion valide"))
+			return
+		}
 
-	err = a.store.Authenticate(ctx, form.UserName, form.Password)
-	if err != nil {
-		a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, fosite.ErrAccessDenied.WithHint(err.Error()))
+		a.renderLoginPage(c, authorizeRequest)
 		return
 	}
 
-	user, err := a.store.GetUser(ctx, form.UserName)
+	userService := service.InitUserService(&ctx, a.store.GetDb())
+	user, err := userService.FindUserById(login.UserID)
 	if err != nil {
-		a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, err)
+		a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, fosite.ErrLoginRequired.WithHint("utilisateur de la session introuvable"))
 		return
 	}
 
-	userScopes := make([]string, len(user.Role.Scopes))
-	for _, scopes := range user.Role.Scopes {
-		userScopes = append(userScopes, scopes.ScopeName)
-	}
+	a.authorize(c, authorizeRequest, login, user, "", "")
+}
 
-	grantScopes := utils.IntersectScopes(form.Scopes, userScopes)
+// réponse d'autorisation avec les scopes accordés à l'utilisateur authentifié
+func (a *Auth) grant(c *gin.Context, authorizeRequest fosite.AuthorizeRequester, login *models.LoginSession, user *models.User, grantScopes []string) {
+	ctx := c.Request.Context()
 
 	for _, scope := range grantScopes {
 		authorizeRequest.GrantScope(scope)
 	}
+	//ressources validées à la demande: audience des jetons (RFC 8707)
+	for _, resource := range provider.RequestedResources(authorizeRequest.GetRequestForm()) {
+		authorizeRequest.GrantAudience(resource)
+	}
+
+	//sujet public ou pairwise selon le client
+	subject, err := a.store.SubjectFor(ctx, authorizeRequest.GetClient(), user.ID, user.UserName)
+	if err != nil {
+		a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, fosite.ErrServerError.WithWrap(err))
+		return
+	}
 
 	extra := map[string]any{
 		"scopes":  grantScopes,
 		"user_id": user.ID,
 	}
-	session, err := models.NewSession(ctx, authorizeRequest.GetClient().GetID(), user.ID.String(), user.UserName, user.UserName, extra)
+	session, err := models.NewSession(ctx, authorizeRequest.GetClient().GetID(), user.ID.String(), user.UserName, subject, extra)
 
 	if err != nil {
 		a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, err)
 		return
 	}
+	session.SetClient(authorizeRequest.GetClient())
+	session.SetLoginSession(login, authorizeRequest.GetRequestedAt())
+
+	//authorization_details validés à la demande et présentés au consentement
+	if session.AuthorizationDetails, err = provider.ParseAuthorizationDetails(authorizeRequest.GetClient(), authorizeRequest.GetRequestForm()); err != nil {
+		a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, err)
+		return
+	}
+
 	response, err := a.provider.NewAuthorizeResponse(ctx, authorizeRequest, session)
 	if err != nil {
 		a.provider.WriteAuthorizeError(ctx, c.Writer, authorizeRequest, err)
@@ -87,27 +125,117 @@ func (a *Auth) AuthorizeHandler(c *gin.Context) {
 func (a *Auth) TokenHandler(c *gin.Context) {
 	ctx := c.Request.Context()
 
+	//preuve DPoP facultative: les jetons émis sont alors liés à sa clé
+	jkt, err := a.provider.ValidateDPoPProof(ctx, c.Request, "")
+	if err != nil {
+		a.provider.WriteAccessError(ctx, c.Writer, nil, err)
+		return
+	}
+
 	accessRequest, err := a.provider.NewAccessRequest(ctx, c.Request, new(models.Session))
 	if err != nil {
 		a.provider.WriteAccessError(ctx, c.Writer, accessRequest, err)
 		return
 	}
 
+	//signature des jetons avec l'algorithme choisi par le client
+	if session, ok := accessRequest.GetSession().(*models.Session); ok {
+		session.SetClient(accessRequest.GetClient())
+	}
+
+	//session de l'utilisateur authentifié par le grant password
+	if accessRequest.GetGrantTypes().ExactOne("password") {
+		if err := a.passwordGrantSession(c, accessRequest); err != nil {
+			a.provider.WriteAccessError(ctx, c.Writer, accessRequest, err)
+			return
+		}
+	}
+
 	if accessRequest.GetGrantTypes().ExactOne("client_credentials") {
 		for _, scope := range accessRequest.GetRequestedScopes() {
 			accessRequest.GrantScope(scope)
 		}
 	}
 
+	//authorization_details demandés ou restreints au token end-point
+	if err := provider.GrantAuthorizationDetails(accessRequest); err != nil {
+		a.provider.WriteAccessError(ctx, c.Writer, accessRequest, err)
+		return
+	}
+
+	//jetons restreints aux ressources demandées (RFC 8707)
+	if err := a.provider.GrantResources(ctx, accessRequest); err != nil {
+		a.provider.WriteAccessError(ctx, c.Writer, accessRequest, err)
+		return
+	}
+
+	if err := provider.BindDPoPKey(accessRequest, jkt); err != nil {
+		a.provider.WriteAccessError(ctx, c.Writer, accessRequest, err)
+		return
+	}
+
+	//jetons liés au certificat client présenté (mTLS)
+	if err := provider.BindCertificate(accessRequest, c.Request); err != nil {
+		a.provider.WriteAccessError(ctx, c.Writer, accessRequest, err)
+		return
+	}
+
 	response, err := a.provider.NewAccessResponse(ctx, accessRequest)
 	if err != nil {
 		a.provider.WriteAccessError(ctx, c.Writer, accessRequest, err)
 		return
 	}
+	if jkt != "" {
+		response.SetTokenType(provider.DPoPTokenType)
+	}
+	if session, ok := accessRequest.GetSession().(*models.Session); ok && len(session.AuthorizationDetails) > 0 {
+		response.SetExtra("authorization_details", session.AuthorizationDetails)
+	}
 
 	a.provider.WriteAccessResponse(ctx, c.Writer, accessRequest, response)
 }
 
+// construction de la session du grant password avec les scopes du rôle
+// comme pour l'authorize end-point (fosite n'a renseigné que le sujet)
+func (a *Auth) passwordGrantSession(c *gin.Context, accessRequest fosite.AccessRequester) error {
+	ctx := c.Request.Context()
+
+	current, ok := accessRequest.GetSession().(*models.Session)
+	if !ok {
+		return fosite.ErrServerError.WithHint("session invalide")
+	}
+
+	user, err := a.store.GetUser(ctx, current.GetSubject())
+	if err != nil {
+		return fosite.ErrInvalidGrant.WithHint("utilisateur introuvable").WithWrap(err)
+	}
+
+	grantScopes := utils.IntersectScopes(accessRequest.GetRequestedScopes(), roleScopes(user))
+	for _, scope := range grantScopes {
+		accessRequest.GrantScope(scope)
+	}
+
+	subject, err := a.store.SubjectFor(ctx, accessRequest.GetClient(), user.ID, user.UserName)
+	if err != nil {
+		return fosite.ErrServerError.WithWrap(err)
+	}
+
+	extra := map[string]any{
+		"scopes":  grantScopes,
+		"user_id": user.ID,
+	}
+	session, err := models.NewSession(ctx, accessRequest.GetClient().GetID(), user.ID.String(), user.UserName, subject, extra)
+	if err != nil {
+		return fosite.ErrServerError.WithWrap(err)
+	}
+	//durées de vie des jetons fixées par le handler fosite
+	session.ExpiresAt = current.ExpiresAt
+	session.SetClient(accessRequest.GetClient())
+
+	accessRequest.SetSession(session)
+	return nil
+}
+
 func (a *Auth) PARRequestHandler(c *gin.Context) {
 	ctx := c.Request.Context()
 
@@ -149,3 +277,12 @@ func (a *Auth) IntrospectionHandler(c *gin.Context) {
 
 	a.provider.WriteIntrospectionResponse(ctx, c.Writer, ir)
 }
+
+// scopes accordés par le rôle de l'utilisateur
+func roleScopes(user *models.User) []string {
+	userScopes := make([]string, 0, len(user.Role.Scopes))
+	for _, scopes := range user.Role.Scopes {
+		userScopes = append(userScopes, scopes.ScopeName)
+	}
+	return userScopes
+}
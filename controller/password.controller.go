@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/ory/fosite"
+)
+
+type ForgotPasswordBody struct {
+	Email string `form:"email" json:"email" binding:"required,email"`
+}
+
+type ResetPasswordBody struct {
+	Token    string `form:"token" json:"token" binding:"required"`
+	Password string `form:"password" json:"password" binding:"required,min=8,password"`
+}
+
+// demande de réinitialisation du mot de passe
+// la réponse est identique et immédiate que le compte existe ou non:
+// la recherche du compte, la création du jeton et l'envoi du mail sont faits en arrière-plan
+func (a *Auth) ForgotPasswordHandler(c *gin.Context) {
+	var body ForgotPasswordBody
+	if err := c.ShouldBind(&body); err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusBadRequest, Message: err.Error()}
+		c.Error(&httpErr)
+		return
+	}
+
+	go a.sendPasswordReset(body.Email)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "si un compte correspond à cette adresse, un mail de réinitialisation a été envoyé",
+		"success": true,
+	})
+}
+
+// création de la demande de réinitialisation et envoi du lien si le compte existe
+func (a *Auth) sendPasswordReset(email string) {
+	ctx := context.Background()
+
+	user, err := a.store.GetUserByLoginHint(ctx, email)
+	if err != nil {
+		if !errors.Is(err, fosite.ErrNotFound) {
+			log.Printf("warning: recherche de l'utilisateur pour la réinitialisation impossible: %v", err)
+		}
+		return
+	}
+
+	token, reset, err := a.store.CreatePasswordReset(ctx, user.ID)
+	if err != nil {
+		if !errors.Is(err, db.ErrPasswordResetThrottled) {
+			log.Printf("warning: création de la demande de réinitialisation impossible: %v", err)
+		}
+		return
+	}
+
+	if err := utils.SendPasswordReset(user.Email, user.UserName, token, reset.ExpiresAt); err != nil {
+		log.Printf("warning: failed to send password reset email to %s: %v", user.Email, err)
+	}
+}
+
+// réinitialisation du mot de passe avec le jeton reçu par mail
+// toutes les sessions et les jetons de l'utilisateur sont révoqués
+func (a *Auth) ResetPasswordHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var body ResetPasswordBody
+	if err := c.ShouldBind(&body); err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusBadRequest, Message: err.Error()}
+		c.Error(&httpErr)
+		return
+	}
+
+	userID, err := a.store.ResetPassword(ctx, body.Token, body.Password)
+	if err != nil {
+		if errors.Is(err, fosite.ErrNotFound) {
+			httpErr := utils.HttpErrors{Status: http.StatusBadRequest, Message: "jeton de réinitialisation invalide ou expiré"}
+			c.Error(&httpErr)
+			return
+		}
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		c.Error(&httpErr)
+		return
+	}
+
+	sessions, err := a.store.GetUserSessions(ctx, userID)
+	if err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		c.Error(&httpErr)
+		return
+	}
+	if err := a.endSessions(c, sessions); err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		c.Error(&httpErr)
+		return
+	}
+	if err := a.store.RevokeUserLoginSessions(ctx, userID); err != nil {
+		httpErr := utils.HttpErrors{Status: http.StatusInternalServerError, Message: err.Error()}
+		c.Error(&httpErr)
+		return
+	}
+	clearLoginSession(c)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "mot de passe réinitialisé",
+		"success": true,
+	})
+}
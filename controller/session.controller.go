@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+)
+
+const (
+	//cookie de la session de connexion du navigateur
+	LoginSessionCookie = "easyclass_session"
+	//durée de vie d'une session de connexion
+	LoginSessionLifespan = 12 * time.Hour
+)
+
+// session de connexion désignée par le cookie signé du navigateur
+func (a *Auth) currentLoginSession(c *gin.Context) *models.LoginSession {
+	cookie, err := c.Cookie(LoginSessionCookie)
+	if err != nil || cookie == "" {
+		return nil
+	}
+	value, ok := utils.VerifySignedValue(cookie)
+	if !ok {
+		return nil
+	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return nil
+	}
+	login, err := a.store.GetLoginSession(c.Request.Context(), id)
+	if err != nil {
+		return nil
+	}
+	return login
+}
+
+// ouverture d'une session de connexion après authentification de l'utilisateur
+func (a *Auth) startLoginSession(c *gin.Context, user *models.User) (*models.LoginSession, error) {
+	now := time.Now().UTC()
+	login := &models.LoginSession{
+		ID:        uuid.New(),
+		AuthTime:  now,
+		ExpiresAt: now.Add(LoginSessionLifespan),
+		UserID:    user.ID,
+	}
+	if err := a.store.CreateLoginSession(c.Request.Context(), login); err != nil {
+		return nil, err
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(LoginSessionCookie, utils.SignValue(login.ID.String()), int(LoginSessionLifespan.Seconds()), "/", "", true, true)
+	return login, nil
+}
+
+// suppression du cookie de la session de connexion
+func clearLoginSession(c *gin.Context) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(LoginSessionCookie, "", -1, "/", "", true, true)
+}
+
+// la session de connexion peut-elle être réutilisée pour la demande
+// (prompt=login impose une nouvelle authentification, max_age borne l'auth_time)
+func loginSessionUsable(login *models.LoginSession, request fosite.AuthorizeRequester) bool {
+	if login == nil {
+		return false
+	}
+
+	prompt := fosite.Arguments(fosite.RemoveEmpty(strings.Split(request.GetRequestForm().Get("prompt"), " ")))
+	if prompt.Has("login") {
+		return false
+	}
+
+	if maxAge, err := strconv.ParseInt(request.GetRequestForm().Get("max_age"), 10, 64); err == nil && maxAge >= 0 {
+		if login.AuthTime.Add(time.Duration(maxAge) * time.Second).Before(request.GetRequestedAt()) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -39,34 +40,14 @@ func (s *StoreRequest) SignTeacher(ctx *gin.Context) {
 		return
 	}
 
-	//recherche parmis la table des utilisateur
-	userFind, err := service.FindUserByName[models.User](&context, s.Store.GetDb(), bodyTeacher.UserName, bodyTeacher.Email)
+	//recherche parmis les utilisateurs, les enseignants en attente et les noms réservés
+	exists, err := s.accountExists(context, bodyTeacher.UserName, bodyTeacher.Email)
 	if err != nil {
-		if !errors.Is(err, gorm.ErrRecordNotFound) {
-			httpErr := utils.HttpErrors{Message: err.Error(), Status: http.StatusInternalServerError}
-			ctx.Error(&httpErr)
-			return
-		}
-	}
-	if userFind != nil {
-		httpErr := utils.HttpErrors{Message: "utilisateurs possède déjà un compte ", Status: http.StatusBadRequest}
+		httpErr := utils.HttpErrors{Message: err.Error(), Status: http.StatusInternalServerError}
 		ctx.Error(&httpErr)
 		return
 	}
-
-	// recherche parmi la table des enseignant  temportaire
-
-	teacherFind, err := service.FindUserByName[models.TeacherWaiting](&context, s.Store.GetDb(), bodyTeacher.UserName, bodyTeacher.Email)
-
-	if err != nil {
-		if !errors.Is(err, gorm.ErrRecordNotFound) {
-			httpErr := utils.HttpErrors{Message: err.Error(), Status: http.StatusInternalServerError}
-			ctx.Error(&httpErr)
-			return
-		}
-	}
-
-	if teacherFind != nil {
+	if exists {
 		httpErr := utils.HttpErrors{Message: "utilisateurs possède déjà un compte ", Status: http.StatusBadRequest}
 		ctx.Error(&httpErr)
 		return
@@ -104,33 +85,14 @@ func (s *StoreRequest) SignStudent(ctx *gin.Context) {
 		return
 	}
 
-	userFind, err := service.FindUserByName[models.User](&context, s.Store.GetDb(), bodyStudent.UserName, bodyStudent.Email)
+	//recherche parmis les utilisateurs, les enseignants en attente et les noms réservés
+	exists, err := s.accountExists(context, bodyStudent.UserName, bodyStudent.Email)
 	if err != nil {
-		if !errors.Is(err, gorm.ErrRecordNotFound) {
-			httpErr := utils.HttpErrors{Message: err.Error(), Status: http.StatusInternalServerError}
-			ctx.Error(&httpErr)
-			return
-		}
-	}
-	if userFind != nil {
-		httpErr := utils.HttpErrors{Message: "utilisateurs possède déjà un compte ", Status: http.StatusBadRequest}
+		httpErr := utils.HttpErrors{Message: err.Error(), Status: http.StatusInternalServerError}
 		ctx.Error(&httpErr)
 		return
 	}
-
-	// recherche parmi la table des enseignant  temportaire
-
-	teacherFind, err := service.FindUserByName[models.TeacherWaiting](&context, s.Store.GetDb(), bodyStudent.UserName, bodyStudent.Email)
-
-	if err != nil {
-		if !errors.Is(err, gorm.ErrRecordNotFound) {
-			httpErr := utils.HttpErrors{Message: err.Error(), Status: http.StatusInternalServerError}
-			ctx.Error(&httpErr)
-			return
-		}
-	}
-
-	if teacherFind != nil {
+	if exists {
 		httpErr := utils.HttpErrors{Message: "utilisateurs possède déjà un compte ", Status: http.StatusBadRequest}
 		ctx.Error(&httpErr)
 		return
@@ -178,34 +140,14 @@ func (s *StoreRequest) SignAdmin(ctx *gin.Context) {
 		return
 	}
 
-	//recherche parmis la table des utilisateur
-	userFind, err := service.FindUserByName[models.User](&context, s.Store.GetDb(), bodyUser.UserName, bodyUser.Email)
+	//recherche parmis les utilisateurs, les enseignants en attente et les noms réservés
+	exists, err := s.accountExists(context, bodyUser.UserName, bodyUser.Email)
 	if err != nil {
-		if !errors.Is(err, gorm.ErrRecordNotFound) {
-			httpErr := utils.HttpErrors{Message: err.Error(), Status: http.StatusInternalServerError}
-			ctx.Error(&httpErr)
-			return
-		}
-	}
-	if userFind != nil {
-		httpErr := utils.HttpErrors{Message: "utilisateurs possède déjà un compte ", Status: http.StatusBadRequest}
+		httpErr := utils.HttpErrors{Message: err.Error(), Status: http.StatusInternalServerError}
 		ctx.Error(&httpErr)
 		return
 	}
-
-	// recherche parmi la table des enseignant  temportaire
-
-	teacherFind, err := service.FindUserByName[models.TeacherWaiting](&context, s.Store.GetDb(), bodyUser.UserName, bodyUser.Email)
-
-	if err != nil {
-		if !errors.Is(err, gorm.ErrRecordNotFound) {
-			httpErr := utils.HttpErrors{Message: err.Error(), Status: http.StatusInternalServerError}
-			ctx.Error(&httpErr)
-			return
-		}
-	}
-
-	if teacherFind != nil {
+	if exists {
 		httpErr := utils.HttpErrors{Message: "utilisateurs possède déjà un compte ", Status: http.StatusBadRequest}
 		ctx.Error(&httpErr)
 		return
@@ -229,3 +171,27 @@ func (s *StoreRequest) SignAdmin(ctx *gin.Context) {
 		"id_user": newUser.GetId().String(),
 	})
 }
+
+// recherche d'un compte utilisant le nom ou l'adresse parmi les utilisateurs
+// et les enseignants en attente, les anciens noms d'utilisateur restent réservés
+func (s *StoreRequest) accountExists(ctx context.Context, name string, email string) (bool, error) {
+	//les comptes supprimés dans leur délai de grâce gardent leur nom et leur adresse
+	userFind, err := service.FindUserByName[models.User](&ctx, s.Store.GetDb().Unscoped(), name, email)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, err
+	}
+	if userFind != nil {
+		return true, nil
+	}
+
+	//seule une demande d'enseignant encore en attente réserve le nom et l'adresse
+	pending, err := s.Store.IsTeacherPending(ctx, name, email)
+	if err != nil || pending {
+		return pending, err
+	}
+
+	if name == "" {
+		return false, nil
+	}
+	return s.Store.IsUserNameRetired(ctx, name)
+}
@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/db/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/token/jwt"
+)
+
+// end-point userinfo OpenID Connect
+// renvoie les claims standards en fonction des scopes accordés au jeton
+func (a *Auth) UserInfoHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	token := fosite.AccessTokenFromRequest(c.Request)
+	if token == "" {
+		writeUserInfoError(c, fosite.ErrRequestUnauthorized.WithHint("aucun jeton d'accès fourni"))
+		return
+	}
+
+	_, accessRequest, err := a.provider.IntrospectToken(ctx, token, fosite.AccessToken, new(models.Session), "openid")
+	if err != nil {
+		writeUserInfoError(c, err)
+		return
+	}
+
+	session, ok := accessRequest.GetSession().(*models.Session)
+	if !ok || session.UserID == nil {
+		writeUserInfoError(c, fosite.ErrRequestUnauthorized.WithHint("le jeton n'est associé à aucun utilisateur"))
+		return
+	}
+
+	userService := service.InitUserService(&ctx, a.store.GetDb())
+	user, err := userService.FindUserById(*session.UserID)
+	if err != nil {
+		writeUserInfoError(c, fosite.ErrRequestUnauthorized.WithHint("utilisateur introuvable"))
+		return
+	}
+
+	claims := userInfoClaims(session.GetSubject(), user, accessRequest.GetGrantedScopes())
+
+	client, ok := accessRequest.GetClient().(*models.Client)
+	if !ok {
+		c.JSON(http.StatusOK, claims)
+		return
+	}
+
+	//réponse signée si le client l'a enregistré ou la demande explicitement
+	if client.GetUserinfoSignedResponseAlg() == "" && !strings.Contains(c.GetHeader("Accept"), "application/jwt") {
+		c.JSON(http.StatusOK, claims)
+		return
+	}
+
+	claims["iss"] = a.provider.Config.GetIDTokenIssuer(ctx)
+	claims["aud"] = client.GetID()
+	claims["iat"] = time.Now().UTC().Unix()
+
+	alg := client.GetUserinfoSignedResponseAlg()
+	if alg == "" {
+		alg = client.GetRequestObjectSigningAlgorithm()
+	}
+
+	signed, _, err := a.provider.Signer.Generate(ctx, claims, &jwt.Headers{Extra: map[string]interface{}{"typ": "JWT", "alg": alg}})
+	if err != nil {
+		writeUserInfoError(c, fosite.ErrServerError.WithWrap(err))
+		return
+	}
+
+	c.Data(http.StatusOK, "application/jwt", []byte(signed))
+}
+
+// construction des claims en fonction des scopes accordés
+func userInfoClaims(subject string, user *models.User, scopes fosite.Arguments) jwt.MapClaims {
+	claims := jwt.MapClaims{
+		"sub":  subject,
+		"role": user.Role.RoleName,
+	}
+
+	if scopes.Has("profile") {
+		claims["preferred_username"] = user.UserName
+		claims["picture"] = user.Image.UrlPictures
+		claims["updated_at"] = user.UpdatedAt.UTC().Unix()
+	}
+
+	if scopes.Has("email") {
+		claims["email"] = user.Email
+		claims["email_verified"] = user.CodeVerif.ID != uuid.Nil && user.CodeVerif.IsUsed()
+	}
+
+	return claims
+}
+
+// erreur userinfo au format RFC 6750 (entête WWW-Authenticate)
+func writeUserInfoError(c *gin.Context, err error) {
+	rfcErr := fosite.ErrorToRFC6749Error(err)
+
+	status := http.StatusUnauthorized
+	code := "invalid_token"
+	switch rfcErr.ErrorField {
+	case fosite.ErrInvalidScope.ErrorField:
+		status = http.StatusForbidden
+		code = "insufficient_scope"
+	case fosite.ErrServerError.ErrorField:
+		status = http.StatusInternalServerError
+		code = rfcErr.ErrorField
+	}
+
+	c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="%s", error_description="%s"`, code, rfcErr.GetDescription()))
+	c.JSON(status, gin.H{
+		"error":             code,
+		"error_description": rfcErr.GetDescription(),
+	})
+	c.Abort()
+}
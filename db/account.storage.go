@@ -0,0 +1,202 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+	"gorm.io/gorm"
+)
+
+//implementation de la suppression des comptes avec délai de grâce
+
+const (
+	//délai de grâce par défaut avant la suppression définitive
+	AccountDeletionGrace = 30 * 24 * time.Hour
+	//intervalle de la suppression définitive des comptes
+	AccountPurgeInterval = 1 * time.Hour
+	//nombre de comptes supprimés par passage
+	accountPurgeBatchSize = 50
+)
+
+// délai de grâce configuré par ACCOUNT_DELETION_GRACE (durée Go, ex: 720h)
+func DeletionGrace() time.Duration {
+	if value := os.Getenv("ACCOUNT_DELETION_GRACE"); value != "" {
+		grace, err := time.ParseDuration(value)
+		if err == nil && grace >= 0 {
+			return grace
+		}
+		log.Printf("warning: ACCOUNT_DELETION_GRACE invalide, délai par défaut utilisé: %s", value)
+	}
+	return AccountDeletionGrace
+}
+
+// date limite du délai de grâce: un compte supprimé après elle peut être restauré,
+// un compte supprimé avant (ou à cette date) est supprimé définitivement
+func deletionCutoff(now time.Time) time.Time {
+	return now.Add(-DeletionGrace()).UTC()
+}
+
+// suppression du compte demandée par l'utilisateur
+// le compte est supprimé logiquement, ses sessions, jetons, consentements et demandes en attente sont révoqués
+// retourne la date de suppression enregistrée
+func (store *Store) DeleteAccount(ctx context.Context, userID uuid.UUID) (time.Time, error) {
+	var deletedAt time.Time
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		sessions := tx.Table(models.Session{}.TableName()).Select("id").Where("user_id = ?", userID)
+		if err := tx.Model(&models.AccessToken{}).Where("session_id IN (?)", sessions).Updates(&models.AccessToken{Active: utils.PtrBool(false)}).Error; err != nil {
+			return fmt.Errorf("erreur de revocation des jetons d'accès : %w", err)
+		}
+		if err := tx.Model(&models.RefreshToken{}).Where("session_id IN (?)", sessions).Updates(&models.RefreshToken{Active: utils.PtrBool(false)}).Error; err != nil {
+			return fmt.Errorf("erreur de revocation des jetons de rafraichissement : %w", err)
+		}
+		//un appareil approuvé mais pas encore échangé ne doit plus recevoir de jetons
+		if err := tx.Model(&models.DeviceCode{}).Where("session_id IN (?) AND state IN ?", sessions, []string{models.DEVICE_STATE_PENDING, models.DEVICE_STATE_APPROVED}).Update("state", models.DEVICE_STATE_DENIED).Error; err != nil {
+			return fmt.Errorf("erreur de refus des device codes : %w", err)
+		}
+		if err := tx.Model(&models.BackchannelAuthRequest{}).Where("user_id = ? AND state IN ?", userID, []string{models.CIBA_STATE_PENDING, models.CIBA_STATE_APPROVED}).Update("state", models.CIBA_STATE_DENIED).Error; err != nil {
+			return fmt.Errorf("erreur de refus des demandes CIBA : %w", err)
+		}
+		if _, err := gorm.G[models.Session](tx).Where("user_id = ?", userID).Delete(ctx); err != nil {
+			return fmt.Errorf("erreur de suppression des sessions : %w", err)
+		}
+		if _, err := gorm.G[models.LoginSession](tx).Where("user_id = ?", userID).Delete(ctx); err != nil {
+			return fmt.Errorf("erreur de suppression des sessions de connexion: %w", err)
+		}
+		if _, err := gorm.G[models.Consent](tx).Where("user_id = ?", userID).Delete(ctx); err != nil {
+			return fmt.Errorf("erreur de suppression des consentements: %w", err)
+		}
+
+		rows, err := gorm.G[models.User](tx).Where("id = ?", userID).Delete(ctx)
+		if err != nil {
+			return fmt.Errorf("erreur de suppression de l'utilisateur: %w", err)
+		}
+		if rows == 0 {
+			return fosite.ErrNotFound
+		}
+
+		//date de suppression réellement enregistrée, base du délai de grâce
+		return tx.Unscoped().Model(&models.User{}).Where("id = ?", userID).Select("deleted_at").Scan(&deletedAt).Error
+	})
+	return deletedAt, err
+}
+
+// utilisateur supprimé encore dans le délai de grâce
+func (store *Store) getDeletedUser(ctx context.Context, name string) (*models.User, error) {
+	user, err := gorm.G[models.User](store.db.Unscoped()).Where("user_name = ? AND deleted_at > ?", name, deletionCutoff(time.Now())).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fosite.ErrNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// restauration d'un compte supprimé par une nouvelle connexion
+func (store *Store) restoreAccount(ctx context.Context, userID uuid.UUID) error {
+	if err := store.db.WithContext(ctx).Unscoped().Session(&gorm.Session{SkipHooks: true}).Model(&models.User{}).Where("id = ?", userID).Update("deleted_at", nil).Error; err != nil {
+		return fmt.Errorf("erreur de restauration du compte: %w", err)
+	}
+	return nil
+}
+
+// suppression définitive des comptes dont le délai de grâce est écoulé
+// l'utilisateur, son image, ses codes de vérification et l'historique de ses jetons
+func (store *Store) PurgeDeletedAccounts(ctx context.Context) (int, error) {
+	var ids []uuid.UUID
+	if err := store.db.WithContext(ctx).Unscoped().Model(&models.User{}).Where("deleted_at IS NOT NULL AND deleted_at <= ?", deletionCutoff(time.Now())).Limit(accountPurgeBatchSize).Pluck("id", &ids).Error; err != nil {
+		return 0, fmt.Errorf("erreur de lecture des comptes supprimés: %w", err)
+	}
+
+	for i, id := range ids {
+		if err := store.purgeAccount(ctx, id); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+// suppression définitive d'un compte
+// les jetons, codes et demandes liés aux sessions sont supprimés en cascade
+func (store *Store) purgeAccount(ctx context.Context, userID uuid.UUID) error {
+	return store.db.WithContext(ctx).Unscoped().Transaction(func(tx *gorm.DB) error {
+		user := models.User{}
+		if err := tx.Select("id", "user_name", "email").Where("id = ?", userID).First(&user).Error; err != nil {
+			return fmt.Errorf("erreur de lecture de l'utilisateur: %w", err)
+		}
+		sessions := tx.Table(models.Session{}.TableName()).Select("id").Where("user_id = ?", userID)
+		changes := tx.Table(models.EmailChange{}.TableName()).Select("id").Where("user_id = ?", userID)
+
+		if err := tx.Where("session_id IN (?)", sessions).Delete(&models.BackchannelLogout{}).Error; err != nil {
+			return fmt.Errorf("erreur de suppression des déconnexions back-channel: %w", err)
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.Session{}).Error; err != nil {
+			return fmt.Errorf("erreur de suppression des sessions : %w", err)
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.LoginRequest{}).Error; err != nil {
+			return fmt.Errorf("erreur de suppression des demandes de connexion: %w", err)
+		}
+		if err := tx.Where("verifiable_type = ? AND verifiable_id = ?", user.TableName(), userID).Or("verifiable_type = ? AND verifiable_id IN (?)", models.EmailChange{}.TableName(), changes).Delete(&models.CodeVerif{}).Error; err != nil {
+			return fmt.Errorf("erreur de suppression des codes de vérification: %w", err)
+		}
+		if err := tx.Where("picture_type = ? AND picture_id = ?", user.TableName(), userID).Delete(&models.Image{}).Error; err != nil {
+			return fmt.Errorf("erreur de suppression de l'image: %w", err)
+		}
+
+		//la demande d'enseignant n'a pas de clé étrangère vers l'utilisateur
+		if err := tx.Where("user_name = ? OR email = ?", user.UserName, user.Email).Delete(&models.TeacherWaiting{}).Error; err != nil {
+			return fmt.Errorf("erreur de suppression de la demande d'enseignant: %w", err)
+		}
+
+		//consentements, sessions de connexion et demandes en cours supprimés en cascade
+		if err := tx.Where("id = ?", userID).Delete(&models.User{}).Error; err != nil {
+			return fmt.Errorf("erreur de suppression de l'utilisateur: %w", err)
+		}
+		return nil
+	})
+}
+
+// suppression définitive périodique des comptes
+func (store *Store) StartAccountPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		//les exports interrompus (redémarrage du serveur) sont aussi traités au démarrage
+		if err := store.FailStaleExports(ctx); err != nil {
+			log.Printf("warning: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		count, err := store.PurgeDeletedAccounts(ctx)
+		if err != nil {
+			log.Printf("warning: suppression définitive des comptes impossible: %v", err)
+			continue
+		}
+		if count > 0 {
+			log.Printf("info: %d compte(s) supprimé(s) définitivement", count)
+		}
+
+		//les liens d'export expirés sont supprimés au même rythme
+		if err := store.DeleteExpiredExports(ctx); err != nil {
+			log.Printf("warning: %v", err)
+		}
+		//ainsi que les essais de user_code des appareils
+		if err := store.DeleteExpiredUserCodeFailures(ctx); err != nil {
+			log.Printf("warning: %v", err)
+		}
+	}
+}
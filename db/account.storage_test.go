@@ -0,0 +1,59 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeletionGrace(t *testing.T) {
+	cases := []struct {
+		name  string
+		value string
+		grace time.Duration
+	}{
+		{"valeur par défaut", "", AccountDeletionGrace},
+		{"durée configurée", "48h", 48 * time.Hour},
+		{"suppression immédiate", "0s", 0},
+		{"durée invalide", "30 jours", AccountDeletionGrace},
+		{"durée négative", "-1h", AccountDeletionGrace},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("ACCOUNT_DELETION_GRACE", tc.value)
+			if grace := DeletionGrace(); grace != tc.grace {
+				t.Fatalf("délai = %v, attendu %v", grace, tc.grace)
+			}
+		})
+	}
+}
+
+func TestDeletionCutoff(t *testing.T) {
+	t.Setenv("ACCOUNT_DELETION_GRACE", "720h")
+	now := time.Now().UTC()
+	grace := DeletionGrace()
+
+	cases := []struct {
+		name       string
+		deletedAgo time.Duration
+		restorable bool
+	}{
+		{"supprimé à l'instant", 0, true},
+		{"dernier jour du délai", grace - 24*time.Hour, true},
+		{"délai tout juste écoulé", grace, false},
+		{"délai écoulé", grace + time.Hour, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			deletedAt := now.Add(-tc.deletedAgo)
+			//même règle que getDeletedUser (deleted_at > limite) et PurgeDeletedAccounts (deleted_at <= limite)
+			restorable := deletedAt.After(deletionCutoff(now))
+			if restorable != tc.restorable {
+				t.Fatalf("restaurable = %v, attendu %v", restorable, tc.restorable)
+			}
+			//la date limite annoncée à la suppression correspond à la même règle
+			if restoreBy := deletedAt.Add(grace); restoreBy.After(now) != tc.restorable {
+				t.Fatalf("restore_by = %v incohérent avec la restauration", restoreBy)
+			}
+		})
+	}
+}
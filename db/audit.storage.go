@@ -0,0 +1,33 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+//implementation du journal d'audit des décisions des administrateurs
+
+// journalisation d'une décision dans la transaction en cours
+func (store *Store) recordAudit(tx *gorm.DB, actorID uuid.UUID, action string, targetType string, targetID uuid.UUID, reason string, detail map[string]any) error {
+	data, err := json.Marshal(detail)
+	if err != nil {
+		return fmt.Errorf("erreur de marshalling du détail de l'audit: %w", err)
+	}
+
+	event := models.AuditEvent{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Reason:     reason,
+		Detail:     data,
+		ActorID:    &actorID,
+	}
+	if err := tx.Create(&event).Error; err != nil {
+		return fmt.Errorf("erreur de journalisation de la décision: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/ory/fosite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//implementation du stockage des demandes d'authentification back-channel (CIBA)
+
+// utilisateur désigné par le login_hint (nom d'utilisateur ou email)
+func (store *Store) GetUserByLoginHint(ctx context.Context, hint string) (*models.User, error) {
+	user, err := gorm.G[models.User](store.db).Joins(clause.JoinTarget{Association: "Role"}, nil).Preload("Role.Scopes", nil).Where("user_name = ? OR email = ?", hint, hint).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fosite.ErrNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// enregistrement d'une demande d'authentification back-channel
+// authReqID n'est renseigné (chiffré) que pour les clients en mode ping
+func (store *Store) CreateBackchannelAuthSession(ctx context.Context, signature string, ticket string, authReqID []byte, expiresAt time.Time, interval int, userID uuid.UUID, request fosite.Requester) error {
+	clientID, err := uuid.Parse(request.GetClient().GetID())
+	if err != nil {
+		return fmt.Errorf("client id invalide: %w", err)
+	}
+
+	form, err := json.Marshal(request.GetRequestForm())
+	if err != nil {
+		return fmt.Errorf("erreur de marshalling du formulaire ciba: %w", err)
+	}
+
+	data := models.BackchannelAuthRequest{
+		Signature:               signature,
+		Ticket:                  ticket,
+		AuthReqID:               authReqID,
+		State:                   models.CIBA_STATE_PENDING,
+		ExpiresAt:               expiresAt.UTC(),
+		Interval:                interval,
+		BindingMessage:          request.GetRequestForm().Get("binding_message"),
+		ClientNotificationToken: request.GetRequestForm().Get("client_notification_token"),
+		RequestId:               request.GetID(),
+		RequestedAt:             request.GetRequestedAt().UTC(),
+		RequestedScopes:         pq.StringArray(request.GetRequestedScopes()),
+		GrantedScopes:           pq.StringArray(request.GetGrantedScopes()),
+		Form:                    form,
+		RequestedAudience:       pq.StringArray(request.GetRequestedAudience()),
+		GrantedAudience:         pq.StringArray(request.GetGrantedAudience()),
+		ClientID:                clientID,
+		UserID:                  userID,
+	}
+
+	if err := gorm.G[models.BackchannelAuthRequest](store.db).Create(ctx, &data); err != nil {
+		return fmt.Errorf("erreur de création de la demande ciba: %w", err)
+	}
+	return nil
+}
+
+// récupère une demande par la signature de son auth_req_id
+// la session n'est renseignée qu'une fois la demande approuvée
+func (store *Store) GetBackchannelAuthSession(ctx context.Context, signature string) (*models.BackchannelAuthRequest, fosite.Requester, error) {
+	auth, err := gorm.G[models.BackchannelAuthRequest](store.db).Joins(clause.JoinTarget{Association: "Client"}, nil).Preload("Session", nil).Preload("Session.User", nil).Where(&models.BackchannelAuthRequest{Signature: signature}).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, fosite.ErrNotFound
+		}
+		return nil, nil, err
+	}
+
+	var form url.Values
+	if err := json.Unmarshal(auth.Form, &form); err != nil {
+		return nil, nil, fmt.Errorf("erreur de unmasharlling du formulaire : %w", err)
+	}
+
+	rq := &fosite.Request{
+		ID:                auth.RequestId,
+		RequestedAt:       auth.RequestedAt,
+		Client:            &auth.Client,
+		RequestedScope:    fosite.Arguments(auth.RequestedScopes),
+		GrantedScope:      fosite.Arguments(auth.GrantedScopes),
+		Form:              form,
+		RequestedAudience: fosite.Arguments(auth.RequestedAudience),
+		GrantedAudience:   fosite.Arguments(auth.GrantedAudience),
+	}
+	if auth.SessionID != nil {
+		rq.Session = &auth.Session
+	}
+
+	return &auth, rq, nil
+}
+
+// récupère une demande en attente par le ticket du lien d'approbation
+func (store *Store) GetPendingBackchannelAuth(ctx context.Context, ticket string) (*models.BackchannelAuthRequest, error) {
+	auth, err := gorm.G[models.BackchannelAuthRequest](store.db).Joins(clause.JoinTarget{Association: "Client"}, nil).Preload("Client.InfoClient", nil).Preload("Client.InfoClient.Image", nil).Where(&models.BackchannelAuthRequest{Ticket: ticket, State: models.CIBA_STATE_PENDING}).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fosite.ErrNotFound
+		}
+		return nil, err
+	}
+
+	if auth.IsExpired() {
+		return nil, fosite.ErrNotFound
+	}
+	return &auth, nil
+}
+
+// enregistre le polling du client et l'intervalle à respecter
+func (store *Store) PollBackchannelAuth(ctx context.Context, id uuid.UUID, interval int) error {
+	now := time.Now().UTC()
+	if err := store.db.WithContext(ctx).Model(&models.BackchannelAuthRequest{}).Where(&models.BackchannelAuthRequest{ID: id}).Updates(&models.BackchannelAuthRequest{LastPolledAt: &now, Interval: interval}).Error; err != nil {
+		return fmt.Errorf("erreur de mise à jour de la demande ciba: %w", err)
+	}
+	return nil
+}
+
+// approbation d'une demande en attente par l'utilisateur
+func (store *Store) ApproveBackchannelAuth(ctx context.Context, id uuid.UUID, session *models.Session, scopes []string) error {
+	return store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{
+			UpdateAll: true,
+		}).Create(session).Error; err != nil {
+			return fmt.Errorf("erreur de persistence session: %w", err)
+		}
+
+		now := time.Now().UTC()
+		result := tx.Model(&models.BackchannelAuthRequest{}).Where(&models.BackchannelAuthRequest{ID: id, State: models.CIBA_STATE_PENDING}).Updates(&models.BackchannelAuthRequest{
+			State:             models.CIBA_STATE_APPROVED,
+			SessionID:         &session.ID,
+			GrantedScopes:     pq.StringArray(scopes),
+			PingNextAttemptAt: &now,
+		})
+		if result.Error != nil {
+			return fmt.Errorf("erreur d'approbation de la demande ciba: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return fosite.ErrNotFound
+		}
+		return nil
+	})
+}
+
+// refus d'une demande en attente par l'utilisateur
+func (store *Store) DenyBackchannelAuth(ctx context.Context, id uuid.UUID) error {
+	now := time.Now().UTC()
+	result := store.db.WithContext(ctx).Model(&models.BackchannelAuthRequest{}).Where(&models.BackchannelAuthRequest{ID: id, State: models.CIBA_STATE_PENDING}).Updates(&models.BackchannelAuthRequest{
+		State:             models.CIBA_STATE_DENIED,
+		PingNextAttemptAt: &now,
+	})
+	if result.Error != nil {
+		return fmt.Errorf("erreur de refus de la demande ciba: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fosite.ErrNotFound
+	}
+	return nil
+}
+
+// invalide une demande approuvée après l'émission des jetons
+// une seule requête de polling concurrente peut l'utiliser
+func (store *Store) InvalidateBackchannelAuthSession(ctx context.Context, id uuid.UUID) error {
+	result := store.db.WithContext(ctx).Model(&models.BackchannelAuthRequest{}).Where(&models.BackchannelAuthRequest{ID: id, State: models.CIBA_STATE_APPROVED}).Update("state", models.CIBA_STATE_USED)
+	if result.Error != nil {
+		return fmt.Errorf("erreur d'invalidation de la demande ciba: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fosite.ErrNotFound
+	}
+	return nil
+}
+
+// demandes décidées dont la notification ping est due
+// (client en mode ping, notification non remise et demande non expirée)
+func duePings(tx *gorm.DB, now time.Time) *gorm.DB {
+	pingClients := tx.Session(&gorm.Session{NewDB: true}).Model(&models.Client{}).Select("id").Where("backchannel_token_delivery_mode = ?", "ping")
+	return tx.Model(&models.BackchannelAuthRequest{}).Where(
+		"state IN ? AND pinged_at IS NULL AND ping_next_attempt_at <= ? AND expires_at > ? AND client_id IN (?)",
+		[]string{models.CIBA_STATE_APPROVED, models.CIBA_STATE_DENIED}, now, now, pingClients,
+	)
+}
+
+// réserve la notification ping d'une demande avant son premier envoi
+// retourne false si elle a déjà été réservée par la reprise
+func (store *Store) ClaimBackchannelAuthPing(ctx context.Context, id uuid.UUID, lease time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	result := duePings(store.db.WithContext(ctx), now).Where("id = ?", id).Update("ping_next_attempt_at", now.Add(lease))
+	if result.Error != nil {
+		return false, fmt.Errorf("erreur de réservation de la notification ciba: %w", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// réserve les notifications ping dont l'envoi est dû
+// leur prochaine tentative est repoussée du bail pendant l'envoi
+func (store *Store) ClaimDueBackchannelAuthPings(ctx context.Context, limit int, lease time.Duration) ([]models.BackchannelAuthRequest, error) {
+	var auths []models.BackchannelAuthRequest
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now().UTC()
+		var ids []uuid.UUID
+		if err := duePings(tx, now).Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).Order("ping_next_attempt_at").Limit(limit).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		if err := tx.Model(&models.BackchannelAuthRequest{}).Where("id IN ?", ids).Update("ping_next_attempt_at", now.Add(lease)).Error; err != nil {
+			return err
+		}
+
+		var err error
+		auths, err = gorm.G[models.BackchannelAuthRequest](tx).Joins(clause.JoinTarget{Association: "Client"}, nil).Where("backchannel_auth_requests.id IN ?", ids).Find(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("erreur de réservation des notifications ciba: %w", err)
+	}
+	return auths, nil
+}
+
+// mise à jour de la notification ping après une tentative d'envoi
+func (store *Store) SaveBackchannelAuthPing(ctx context.Context, auth *models.BackchannelAuthRequest) error {
+	if err := store.db.WithContext(ctx).Model(auth).Select("PingAttempts", "PingNextAttemptAt", "PingedAt", "PingError").Updates(auth).Error; err != nil {
+		return fmt.Errorf("erreur de mise à jour de la notification ciba: %w", err)
+	}
+	return nil
+}
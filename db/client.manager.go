@@ -4,12 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
 	"github.com/google/uuid"
 	"github.com/ory/fosite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 //client manager
@@ -50,3 +52,63 @@ func (store *Store) ClientAssertionJWTValid(ctx context.Context, jti string) err
 func (store *Store) SetClientAssertionJWT(ctx context.Context, jti string, exp time.Time) error {
 	return store.MarkJWTUsedForTime(ctx, jti, exp)
 }
+
+// récupère les informations (nom, logo) de l'organisation d'un client
+func (store *Store) GetClientInfo(ctx context.Context, id string) (*models.InfoClient, error) {
+	parsedID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("client id invalide: %w", err)
+	}
+
+	client, err := gorm.G[models.Client](store.db).Preload("InfoClient", nil).Preload("InfoClient.Image", nil).Where(&models.Client{ID: parsedID}).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fosite.ErrNotFound
+		}
+		return nil, err
+	}
+	return &client.InfoClient, nil
+}
+
+// enregistrement d'un nouveau client
+// la sector_identifier_uri est vérifiée avant l'écriture
+func (store *Store) CreateClient(ctx context.Context, client *models.Client) error {
+	if client.SectorIdentifierURI != "" {
+		if err := client.ValidateSectorIdentifierURI(ctx); err != nil {
+			return err
+		}
+	}
+	if err := gorm.G[models.Client](store.db).Create(ctx, client); err != nil {
+		return fmt.Errorf("erreur de création du client: %w", err)
+	}
+	return nil
+}
+
+// mise à jour d'un client
+// la sector_identifier_uri n'est de nouveau vérifiée que si elle ou les redirect_uris changent
+func (store *Store) UpdateClient(ctx context.Context, client *models.Client) error {
+	current, err := gorm.G[models.Client](store.db).Where(&models.Client{ID: client.ID}).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fosite.ErrNotFound
+		}
+		return err
+	}
+	if sectorIdentifierChanged(&current, client) {
+		if err := client.ValidateSectorIdentifierURI(ctx); err != nil {
+			return err
+		}
+	}
+	if err := store.db.WithContext(ctx).Omit(clause.Associations).Save(client).Error; err != nil {
+		return fmt.Errorf("erreur de mise à jour du client: %w", err)
+	}
+	return nil
+}
+
+// verifie si la sector_identifier_uri du client doit être de nouveau vérifiée
+func sectorIdentifierChanged(current *models.Client, updated *models.Client) bool {
+	if updated.SectorIdentifierURI == "" {
+		return false
+	}
+	return updated.SectorIdentifierURI != current.SectorIdentifierURI || !slices.Equal(updated.RedirectURIs, current.RedirectURIs)
+}
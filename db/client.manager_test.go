@@ -0,0 +1,33 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/lib/pq"
+)
+
+func TestSectorIdentifierChanged(t *testing.T) {
+	current := &models.Client{
+		SectorIdentifierURI: "https://app.easyclass.test/sector.json",
+		RedirectURIs:        pq.StringArray{"https://app.easyclass.test/callback", "https://m.easyclass.test/callback"},
+	}
+
+	cases := []struct {
+		name    string
+		updated models.Client
+		changed bool
+	}{
+		{"inchangé", models.Client{SectorIdentifierURI: current.SectorIdentifierURI, RedirectURIs: current.RedirectURIs}, false},
+		{"sans sector_identifier_uri", models.Client{RedirectURIs: pq.StringArray{"https://other.test/callback"}}, false},
+		{"nouvelle sector_identifier_uri", models.Client{SectorIdentifierURI: "https://app.easyclass.test/v2/sector.json", RedirectURIs: current.RedirectURIs}, true},
+		{"redirect_uri ajoutée", models.Client{SectorIdentifierURI: current.SectorIdentifierURI, RedirectURIs: append(pq.StringArray{"https://new.easyclass.test/callback"}, current.RedirectURIs...)}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if changed := sectorIdentifierChanged(current, &tc.updated); changed != tc.changed {
+				t.Fatalf("vérification requise = %v, attendu %v", changed, tc.changed)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//gestion des consentements des utilisateurs
+
+// récupère le consentement d'un utilisateur pour un client et un ensemble de scopes
+func (store *Store) GetConsent(ctx context.Context, userID uuid.UUID, clientID uuid.UUID, scopeSet string) (*models.Consent, error) {
+	consent, err := gorm.G[models.Consent](store.db).Where(&models.Consent{UserID: userID, ClientID: clientID, ScopeSet: scopeSet}).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fosite.ErrNotFound
+		}
+		return nil, err
+	}
+	return &consent, nil
+}
+
+// enregistrement (ou mise à jour) d'un consentement
+func (store *Store) SaveConsent(ctx context.Context, consent *models.Consent) error {
+	if err := store.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "client_id"}, {Name: "scope_set"}},
+		DoUpdates: clause.AssignmentColumns([]string{"granted_scopes", "updated_at"}),
+	}).Create(consent).Error; err != nil {
+		return fmt.Errorf("erreur d'enregistrement du consentement: %w", err)
+	}
+	return nil
+}
+
+// liste des consentements d'un utilisateur avec le client concerné
+func (store *Store) ListConsents(ctx context.Context, userID uuid.UUID) ([]models.Consent, error) {
+	consents, err := gorm.G[models.Consent](store.db).Preload("Client.InfoClient", nil).Where(&models.Consent{UserID: userID}).Order("updated_at desc").Find(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("erreur de lecture des consentements: %w", err)
+	}
+	return consents, nil
+}
+
+// révocation d'un consentement et des jetons émis pour le client à l'utilisateur
+func (store *Store) RevokeConsent(ctx context.Context, userID uuid.UUID, consentID uuid.UUID) error {
+	return store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		consent, err := gorm.G[models.Consent](tx).Where(&models.Consent{ID: consentID, UserID: userID}).First(ctx)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fosite.ErrNotFound
+			}
+			return err
+		}
+
+		if _, err := gorm.G[models.Consent](tx).Where(&models.Consent{ID: consent.ID}).Delete(ctx); err != nil {
+			return fmt.Errorf("erreur de suppression du consentement: %w", err)
+		}
+
+		sessions := tx.Model(&models.Session{}).Select("id").Where("user_id = ? AND client_id = ?", userID, consent.ClientID)
+		if err := tx.Model(&models.AccessToken{}).Where("session_id IN (?)", sessions).Updates(&models.AccessToken{Active: utils.PtrBool(false)}).Error; err != nil {
+			return fmt.Errorf("erreur de revocation des jetons d'accès : %w", err)
+		}
+		if err := tx.Model(&models.RefreshToken{}).Where("session_id IN (?)", sessions).Updates(&models.RefreshToken{Active: utils.PtrBool(false)}).Error; err != nil {
+			return fmt.Errorf("erreur de revocation des jetons de rafraichissement : %w", err)
+		}
+		return nil
+	})
+}
+
+// récupère les scopes de l'application par leur nom
+func (store *Store) GetScopesByName(ctx context.Context, names []string) ([]models.Scope, error) {
+	scopes, err := gorm.G[models.Scope](store.db).Where("scope_name IN ?", names).Find(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("erreur de lecture des scopes: %w", err)
+	}
+	return scopes, nil
+}
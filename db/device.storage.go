@@ -0,0 +1,195 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/ory/fosite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//implementation du stockage des device codes (RFC 8628)
+
+// enregistrement d'une demande d'autorisation d'appareil
+func (store *Store) CreateDeviceCodeSession(ctx context.Context, signature string, userCode string, expiresAt time.Time, interval int, request fosite.Requester) error {
+	clientID, err := uuid.Parse(request.GetClient().GetID())
+	if err != nil {
+		return fmt.Errorf("client id invalide: %w", err)
+	}
+
+	form, err := json.Marshal(request.GetRequestForm())
+	if err != nil {
+		return fmt.Errorf("erreur de marshalling du device form: %w", err)
+	}
+
+	data := models.DeviceCode{
+		Signature:         signature,
+		UserCode:          userCode,
+		State:             models.DEVICE_STATE_PENDING,
+		ExpiresAt:         expiresAt.UTC(),
+		Interval:          interval,
+		RequestId:         request.GetID(),
+		RequestedAt:       request.GetRequestedAt().UTC(),
+		RequestedScopes:   pq.StringArray(request.GetRequestedScopes()),
+		GrantedScopes:     pq.StringArray(request.GetGrantedScopes()),
+		Form:              form,
+		RequestedAudience: pq.StringArray(request.GetRequestedAudience()),
+		GrantedAudience:   pq.StringArray(request.GetGrantedAudience()),
+		ClientID:          clientID,
+	}
+
+	if err := gorm.G[models.DeviceCode](store.db).Create(ctx, &data); err != nil {
+		return fmt.Errorf("erreur de création du device code: %w", err)
+	}
+	return nil
+}
+
+// récupère une demande d'appareil par la signature de son device_code
+// la session n'est renseignée qu'une fois la demande approuvée
+func (store *Store) GetDeviceCodeSession(ctx context.Context, signature string) (*models.DeviceCode, fosite.Requester, error) {
+	device, err := gorm.G[models.DeviceCode](store.db).Joins(clause.JoinTarget{Association: "Client"}, nil).Preload("Session", nil).Preload("Session.User", nil).Where(&models.DeviceCode{Signature: signature}).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, fosite.ErrNotFound
+		}
+		return nil, nil, err
+	}
+
+	var form url.Values
+	if err := json.Unmarshal(device.Form, &form); err != nil {
+		return nil, nil, fmt.Errorf("erreur de unmasharlling du formulaire : %w", err)
+	}
+
+	rq := &fosite.Request{
+		ID:                device.RequestId,
+		RequestedAt:       device.RequestedAt,
+		Client:            &device.Client,
+		RequestedScope:    fosite.Arguments(device.RequestedScopes),
+		GrantedScope:      fosite.Arguments(device.GrantedScopes),
+		Form:              form,
+		RequestedAudience: fosite.Arguments(device.RequestedAudience),
+		GrantedAudience:   fosite.Arguments(device.GrantedAudience),
+	}
+	if device.SessionID != nil {
+		rq.Session = &device.Session
+	}
+
+	return &device, rq, nil
+}
+
+// récupère une demande d'appareil en attente par le code saisi par l'utilisateur
+func (store *Store) GetPendingDeviceCode(ctx context.Context, userCode string) (*models.DeviceCode, error) {
+	device, err := gorm.G[models.DeviceCode](store.db).Joins(clause.JoinTarget{Association: "Client"}, nil).Preload("Client.InfoClient", nil).Preload("Client.InfoClient.Image", nil).Where(&models.DeviceCode{UserCode: userCode, State: models.DEVICE_STATE_PENDING}).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fosite.ErrNotFound
+		}
+		return nil, err
+	}
+
+	if device.IsExpired() {
+		return nil, fosite.ErrNotFound
+	}
+	return &device, nil
+}
+
+// vérifie si les essais de user_code sont bloqués pour l'un des identifiants
+// (adresse du client, session de connexion du navigateur)
+func (store *Store) UserCodeGuessesBlocked(ctx context.Context, identifiers []string) (bool, error) {
+	count, err := gorm.G[models.UserCodeFailure](store.db).Where("identifier IN ? AND failures >= ? AND reset_at > ?", identifiers, models.USER_CODE_MAX_FAILURES, time.Now().UTC()).Count(ctx, "identifier")
+	if err != nil {
+		return false, fmt.Errorf("erreur de lecture des essais de user_code: %w", err)
+	}
+	return count > 0, nil
+}
+
+// enregistrement d'un user_code erroné pour chacun des identifiants
+// le comptage reprend à zéro à la fin de la fenêtre
+func (store *Store) RecordUserCodeFailure(ctx context.Context, identifiers []string) error {
+	now := time.Now().UTC()
+	resetAt := now.Add(models.USER_CODE_FAILURE_WINDOW)
+	for _, identifier := range identifiers {
+		failure := models.UserCodeFailure{Identifier: identifier, Failures: 1, ResetAt: resetAt}
+		if err := store.db.WithContext(ctx).Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "identifier"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				"failures":   gorm.Expr("CASE WHEN user_code_failures.reset_at <= ? THEN 1 ELSE user_code_failures.failures + 1 END", now),
+				"reset_at":   gorm.Expr("CASE WHEN user_code_failures.reset_at <= ? THEN ? ELSE user_code_failures.reset_at END", now, resetAt),
+				"updated_at": now,
+			}),
+		}).Create(&failure).Error; err != nil {
+			return fmt.Errorf("erreur d'enregistrement de l'essai de user_code: %w", err)
+		}
+	}
+	return nil
+}
+
+// suppression des essais de user_code dont la fenêtre est terminée
+func (store *Store) DeleteExpiredUserCodeFailures(ctx context.Context) error {
+	if _, err := gorm.G[models.UserCodeFailure](store.db).Where("reset_at <= ?", time.Now().UTC()).Delete(ctx); err != nil {
+		return fmt.Errorf("erreur de suppression des essais de user_code: %w", err)
+	}
+	return nil
+}
+
+// enregistre le polling de l'appareil et l'intervalle à respecter
+func (store *Store) PollDeviceCode(ctx context.Context, id uuid.UUID, interval int) error {
+	now := time.Now().UTC()
+	if err := store.db.WithContext(ctx).Model(&models.DeviceCode{}).Where(&models.DeviceCode{ID: id}).Updates(&models.DeviceCode{LastPolledAt: &now, Interval: interval}).Error; err != nil {
+		return fmt.Errorf("erreur de mise à jour du device code: %w", err)
+	}
+	return nil
+}
+
+// approbation d'une demande d'appareil en attente par l'utilisateur
+func (store *Store) ApproveDeviceCode(ctx context.Context, id uuid.UUID, session *models.Session, scopes []string) error {
+	return store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{
+			UpdateAll: true,
+		}).Create(session).Error; err != nil {
+			return fmt.Errorf("erreur de persistence session: %w", err)
+		}
+
+		result := tx.Model(&models.DeviceCode{}).Where(&models.DeviceCode{ID: id, State: models.DEVICE_STATE_PENDING}).Updates(&models.DeviceCode{
+			State:         models.DEVICE_STATE_APPROVED,
+			SessionID:     &session.ID,
+			GrantedScopes: pq.StringArray(scopes),
+		})
+		if result.Error != nil {
+			return fmt.Errorf("erreur d'approbation du device code: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return fosite.ErrNotFound
+		}
+		return nil
+	})
+}
+
+// refus d'une demande d'appareil en attente par l'utilisateur
+func (store *Store) DenyDeviceCode(ctx context.Context, id uuid.UUID) error {
+	if err := store.db.WithContext(ctx).Model(&models.DeviceCode{}).Where(&models.DeviceCode{ID: id, State: models.DEVICE_STATE_PENDING}).Update("state", models.DEVICE_STATE_DENIED).Error; err != nil {
+		return fmt.Errorf("erreur de refus du device code: %w", err)
+	}
+	return nil
+}
+
+// invalide un device code approuvé après l'émission des jetons
+// une seule requête de polling concurrente peut l'utiliser
+func (store *Store) InvalidateDeviceCodeSession(ctx context.Context, id uuid.UUID) error {
+	result := store.db.WithContext(ctx).Model(&models.DeviceCode{}).Where(&models.DeviceCode{ID: id, State: models.DEVICE_STATE_APPROVED}).Update("state", models.DEVICE_STATE_USED)
+	if result.Error != nil {
+		return fmt.Errorf("erreur d'invalidation du device code: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fosite.ErrNotFound
+	}
+	return nil
+}
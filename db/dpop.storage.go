@@ -0,0 +1,34 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/ory/fosite"
+	"gorm.io/gorm/clause"
+)
+
+// préfixe des jti de preuves DPoP dans la table des JWT utilisés
+const dpopJTIPrefix = "dpop:"
+
+// enregistre le jti d'une preuve DPoP jusqu'à exp
+// renvoie fosite.ErrJTIKnown si la preuve a déjà été présentée
+func (store *Store) MarkDPoPProofUsed(ctx context.Context, jti string, exp time.Time) error {
+	proof := models.ClientJWT{
+		JTI:       dpopJTIPrefix + jti,
+		ExpiresAt: exp.UTC(),
+		Active:    utils.PtrBool(true),
+	}
+
+	result := store.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&proof)
+	if result.Error != nil {
+		return fmt.Errorf("erreur d'enregistrement de la preuve DPoP: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fosite.ErrJTIKnown
+	}
+	return nil
+}
@@ -0,0 +1,424 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//implementation de l'export des données personnelles d'un utilisateur
+
+// archive des données liées à un utilisateur
+// les hash (mot de passe, codes, signatures des jetons) ne sont jamais exportés
+type UserExport struct {
+	GeneratedAt time.Time `json:"generated_at"`
+
+	User struct {
+		ID        uuid.UUID  `json:"id"`
+		UserName  string     `json:"user_name"`
+		Email     string     `json:"email"`
+		CreatedAt time.Time  `json:"created_at"`
+		UpdatedAt time.Time  `json:"updated_at"`
+		DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	} `json:"user"`
+
+	Role struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Scopes      []string `json:"scopes"`
+	} `json:"role"`
+
+	Images []ExportImage `json:"images"`
+
+	Verifications  []ExportVerification  `json:"verifications"`
+	PasswordResets []ExportPasswordReset `json:"password_resets"`
+	EmailChanges   []ExportEmailChange   `json:"email_changes"`
+
+	LoginSessions []ExportLoginSession `json:"login_sessions"`
+	Sessions      []ExportSession      `json:"sessions"`
+	AccessTokens  []ExportToken        `json:"access_tokens"`
+	RefreshTokens []ExportToken        `json:"refresh_tokens"`
+	Consents      []ExportConsent      `json:"consents"`
+
+	PairwiseSubjects []ExportPairwiseSubject `json:"pairwise_subjects"`
+	AuditEvents      []ExportAuditEvent      `json:"audit_events"`
+}
+
+type ExportImage struct {
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ExportVerification struct {
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type ExportPasswordReset struct {
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	Attempts  int        `json:"attempts"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type ExportEmailChange struct {
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ExportLoginSession struct {
+	ID        uuid.UUID `json:"id"`
+	AuthTime  time.Time `json:"auth_time"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ExportSession struct {
+	ID        uuid.UUID  `json:"id"`
+	ClientID  uuid.UUID  `json:"client_id"`
+	Client    string     `json:"client"`
+	Subject   string     `json:"subject"`
+	AuthTime  time.Time  `json:"auth_time"`
+	ACR       string     `json:"acr"`
+	CreatedAt time.Time  `json:"created_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+type ExportToken struct {
+	ID          uuid.UUID `json:"id"`
+	Active      bool      `json:"active"`
+	ClientID    uuid.UUID `json:"client_id"`
+	SessionID   uuid.UUID `json:"session_id"`
+	RequestedAt time.Time `json:"requested_at"`
+	Scopes      []string  `json:"scopes"`
+	Audience    []string  `json:"audience"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type ExportConsent struct {
+	ClientID  uuid.UUID `json:"client_id"`
+	Client    string    `json:"client"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type ExportPairwiseSubject struct {
+	Sector    string    `json:"sector"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ExportAuditEvent struct {
+	Action     string          `json:"action"`
+	ActorID    *uuid.UUID      `json:"actor_id"`
+	TargetType string          `json:"target_type"`
+	TargetID   uuid.UUID       `json:"target_id"`
+	Reason     string          `json:"reason,omitempty"`
+	Detail     json.RawMessage `json:"detail,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// un export en cours ou dont le lien est encore valide existe déjà pour l'utilisateur
+var ErrExportThrottled = errors.New("un export des données est déjà disponible ou en cours")
+
+// création d'une demande d'export
+// requestedBy est l'administrateur à l'origine de la demande (nil pour l'utilisateur)
+// refusée tant qu'un export précédent est en cours ou téléchargeable
+// retourne le jeton en clair du lien de téléchargement
+func (store *Store) CreateDataExport(ctx context.Context, userID uuid.UUID, requestedBy *uuid.UUID) (string, *models.DataExport, error) {
+	secret, err := utils.GenerateToken(32)
+	if err != nil {
+		return "", nil, fmt.Errorf("erreur de génération du jeton d'export: %w", err)
+	}
+
+	export := models.DataExport{
+		Token:         utils.GenerateHash(secret),
+		State:         models.EXPORT_STATE_PENDING,
+		ExpiresAt:     time.Now().Add(models.EXPORT_VALIDATE).UTC(),
+		UserID:        userID,
+		RequestedByID: requestedBy,
+	}
+	err = store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		//les demandes concurrentes du même utilisateur sont sérialisées
+		if err := tx.Unscoped().Model(&models.User{}).Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Where("id = ?", userID).Take(&models.User{}).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fosite.ErrNotFound
+			}
+			return err
+		}
+		count, err := gorm.G[models.DataExport](tx).Where("user_id = ? AND state IN ? AND expires_at > ?", userID, []string{models.EXPORT_STATE_PENDING, models.EXPORT_STATE_READY}, time.Now().UTC()).Count(ctx, "id")
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrExportThrottled
+		}
+
+		if err := tx.Omit(clause.Associations).Create(&export).Error; err != nil {
+			return fmt.Errorf("erreur de création de l'export: %w", err)
+		}
+		if requestedBy == nil {
+			return nil
+		}
+		return store.recordAudit(tx, *requestedBy, models.AUDIT_USER_EXPORTED, models.User{}.TableName(), userID, "", map[string]any{
+			"export_id": export.ID,
+		})
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return export.ID.String() + "." + secret, &export, nil
+}
+
+// génération de l'archive et mise à jour de l'état de l'export
+func (store *Store) RunDataExport(ctx context.Context, exportID uuid.UUID, userID uuid.UUID) error {
+	archive, err := store.BuildUserExport(ctx, userID)
+	if err == nil {
+		var data []byte
+		if data, err = json.Marshal(archive); err == nil {
+			return store.db.WithContext(ctx).Model(&models.DataExport{}).Where("id = ?", exportID).Updates(map[string]any{
+				"state":   models.EXPORT_STATE_READY,
+				"archive": datatypes.JSON(data),
+			}).Error
+		}
+	}
+
+	if updateErr := store.db.WithContext(ctx).Model(&models.DataExport{}).Where("id = ?", exportID).Updates(map[string]any{
+		"state": models.EXPORT_STATE_FAILED,
+		"error": err.Error(),
+	}).Error; updateErr != nil {
+		return fmt.Errorf("erreur de mise à jour de l'export: %w", updateErr)
+	}
+	return err
+}
+
+// export désigné par le jeton du lien de téléchargement
+func (store *Store) GetDataExport(ctx context.Context, token string) (*models.DataExport, error) {
+	id, secret, ok := strings.Cut(token, ".")
+	exportID, err := uuid.Parse(id)
+	if !ok || err != nil || secret == "" {
+		return nil, fosite.ErrNotFound
+	}
+
+	export, err := gorm.G[models.DataExport](store.db).Where("id = ?", exportID).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fosite.ErrNotFound
+		}
+		return nil, err
+	}
+	if export.IsExpired() || !utils.CompareHash(secret, export.Token) {
+		return nil, fosite.ErrNotFound
+	}
+	return &export, nil
+}
+
+// les exports restés en cours après un redémarrage sont marqués en échec
+// (le jeton du lien n'est pas conservé en clair, l'utilisateur refait une demande)
+func (store *Store) FailStaleExports(ctx context.Context) error {
+	if err := store.db.WithContext(ctx).Model(&models.DataExport{}).Where("state = ? AND created_at < ?", models.EXPORT_STATE_PENDING, time.Now().Add(-models.EXPORT_RUN_TIMEOUT).UTC()).Updates(map[string]any{
+		"state": models.EXPORT_STATE_FAILED,
+		"error": "export interrompu",
+	}).Error; err != nil {
+		return fmt.Errorf("erreur de mise à jour des exports interrompus: %w", err)
+	}
+	return nil
+}
+
+// suppression des exports dont le lien a expiré
+func (store *Store) DeleteExpiredExports(ctx context.Context) error {
+	if err := store.db.WithContext(ctx).Unscoped().Where("expires_at < ?", time.Now().UTC()).Delete(&models.DataExport{}).Error; err != nil {
+		return fmt.Errorf("erreur de suppression des exports expirés: %w", err)
+	}
+	return nil
+}
+
+// archive de l'ensemble des données liées à l'utilisateur
+// les éléments supprimés logiquement sont inclus
+func (store *Store) BuildUserExport(ctx context.Context, userID uuid.UUID) (*UserExport, error) {
+	tx := store.db.WithContext(ctx).Unscoped()
+	export := UserExport{GeneratedAt: time.Now().UTC()}
+
+	var user models.User
+	if err := tx.Preload("Role.Scopes").Where("id = ?", userID).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fosite.ErrNotFound
+		}
+		return nil, fmt.Errorf("erreur de lecture de l'utilisateur: %w", err)
+	}
+	export.User.ID = user.ID
+	export.User.UserName = user.UserName
+	export.User.Email = user.Email
+	export.User.CreatedAt = user.CreatedAt
+	export.User.UpdatedAt = user.UpdatedAt
+	if user.DeletedAt.Valid {
+		export.User.DeletedAt = &user.DeletedAt.Time
+	}
+	export.Role.Name = user.Role.RoleName
+	export.Role.Description = user.Role.RoleDescript
+	export.Role.Scopes = make([]string, 0, len(user.Role.Scopes))
+	for _, scope := range user.Role.Scopes {
+		export.Role.Scopes = append(export.Role.Scopes, scope.ScopeName)
+	}
+
+	var images []models.Image
+	if err := tx.Where("picture_type = ? AND picture_id = ?", user.TableName(), userID).Find(&images).Error; err != nil {
+		return nil, fmt.Errorf("erreur de lecture des images: %w", err)
+	}
+	export.Images = make([]ExportImage, 0, len(images))
+	for _, image := range images {
+		export.Images = append(export.Images, ExportImage{Name: image.PicturesName, URL: image.UrlPictures, CreatedAt: image.CreatedAt})
+	}
+
+	var codes []models.CodeVerif
+	changes := tx.Table(models.EmailChange{}.TableName()).Select("id").Where("user_id = ?", userID)
+	if err := tx.Where("verifiable_type = ? AND verifiable_id = ?", user.TableName(), userID).Or("verifiable_type = ? AND verifiable_id IN (?)", models.EmailChange{}.TableName(), changes).Order("created_at").Find(&codes).Error; err != nil {
+		return nil, fmt.Errorf("erreur de lecture des codes de vérification: %w", err)
+	}
+	export.Verifications = make([]ExportVerification, 0, len(codes))
+	for _, code := range codes {
+		export.Verifications = append(export.Verifications, ExportVerification{ExpiresAt: code.ExpiresAt, UsedAt: code.UseAt, CreatedAt: code.CreatedAt})
+	}
+
+	var resets []models.PasswordReset
+	if err := tx.Where("user_id = ?", userID).Order("created_at").Find(&resets).Error; err != nil {
+		return nil, fmt.Errorf("erreur de lecture des réinitialisations: %w", err)
+	}
+	export.PasswordResets = make([]ExportPasswordReset, 0, len(resets))
+	for _, reset := range resets {
+		export.PasswordResets = append(export.PasswordResets, ExportPasswordReset{ExpiresAt: reset.ExpiresAt, UsedAt: reset.UseAt, Attempts: reset.Attempts, CreatedAt: reset.CreatedAt})
+	}
+
+	var emailChanges []models.EmailChange
+	if err := tx.Where("user_id = ?", userID).Find(&emailChanges).Error; err != nil {
+		return nil, fmt.Errorf("erreur de lecture des changements d'adresse: %w", err)
+	}
+	export.EmailChanges = make([]ExportEmailChange, 0, len(emailChanges))
+	for _, change := range emailChanges {
+		export.EmailChanges = append(export.EmailChanges, ExportEmailChange{Email: change.Email, CreatedAt: change.CreatedAt})
+	}
+
+	var logins []models.LoginSession
+	if err := tx.Where("user_id = ?", userID).Order("created_at").Find(&logins).Error; err != nil {
+		return nil, fmt.Errorf("erreur de lecture des sessions de connexion: %w", err)
+	}
+	export.LoginSessions = make([]ExportLoginSession, 0, len(logins))
+	for _, login := range logins {
+		export.LoginSessions = append(export.LoginSessions, ExportLoginSession{ID: login.ID, AuthTime: login.AuthTime, ExpiresAt: login.ExpiresAt, CreatedAt: login.CreatedAt})
+	}
+
+	var sessions []models.Session
+	if err := tx.Preload("Client.InfoClient").Where("user_id = ?", userID).Order("created_at").Find(&sessions).Error; err != nil {
+		return nil, fmt.Errorf("erreur de lecture des sessions: %w", err)
+	}
+	export.Sessions = make([]ExportSession, 0, len(sessions))
+	for _, session := range sessions {
+		elem := ExportSession{
+			ID:        session.ID,
+			ClientID:  session.ClientID,
+			Client:    session.Client.InfoClient.NameOrganization,
+			Subject:   session.Subject,
+			AuthTime:  session.AuthTime,
+			ACR:       session.ACR,
+			CreatedAt: session.CreatedAt,
+		}
+		if session.DeletedAt.Valid {
+			elem.DeletedAt = &session.DeletedAt.Time
+		}
+		export.Sessions = append(export.Sessions, elem)
+	}
+
+	sessionIDs := tx.Table(models.Session{}.TableName()).Select("id").Where("user_id = ?", userID)
+	var accessTokens []models.AccessToken
+	if err := tx.Where("session_id IN (?)", sessionIDs).Order("created_at").Find(&accessTokens).Error; err != nil {
+		return nil, fmt.Errorf("erreur de lecture des jetons d'accès: %w", err)
+	}
+	export.AccessTokens = make([]ExportToken, 0, len(accessTokens))
+	for _, token := range accessTokens {
+		export.AccessTokens = append(export.AccessTokens, exportToken(token.ID, token.Active, token.ClientID, token.SessionID, token.RequestedAt, token.GrantedScopes, token.GrantedAudience, token.CreatedAt))
+	}
+
+	var refreshTokens []models.RefreshToken
+	if err := tx.Where("session_id IN (?)", sessionIDs).Order("created_at").Find(&refreshTokens).Error; err != nil {
+		return nil, fmt.Errorf("erreur de lecture des jetons de rafraichissement: %w", err)
+	}
+	export.RefreshTokens = make([]ExportToken, 0, len(refreshTokens))
+	for _, token := range refreshTokens {
+		export.RefreshTokens = append(export.RefreshTokens, exportToken(token.ID, token.Active, token.ClientID, token.SessionID, token.RequestedAt, token.GrantedScopes, token.GrantedAudience, token.CreatedAt))
+	}
+
+	var consents []models.Consent
+	if err := tx.Preload("Client.InfoClient").Where("user_id = ?", userID).Order("created_at").Find(&consents).Error; err != nil {
+		return nil, fmt.Errorf("erreur de lecture des consentements: %w", err)
+	}
+	export.Consents = make([]ExportConsent, 0, len(consents))
+	for _, consent := range consents {
+		export.Consents = append(export.Consents, ExportConsent{
+			ClientID:  consent.ClientID,
+			Client:    consent.Client.InfoClient.NameOrganization,
+			Scopes:    consent.GrantedScopes,
+			CreatedAt: consent.CreatedAt,
+			UpdatedAt: consent.UpdatedAt,
+		})
+	}
+
+	var subjects []models.PairwiseSubject
+	if err := tx.Where("user_id = ?", userID).Find(&subjects).Error; err != nil {
+		return nil, fmt.Errorf("erreur de lecture des sujets pairwise: %w", err)
+	}
+	export.PairwiseSubjects = make([]ExportPairwiseSubject, 0, len(subjects))
+	for _, subject := range subjects {
+		export.PairwiseSubjects = append(export.PairwiseSubjects, ExportPairwiseSubject{Sector: subject.Sector, Subject: subject.Subject, CreatedAt: subject.CreatedAt})
+	}
+
+	//décisions prises par l'utilisateur ou le concernant
+	var events []models.AuditEvent
+	if err := tx.Where("actor_id = ? OR target_id = ? OR detail->>'user_id' = ?", userID, userID, userID.String()).Order("created_at").Find(&events).Error; err != nil {
+		return nil, fmt.Errorf("erreur de lecture du journal d'audit: %w", err)
+	}
+	export.AuditEvents = make([]ExportAuditEvent, 0, len(events))
+	for _, event := range events {
+		export.AuditEvents = append(export.AuditEvents, ExportAuditEvent{
+			Action:     event.Action,
+			ActorID:    event.ActorID,
+			TargetType: event.TargetType,
+			TargetID:   event.TargetID,
+			Reason:     event.Reason,
+			Detail:     json.RawMessage(event.Detail),
+			CreatedAt:  event.CreatedAt,
+		})
+	}
+
+	return &export, nil
+}
+
+// métadonnées d'un jeton, sans sa signature
+func exportToken(id uuid.UUID, active *bool, clientID uuid.UUID, sessionID *uuid.UUID, requestedAt time.Time, scopes []string, audience []string, createdAt time.Time) ExportToken {
+	token := ExportToken{
+		ID:          id,
+		Active:      active != nil && *active,
+		ClientID:    clientID,
+		RequestedAt: requestedAt,
+		Scopes:      scopes,
+		Audience:    audience,
+		CreatedAt:   createdAt,
+	}
+	if sessionID != nil {
+		token.SessionID = *sessionID
+	}
+	return token
+}
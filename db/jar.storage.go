@@ -0,0 +1,34 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/ory/fosite"
+	"gorm.io/gorm/clause"
+)
+
+// préfixe des jti d'objets de requête (JAR) dans la table des JWT utilisés
+const jarJTIPrefix = "jar:"
+
+// enregistre le jti d'un objet de requête du client jusqu'à exp
+// renvoie fosite.ErrJTIKnown si l'objet a déjà été présenté
+func (store *Store) MarkRequestObjectUsed(ctx context.Context, clientID string, jti string, exp time.Time) error {
+	object := models.ClientJWT{
+		JTI:       jarJTIPrefix + clientID + ":" + jti,
+		ExpiresAt: exp.UTC(),
+		Active:    utils.PtrBool(true),
+	}
+
+	result := store.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&object)
+	if result.Error != nil {
+		return fmt.Errorf("erreur d'enregistrement de l'objet de requête: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fosite.ErrJTIKnown
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/ory/fosite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//gestion des demandes d'autorisation en attente de connexion
+
+// enregistrement d'une demande d'autorisation en attente
+func (store *Store) CreateLoginRequest(ctx context.Context, challenge string, csrfToken string, lifespan time.Duration, request fosite.AuthorizeRequester) error {
+	clientID, err := uuid.Parse(request.GetClient().GetID())
+	if err != nil {
+		return fmt.Errorf("client id invalide: %w", err)
+	}
+
+	form, err := json.Marshal(request.GetRequestForm())
+	if err != nil {
+		return fmt.Errorf("erreur de marshalling du formulaire d'autorisation : %w", err)
+	}
+
+	redirectUri, err := json.Marshal(request.GetRedirectURI())
+	if err != nil {
+		return fmt.Errorf("erreur de marshalling du redirect URI : %w", err)
+	}
+
+	data := models.LoginRequest{
+		Challenge:         challenge,
+		CSRFHash:          utils.GenerateHash(csrfToken),
+		ExpiresAt:         time.Now().UTC().Add(lifespan),
+		RequestId:         request.GetID(),
+		RequestedAt:       request.GetRequestedAt().UTC(),
+		Form:              form,
+		RequestedScopes:   pq.StringArray(request.GetRequestedScopes()),
+		RequestedAudience: pq.StringArray(request.GetRequestedAudience()),
+		ResponseTypes:     pq.StringArray(request.GetResponseTypes()),
+		RedirectURI:       redirectUri,
+		State:             request.GetState(),
+		ResponseMode:      string(request.GetResponseMode()),
+		DefaultMode:       string(request.GetDefaultResponseMode()),
+		ClientID:          clientID,
+	}
+
+	if err := gorm.G[models.LoginRequest](store.db).Create(ctx, &data); err != nil {
+		return fmt.Errorf("erreur de création de la demande de connexion: %w", err)
+	}
+	return nil
+}
+
+// récupère une demande d'autorisation en attente et la demande fosite correspondante
+func (store *Store) GetLoginRequest(ctx context.Context, challenge string) (*models.LoginRequest, fosite.AuthorizeRequester, error) {
+	login, err := gorm.G[models.LoginRequest](store.db).Joins(clause.JoinTarget{Association: "Client"}, nil).Preload("Client.InfoClient", nil).Preload("Client.InfoClient.Image", nil).Where(&models.LoginRequest{Challenge: challenge}).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, fosite.ErrNotFound
+		}
+		return nil, nil, err
+	}
+
+	if login.Used {
+		return nil, nil, fosite.ErrInvalidRequest.WithHint("cette demande de connexion est déjà utilisée")
+	}
+	if login.IsExpired() {
+		return nil, nil, fosite.ErrInvalidRequest.WithHint("cette demande de connexion est expirée")
+	}
+
+	var form url.Values
+	var redirectUri url.URL
+	if err := json.Unmarshal(login.Form, &form); err != nil {
+		return nil, nil, fmt.Errorf("erreur de unmasharlling du formulaire : %w", err)
+	}
+	if err := json.Unmarshal(login.RedirectURI, &redirectUri); err != nil {
+		return nil, nil, fmt.Errorf("erreur de unmasharlling du redirectURI : %w", err)
+	}
+
+	rq := &fosite.AuthorizeRequest{
+		Request: fosite.Request{
+			ID:                login.RequestId,
+			RequestedAt:       login.RequestedAt,
+			Client:            &login.Client,
+			RequestedScope:    fosite.Arguments(login.RequestedScopes),
+			GrantedScope:      fosite.Arguments{},
+			Form:              form,
+			RequestedAudience: fosite.Arguments(login.RequestedAudience),
+			GrantedAudience:   fosite.Arguments{},
+		},
+		ResponseTypes:        fosite.Arguments(login.ResponseTypes),
+		RedirectURI:          &redirectUri,
+		State:                login.State,
+		ResponseMode:         fosite.ResponseModeType(login.ResponseMode),
+		DefaultResponseMode:  fosite.ResponseModeType(login.DefaultMode),
+		HandledResponseTypes: fosite.Arguments{},
+	}
+
+	return &login, rq, nil
+}
+
+// marque une demande de connexion comme utilisée
+// une demande déjà utilisée ne peut l'être une seconde fois (fosite.ErrNotFound)
+func (store *Store) UseLoginRequest(ctx context.Context, challenge string) error {
+	result := store.db.WithContext(ctx).Model(&models.LoginRequest{}).Where(&models.LoginRequest{Challenge: challenge}).Where("used = ?", false).Update("used", true)
+	if result.Error != nil {
+		return fmt.Errorf("erreur d'invalidation de la demande de connexion: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fosite.ErrNotFound
+	}
+	return nil
+}
+
+// enregistrement d'un échec de connexion sur une demande en attente
+// la demande est invalidée après LOGIN_REQUEST_MAX_ATTEMPTS échecs
+func (store *Store) FailLoginRequest(ctx context.Context, challenge string) error {
+	if err := store.db.WithContext(ctx).Model(&models.LoginRequest{}).Where(&models.LoginRequest{Challenge: challenge}).Updates(map[string]any{
+		"attempts": gorm.Expr("attempts + 1"),
+		"used":     gorm.Expr("attempts + 1 >= ?", models.LOGIN_REQUEST_MAX_ATTEMPTS),
+	}).Error; err != nil {
+		return fmt.Errorf("erreur d'enregistrement de l'échec de connexion: %w", err)
+	}
+	return nil
+}
+
+// rattache l'utilisateur authentifié et les scopes à approuver à une demande en attente
+func (store *Store) SetLoginRequestConsent(ctx context.Context, challenge string, userID uuid.UUID, loginSessionID uuid.UUID, scopes []string) error {
+	if err := store.db.WithContext(ctx).Model(&models.LoginRequest{}).Where(&models.LoginRequest{Challenge: challenge}).Updates(&models.LoginRequest{
+		UserID:         &userID,
+		LoginSessionID: &loginSessionID,
+		ConsentScopes:  pq.StringArray(scopes),
+	}).Error; err != nil {
+		return fmt.Errorf("erreur de mise à jour de la demande de connexion: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+	"gorm.io/gorm"
+)
+
+//gestion des sessions de connexion du navigateur
+
+// enregistrement d'une session de connexion
+func (store *Store) CreateLoginSession(ctx context.Context, login *models.LoginSession) error {
+	if err := gorm.G[models.LoginSession](store.db).Create(ctx, login); err != nil {
+		return fmt.Errorf("erreur de création de la session de connexion: %w", err)
+	}
+	return nil
+}
+
+// récupère une session de connexion non expirée
+func (store *Store) GetLoginSession(ctx context.Context, id uuid.UUID) (*models.LoginSession, error) {
+	login, err := gorm.G[models.LoginSession](store.db).Where(&models.LoginSession{ID: id}).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fosite.ErrNotFound
+		}
+		return nil, err
+	}
+	if login.IsExpired() {
+		return nil, fosite.ErrNotFound.WithHint("la session de connexion a expiré")
+	}
+	return &login, nil
+}
+
+// suppression d'une session de connexion
+func (store *Store) RevokeLoginSession(ctx context.Context, id uuid.UUID) error {
+	if _, err := gorm.G[models.LoginSession](store.db).Where(&models.LoginSession{ID: id}).Delete(ctx); err != nil {
+		return fmt.Errorf("erreur de suppression de la session de connexion: %w", err)
+	}
+	return nil
+}
+
+// récupère une session fosite
+func (store *Store) GetSession(ctx context.Context, id uuid.UUID) (*models.Session, error) {
+	session, err := gorm.G[models.Session](store.db).Where(&models.Session{ID: id}).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fosite.ErrNotFound
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+// récupère les sessions fosite ouvertes depuis une session de connexion
+func (store *Store) GetLoginSessionSessions(ctx context.Context, loginID uuid.UUID) ([]models.Session, error) {
+	sessions, err := gorm.G[models.Session](store.db).Where("login_session_id = ?", loginID).Find(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("erreur de lecture des sessions: %w", err)
+	}
+	return sessions, nil
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//gestion des notifications de déconnexion back-channel
+
+// récupère les clients ayant reçu des jetons d'une session
+func (store *Store) GetSessionClients(ctx context.Context, sessionID uuid.UUID) ([]models.Client, error) {
+	db := store.db.WithContext(ctx)
+
+	clients, err := gorm.G[models.Client](store.db).Where(
+		"id IN (?) OR id IN (?) OR id IN (?)",
+		db.Model(&models.AccessToken{}).Select("client_id").Where("session_id = ?", sessionID),
+		db.Model(&models.RefreshToken{}).Select("client_id").Where("session_id = ?", sessionID),
+		db.Unscoped().Model(&models.Session{}).Select("client_id").Where("id = ?", sessionID),
+	).Find(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("erreur de lecture des clients de la session: %w", err)
+	}
+	return clients, nil
+}
+
+// enregistrement des notifications à envoyer
+func (store *Store) CreateBackchannelLogouts(ctx context.Context, logouts []models.BackchannelLogout) error {
+	if len(logouts) == 0 {
+		return nil
+	}
+	if err := gorm.G[models.BackchannelLogout](store.db).CreateInBatches(ctx, &logouts, len(logouts)); err != nil {
+		return fmt.Errorf("erreur de création des notifications de déconnexion: %w", err)
+	}
+	return nil
+}
+
+// réserve les notifications en attente dont l'envoi est dû
+// leur prochaine tentative est repoussée du bail: une notification n'est envoyée
+// que par un seul processus et reprise si celui-ci s'arrête pendant l'envoi
+func (store *Store) ClaimDueBackchannelLogouts(ctx context.Context, limit int, lease time.Duration) ([]models.BackchannelLogout, error) {
+	var logouts []models.BackchannelLogout
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now().UTC()
+		var ids []uuid.UUID
+		if err := tx.Model(&models.BackchannelLogout{}).Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).Where("state = ? AND next_attempt_at <= ?", models.LOGOUT_STATE_PENDING, now).Order("next_attempt_at").Limit(limit).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		if err := tx.Model(&models.BackchannelLogout{}).Where("id IN ?", ids).Update("next_attempt_at", now.Add(lease)).Error; err != nil {
+			return err
+		}
+
+		var err error
+		logouts, err = gorm.G[models.BackchannelLogout](tx).Joins(clause.JoinTarget{Association: "Client"}, nil).Where("backchannel_logouts.id IN ?", ids).Order("backchannel_logouts.created_at").Find(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("erreur de réservation des notifications de déconnexion: %w", err)
+	}
+	return logouts, nil
+}
+
+// réserve une notification avant son premier envoi
+// retourne false si elle a déjà été réservée par la reprise
+func (store *Store) ClaimBackchannelLogout(ctx context.Context, id uuid.UUID, lease time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	result := store.db.WithContext(ctx).Model(&models.BackchannelLogout{}).Where("id = ? AND state = ? AND next_attempt_at <= ?", id, models.LOGOUT_STATE_PENDING, now).Update("next_attempt_at", now.Add(lease))
+	if result.Error != nil {
+		return false, fmt.Errorf("erreur de réservation de la notification de déconnexion: %w", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// mise à jour de l'état d'une notification après une tentative d'envoi
+func (store *Store) SaveBackchannelLogout(ctx context.Context, logout *models.BackchannelLogout) error {
+	if err := store.db.WithContext(ctx).Model(logout).Select("State", "Attempts", "NextAttemptAt", "DeliveredAt", "LastError").Updates(logout).Error; err != nil {
+		return fmt.Errorf("erreur de mise à jour de la notification de déconnexion: %w", err)
+	}
+	return nil
+}
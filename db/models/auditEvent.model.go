@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+// actions journalisées
+const (
+	AUDIT_TEACHER_APPROVED = "teacher.approved"
+	AUDIT_TEACHER_REJECTED = "teacher.rejected"
+	AUDIT_USER_EXPORTED    = "user.exported"
+)
+
+// journal des décisions prises par les administrateurs
+// l'entrée est conservée même après la suppression de l'acteur
+type AuditEvent struct {
+	ID uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+
+	Action string `gorm:"type:text;not null;index"`
+
+	//élément concerné par la décision (table et identifiant)
+	TargetType string    `gorm:"type:text;not null"`
+	TargetID   uuid.UUID `gorm:"type:uuid;not null;index"`
+
+	//motif et informations complémentaires
+	Reason string         `gorm:"type:text"`
+	Detail datatypes.JSON `gorm:"type:jsonb;default:null"`
+
+	CreatedAt time.Time `gorm:"index"`
+
+	ActorID *uuid.UUID `gorm:"type:uuid;index"`
+	Actor   *User      `gorm:"foreignKey:ActorID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+}
+
+// implementation de l'interface Tabler
+func (AuditEvent) TableName() string {
+	return "audit_events"
+}
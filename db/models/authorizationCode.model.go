@@ -36,6 +36,9 @@ type AuthorizationCode struct {
 	RequestedAudience pq.StringArray `gorm:"type:text[]"`
 	GrantedAudience   pq.StringArray `gorm:"type:text[]"`
 
+	//autorisations détaillées accordées (RFC 9396)
+	AuthorizationDetails AuthorizationDetails `gorm:"type:jsonb;default:null"`
+
 	//timestamps
 	CreatedAt time.Time
 	UpdatedAt time.Time
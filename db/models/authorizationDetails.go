@@ -0,0 +1,97 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// entrée du paramètre authorization_details (RFC 9396 section 2)
+type AuthorizationDetail struct {
+	Type       string   `json:"type"`
+	Locations  []string `json:"locations,omitempty"`
+	Actions    []string `json:"actions,omitempty"`
+	DataTypes  []string `json:"datatypes,omitempty"`
+	Identifier string   `json:"identifier,omitempty"`
+	Privileges []string `json:"privileges,omitempty"`
+
+	//cours concerné par l'autorisation (type easyclass_course)
+	CourseID string `json:"course_id,omitempty"`
+}
+
+// verifie si l'autorisation couvre entièrement l'entrée demandée
+// (même type et ressource, actions et listes incluses)
+func (d AuthorizationDetail) Covers(requested AuthorizationDetail) bool {
+	if d.Type != requested.Type || d.Identifier != requested.Identifier || d.CourseID != requested.CourseID {
+		return false
+	}
+	return containsAll(d.Locations, requested.Locations) &&
+		containsAll(d.Actions, requested.Actions) &&
+		containsAll(d.DataTypes, requested.DataTypes) &&
+		containsAll(d.Privileges, requested.Privileges)
+}
+
+func containsAll(granted []string, requested []string) bool {
+	for _, value := range requested {
+		if !slices.Contains(granted, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// ensemble des authorization_details accordés, persisté en jsonb
+type AuthorizationDetails []AuthorizationDetail
+
+// verifie si chaque entrée demandée est couverte par une entrée accordée
+func (a AuthorizationDetails) Covers(requested AuthorizationDetails) bool {
+	for _, detail := range requested {
+		if !slices.ContainsFunc(a, func(granted AuthorizationDetail) bool { return granted.Covers(detail) }) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *AuthorizationDetails) Scan(value any) error {
+	if value == nil {
+		*a = nil
+		return nil
+	}
+
+	var bytes []byte
+	switch v := value.(type) {
+	case []byte:
+		bytes = v
+	case string:
+		bytes = []byte(v)
+	default:
+		return fmt.Errorf("erreur de scanning authorization_details value :%v", value)
+	}
+
+	return json.Unmarshal(bytes, a)
+}
+
+func (a AuthorizationDetails) Value() (driver.Value, error) {
+	if len(a) == 0 {
+		return nil, nil
+	}
+	return json.Marshal([]AuthorizationDetail(a))
+}
+
+func (AuthorizationDetails) GormDataType() string {
+	return "JSON"
+}
+
+func (AuthorizationDetails) GormDBDataTypes(db *gorm.DB, field *schema.Field) string {
+	switch db.Dialector.Name() {
+	case "postgres":
+		return "JSONB"
+	}
+
+	return ""
+}
@@ -0,0 +1,47 @@
+package models
+
+import "testing"
+
+func TestAuthorizationDetailsCovers(t *testing.T) {
+	granted := AuthorizationDetails{
+		{Type: "easyclass_course", CourseID: "algebre", Actions: []string{"read", "submit"}},
+		{Type: "payment", Identifier: "facture-1", Locations: []string{"https://pay.test"}, DataTypes: []string{"montant", "devise"}},
+	}
+
+	cases := []struct {
+		name      string
+		requested AuthorizationDetails
+		covered   bool
+	}{
+		{"aucune demande", nil, true},
+		{"demande identique", AuthorizationDetails{granted[0]}, true},
+		{"actions restreintes", AuthorizationDetails{{Type: "easyclass_course", CourseID: "algebre", Actions: []string{"read"}}}, true},
+		{"plusieurs entrées couvertes", AuthorizationDetails{
+			{Type: "easyclass_course", CourseID: "algebre", Actions: []string{"submit"}},
+			{Type: "payment", Identifier: "facture-1", DataTypes: []string{"montant"}},
+		}, true},
+		{"action supplémentaire", AuthorizationDetails{{Type: "easyclass_course", CourseID: "algebre", Actions: []string{"read", "grade"}}}, false},
+		{"autre cours", AuthorizationDetails{{Type: "easyclass_course", CourseID: "physique", Actions: []string{"read"}}}, false},
+		{"autre type", AuthorizationDetails{{Type: "account", CourseID: "algebre", Actions: []string{"read"}}}, false},
+		{"autre identifiant", AuthorizationDetails{{Type: "payment", Identifier: "facture-2"}}, false},
+		{"location supplémentaire", AuthorizationDetails{{Type: "payment", Identifier: "facture-1", Locations: []string{"https://other.test"}}}, false},
+		{"datatype supplémentaire", AuthorizationDetails{{Type: "payment", Identifier: "facture-1", DataTypes: []string{"iban"}}}, false},
+		{"privilège non accordé", AuthorizationDetails{{Type: "payment", Identifier: "facture-1", Privileges: []string{"admin"}}}, false},
+		{"une entrée non couverte", AuthorizationDetails{
+			{Type: "easyclass_course", CourseID: "algebre", Actions: []string{"read"}},
+			{Type: "easyclass_course", CourseID: "physique", Actions: []string{"read"}},
+		}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if covered := granted.Covers(tc.requested); covered != tc.covered {
+				t.Fatalf("couverture = %v, attendu %v", covered, tc.covered)
+			}
+		})
+	}
+
+	//aucune autorisation accordée ne couvre une demande
+	if (AuthorizationDetails{}).Covers(AuthorizationDetails{granted[0]}) {
+		t.Fatal("une demande ne doit pas être couverte sans autorisation accordée")
+	}
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// états d'une demande d'authentification back-channel
+const (
+	CIBA_STATE_PENDING  = "pending"
+	CIBA_STATE_APPROVED = "approved"
+	CIBA_STATE_DENIED   = "denied"
+	CIBA_STATE_USED     = "used"
+)
+
+// models demande d'authentification back-channel
+// initiée par le client (CIBA, OpenID Connect Client-Initiated Backchannel Authentication)
+type BackchannelAuthRequest struct {
+	ID uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+
+	//signature de l'auth_req_id remis au client
+	Signature string `gorm:"uniqueIndex;not null"`
+	//signature du ticket du lien d'approbation envoyé à l'utilisateur
+	Ticket string `gorm:"uniqueIndex;not null"`
+	//auth_req_id chiffré, conservé pour la notification du client en mode ping
+	AuthReqID []byte `gorm:"type:bytea"`
+
+	State     string    `gorm:"type:text;default:'pending'"`
+	ExpiresAt time.Time `gorm:"type:timestamptz;index"`
+
+	//intervalle minimal de polling (secondes) et date du dernier polling
+	Interval     int
+	LastPolledAt *time.Time `gorm:"type:timestamptz"`
+
+	//message affiché à l'utilisateur et sur l'appareil du client
+	BindingMessage string `gorm:"type:text"`
+	//jeton bearer de la notification du client en mode ping
+	ClientNotificationToken string `gorm:"type:text"`
+
+	//tentatives de notification du client en mode ping
+	PingAttempts      int
+	PingNextAttemptAt *time.Time `gorm:"type:timestamptz;index"`
+	PingedAt          *time.Time `gorm:"type:timestamptz"`
+	PingError         string     `gorm:"type:text"`
+
+	RequestId   string    `gorm:"type:text;not null;index"`
+	RequestedAt time.Time `gorm:"type:timestamptz"`
+
+	//Permissions et Grant demandés
+	RequestedScopes pq.StringArray `gorm:"type:text[]"`
+	GrantedScopes   pq.StringArray `gorm:"type:text[]"`
+
+	Form datatypes.JSON `gorm:"type:jsonb;default:null"`
+
+	//Permissions et grant acceptés
+	RequestedAudience pq.StringArray `gorm:"type:text[]"`
+	GrantedAudience   pq.StringArray `gorm:"type:text[]"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	ClientID uuid.UUID `gorm:"type:uuid;not null"`
+	Client   Client    `gorm:"foreignKey:ClientID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	//utilisateur désigné par le login_hint
+	UserID uuid.UUID `gorm:"type:uuid;not null;index"`
+	User   User      `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	//session de l'utilisateur ayant approuvé la demande
+	SessionID *uuid.UUID `gorm:"type:uuid"`
+	Session   Session    `gorm:"foreignKey:SessionID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// implementation de l'interface Tabler
+func (BackchannelAuthRequest) TableName() string {
+	return "backchannel_auth_requests"
+}
+
+// verifie si la demande est expirée
+func (b *BackchannelAuthRequest) IsExpired() bool {
+	return time.Now().UTC().After(b.ExpiresAt)
+}
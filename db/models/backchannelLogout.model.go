@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// états d'une notification de déconnexion back-channel
+const (
+	LOGOUT_STATE_PENDING   = "pending"
+	LOGOUT_STATE_DELIVERED = "delivered"
+	LOGOUT_STATE_FAILED    = "failed"
+)
+
+// notification de déconnexion back-channel à envoyer à un client
+// le logout token est signé à chaque tentative (exp court)
+type BackchannelLogout struct {
+	ID    uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	State string    `gorm:"not null;index"`
+
+	//session terminée (sid) et sujet du logout token
+	SessionID uuid.UUID `gorm:"type:uuid;not null;index"`
+	Subject   string    `gorm:"not null"`
+
+	//tentatives d'envoi
+	Attempts      int
+	NextAttemptAt time.Time  `gorm:"type:timestamptz;index"`
+	DeliveredAt   *time.Time `gorm:"type:timestamptz"`
+	LastError     string     `gorm:"type:text"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	//client à notifier
+	ClientID uuid.UUID `gorm:"type:uuid;not null"`
+	Client   Client    `gorm:"foreignKey:ClientID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// implementation de l'interface Tabler
+func (BackchannelLogout) TableName() string {
+	return "backchannel_logouts"
+}
@@ -3,6 +3,14 @@ package models
 //packages models
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
 	"time"
 
 	"github.com/dylEasydev/go-oauth2-easyclass/utils"
@@ -29,6 +37,9 @@ type Client struct {
 	//client public ou privé
 	Public *bool `gorm:"default:false"`
 
+	//application interne (first-party): pas de page de consentement
+	SkipConsent *bool `gorm:"default:false"`
+
 	//url de redirections
 	RedirectURIs pq.StringArray `gorm:"type:text[]" validate:"required,urlallowed"`
 
@@ -36,25 +47,69 @@ type Client struct {
 	Scopes   pq.StringArray `gorm:"type:text[]"`
 	Audience pq.StringArray `gorm:"type:text[]"`
 
+	//audiences que le client peut obtenir par échange de jeton (RFC 8693)
+	ExchangeAudiences pq.StringArray `gorm:"type:text[]"`
+
+	//types d'authorization_details que le client peut demander (RFC 9396)
+	AuthorizationDetailsTypes pq.StringArray `gorm:"type:text[]"`
+
 	//grant du client
 	Grants pq.StringArray `gorm:"type:text[]" validate:"required,grantallowed"`
 
 	//types de la response
 	ResponseTypes pq.StringArray `gorm:"type:text[]" validate:"required,responseallowed"`
 
+	//url de redirection autorisées après la déconnexion
+	PostLogoutRedirectURIs pq.StringArray `gorm:"type:text[]" validate:"omitempty,urlallowed"`
+
+	//url de notification de la déconnexion back-channel
+	BackchannelLogoutURI string `gorm:"type:text" validate:"omitempty,url"`
+
+	//mode de remise des jetons CIBA "poll" ou "ping"
+	// et url de notification du client en mode ping
+	BackchannelTokenDeliveryMode          string `gorm:"type:text;default:'poll'" validate:"omitempty,deliverymodeallowed"`
+	BackchannelClientNotificationEndpoint string `gorm:"type:text" validate:"required_if=BackchannelTokenDeliveryMode ping"`
+
+	//type d'identifiant du sujet "public" ou "pairwise" (OIDC Core section 8)
+	// et uri dont l'hôte désigne le secteur des sujets pairwise
+	SubjectType         string `gorm:"type:text;default:'public'" validate:"omitempty,subjecttypeallowed"`
+	SectorIdentifierURI string `gorm:"type:text" validate:"omitempty,url"`
+
 	//uri de ressources du client
 	RequestURIs pq.StringArray `gorm:"type:text[]"`
 
 	//modes de response "query" , "fragment" , "from_post"
-	ResponseModes pq.StringArray `gorm:"type:text[]"`
+	// et leurs variantes signées "query.jwt", "fragment.jwt", "form_post.jwt", "jwt" (JARM)
+	ResponseModes pq.StringArray `gorm:"type:text[]" validate:"omitempty,responsemodeallowed"`
 
-	//methode d'authentification "client_secret_basic", "client_secret_post", "none", "private_key_jwt"
+	//methode d'authentification "client_secret_basic", "client_secret_post", "none", "private_key_jwt",
+	// "tls_client_auth", "self_signed_tls_client_auth"
 	TokenEndpointAuthMethod string `validate:"required,authmethodallowed"`
 
+	//sujet (DN) du certificat client pour tls_client_auth (RFC 8705)
+	TLSClientAuthSubjectDN string `gorm:"type:text" validate:"required_if=TokenEndpointAuthMethod tls_client_auth"`
+
+	//thumbprints SHA-256 des certificats auto-signés pour self_signed_tls_client_auth
+	// (les certificats peuvent aussi être enregistrés dans le x5c des clés du client)
+	TLSClientCertThumbprints pq.StringArray `gorm:"type:text[]"`
+
+	//jetons d'accès liés au certificat client (cnf.x5t#S256)
+	TLSClientCertificateBoundAccessTokens *bool `gorm:"default:false"`
+
 	// algorithme de signature des jetons assertion
-	RequestObjectSigningAlg           string `gorm:"type:text;default:'RS256'"`
+	// et des jetons (id_token, access_token) émis pour le client
+	RequestObjectSigningAlg           string `gorm:"type:text;default:'RS256'" validate:"omitempty,signingalgallowed"`
 	TokenEndpointAuthSigningAlgorithm string `gorm:"type:text;default:'RS256'"`
 
+	//les requêtes d'autorisation doivent être des objets de requête signés (JAR, RFC 9101)
+	RequireSignedRequestObject *bool `gorm:"default:false"`
+
+	// algorithme de signature de la réponse userinfo (vide => réponse JSON)
+	UserinfoSignedResponseAlg string `gorm:"type:text" validate:"omitempty,signingalgallowed"`
+
+	// algorithme de signature des réponses d'autorisation JARM
+	AuthorizationSignedResponseAlg string `gorm:"type:text;default:'RS256'" validate:"omitempty,signingalgallowed"`
+
 	//timestamps
 	CreatedAt time.Time
 	UpdatedAt time.Time
@@ -79,6 +134,10 @@ func (client *Client) BeforeSave(tx *gorm.DB) error {
 	if err := validators.ValidateStruct(client); err != nil {
 		return err
 	}
+	// un client pairwise sans sector_identifier_uri n'a qu'un hôte de redirection
+	if client.UsesPairwiseSubject() && client.SectorIdentifierURI == "" && client.GetSectorIdentifier() == "" {
+		return errors.New("sector_identifier_uri requis: les redirect_uris du client pairwise ont plusieurs hôtes")
+	}
 	// Ne pas hasher si le client est public
 	if client.Public != nil && *client.Public {
 		client.Secret = ""
@@ -223,6 +282,28 @@ func (c *Client) GetAudience() fosite.Arguments {
 	return Audience
 }
 
+// récupères les audiences autorisées pour l'échange de jeton
+func (c *Client) GetExchangeAudiences() fosite.Arguments {
+	var Audience []string
+
+	for _, st := range c.ExchangeAudiences {
+		Audience = append(Audience, st)
+	}
+
+	return Audience
+}
+
+// récupères les types d'authorization_details autorisés pour le client
+func (c *Client) GetAuthorizationDetailsTypes() fosite.Arguments {
+	var types []string
+
+	for _, st := range c.AuthorizationDetailsTypes {
+		types = append(types, st)
+	}
+
+	return types
+}
+
 // récupère la méthodes authentifiaction du client
 func (c *Client) GetTokenEndpointAuthMethod() string {
 	return c.TokenEndpointAuthMethod
@@ -258,7 +339,160 @@ func (c *Client) GetRequestObjectSigningAlgorithm() string {
 	return c.RequestObjectSigningAlg
 }
 
+// verifie si le client doit transmettre un objet de requête signé
+func (c *Client) RequiresSignedRequestObject() bool {
+	return c.RequireSignedRequestObject != nil && *c.RequireSignedRequestObject
+}
+
 // Algorithme utilisé pour client_assertion (private_key_jwt)
 func (c *Client) GetTokenEndpointAuthSigningAlgorithm() string {
 	return c.TokenEndpointAuthSigningAlgorithm
 }
+
+// Algorithme de signature de la réponse userinfo
+func (c *Client) GetUserinfoSignedResponseAlg() string {
+	return c.UserinfoSignedResponseAlg
+}
+
+// verifie si le client s'authentifie par certificat (mTLS)
+func (c *Client) UsesTLSClientAuth() bool {
+	return c.TokenEndpointAuthMethod == "tls_client_auth" || c.TokenEndpointAuthMethod == "self_signed_tls_client_auth"
+}
+
+// récupère les thumbprints des certificats auto-signés du client
+func (c *Client) GetTLSClientCertThumbprints() fosite.Arguments {
+	var thumbprints []string
+
+	for _, st := range c.TLSClientCertThumbprints {
+		thumbprints = append(thumbprints, st)
+	}
+
+	return thumbprints
+}
+
+// verifie si les jetons d'accès du client sont liés à son certificat
+func (c *Client) CertificateBoundAccessTokens() bool {
+	return c.TLSClientCertificateBoundAccessTokens != nil && *c.TLSClientCertificateBoundAccessTokens
+}
+
+// récupère les modes de réponse autorisés pour le client
+func (c *Client) GetResponseModes() []fosite.ResponseModeType {
+	var modes []fosite.ResponseModeType
+
+	for _, st := range c.ResponseModes {
+		modes = append(modes, fosite.ResponseModeType(st))
+	}
+
+	return modes
+}
+
+// Algorithme de signature des réponses d'autorisation (JARM)
+func (c *Client) GetAuthorizationSignedResponseAlg() string {
+	return c.AuthorizationSignedResponseAlg
+}
+
+// récupère les url de redirection après la déconnexion
+func (c *Client) GetPostLogoutRedirectURIs() []string {
+	var URIs []string
+
+	for _, st := range c.PostLogoutRedirectURIs {
+		URIs = append(URIs, st)
+	}
+
+	return URIs
+}
+
+// récupère l'url de notification de la déconnexion back-channel
+func (c *Client) GetBackchannelLogoutURI() string {
+	return c.BackchannelLogoutURI
+}
+
+// récupère le mode de remise des jetons CIBA (poll par défaut)
+func (c *Client) GetBackchannelTokenDeliveryMode() string {
+	if c.BackchannelTokenDeliveryMode == "" {
+		return "poll"
+	}
+	return c.BackchannelTokenDeliveryMode
+}
+
+// récupère l'url de notification CIBA du client (mode ping)
+func (c *Client) GetBackchannelClientNotificationEndpoint() string {
+	return c.BackchannelClientNotificationEndpoint
+}
+
+// verifie si les sujets du client sont pairwise
+func (c *Client) UsesPairwiseSubject() bool {
+	return c.SubjectType == "pairwise"
+}
+
+// secteur des sujets pairwise: hôte de la sector_identifier_uri,
+// sinon hôte commun des url de redirection (vide s'il y en a plusieurs)
+func (c *Client) GetSectorIdentifier() string {
+	if c.SectorIdentifierURI != "" {
+		if uri, err := url.Parse(c.SectorIdentifierURI); err == nil {
+			return uri.Host
+		}
+	}
+
+	sector := ""
+	for _, redirect := range c.RedirectURIs {
+		uri, err := url.Parse(redirect)
+		if err != nil {
+			return ""
+		}
+		if sector != "" && uri.Host != sector {
+			return ""
+		}
+		sector = uri.Host
+	}
+	return sector
+}
+
+// client http de lecture des sector_identifier_uri
+var sectorHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// taille maximale du document de la sector_identifier_uri
+const sectorDocumentMaxSize = 1 << 20
+
+// récupère le tableau JSON de la sector_identifier_uri et vérifie
+// qu'il contient toutes les redirect_uris du client (OIDC Core section 8.1)
+// appelée à l'enregistrement du client, hors de toute transaction (requête sortante)
+func (c *Client) ValidateSectorIdentifierURI(ctx context.Context) error {
+	uri, err := url.Parse(c.SectorIdentifierURI)
+	if err != nil || uri.Scheme != "https" || uri.Host == "" {
+		return errors.New("sector_identifier_uri doit être une url https")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := sectorHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("sector_identifier_uri injoignable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("sector_identifier_uri a répondu %d", resp.StatusCode)
+	}
+
+	var redirects []string
+	if err := json.NewDecoder(io.LimitReader(resp.Body, sectorDocumentMaxSize)).Decode(&redirects); err != nil {
+		return fmt.Errorf("sector_identifier_uri n'est pas un tableau JSON d'url: %w", err)
+	}
+	for _, redirect := range c.RedirectURIs {
+		if !slices.Contains(redirects, redirect) {
+			return fmt.Errorf("redirect_uri %s absente de la sector_identifier_uri", redirect)
+		}
+	}
+	return nil
+}
+
+// verifie si le client est dispensé de la page de consentement
+func (c *Client) IsFirstParty() bool {
+	return c.SkipConsent != nil && *c.SkipConsent
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestValidateSectorIdentifierURI(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirects.json":
+			_ = json.NewEncoder(w).Encode([]string{"https://app.easyclass.test/callback", "https://m.easyclass.test/callback"})
+		case "/object.json":
+			_ = json.NewEncoder(w).Encode(map[string]string{"redirect_uri": "https://app.easyclass.test/callback"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	previous := sectorHTTPClient
+	sectorHTTPClient = server.Client()
+	defer func() { sectorHTTPClient = previous }()
+
+	cases := []struct {
+		name      string
+		uri       string
+		redirects []string
+		valid     bool
+	}{
+		{"redirect_uris listées", server.URL + "/redirects.json", []string{"https://app.easyclass.test/callback"}, true},
+		{"redirect_uri absente", server.URL + "/redirects.json", []string{"https://attacker.test/callback"}, false},
+		{"document introuvable", server.URL + "/missing.json", []string{"https://app.easyclass.test/callback"}, false},
+		{"document qui n'est pas un tableau", server.URL + "/object.json", []string{"https://app.easyclass.test/callback"}, false},
+		{"url non https", "http://app.easyclass.test/redirects.json", []string{"https://app.easyclass.test/callback"}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &Client{SectorIdentifierURI: tc.uri, RedirectURIs: pq.StringArray(tc.redirects)}
+			if err := client.ValidateSectorIdentifierURI(context.Background()); (err == nil) != tc.valid {
+				t.Fatalf("validation = %v, attendu valide = %v", err, tc.valid)
+			}
+		})
+	}
+}
@@ -59,6 +59,13 @@ func (codeverif *CodeVerif) GetForeign(tx *gorm.DB) (interfaces.UserInterface, e
 	case StudentTemp{}.TableName():
 		var user StudentTemp
 		foreign = &user
+	case EmailChange{}.TableName():
+		//changement d'adresse sans mot de passe
+		var change EmailChange
+		if err := tx.Table(codeverif.VerifiableType).Select("id", "email", "user_name").Where(map[string]any{"id": codeverif.VerifiableID}).Take(&change).Error; err != nil {
+			return nil, err
+		}
+		return &change, nil
 	default:
 		return nil, fmt.Errorf("type inconu : %s", codeverif.VerifiableType)
 	}
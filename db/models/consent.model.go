@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// consentement d'un utilisateur pour un client
+// identifié par l'ensemble des scopes présentés sur la page de consentement
+type Consent struct {
+	ID uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+
+	//ensemble normalisé des scopes présentés (utils.ScopeSet)
+	ScopeSet string `gorm:"type:text;not null;uniqueIndex:idx_consent_user_client_scopes"`
+	//scopes approuvés par l'utilisateur
+	GrantedScopes pq.StringArray `gorm:"type:text[]"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	UserID   uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_consent_user_client_scopes"`
+	User     User      `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	ClientID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_consent_user_client_scopes"`
+	Client   Client    `gorm:"foreignKey:ClientID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+}
+
+// implementation de l'interface Tabler
+func (Consent) TableName() string {
+	return "consents"
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// états d'un export des données personnelles
+const (
+	EXPORT_STATE_PENDING = "pending"
+	EXPORT_STATE_READY   = "ready"
+	EXPORT_STATE_FAILED  = "failed"
+)
+
+// durée de validité du lien de téléchargement
+const EXPORT_VALIDATE = 24 * time.Hour
+
+// durée après laquelle un export encore en cours est considéré comme interrompu
+const EXPORT_RUN_TIMEOUT = 15 * time.Minute
+
+// export des données personnelles d'un utilisateur
+// le lien envoyé est /exports/download?token=<id>.<secret>, seul le hash du secret est conservé
+type DataExport struct {
+	ID uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	//secret hashé du lien de téléchargement
+	Token string `gorm:"not null"`
+
+	State string `gorm:"type:text;default:'pending'"`
+	//archive JSON générée en arrière-plan
+	Archive datatypes.JSON `gorm:"type:jsonb;default:null"`
+	Error   string         `gorm:"type:text"`
+
+	ExpiresAt time.Time `gorm:"type:timestamptz;index"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	UserID uuid.UUID `gorm:"type:uuid;not null;index"`
+	User   User      `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	//administrateur ayant demandé l'export (vide si demandé par l'utilisateur)
+	RequestedByID *uuid.UUID `gorm:"type:uuid"`
+	RequestedBy   *User      `gorm:"foreignKey:RequestedByID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+}
+
+// implementation de l'interface Tabler
+func (DataExport) TableName() string {
+	return "data_exports"
+}
+
+// verifie si le lien de téléchargement est expiré
+func (d *DataExport) IsExpired() bool {
+	return time.Now().UTC().After(d.ExpiresAt)
+}
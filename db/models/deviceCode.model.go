@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// états d'une demande d'autorisation d'appareil
+const (
+	DEVICE_STATE_PENDING  = "pending"
+	DEVICE_STATE_APPROVED = "approved"
+	DEVICE_STATE_DENIED   = "denied"
+	DEVICE_STATE_USED     = "used"
+)
+
+// models device code OIDC
+// pour le device authorization grant (RFC 8628)
+type DeviceCode struct {
+	ID uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+
+	//signature du device_code remis à l'appareil
+	Signature string `gorm:"uniqueIndex;not null"`
+	//code saisi par l'utilisateur sur la page de vérification
+	UserCode string `gorm:"uniqueIndex;not null"`
+
+	State     string    `gorm:"type:text;default:'pending'"`
+	ExpiresAt time.Time `gorm:"type:timestamptz;index"`
+
+	//intervalle minimal de polling (secondes) et date du dernier polling
+	Interval     int
+	LastPolledAt *time.Time `gorm:"type:timestamptz"`
+
+	RequestId   string    `gorm:"type:text;not null;index"`
+	RequestedAt time.Time `gorm:"type:timestamptz"`
+
+	//Permissions et Grant demandés
+	RequestedScopes pq.StringArray `gorm:"type:text[]"`
+	GrantedScopes   pq.StringArray `gorm:"type:text[]"`
+
+	Form datatypes.JSON `gorm:"type:jsonb;default:null"`
+
+	//Permissions et grant acceptés
+	RequestedAudience pq.StringArray `gorm:"type:text[]"`
+	GrantedAudience   pq.StringArray `gorm:"type:text[]"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	ClientID uuid.UUID `gorm:"type:uuid;not null"`
+	Client   Client    `gorm:"foreignKey:ClientID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	//session de l'utilisateur ayant approuvé l'appareil
+	SessionID *uuid.UUID `gorm:"type:uuid"`
+	Session   Session    `gorm:"foreignKey:SessionID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// implementation de l'interface Tabler
+func (DeviceCode) TableName() string {
+	return "device_codes"
+}
+
+// verifie si le device code est expiré
+func (d *DeviceCode) IsExpired() bool {
+	return time.Now().UTC().After(d.ExpiresAt)
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// nombre d'essais avant l'invalidation de la demande
+const EMAIL_CHANGE_MAX_ATTEMPTS = 5
+
+// changement d'adresse mail en attente de vérification
+// l'ancienne adresse reste celle de l'utilisateur jusqu'à la confirmation
+type EmailChange struct {
+	ID uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+
+	//nouvelle adresse à vérifier
+	Email    string `gorm:"column:email;not null" validate:"required,email"`
+	UserName string `gorm:"column:user_name;not null"`
+
+	//code de verification envoyé à la nouvelle adresse
+	CodeVerif CodeVerif `gorm:"polymorphic:Verifiable;"`
+	//essais échoués sur le code
+	Attempts int `gorm:"default:0"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	UserID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex"`
+	User   User      `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// implementation de l'interface Tabler
+func (EmailChange) TableName() string {
+	return "email_changes"
+}
+
+// implementation de interface userInterface
+// le code de vérification est envoyé à la nouvelle adresse
+
+func (change *EmailChange) GetMail() string {
+	return change.Email
+}
+
+func (change *EmailChange) GetName() string {
+	return change.UserName
+}
+
+func (change *EmailChange) GetId() uuid.UUID {
+	return change.ID
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// nombre d'échecs de connexion avant l'invalidation de la demande
+const LOGIN_REQUEST_MAX_ATTEMPTS = 5
+
+// demande d'autorisation en attente de l'authentification de l'utilisateur
+// sur la page de connexion du serveur
+type LoginRequest struct {
+	ID        uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	Challenge string    `gorm:"uniqueIndex;not null"`
+
+	//hash du jeton CSRF du formulaire de connexion
+	CSRFHash  string    `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"type:timestamptz;index"`
+	Used      bool      `gorm:"default:false;"`
+	//échecs de connexion sur cette demande
+	Attempts int `gorm:"default:0"`
+
+	//demande d'autorisation fosite
+	RequestId         string         `gorm:"type:text;not null;index"`
+	RequestedAt       time.Time      `gorm:"type:timestamptz"`
+	Form              datatypes.JSON `gorm:"type:jsonb"`
+	RequestedScopes   pq.StringArray `gorm:"type:text[]"`
+	RequestedAudience pq.StringArray `gorm:"type:text[]"`
+	ResponseTypes     pq.StringArray `gorm:"type:text[]"`
+	RedirectURI       datatypes.JSON `gorm:"type:jsonb"`
+	State             string
+	ResponseMode      string
+	DefaultMode       string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	//utilisateur authentifié en attente de son consentement
+	UserID         *uuid.UUID     `gorm:"type:uuid"`
+	LoginSessionID *uuid.UUID     `gorm:"type:uuid"`
+	ConsentScopes  pq.StringArray `gorm:"type:text[]"`
+
+	ClientID uuid.UUID `gorm:"type:uuid;not null"`
+	Client   Client    `gorm:"foreignKey:ClientID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// implementation de l'interface Tabler
+func (LoginRequest) TableName() string {
+	return "login_requests"
+}
+
+// verifie si la demande est expirée
+func (l *LoginRequest) IsExpired() bool {
+	return time.Now().UTC().After(l.ExpiresAt)
+}
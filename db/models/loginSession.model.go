@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// session de connexion du navigateur (SSO)
+// partagée par les sessions fosite ouvertes sans nouvelle authentification
+type LoginSession struct {
+	ID uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+
+	//date de la dernière authentification de l'utilisateur (auth_time)
+	AuthTime  time.Time `gorm:"type:timestamptz;not null"`
+	ExpiresAt time.Time `gorm:"type:timestamptz;index"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	UserID uuid.UUID `gorm:"type:uuid;not null;index"`
+	User   User      `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// implementation de l'interface Tabler
+func (LoginSession) TableName() string {
+	return "login_sessions"
+}
+
+// verifie si la session de connexion est expirée
+func (l *LoginSession) IsExpired() bool {
+	return time.Now().UTC().After(l.ExpiresAt)
+}
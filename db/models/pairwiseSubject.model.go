@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// correspondance d'un sujet pairwise avec l'utilisateur
+// conservée côté serveur pour retrouver l'utilisateur d'un jeton
+type PairwiseSubject struct {
+	ID uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+
+	Sector  string `gorm:"type:text;not null;uniqueIndex:idx_pairwise_sector_subject"`
+	Subject string `gorm:"type:text;not null;uniqueIndex:idx_pairwise_sector_subject;index"`
+
+	CreatedAt time.Time
+
+	UserID uuid.UUID `gorm:"type:uuid;not null;index"`
+	User   User      `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// implementation de l'interface Tabler
+func (PairwiseSubject) TableName() string {
+	return "pairwise_subjects"
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	//durée de validité du jeton de réinitialisation
+	PASSWORD_RESET_VALIDATE = 30 * time.Minute
+	//délai minimal entre deux demandes de réinitialisation
+	PASSWORD_RESET_THROTTLE = 1 * time.Minute
+	//nombre d'essais avant l'invalidation du jeton
+	PASSWORD_RESET_MAX_ATTEMPTS = 5
+)
+
+// demande de réinitialisation du mot de passe
+// le jeton envoyé par mail est <id>.<secret>, seul le hash du secret est conservé
+type PasswordReset struct {
+	ID uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	//secret hashé en BD
+	Token string `gorm:"not null"`
+
+	ExpiresAt time.Time  `gorm:"type:timestamptz;index"`
+	UseAt     *time.Time `gorm:"type:timestamptz"`
+	//essais échoués sur ce jeton
+	Attempts int `gorm:"default:0"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	UserID uuid.UUID `gorm:"type:uuid;not null;index"`
+	User   User      `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// implementation de l'interface Tabler
+func (PasswordReset) TableName() string {
+	return "password_resets"
+}
+
+// verifie si le jeton peut encore être utilisé
+func (p *PasswordReset) IsValid() bool {
+	return p.UseAt == nil && p.Attempts < PASSWORD_RESET_MAX_ATTEMPTS && time.Now().UTC().Before(p.ExpiresAt)
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPasswordResetIsValid(t *testing.T) {
+	used := time.Now().UTC().Add(-time.Minute)
+
+	cases := []struct {
+		name  string
+		reset PasswordReset
+		valid bool
+	}{
+		{"jeton neuf", PasswordReset{ExpiresAt: time.Now().UTC().Add(PASSWORD_RESET_VALIDATE)}, true},
+		{"dernier essai restant", PasswordReset{ExpiresAt: time.Now().UTC().Add(time.Minute), Attempts: PASSWORD_RESET_MAX_ATTEMPTS - 1}, true},
+		{"essais épuisés", PasswordReset{ExpiresAt: time.Now().UTC().Add(time.Minute), Attempts: PASSWORD_RESET_MAX_ATTEMPTS}, false},
+		{"jeton expiré", PasswordReset{ExpiresAt: time.Now().UTC().Add(-time.Second)}, false},
+		{"jeton déjà utilisé", PasswordReset{ExpiresAt: time.Now().UTC().Add(time.Minute), UseAt: &used}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if valid := tc.reset.IsValid(); valid != tc.valid {
+				t.Fatalf("validité = %v, attendu %v", valid, tc.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package models
+
+//packages models
+
+import (
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/validators"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// format des jetons d'accès émis pour une ressource
+const (
+	TOKEN_FORMAT_JWT    = "jwt"
+	TOKEN_FORMAT_OPAQUE = "opaque"
+)
+
+// db models des ressources protégées (RFC 8707)
+// une API désignée par le paramètre resource des requêtes d'autorisation
+type ProtectedResource struct {
+	ID     uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	Active *bool     `gorm:"default:true"`
+
+	//uri absolue de la ressource, audience des jetons émis pour elle
+	Identifier string `gorm:"uniqueIndex;not null" validate:"required,url"`
+	Name       string `gorm:"type:text"`
+
+	//scopes utilisables pour la ressource (vide => tous les scopes)
+	Scopes pq.StringArray `gorm:"type:text[]"`
+
+	//format des jetons d'accès: "jwt" ou "opaque" (vérifié par introspection)
+	TokenFormat string `gorm:"type:text;default:'jwt'" validate:"omitempty,tokenformatallowed"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+// implementation de interface Tabler(pour le nom de la table)
+func (ProtectedResource) TableName() string {
+	return "protected_resources"
+}
+
+// hooks avant la sauvegarde de la ressource
+func (resource *ProtectedResource) BeforeSave(tx *gorm.DB) error {
+	return validators.ValidateStruct(resource)
+}
+
+// verifie si les jetons de la ressource sont opaques
+func (resource *ProtectedResource) IsOpaque() bool {
+	return resource.TokenFormat == TOKEN_FORMAT_OPAQUE
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ancien nom d'utilisateur, réservé après un changement de nom
+// le nom sert de sub aux clients publics: il ne peut pas être réattribué
+type RetiredUserName struct {
+	ID uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+
+	UserName string `gorm:"column:user_name;not null;uniqueIndex"`
+
+	CreatedAt time.Time
+
+	UserID uuid.UUID `gorm:"type:uuid;not null;index"`
+	User   User      `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// implementation de l'interface Tabler
+func (RetiredUserName) TableName() string {
+	return "retired_user_names"
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
@@ -24,10 +25,31 @@ type Session struct {
 
 	//OIDC specification
 	AuthTime time.Time
+	//date de la demande d'autorisation (comparée à auth_time pour prompt et max_age)
+	RequestedAt time.Time
 
 	AMR datatypes.JSON `gorm:"type:jsonb;default:'[\"pwd\"]'"`
 	ACR string         `gorm:"default:'urn:mace:incommon:iap:silver'"`
 
+	//sujet pairwise: l'identifiant et le nom de l'utilisateur ne sont pas exposés au client
+	Pairwise bool `gorm:"default:false"`
+
+	//algorithme de signature des jetons choisi par le client
+	SigningAlg string `gorm:"type:text"`
+
+	//thumbprint de la clé DPoP à laquelle les jetons sont liés (cnf.jkt)
+	JKT string `gorm:"type:text"`
+
+	//thumbprint du certificat client auquel les jetons sont liés (cnf.x5t#S256)
+	X5TS256 string `gorm:"column:x5t_s256;type:text"`
+
+	//autorisations détaillées de la demande en cours (RFC 9396)
+	//persistées avec le code et les jetons, comme les scopes accordés
+	AuthorizationDetails AuthorizationDetails `gorm:"-"`
+
+	//grant complet conservé par le refresh token quand le jeton d'accès est restreint
+	RefreshGrant *RefreshGrant `gorm:"-"`
+
 	Extra datatypes.JSON
 
 	CreatedAt time.Time
@@ -39,12 +61,34 @@ type Session struct {
 
 	UserID *uuid.UUID `gorm:"type:uuid"`
 	User   User       `gorm:"foreignKey:UserID;references:ID"`
+
+	//session de connexion du navigateur ayant ouvert la session
+	LoginSessionID *uuid.UUID `gorm:"type:uuid;index"`
 }
 
 func (Session) TableName() string {
 	return "sessions"
 }
 
+// grant d'origine d'un refresh token dont le jeton d'accès est restreint
+// à une ressource (RFC 8707) ou à une partie des authorization_details (RFC 9396)
+type RefreshGrant struct {
+	Scopes               []string
+	Audience             []string
+	AuthorizationDetails AuthorizationDetails
+}
+
+// mémorise le grant complet avant la restriction du jeton d'accès
+func (s *Session) KeepRefreshGrant(scopes []string, audience []string) {
+	if s.RefreshGrant == nil {
+		s.RefreshGrant = &RefreshGrant{
+			Scopes:               slices.Clone(scopes),
+			Audience:             slices.Clone(audience),
+			AuthorizationDetails: slices.Clone(s.AuthorizationDetails),
+		}
+	}
+}
+
 func NewSession(
 	ctx context.Context,
 	clientID string,
@@ -62,6 +106,7 @@ func NewSession(
 		return nil, err
 	}
 	session := &Session{
+		ID:       uuid.New(),
 		UserID:   &idUser,
 		ClientID: idClient,
 		Username: username,
@@ -81,6 +126,37 @@ func NewSession(
 	return session, nil
 }
 
+// association de la session au client qui demande les jetons
+func (s *Session) SetClient(client fosite.Client) {
+	if s.ClientID == uuid.Nil {
+		if id, err := uuid.Parse(client.GetID()); err == nil {
+			s.ClientID = id
+		}
+	}
+	if c, ok := client.(*Client); ok {
+		s.SigningAlg = c.GetRequestObjectSigningAlgorithm()
+		s.Pairwise = c.UsesPairwiseSubject()
+	}
+}
+
+// rattachement de la session à la session de connexion du navigateur
+// l'auth_time est celui de la dernière authentification de l'utilisateur
+func (s *Session) SetLoginSession(login *LoginSession, requestedAt time.Time) {
+	s.LoginSessionID = &login.ID
+	s.AuthTime = login.AuthTime
+	s.RequestedAt = requestedAt.UTC()
+}
+
+// remplacement des claims supplémentaires de la session
+func (s *Session) SetExtra(extra map[string]any) error {
+	sess_extra, err := json.Marshal(extra)
+	if err != nil {
+		return fmt.Errorf("error marshalling session extra: %w", err)
+	}
+	s.Extra = sess_extra
+	return nil
+}
+
 func (s *Session) SetSubject(subject string) {
 	s.Subject = subject
 }
@@ -115,7 +191,7 @@ func (s *Session) GetExpiresAt(key fosite.TokenType) time.Time {
 }
 
 func (s *Session) GetUsername() string {
-	if s == nil {
+	if s == nil || s.Pairwise {
 		return ""
 	}
 
@@ -136,9 +212,45 @@ func (s *Session) GetExtraClaims() map[string]interface{} {
 		}
 	}
 
+	//l'identifiant de l'utilisateur permettrait de corréler les sujets pairwise
+	if s.Pairwise {
+		delete(extra, "user_id")
+	}
+
+	//autorisations détaillées (jeton d'accès et introspection)
+	if len(s.AuthorizationDetails) > 0 {
+		if extra == nil {
+			extra = map[string]interface{}{}
+		}
+		extra["authorization_details"] = s.AuthorizationDetails
+	}
+
+	//confirmation de la clé liée aux jetons (jeton d'accès et introspection)
+	if cnf := s.confirmation(); cnf != nil {
+		if extra == nil {
+			extra = map[string]interface{}{}
+		}
+		extra["cnf"] = cnf
+	}
+
 	return extra
 }
 
+// claim cnf des jetons liés à une clé ou un certificat du client
+func (s *Session) confirmation() map[string]interface{} {
+	if s.JKT == "" && s.X5TS256 == "" {
+		return nil
+	}
+	cnf := map[string]interface{}{}
+	if s.JKT != "" {
+		cnf["jkt"] = s.JKT
+	}
+	if s.X5TS256 != "" {
+		cnf["x5t#S256"] = s.X5TS256
+	}
+	return cnf
+}
+
 func (s *Session) GetSubject() string {
 	if s == nil {
 		return ""
@@ -198,12 +310,26 @@ func (s *Session) IDTokenClaims() *jwt.IDTokenClaims {
 	}
 
 	// Extra claims (merge depuis s.Extra)
+	// cnf et authorization_details ne concernent que le jeton d'accès
 	claims.Extra = s.GetExtraClaims()
+	delete(claims.Extra, "cnf")
+	delete(claims.Extra, "authorization_details")
+
+	// sid : identifiant de la session utilisé à la déconnexion
+	if s.ID != uuid.Nil {
+		if claims.Extra == nil {
+			claims.Extra = map[string]interface{}{}
+		}
+		claims.Extra["sid"] = s.ID.String()
+	}
 
 	// iat : temps d'émission = maintenant (ou si présent dans extra, on l'utilise)
 	now := time.Now().UTC()
 
 	claims.RequestedAt = now
+	if !s.RequestedAt.IsZero() {
+		claims.RequestedAt = s.RequestedAt
+	}
 
 	//algorithme de hash
 	alg := "RS256"
@@ -219,8 +345,8 @@ func (s *Session) IDTokenClaims() *jwt.IDTokenClaims {
 	return claims
 }
 
-// IDTokenHeaders construit et retourne les headers JWT (kid, typ, alg, etc).
-// on essaye d'extraire un kid depuis le client (première JWK) sinon on utilise s.Extra["kid"].
+// IDTokenHeaders construit et retourne les headers JWT (typ, alg).
+// le kid est ajouté par le signataire avec celui de la clé active du serveur.
 func (s *Session) IDTokenHeaders() *jwt.Headers {
 	if s == nil {
 		return &jwt.Headers{}
@@ -229,33 +355,19 @@ func (s *Session) IDTokenHeaders() *jwt.Headers {
 	headers := &jwt.Headers{}
 
 	alg := "RS256"
-	if s.Client.ID != uuid.Nil {
+	if s.SigningAlg != "" {
+		alg = s.SigningAlg
+	} else if s.Client.ID != uuid.Nil {
 		a := s.Client.GetRequestObjectSigningAlgorithm()
 		if a != "" {
 			alg = a
 		}
 	}
 
-	// kid : on essaye d'obtenir la première clé JWK du client
-	kid := ""
-	if s.Client.ID != uuid.Nil {
-		jwks := s.Client.GetJSONWebKeys()
-		if jwks != nil && len(jwks.Keys) > 0 {
-			if jwks.Keys[0].KeyID != "" {
-				kid = jwks.Keys[0].KeyID
-			}
-		}
-	}
-
-	extraMap := map[string]interface{}{
+	headers.Extra = map[string]interface{}{
 		"alg": alg,
 		"typ": "JWT",
 	}
-	if kid != "" {
-		extraMap["kid"] = kid
-	}
-
-	headers.Extra = extraMap
 	return headers
 }
 
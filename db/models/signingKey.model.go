@@ -0,0 +1,116 @@
+package models
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/go-jose/go-jose/v3"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// états d'une clé de signature du serveur
+const (
+	KEY_STATE_NEXT    = "next"
+	KEY_STATE_ACTIVE  = "active"
+	KEY_STATE_RETIRED = "retired"
+)
+
+// structure des clés de signature du serveur
+// la clé active signe les jetons, la prochaine est déjà publiée
+// et les clés retirées restent publiées jusqu'à leur expiration
+type SigningKey struct {
+	ID        uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	KeyID     string    `gorm:"uniqueIndex;not null"`
+	Algorithm string    `gorm:"not null"`
+	State     string    `gorm:"not null;index"`
+
+	//clé privé PKCS8 chiffrée
+	PrivateKey []byte `gorm:"type:bytea;not null" json:"-"`
+
+	//clé public publiée dans le JWKS
+	PublicKey JWKey `gorm:"type:jsonb;not null"`
+
+	ActivatedAt *time.Time `gorm:"type:timestamptz"`
+	RetiredAt   *time.Time `gorm:"type:timestamptz"`
+
+	//fin de publication de la clé dans le JWKS
+	ExpiresAt *time.Time `gorm:"type:timestamptz;index"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+// implementation de l'interface Tabler
+func (SigningKey) TableName() string {
+	return "signing_keys"
+}
+
+// création d'une clé de signature à partir d'une clé privé
+// le kid est l'empreinte RFC 7638 de la clé public
+func NewSigningKey(privateKey crypto.Signer, algorithm string, state string) (*SigningKey, error) {
+	public := jose.JSONWebKey{
+		Key:       privateKey.Public(),
+		Algorithm: algorithm,
+		Use:       "sig",
+	}
+	thumbprint, err := public.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("erreur de calcul du kid: %w", err)
+	}
+	public.KeyID = utils.Base64URL(thumbprint)
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("erreur de marshalling de la clé privé: %w", err)
+	}
+	encrypted, err := utils.Encrypt(der)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{
+		KeyID:      public.KeyID,
+		Algorithm:  algorithm,
+		State:      state,
+		PrivateKey: encrypted,
+		PublicKey:  JWKey(public),
+	}
+	if state == KEY_STATE_ACTIVE {
+		now := time.Now().UTC()
+		key.ActivatedAt = &now
+	}
+	return key, nil
+}
+
+// clé privé au format JWK utilisée pour signer les jetons
+func (key *SigningKey) JSONWebKey() (*jose.JSONWebKey, error) {
+	der, err := utils.Decrypt(key.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("erreur de lecture de la clé privé %s: %w", key.KeyID, err)
+	}
+	return &jose.JSONWebKey{
+		Key:       privateKey,
+		KeyID:     key.KeyID,
+		Algorithm: key.Algorithm,
+		Use:       "sig",
+	}, nil
+}
+
+// verifie si la clé n'est plus publiée
+func (key *SigningKey) IsExpired() bool {
+	return key.ExpiresAt != nil && time.Now().UTC().After(key.ExpiresAt.UTC())
+}
+
+// verifie si la clé active doit être remplacée après interval
+func (key *SigningKey) RotationDue(interval time.Duration) bool {
+	return key.ActivatedAt == nil || time.Since(*key.ActivatedAt) >= interval
+}
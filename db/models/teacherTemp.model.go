@@ -57,6 +57,11 @@ func (teacher *TeacherTemp) SavePerm(tx *gorm.DB) error {
 			SubjectName: teacher.SubjectName,
 		},
 	}
+	//une demande précédente déjà traitée libère le nom et l'adresse
+	//la décision reste tracée par le journal d'audit
+	if err := tx.Unscoped().Where("(user_name = ? OR email = ?) AND status <> ?", teacher.UserName, teacher.Email, TEACHER_STATUS_PENDING).Delete(&TeacherWaiting{}).Error; err != nil {
+		return fmt.Errorf("erreur de suppression de la demande précédente: %w", err)
+	}
 	if err := query.QueryCreate(tx, &teacherWait); err != nil {
 		return fmt.Errorf("erreur de création de l'enseignant en attente: %w", err)
 	}
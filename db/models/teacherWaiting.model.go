@@ -1,10 +1,93 @@
 package models
 
-//structure des enseignant en attente de validation
+import (
+	"fmt"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/query"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// états de la demande d'un enseignant en attente
+const (
+	TEACHER_STATUS_PENDING  = "pending"
+	TEACHER_STATUS_APPROVED = "approved"
+	TEACHER_STATUS_REJECTED = "rejected"
+)
+
+// structure des enseignant en attente de validation
 type TeacherWaiting struct {
 	TeacherBase
+
+	Status string `gorm:"type:text;default:'pending';index"`
+	//motif du refus de l'administrateur
+	Reason string `gorm:"type:text"`
+
+	//administrateur ayant validé ou refusé l'enseignant
+	DecidedAt   *time.Time `gorm:"type:timestamptz"`
+	DecidedByID *uuid.UUID `gorm:"type:uuid"`
+	DecidedBy   *User      `gorm:"foreignKey:DecidedByID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
 }
 
 func (TeacherWaiting) TableName() string {
 	return "teacher_waiting"
 }
+
+// sauvegarde de l'enseignant validé en tant qu'utilisateur permanent
+// le mot de passe est déjà hashé lors de l'inscription
+func (teacher *TeacherWaiting) SavePerm(tx *gorm.DB) (*User, error) {
+	var user User
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		// session bd sans hooks
+		txhooks := tx.Session(&gorm.Session{SkipHooks: true})
+
+		// association de l'utilisateur au role enseignant
+		// la création du role charge les scopes de scope_teacher.json
+		role := Role{
+			RoleName:     "teacher",
+			RoleDescript: "role de l'enseignant",
+		}
+		if err := tx.Where(Role{RoleName: role.RoleName}).FirstOrCreate(&role).Error; err != nil {
+			return fmt.Errorf("erreur lors de la création du rôle: %w", err)
+		}
+
+		user = User{
+			UserBase: UserBase{
+				UserName: teacher.UserName,
+				Email:    teacher.Email,
+				Password: teacher.Password,
+			},
+			RoleID: role.ID,
+			Role:   role,
+			Image: Image{
+				PicturesName: "profil_default.png",
+				UrlPictures:  fmt.Sprintf("%s/public/profil_default.png", utils.URL_Image),
+			},
+		}
+
+		// création de l'utilisateur permanent
+		if err := query.QueryCreate(txhooks, &user); err != nil {
+			return fmt.Errorf("erreur lors de la création de l'utilisateur: %w", err)
+		}
+
+		// création du code de vérification
+		code := CodeVerif{
+			VerifiableID:   user.ID,
+			VerifiableType: user.TableName(),
+		}
+		if err := code.BeforeSave(tx); err != nil {
+			return fmt.Errorf("erreur lors de la création du code de vérification: %w", err)
+		}
+		if err := query.QueryCreate(txhooks, &code); err != nil {
+			return fmt.Errorf("erreur lors de la création du code de vérification: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
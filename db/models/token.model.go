@@ -29,6 +29,9 @@ type AccessToken struct {
 	RequestedAudience pq.StringArray `gorm:"type:text[]"`
 	GrantedAudience   pq.StringArray `gorm:"type:text[]"`
 
+	//autorisations détaillées accordées (RFC 9396)
+	AuthorizationDetails AuthorizationDetails `gorm:"type:jsonb;default:null"`
+
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	DeletedAt gorm.DeletedAt `gorm:"index"`
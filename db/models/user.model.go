@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"github.com/dylEasydev/go-oauth2-easyclass/validators"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
@@ -12,6 +14,13 @@ const (
 	Cout_hash = 10
 )
 
+const (
+	//nombre d'échecs de connexion avant le verrouillage du compte
+	LOGIN_MAX_ATTEMPTS = 5
+	//durée du verrouillage du compte
+	LOGIN_LOCK_DURATION = 15 * time.Minute
+)
+
 // structure du model utilisateur permanent
 type User struct {
 	UserBase
@@ -22,12 +31,21 @@ type User struct {
 	//rôle de l'utilisateur (admin , student , teacher ...)
 	Role   Role      `gorm:"foreignKey:RoleID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 	RoleID uuid.UUID `gorm:"type:uuid;not null"`
+
+	//échecs de connexion consécutifs et fin du verrouillage du compte
+	FailedLogins int        `gorm:"default:0" json:"-"`
+	LockedUntil  *time.Time `gorm:"type:timestamptz" json:"-"`
 }
 
 func (User) TableName() string {
 	return "user"
 }
 
+// verifie si le compte est verrouillé après trop d'échecs de connexion
+func (user *User) IsLocked() bool {
+	return user.LockedUntil != nil && time.Now().UTC().Before(*user.LockedUntil)
+}
+
 // si modification du password hash du mots de passe avant la sauvegarde
 func (user *User) BeforeSave(tx *gorm.DB) error {
 	if err := validators.ValidateStruct(user); err != nil {
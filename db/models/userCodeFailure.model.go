@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+const (
+	//nombre de user_code erronés avant le blocage de la page de vérification
+	USER_CODE_MAX_FAILURES = 5
+	//durée de la fenêtre de comptage des essais
+	USER_CODE_FAILURE_WINDOW = 15 * time.Minute
+)
+
+// essais de user_code erronés sur la page de vérification des appareils (RFC 8628 section 5.1)
+// comptés par adresse du client et par session de connexion du navigateur
+type UserCodeFailure struct {
+	Identifier string `gorm:"primaryKey;type:text"`
+	Failures   int    `gorm:"default:0"`
+	//fin de la fenêtre de comptage
+	ResetAt time.Time `gorm:"type:timestamptz;index"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// implementation de l'interface Tabler
+func (UserCodeFailure) TableName() string {
+	return "user_code_failures"
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"time"
 
 	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
 	"github.com/dylEasydev/go-oauth2-easyclass/utils"
@@ -17,6 +18,9 @@ import (
 	"gorm.io/gorm/clause"
 )
 
+// compte verrouillé après trop d'échecs de connexion
+var ErrAccountLocked = errors.New("compte temporairement verrouillé")
+
 //implementation de l'interface de CoreStorage
 
 // AuthorizationStorage
@@ -57,6 +61,8 @@ func (store *Store) CreateAuthorizeCodeSession(ctx context.Context, code string,
 		SessionID:         session.ID,
 		RequestedAudience: pq.StringArray(request.GetRequestedAudience()),
 		GrantedAudience:   pq.StringArray(request.GetGrantedAudience()),
+
+		AuthorizationDetails: session.AuthorizationDetails,
 	}
 
 	// enregistrement du code d'authorization en BD
@@ -99,6 +105,9 @@ func (store *Store) GetAuthorizeCodeSession(ctx context.Context, code string, se
 		GrantedAudience:   fosite.Arguments(authorize_code.GrantedAudience),
 	}
 
+	//les autorisations détaillées sont propres au code ou au jeton
+	authorize_code.Session.AuthorizationDetails = authorize_code.AuthorizationDetails
+
 	//verification de la validité du code d'authorization
 	if authorize_code.Active != nil && !*authorize_code.Active {
 		return rq, fosite.ErrInvalidatedAuthorizeCode
@@ -158,6 +167,8 @@ func (store *Store) CreateAccessTokenSession(ctx context.Context, signature stri
 		SessionID:         &session.ID,
 		RequestedAudience: pq.StringArray(request.GetRequestedAudience()),
 		GrantedAudience:   pq.StringArray(request.GetGrantedAudience()),
+
+		AuthorizationDetails: session.AuthorizationDetails,
 	}
 
 	if err = gorm.G[models.AccessToken](store.db).Create(ctx, &data); err != nil {
@@ -195,6 +206,14 @@ func (store *Store) GetAccessTokenSession(ctx context.Context, signature string,
 		GrantedAudience:   fosite.Arguments(access_token.GrantedAudience),
 	}
 
+	//les autorisations détaillées sont propres au code ou au jeton
+	access_token.Session.AuthorizationDetails = access_token.AuthorizationDetails
+
+	//jeton révoqué (révocation ou déconnexion)
+	if access_token.Active != nil && !*access_token.Active {
+		return rq, fosite.ErrInactiveToken
+	}
+
 	return rq, nil
 }
 
@@ -227,6 +246,14 @@ func (store *Store) CreateRefreshTokenSession(ctx context.Context, signature str
 		return fmt.Errorf("erreur de persistence session: %w", err)
 	}
 
+	//le refresh token garde le grant complet si le jeton d'accès est restreint
+	grantedScopes, grantedAudience := request.GetGrantedScopes(), request.GetGrantedAudience()
+	details := session.AuthorizationDetails
+	if session.RefreshGrant != nil {
+		grantedScopes, grantedAudience = session.RefreshGrant.Scopes, session.RefreshGrant.Audience
+		details = session.RefreshGrant.AuthorizationDetails
+	}
+
 	data := models.RefreshToken{
 		RequestId:         request.GetID(),
 		Active:            utils.PtrBool(true),
@@ -235,11 +262,13 @@ func (store *Store) CreateRefreshTokenSession(ctx context.Context, signature str
 		RequestedAt:       request.GetRequestedAt().UTC(),
 		ClientID:          clientID,
 		RequestedScopes:   pq.StringArray(request.GetRequestedScopes()),
-		GrantedScopes:     pq.StringArray(request.GetGrantedScopes()),
+		GrantedScopes:     pq.StringArray(grantedScopes),
 		Form:              form,
 		SessionID:         &session.ID,
 		RequestedAudience: pq.StringArray(request.GetRequestedAudience()),
-		GrantedAudience:   pq.StringArray(request.GetGrantedAudience()),
+		GrantedAudience:   pq.StringArray(grantedAudience),
+
+		AuthorizationDetails: details,
 	}
 
 	if err = gorm.G[models.RefreshToken](store.db).Create(ctx, &data); err != nil {
@@ -277,6 +306,9 @@ func (store *Store) GetRefreshTokenSession(ctx context.Context, signature string
 		GrantedAudience:   fosite.Arguments(result.GrantedAudience),
 	}
 
+	//les autorisations détaillées sont propres au code ou au jeton
+	result.Session.AuthorizationDetails = result.AuthorizationDetails
+
 	if result.Active != nil && !*result.Active {
 		return rq, fosite.ErrInactiveToken
 	}
@@ -308,22 +340,74 @@ func (store *Store) RotateRefreshToken(ctx context.Context, requestID string, re
 	return nil
 }
 
-func (store *Store) Authenticate(ctx context.Context, name string, secret string) error {
+// implementation de ResourceOwnerPasswordCredentialsGrantStorage
+// le sujet renvoyé est le nom d'utilisateur (sujet des sessions)
+func (store *Store) Authenticate(ctx context.Context, name string, secret string) (string, error) {
 
 	user, err := gorm.G[models.User](store.db).Where("user_name = ?", name).First(ctx)
+	deleted := false
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return fosite.ErrNotFound.WithDebug("Invalid credentials")
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", err
 		}
-		return err
+		//compte supprimé encore dans le délai de grâce
+		deletedUser, err := store.getDeletedUser(ctx, name)
+		if err != nil {
+			if errors.Is(err, fosite.ErrNotFound) {
+				return "", fosite.ErrNotFound.WithDebug("Invalid credentials")
+			}
+			return "", err
+		}
+		user, deleted = *deletedUser, true
+	}
+
+	//le compte est verrouillé après LOGIN_MAX_ATTEMPTS échecs consécutifs
+	if user.IsLocked() {
+		return "", fosite.ErrNotFound.WithWrap(ErrAccountLocked).WithDebug("Account locked")
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(secret)); err != nil {
-		return fosite.ErrNotFound.WithDebug("Invalid credentials")
+		if err := store.recordLoginFailure(ctx, user.ID); err != nil {
+			return "", err
+		}
+		return "", fosite.ErrNotFound.WithDebug("Invalid credentials")
+	}
+	if user.FailedLogins > 0 {
+		if err := store.db.WithContext(ctx).Unscoped().Session(&gorm.Session{SkipHooks: true}).Model(&models.User{}).Where("id = ?", user.ID).Update("failed_logins", 0).Error; err != nil {
+			return "", fmt.Errorf("erreur de mise à jour des échecs de connexion: %w", err)
+		}
 	}
 
-	return nil
+	//la connexion restaure le compte supprimé
+	if deleted {
+		if err := store.restoreAccount(ctx, user.ID); err != nil {
+			return "", err
+		}
+	}
+
+	return user.UserName, nil
+
+}
 
+// enregistrement d'un échec de connexion
+// le compte est verrouillé pendant LOGIN_LOCK_DURATION au LOGIN_MAX_ATTEMPTS-ième échec
+func (store *Store) recordLoginFailure(ctx context.Context, userID uuid.UUID) error {
+	return store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		tx = tx.Unscoped().Session(&gorm.Session{SkipHooks: true})
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "failed_logins").Where("id = ?", userID).First(&user).Error; err != nil {
+			return fmt.Errorf("erreur de lecture des échecs de connexion: %w", err)
+		}
+
+		updates := map[string]any{"failed_logins": user.FailedLogins + 1}
+		if user.FailedLogins+1 >= models.LOGIN_MAX_ATTEMPTS {
+			updates = map[string]any{"failed_logins": 0, "locked_until": time.Now().UTC().Add(models.LOGIN_LOCK_DURATION)}
+		}
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(updates).Error; err != nil {
+			return fmt.Errorf("erreur d'enregistrement de l'échec de connexion: %w", err)
+		}
+		return nil
+	})
 }
 
 func (store *Store) GetUser(ctx context.Context, username string) (*models.User, error) {
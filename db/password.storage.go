@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//implementation de la réinitialisation du mot de passe par mail
+
+// demande de réinitialisation trop rapprochée de la précédente
+var ErrPasswordResetThrottled = errors.New("demande de réinitialisation trop fréquente")
+
+// création d'une demande de réinitialisation pour l'utilisateur
+// les demandes précédentes non utilisées sont invalidées
+// retourne le jeton en clair à envoyer par mail
+func (store *Store) CreatePasswordReset(ctx context.Context, userID uuid.UUID) (string, *models.PasswordReset, error) {
+	secret, err := utils.GenerateToken(32)
+	if err != nil {
+		return "", nil, fmt.Errorf("erreur de génération du jeton de réinitialisation: %w", err)
+	}
+
+	reset := models.PasswordReset{
+		Token:     utils.GenerateHash(secret),
+		ExpiresAt: time.Now().Add(models.PASSWORD_RESET_VALIDATE).UTC(),
+		UserID:    userID,
+	}
+	err = store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.PasswordReset{}).Where("user_id = ? AND created_at > ?", userID, time.Now().Add(-models.PASSWORD_RESET_THROTTLE).UTC()).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrPasswordResetThrottled
+		}
+
+		if err := tx.Where("user_id = ? AND use_at IS NULL", userID).Delete(&models.PasswordReset{}).Error; err != nil {
+			return fmt.Errorf("erreur d'invalidation des demandes précédentes: %w", err)
+		}
+		if err := tx.Create(&reset).Error; err != nil {
+			return fmt.Errorf("erreur de création de la demande de réinitialisation: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return reset.ID.String() + "." + secret, &reset, nil
+}
+
+// réinitialisation du mot de passe avec le jeton reçu par mail
+// un essai échoué est comptabilisé, le jeton est invalidé après PASSWORD_RESET_MAX_ATTEMPTS essais
+// retourne l'identifiant de l'utilisateur dont le mot de passe a changé
+func (store *Store) ResetPassword(ctx context.Context, token string, password string) (uuid.UUID, error) {
+	id, secret, ok := strings.Cut(token, ".")
+	resetID, err := uuid.Parse(id)
+	if !ok || err != nil || secret == "" {
+		return uuid.Nil, fosite.ErrNotFound
+	}
+
+	var userID uuid.UUID
+	failed := false
+	err = store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var reset models.PasswordReset
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(&models.PasswordReset{ID: resetID}).First(&reset).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fosite.ErrNotFound
+			}
+			return err
+		}
+		if !reset.IsValid() {
+			return fosite.ErrNotFound
+		}
+
+		//l'essai échoué est enregistré avec la transaction
+		if !utils.CompareHash(secret, reset.Token) {
+			failed = true
+			return tx.Model(&models.PasswordReset{}).Where(&models.PasswordReset{ID: reset.ID}).Update("attempts", gorm.Expr("attempts + 1")).Error
+		}
+
+		if err := savePassword(ctx, tx, reset.UserID, password); err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		if err := tx.Model(&models.PasswordReset{}).Where(&models.PasswordReset{ID: reset.ID}).Update("use_at", now).Error; err != nil {
+			return fmt.Errorf("erreur d'utilisation du jeton de réinitialisation: %w", err)
+		}
+		userID = reset.UserID
+		return nil
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if failed {
+		return uuid.Nil, fosite.ErrNotFound
+	}
+	return userID, nil
+}
+
+// récupère les sessions fosite de l'utilisateur
+func (store *Store) GetUserSessions(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
+	sessions, err := gorm.G[models.Session](store.db).Where("user_id = ?", userID).Find(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("erreur de lecture des sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// suppression des sessions de connexion du navigateur de l'utilisateur
+func (store *Store) RevokeUserLoginSessions(ctx context.Context, userID uuid.UUID) error {
+	if _, err := gorm.G[models.LoginSession](store.db).Where("user_id = ?", userID).Delete(ctx); err != nil {
+		return fmt.Errorf("erreur de suppression des sessions de connexion: %w", err)
+	}
+	return nil
+}
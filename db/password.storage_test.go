@@ -0,0 +1,21 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+)
+
+func TestResetPasswordMalformedToken(t *testing.T) {
+	store := &Store{}
+
+	//un jeton mal formé est refusé sans lecture de la base
+	for _, token := range []string{"", "secret", "pas-un-uuid.secret", uuid.NewString(), uuid.NewString() + "."} {
+		if _, err := store.ResetPassword(context.Background(), token, "NouveauMotDePasse1!"); !errors.Is(err, fosite.ErrNotFound) {
+			t.Errorf("jeton %q: erreur not_found attendue, obtenu %v", token, err)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/db/query"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//implementation de la gestion du profil par l'utilisateur connecté
+
+// adresse mail déjà utilisée par un autre compte
+var ErrEmailTaken = errors.New("adresse mail déjà utilisée")
+
+// changement du nom d'utilisateur
+// l'ancien nom est réservé pour ne pas être réattribué (sub des clients publics)
+// le mot de passe déjà hashé n'est pas repassé par les hooks
+func (store *Store) UpdateUserName(ctx context.Context, userID uuid.UUID, name string) error {
+	return store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		user, err := gorm.G[models.User](tx).Where("id = ?", userID).First(ctx)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fosite.ErrNotFound
+			}
+			return err
+		}
+		if user.UserName == name {
+			return nil
+		}
+
+		retired := models.RetiredUserName{UserName: user.UserName, UserID: user.ID}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Omit(clause.Associations).Create(&retired).Error; err != nil {
+			return fmt.Errorf("erreur de réservation de l'ancien nom d'utilisateur: %w", err)
+		}
+		if err := tx.Session(&gorm.Session{SkipHooks: true}).Model(&models.User{}).Where("id = ?", userID).Update("user_name", name).Error; err != nil {
+			return fmt.Errorf("erreur de mise à jour du nom d'utilisateur: %w", err)
+		}
+		return nil
+	})
+}
+
+// nom d'utilisateur réservé après un changement de nom
+func (store *Store) IsUserNameRetired(ctx context.Context, name string) (bool, error) {
+	count, err := gorm.G[models.RetiredUserName](store.db).Where("user_name = ?", name).Count(ctx, "id")
+	if err != nil {
+		return false, fmt.Errorf("erreur de lecture des noms réservés: %w", err)
+	}
+	return count > 0, nil
+}
+
+// changement du mot de passe, le mot de passe actuel est requis
+func (store *Store) ChangePassword(ctx context.Context, userID uuid.UUID, current string, password string) error {
+	return store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		user, err := gorm.G[models.User](tx).Where("id = ?", userID).First(ctx)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fosite.ErrNotFound
+			}
+			return err
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(current)); err != nil {
+			return fosite.ErrNotFound.WithDebug("Invalid credentials")
+		}
+		return savePassword(ctx, tx, userID, password)
+	})
+}
+
+// validation et hashage du nouveau mot de passe par les hooks du model
+func savePassword(ctx context.Context, tx *gorm.DB, userID uuid.UUID, password string) error {
+	user, err := gorm.G[models.User](tx).Joins(clause.JoinTarget{Association: "Image"}, nil).Joins(clause.JoinTarget{Association: "Role"}, nil).Where("\"user\".id = ?", userID).First(ctx)
+	if err != nil {
+		return fmt.Errorf("erreur de lecture de l'utilisateur: %w", err)
+	}
+
+	user.Password = password
+	if err := tx.Omit(clause.Associations).Save(&user).Error; err != nil {
+		return fmt.Errorf("erreur de mise à jour du mot de passe: %w", err)
+	}
+	return nil
+}
+
+// demande de changement d'adresse mail
+// la demande précédente est remplacée et le code est envoyé à la nouvelle adresse
+func (store *Store) CreateEmailChange(ctx context.Context, user *models.User, email string) (*models.EmailChange, error) {
+	change := models.EmailChange{
+		Email:    email,
+		UserName: user.UserName,
+		UserID:   user.ID,
+	}
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var previous []models.EmailChange
+		if err := tx.Where("user_id = ?", user.ID).Find(&previous).Error; err != nil {
+			return err
+		}
+		for _, elem := range previous {
+			if err := tx.Unscoped().Where(&models.CodeVerif{VerifiableID: elem.ID, VerifiableType: elem.TableName()}).Delete(&models.CodeVerif{}).Error; err != nil {
+				return fmt.Errorf("erreur de suppression du code de vérification: %w", err)
+			}
+		}
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.EmailChange{}).Error; err != nil {
+			return fmt.Errorf("erreur de suppression de la demande précédente: %w", err)
+		}
+
+		if err := tx.Omit(clause.Associations).Create(&change).Error; err != nil {
+			return fmt.Errorf("erreur de création de la demande de changement d'adresse: %w", err)
+		}
+
+		//création du code avec les hooks: envoi du mail à la nouvelle adresse
+		code := models.CodeVerif{
+			VerifiableID:   change.ID,
+			VerifiableType: change.TableName(),
+		}
+		if err := query.QueryCreate(tx, &code); err != nil {
+			return fmt.Errorf("erreur lors de la création du code de vérification: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+// confirmation du changement d'adresse avec le code reçu à la nouvelle adresse
+// un essai échoué est comptabilisé, la demande est supprimée après EMAIL_CHANGE_MAX_ATTEMPTS essais
+func (store *Store) ConfirmEmailChange(ctx context.Context, userID uuid.UUID, code string) (string, error) {
+	var email string
+	failed := false
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var change models.EmailChange
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).First(&change).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fosite.ErrNotFound
+			}
+			return err
+		}
+
+		if change.Attempts >= models.EMAIL_CHANGE_MAX_ATTEMPTS {
+			return fosite.ErrNotFound
+		}
+
+		var codeVerif models.CodeVerif
+		if err := tx.Where(&models.CodeVerif{Code: utils.GenerateHash(code), VerifiableID: change.ID, VerifiableType: change.TableName()}).First(&codeVerif).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				//l'essai échoué est enregistré avec la transaction
+				failed = true
+				return failEmailChange(tx, &change)
+			}
+			return err
+		}
+		if codeVerif.IsUsed() || codeVerif.IsExpired() {
+			return fosite.ErrNotFound
+		}
+
+		//l'adresse a pu être prise depuis la demande
+		var count int64
+		if err := tx.Unscoped().Model(&models.User{}).Where("email = ? AND id <> ?", change.Email, userID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrEmailTaken
+		}
+
+		if err := tx.Session(&gorm.Session{SkipHooks: true}).Model(&models.User{}).Where("id = ?", userID).Update("email", change.Email).Error; err != nil {
+			return fmt.Errorf("erreur de mise à jour de l'adresse mail: %w", err)
+		}
+		if err := tx.Unscoped().Delete(&codeVerif).Error; err != nil {
+			return fmt.Errorf("erreur de suppression du code de vérification: %w", err)
+		}
+		if err := tx.Unscoped().Delete(&change).Error; err != nil {
+			return fmt.Errorf("erreur de suppression de la demande de changement d'adresse: %w", err)
+		}
+		email = change.Email
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if failed {
+		return "", fosite.ErrNotFound
+	}
+	return email, nil
+}
+
+// enregistrement d'un essai échoué sur une demande de changement d'adresse
+// la demande et son code sont supprimés au dernier essai
+func failEmailChange(tx *gorm.DB, change *models.EmailChange) error {
+	if change.Attempts+1 < models.EMAIL_CHANGE_MAX_ATTEMPTS {
+		return tx.Model(&models.EmailChange{}).Where("id = ?", change.ID).Update("attempts", gorm.Expr("attempts + 1")).Error
+	}
+	if err := tx.Unscoped().Where(&models.CodeVerif{VerifiableID: change.ID, VerifiableType: change.TableName()}).Delete(&models.CodeVerif{}).Error; err != nil {
+		return fmt.Errorf("erreur de suppression du code de vérification: %w", err)
+	}
+	if err := tx.Unscoped().Delete(change).Error; err != nil {
+		return fmt.Errorf("erreur de suppression de la demande de changement d'adresse: %w", err)
+	}
+	return nil
+}
+
+// adresse en attente de vérification de l'utilisateur (vide si aucune)
+func (store *Store) GetPendingEmail(ctx context.Context, userID uuid.UUID) (string, error) {
+	change, err := gorm.G[models.EmailChange](store.db).Where("user_id = ?", userID).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	return change.Email, nil
+}
@@ -0,0 +1,24 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"gorm.io/gorm"
+)
+
+//registre des ressources protégées (RFC 8707)
+
+// récupère les ressources actives désignées par leurs identifiants
+func (store *Store) GetProtectedResources(ctx context.Context, identifiers []string) ([]models.ProtectedResource, error) {
+	if len(identifiers) == 0 {
+		return []models.ProtectedResource{}, nil
+	}
+
+	resources, err := gorm.G[models.ProtectedResource](store.db).Where("identifier IN ? AND active = ?", identifiers, true).Find(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("erreur de lecture des ressources protégées: %w", err)
+	}
+	return resources, nil
+}
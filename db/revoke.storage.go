@@ -7,6 +7,7 @@ import (
 
 	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
 	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/google/uuid"
 	"github.com/ory/fosite"
 	"gorm.io/gorm"
 )
@@ -42,3 +43,35 @@ func (store *Store) RevokeAccessToken(ctx context.Context, requestID string) err
 	}
 	return nil
 }
+
+// révocation d'une session et de l'ensemble des jetons émis pour elle
+func (store *Store) RevokeSession(ctx context.Context, sessionID uuid.UUID) error {
+	return store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.AccessToken{}).Where("session_id = ?", sessionID).Updates(&models.AccessToken{Active: utils.PtrBool(false)}).Error; err != nil {
+			return fmt.Errorf("erreur de revocation des jetons d'accès : %w", err)
+		}
+		if err := tx.Model(&models.RefreshToken{}).Where("session_id = ?", sessionID).Updates(&models.RefreshToken{Active: utils.PtrBool(false)}).Error; err != nil {
+			return fmt.Errorf("erreur de revocation des jetons de rafraichissement : %w", err)
+		}
+		if _, err := gorm.G[models.Session](tx).Where(&models.Session{ID: sessionID}).Delete(ctx); err != nil {
+			return fmt.Errorf("erreur de suppression de la session : %w", err)
+		}
+		return nil
+	})
+}
+
+// vérification qu'un jeton d'accès émis par le serveur est toujours actif
+// utilisée par les serveurs de ressources après la vérification de la signature du JWT
+func (store *Store) CheckAccessToken(ctx context.Context, signature string) error {
+	accessToken, err := gorm.G[models.AccessToken](store.db).Where(&models.AccessToken{Signature: signature}).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fosite.ErrNotFound
+		}
+		return err
+	}
+	if accessToken.Active != nil && !*accessToken.Active {
+		return fosite.ErrInactiveToken
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/ory/fosite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//gestion des clés de signature du serveur
+
+// récupère la clé de signature active d'un algorithme
+func (store *Store) GetActiveSigningKey(ctx context.Context, alg string) (*models.SigningKey, error) {
+	key, err := gorm.G[models.SigningKey](store.db).Where(&models.SigningKey{State: models.KEY_STATE_ACTIVE, Algorithm: alg}).Order("activated_at desc").First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fosite.ErrNotFound
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+// récupère une clé de signature par son kid
+func (store *Store) GetSigningKey(ctx context.Context, kid string) (*models.SigningKey, error) {
+	key, err := gorm.G[models.SigningKey](store.db).Where(&models.SigningKey{KeyID: kid}).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fosite.ErrNotFound
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+// récupère l'ensemble des clés encore publiées (non expirées)
+func (store *Store) GetPublishedSigningKeys(ctx context.Context) ([]models.SigningKey, error) {
+	keys, err := gorm.G[models.SigningKey](store.db).Where("expires_at IS NULL OR expires_at > ?", time.Now().UTC()).Order("created_at desc").Find(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("erreur de lecture des clés de signature: %w", err)
+	}
+	return keys, nil
+}
+
+// enregistrement d'une nouvelle clé de signature
+func (store *Store) CreateSigningKey(ctx context.Context, key *models.SigningKey) error {
+	if err := gorm.G[models.SigningKey](store.db).Create(ctx, key); err != nil {
+		return fmt.Errorf("erreur de création de la clé de signature: %w", err)
+	}
+	return nil
+}
+
+// rotation des clés de signature de l'algorithme de next:
+// la clé active est retirée (publiée jusqu'à now+retention),
+// la prochaine clé devient active et next devient la prochaine clé
+// avec un interval non nul, la rotation n'a lieu que si elle est encore due
+// une fois les clés verrouillées
+func (store *Store) RotateSigningKeys(ctx context.Context, next *models.SigningKey, retention time.Duration, interval time.Duration) (bool, error) {
+	rotated := false
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now().UTC()
+		expiresAt := now.Add(retention)
+
+		// verrou sur les clés courantes pour éviter une double rotation
+		var current []models.SigningKey
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("algorithm = ? AND state IN ?", next.Algorithm, []string{models.KEY_STATE_ACTIVE, models.KEY_STATE_NEXT}).Find(&current).Error; err != nil {
+			return fmt.Errorf("erreur de lecture des clés de signature: %w", err)
+		}
+
+		// une autre instance a pu effectuer la rotation depuis la vérification
+		if interval > 0 && !rotationDue(current, interval) {
+			return nil
+		}
+
+		if err := tx.Model(&models.SigningKey{}).Where(&models.SigningKey{State: models.KEY_STATE_ACTIVE, Algorithm: next.Algorithm}).Updates(map[string]any{
+			"state":      models.KEY_STATE_RETIRED,
+			"retired_at": now,
+			"expires_at": expiresAt,
+		}).Error; err != nil {
+			return fmt.Errorf("erreur de retrait de la clé active: %w", err)
+		}
+
+		if err := tx.Model(&models.SigningKey{}).Where(&models.SigningKey{State: models.KEY_STATE_NEXT, Algorithm: next.Algorithm}).Updates(map[string]any{
+			"state":        models.KEY_STATE_ACTIVE,
+			"activated_at": now,
+		}).Error; err != nil {
+			return fmt.Errorf("erreur d'activation de la prochaine clé: %w", err)
+		}
+
+		// sans prochaine clé publiée, la nouvelle clé est activée directement
+		hasNext := false
+		for _, key := range current {
+			if key.State == models.KEY_STATE_NEXT {
+				hasNext = true
+			}
+		}
+		if !hasNext {
+			next.State = models.KEY_STATE_ACTIVE
+			next.ActivatedAt = &now
+		}
+
+		if err := gorm.G[models.SigningKey](tx).Create(ctx, next); err != nil {
+			return fmt.Errorf("erreur de création de la clé de signature: %w", err)
+		}
+
+		// purge des clés retirées qui ne sont plus publiées
+		if _, err := gorm.G[models.SigningKey](tx.Unscoped()).Where("state = ? AND expires_at <= ?", models.KEY_STATE_RETIRED, now).Delete(ctx); err != nil {
+			return fmt.Errorf("erreur de suppression des clés expirées: %w", err)
+		}
+
+		rotated = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return rotated, nil
+}
+
+// verifie si la clé active la plus récente doit être remplacée
+// la rotation est due sans clé active
+func rotationDue(keys []models.SigningKey, interval time.Duration) bool {
+	var active *models.SigningKey
+	for i, key := range keys {
+		if key.State != models.KEY_STATE_ACTIVE {
+			continue
+		}
+		if active == nil || (key.ActivatedAt != nil && (active.ActivatedAt == nil || key.ActivatedAt.After(*active.ActivatedAt))) {
+			active = &keys[i]
+		}
+	}
+	return active == nil || active.RotationDue(interval)
+}
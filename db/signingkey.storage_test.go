@@ -0,0 +1,38 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+)
+
+func TestRotationDue(t *testing.T) {
+	at := func(age time.Duration) *time.Time {
+		date := time.Now().UTC().Add(-age)
+		return &date
+	}
+	interval := 30 * 24 * time.Hour
+
+	cases := []struct {
+		name string
+		keys []models.SigningKey
+		due  bool
+	}{
+		{"aucune clé active", []models.SigningKey{{State: models.KEY_STATE_NEXT}}, true},
+		{"clé active récente", []models.SigningKey{{State: models.KEY_STATE_ACTIVE, ActivatedAt: at(time.Hour)}, {State: models.KEY_STATE_NEXT}}, false},
+		{"clé active ancienne", []models.SigningKey{{State: models.KEY_STATE_ACTIVE, ActivatedAt: at(31 * 24 * time.Hour)}}, true},
+		{"date d'activation absente", []models.SigningKey{{State: models.KEY_STATE_ACTIVE}}, true},
+		{"la clé la plus récente décide", []models.SigningKey{
+			{State: models.KEY_STATE_ACTIVE, ActivatedAt: at(31 * 24 * time.Hour)},
+			{State: models.KEY_STATE_ACTIVE, ActivatedAt: at(time.Minute)},
+		}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if due := rotationDue(tc.keys, interval); due != tc.due {
+				t.Fatalf("rotation due = %v, attendu %v", due, tc.due)
+			}
+		})
+	}
+}
@@ -61,6 +61,10 @@ func InitDB(db *gorm.DB) error {
 	if err = db.Where(models.Role{RoleName: role.RoleName}).FirstOrCreate(&role).Error; err != nil {
 		return err
 	}
+	//rattachement des scopes ajoutés depuis la création du role
+	if err = role.AddScope(db); err != nil {
+		return fmt.Errorf("erreur lors de l'ajout des scopes de l'administrateur: %w", err)
+	}
 
 	//creation de l'utilisateur administrateur
 	username := os.Getenv("USER_NAME")
@@ -128,8 +132,9 @@ func InitDB(db *gorm.DB) error {
 		Secret:                  os.Getenv("SECRET_CLIENT"),
 		RotatedSecrets:          pq.StringArray{os.Getenv("SECRET_CLIENT2")},
 		Public:                  utils.PtrBool(false),
+		SkipConsent:             utils.PtrBool(true),
 		RedirectURIs:            pq.StringArray{"https://localhost:3000/callback", "https://127.0.0.1:3000/callback"},
-		Scopes:                  pq.StringArray{"openid", "admin.*"},
+		Scopes:                  pq.StringArray{"openid", "profile", "email", "admin.*"},
 		Audience:                pq.StringArray{},
 		Grants:                  pq.StringArray{"code", "token", "client_credentials", "password"},
 		ResponseTypes:           pq.StringArray{"code", "token"},
@@ -153,18 +158,22 @@ func InitDB(db *gorm.DB) error {
 			if err != nil {
 				return err
 			}
+			alg, err := utils.KeyAlgorithm(secret)
+			if err != nil {
+				return err
+			}
 
 			jwk := jose.JSONWebKey{
 				Key:       secret,
 				KeyID:     "init-key",
-				Algorithm: "RS256",
+				Algorithm: alg,
 				Use:       "sig",
 			}
 			ck := models.ClientKey{
 				Issuer:    client.ID.String(),
 				Subject:   client.ID.String(),
 				KeyID:     "init-key",
-				Algorithm: "RS256",
+				Algorithm: alg,
 				Scopes:    client.Scopes,
 				JWK:       models.JWKey(jwk),
 				ClientID:  client.ID,
@@ -193,6 +202,8 @@ func New() *Store {
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
 		//Logger:      logger.Default.LogMode(logger.Info),
 		PrepareStmt: true,
+		//Unscoped est conservé par les requêtes génériques (gorm.G) et les sessions
+		PropagateUnscoped: true,
 	})
 	if err != nil {
 		log.Fatalf("erreur de connexion à la base de données: %v", err)
@@ -220,11 +231,26 @@ func New() *Store {
 		models.PKCE{},
 		models.ClientKey{},
 		models.PARRequest{},
+		models.DeviceCode{},
+		models.BackchannelAuthRequest{},
 		models.Nonce{},
 		models.StudentTemp{},
 		models.TeacherTemp{},
 		models.TeacherWaiting{},
 		models.AuthPermission{},
+		models.SigningKey{},
+		models.BackchannelLogout{},
+		models.LoginSession{},
+		models.LoginRequest{},
+		models.Consent{},
+		models.ProtectedResource{},
+		models.PairwiseSubject{},
+		models.AuditEvent{},
+		models.PasswordReset{},
+		models.EmailChange{},
+		models.DataExport{},
+		models.RetiredUserName{},
+		models.UserCodeFailure{},
 	)
 
 	if err != nil {
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//implementation du stockage des sujets pairwise
+
+// sujet (sub) de l'utilisateur pour le client
+// le nom d'utilisateur pour les clients public, l'identifiant pairwise du secteur sinon
+// (la correspondance est enregistrée pour retrouver l'utilisateur)
+func (store *Store) SubjectFor(ctx context.Context, client fosite.Client, userID uuid.UUID, username string) (string, error) {
+	c, ok := client.(*models.Client)
+	if !ok || !c.UsesPairwiseSubject() {
+		return username, nil
+	}
+
+	sector := c.GetSectorIdentifier()
+	if sector == "" {
+		return "", fmt.Errorf("secteur du client pairwise %s introuvable", c.GetID())
+	}
+
+	data := models.PairwiseSubject{
+		Sector:  sector,
+		Subject: utils.PairwiseSubject(sector, userID.String()),
+		UserID:  userID,
+	}
+	if err := store.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&data).Error; err != nil {
+		return "", fmt.Errorf("erreur d'enregistrement du sujet pairwise: %w", err)
+	}
+	return data.Subject, nil
+}
+
+// utilisateur correspondant à un sujet pairwise
+func (store *Store) GetPairwiseUserID(ctx context.Context, subject string) (uuid.UUID, error) {
+	pairwise, err := gorm.G[models.PairwiseSubject](store.db).Where(&models.PairwiseSubject{Subject: subject}).First(ctx)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return uuid.Nil, fosite.ErrNotFound
+		}
+		return uuid.Nil, err
+	}
+	return pairwise.UserID, nil
+}
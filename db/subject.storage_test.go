@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+func TestPairwiseSubject(t *testing.T) {
+	t.Setenv("PAIRWISE_SALT", "sel-de-test")
+	alice, bob := uuid.NewString(), uuid.NewString()
+
+	cases := []struct {
+		name  string
+		a, b  [2]string
+		equal bool
+	}{
+		{"même secteur et utilisateur", [2]string{"app.easyclass.test", alice}, [2]string{"app.easyclass.test", alice}, true},
+		{"secteurs différents", [2]string{"app.easyclass.test", alice}, [2]string{"other.test", alice}, false},
+		{"utilisateurs différents", [2]string{"app.easyclass.test", alice}, [2]string{"app.easyclass.test", bob}, false},
+		//le séparateur empêche deux découpages de produire le même sub
+		{"concaténation ambiguë", [2]string{"app.test1", "23"}, [2]string{"app.test", "123"}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a, b := utils.PairwiseSubject(tc.a[0], tc.a[1]), utils.PairwiseSubject(tc.b[0], tc.b[1])
+			if (a == b) != tc.equal {
+				t.Fatalf("sub %q et %q, égalité attendue = %v", a, b, tc.equal)
+			}
+			if a == tc.a[1] {
+				t.Fatal("le sub pairwise ne doit pas révéler l'identifiant de l'utilisateur")
+			}
+		})
+	}
+
+	//le sub dépend du sel du serveur
+	subject := utils.PairwiseSubject("app.easyclass.test", alice)
+	t.Setenv("PAIRWISE_SALT", "autre-sel")
+	if utils.PairwiseSubject("app.easyclass.test", alice) == subject {
+		t.Fatal("le sub doit changer avec le sel")
+	}
+}
+
+func TestSectorIdentifier(t *testing.T) {
+	cases := []struct {
+		name   string
+		client models.Client
+		sector string
+	}{
+		{"sector_identifier_uri", models.Client{
+			SectorIdentifierURI: "https://sector.easyclass.test/redirects.json",
+			RedirectURIs:        pq.StringArray{"https://app.easyclass.test/callback", "https://m.other.test/callback"},
+		}, "sector.easyclass.test"},
+		{"hôte commun des redirect_uris", models.Client{
+			RedirectURIs: pq.StringArray{"https://app.easyclass.test/callback", "https://app.easyclass.test/silent"},
+		}, "app.easyclass.test"},
+		{"plusieurs hôtes sans sector_identifier_uri", models.Client{
+			RedirectURIs: pq.StringArray{"https://app.easyclass.test/callback", "https://m.other.test/callback"},
+		}, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if sector := tc.client.GetSectorIdentifier(); sector != tc.sector {
+				t.Fatalf("secteur = %q, attendu %q", sector, tc.sector)
+			}
+		})
+	}
+}
+
+func TestSubjectForWithoutPairwise(t *testing.T) {
+	store := &Store{}
+	userID := uuid.New()
+
+	//les clients public reçoivent le nom d'utilisateur
+	subject, err := store.SubjectFor(context.Background(), &models.Client{ID: uuid.New(), SubjectType: "public"}, userID, "alice")
+	if err != nil || subject != "alice" {
+		t.Fatalf("sub = %q, err = %v", subject, err)
+	}
+
+	//un client pairwise sans secteur ne reçoit aucun sub
+	client := &models.Client{
+		ID:           uuid.New(),
+		SubjectType:  "pairwise",
+		RedirectURIs: pq.StringArray{"https://app.easyclass.test/callback", "https://m.other.test/callback"},
+	}
+	if subject, err := store.SubjectFor(context.Background(), client, userID, "alice"); err == nil {
+		t.Fatalf("erreur attendue sans secteur, obtenu le sub %q", subject)
+	}
+}
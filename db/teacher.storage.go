@@ -0,0 +1,129 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//implementation de la validation des enseignants en attente par les administrateurs
+
+// enseignants en attente filtrés par état et par matière
+func (store *Store) ListWaitingTeachers(ctx context.Context, status string, subject string) ([]models.TeacherWaiting, error) {
+	query := gorm.G[models.TeacherWaiting](store.db).Where("status = ?", status)
+	if subject != "" {
+		query = query.Where("subject_name ILIKE ?", "%"+subject+"%")
+	}
+	teachers, err := query.Order("created_at").Find(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("erreur de lecture des enseignants en attente: %w", err)
+	}
+	return teachers, nil
+}
+
+// vérifie si une demande d'enseignant en attente utilise le nom ou l'adresse
+func (store *Store) IsTeacherPending(ctx context.Context, name string, email string) (bool, error) {
+	count, err := gorm.G[models.TeacherWaiting](store.db).Where("(user_name = ? OR email = ?) AND status = ?", name, email, models.TEACHER_STATUS_PENDING).Count(ctx, "id")
+	if err != nil {
+		return false, fmt.Errorf("erreur de lecture des enseignants en attente: %w", err)
+	}
+	return count > 0, nil
+}
+
+// validation d'un enseignant en attente par un administrateur
+// l'enseignant devient un utilisateur permanent avec le role teacher
+func (store *Store) ApproveTeacher(ctx context.Context, id uuid.UUID, adminID uuid.UUID) (*models.TeacherWaiting, *models.User, error) {
+	var teacher models.TeacherWaiting
+	var user *models.User
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if teacher, err = pendingTeacher(ctx, tx, id); err != nil {
+			return err
+		}
+
+		if user, err = teacher.SavePerm(tx); err != nil {
+			return err
+		}
+
+		if err := decideTeacher(tx, &teacher, models.TEACHER_STATUS_APPROVED, "", adminID); err != nil {
+			return err
+		}
+		detail := map[string]any{
+			"user_id":      user.ID,
+			"user_name":    teacher.UserName,
+			"subject_name": teacher.SubjectName,
+		}
+		return store.recordAudit(tx, adminID, models.AUDIT_TEACHER_APPROVED, teacher.TableName(), teacher.ID, "", detail)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &teacher, user, nil
+}
+
+// refus d'un enseignant en attente avec le motif de l'administrateur
+func (store *Store) RejectTeacher(ctx context.Context, id uuid.UUID, adminID uuid.UUID, reason string) (*models.TeacherWaiting, error) {
+	var teacher models.TeacherWaiting
+	err := store.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if teacher, err = pendingTeacher(ctx, tx, id); err != nil {
+			return err
+		}
+
+		if err := decideTeacher(tx, &teacher, models.TEACHER_STATUS_REJECTED, reason, adminID); err != nil {
+			return err
+		}
+		detail := map[string]any{
+			"user_name":    teacher.UserName,
+			"subject_name": teacher.SubjectName,
+		}
+		return store.recordAudit(tx, adminID, models.AUDIT_TEACHER_REJECTED, teacher.TableName(), teacher.ID, reason, detail)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &teacher, nil
+}
+
+// enseignant en attente verrouillé jusqu'à la fin de la transaction
+func pendingTeacher(ctx context.Context, tx *gorm.DB, id uuid.UUID) (models.TeacherWaiting, error) {
+	var teacher models.TeacherWaiting
+	err := tx.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ? AND status = ?", id, models.TEACHER_STATUS_PENDING).First(&teacher).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return teacher, fosite.ErrNotFound
+		}
+		return teacher, err
+	}
+	return teacher, nil
+}
+
+// enregistrement de la décision sur la demande de l'enseignant
+// le hash du mot de passe n'est plus conservé une fois la demande traitée
+func decideTeacher(tx *gorm.DB, teacher *models.TeacherWaiting, status string, reason string, adminID uuid.UUID) error {
+	now := time.Now().UTC()
+	teacher.Status = status
+	teacher.Reason = reason
+	teacher.DecidedAt = &now
+	teacher.DecidedByID = &adminID
+	teacher.Password = ""
+
+	err := tx.Session(&gorm.Session{SkipHooks: true}).Model(&models.TeacherWaiting{}).Where("id = ?", teacher.ID).Updates(map[string]any{
+		"status":        status,
+		"reason":        reason,
+		"decided_at":    now,
+		"decided_by_id": adminID,
+		"password":      "",
+	}).Error
+	if err != nil {
+		return fmt.Errorf("erreur d'enregistrement de la décision: %w", err)
+	}
+	return nil
+}
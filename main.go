@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
 
 	"github.com/dylEasydev/go-oauth2-easyclass/db"
 	"github.com/dylEasydev/go-oauth2-easyclass/middleware"
 	"github.com/dylEasydev/go-oauth2-easyclass/router"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 )
 
@@ -22,21 +29,97 @@ func main() {
 	//intialisation de la BD
 	store := db.New()
 
+	//commande d'administration: export des données d'un utilisateur
+	// go run . export <user-id> <fichier.json>
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := exportUser(store, os.Args[2:]); err != nil {
+			log.Fatal("Erreur de l'export des données ", err)
+		}
+		return
+	}
+
+	//suppression définitive des comptes après le délai de grâce
+	go store.StartAccountPurge(context.Background(), db.AccountPurgeInterval)
+
 	port := os.Getenv("PORT")
 
 	log.Printf("Serveur démarre à l'adresse https://localhost:%s", port)
 
 	server.Use(middleware.ErrorHandler())
+	//pages servies par le serveur (connexion, ...)
+	server.LoadHTMLGlob("ressources/templates/*.html")
 	router := router.NewRouter(server, store)
 	router.IndexRouter()
 	router.OIDCRouter()
 	router.JWKRouter()
+	router.WellKnownRouter()
 	router.SignRouter()
 	router.CodeRouter()
+	router.PasswordRouter()
+	router.MeRouter()
+	router.AdminRouter()
+	router.ExportRouter()
 
 	//démarrage du serveur https
-	if err := server.RunTLS(":"+port, "./key/server.pem", "./key/server.key"); err != nil {
+	//le certificat client est demandé sans être vérifié par la poignée de main:
+	// les clients mTLS (RFC 8705) sont vérifiés à leur authentification
+	//demander un certificat fait afficher un choix de certificat par les navigateurs
+	// sur les pages de connexion et de consentement: avec MTLS_PORT les clients mTLS
+	// utilisent un listener dédié (mtls_endpoint_aliases) et le listener principal
+	// ne demande plus de certificat
+	clientAuth := tls.RequestClientCert
+	if mtlsPort := os.Getenv("MTLS_PORT"); mtlsPort != "" {
+		clientAuth = tls.NoClientCert
+		mtlsServer := &http.Server{
+			Addr:      ":" + mtlsPort,
+			Handler:   server,
+			TLSConfig: &tls.Config{ClientAuth: tls.RequestClientCert},
+		}
+		go func() {
+			log.Printf("Listener mTLS à l'adresse https://localhost:%s", mtlsPort)
+			if err := mtlsServer.ListenAndServeTLS("./key/server.pem", "./key/server.key"); err != nil {
+				log.Fatal("Erreur du démarrage du listener mTLS", err)
+			}
+		}()
+	}
+
+	httpServer := &http.Server{
+		Addr:      ":" + port,
+		Handler:   server,
+		TLSConfig: &tls.Config{ClientAuth: clientAuth},
+	}
+	if err := httpServer.ListenAndServeTLS("./key/server.pem", "./key/server.key"); err != nil {
 		log.Fatal("Erreur du démarrage du serveur", err)
 	}
 
 }
+
+// écriture dans un fichier de l'archive des données d'un utilisateur
+func exportUser(store *db.Store, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: export <user-id> <fichier.json>")
+	}
+	userID, err := uuid.Parse(args[0])
+	if err != nil {
+		return fmt.Errorf("identifiant d'utilisateur invalide: %w", err)
+	}
+
+	archive, err := store.BuildUserExport(context.Background(), userID)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(args[1], os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(archive); err != nil {
+		return err
+	}
+	log.Printf("export des données de %s écrit dans %s", userID, args[1])
+	return nil
+}
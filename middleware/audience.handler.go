@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	fosite_jwt "github.com/ory/fosite/token/jwt"
+)
+
+// restriction d'une API aux jetons émis pour elle (RFC 8707)
+// à placer après AuthMiddleware ou DPoPMiddleware
+// resource est l'identifiant de l'API dans le registre des ressources protégées
+func AudienceMiddleware(resource string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		value, _ := ctx.Get("claims")
+		claims, ok := value.(fosite_jwt.JWTClaims)
+		if !ok || !slices.Contains(claims.Audience, resource) {
+			ctx.JSON(http.StatusUnauthorized, gin.H{
+				"message": "jeton non destiné à cette ressource ",
+				"success": false,
+			})
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -1,17 +1,32 @@
 package middleware
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/cristalhq/jwt/v4"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
 	"github.com/gin-gonic/gin"
+	"github.com/go-jose/go-jose/v3"
+	"github.com/ory/fosite"
 	fosite_jwt "github.com/ory/fosite/token/jwt"
 )
 
-func AuthMiddleware(publicKey *rsa.PublicKey) gin.HandlerFunc {
+// résolution de la clé public de vérification à partir du kid du jeton
+type KeyResolver func(ctx context.Context, kid string) (*jose.JSONWebKey, error)
+
+// vérification en BD qu'un jeton d'accès n'est pas révoqué à partir de sa signature
+// renvoie une erreur si le jeton est inconnu ou inactif
+type TokenChecker func(ctx context.Context, signature string) error
+
+func AuthMiddleware(resolveKey KeyResolver, checkToken TokenChecker) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		authHeader := ctx.GetHeader("Authorization")
 		if authHeader == "" {
@@ -32,33 +47,154 @@ func AuthMiddleware(publicKey *rsa.PublicKey) gin.HandlerFunc {
 			return
 		}
 
-		tokenString := partsToken[1]
-
-		verifier, err := jwt.NewVerifierRS("RS256", publicKey)
-		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "erreur au niveau du serveur ",
-				"success": false,
-			})
-			ctx.Abort()
+		claims, ok := verifyAccessToken(ctx, resolveKey, checkToken, partsToken[1])
+		if !ok {
 			return
 		}
-		var claims = fosite_jwt.JWTClaims{}
-		token, err := jwt.Parse([]byte(tokenString), verifier)
 
-		if err != nil {
+		//un jeton lié par DPoP n'est pas utilisable comme Bearer Token
+		if confirmationKey(claims) != "" {
 			ctx.JSON(http.StatusUnauthorized, gin.H{
-				"message": "mauvais jeton fournis ",
+				"message": "jeton lié par DPoP présenté comme Bearer Token ",
 				"success": false,
 			})
 			ctx.Abort()
 			return
 		}
-		err = json.Unmarshal(token.Claims(), &claims)
-		if err != nil {
 
-		}
 		ctx.Set("claims", claims)
 		ctx.Next()
 	}
 }
+
+// vérification de la signature, de l'expiration et de la révocation d'un jeton d'accès
+// la réponse d'erreur est écrite si le jeton est refusé
+func verifyAccessToken(ctx *gin.Context, resolveKey KeyResolver, checkToken TokenChecker, tokenString string) (fosite_jwt.JWTClaims, bool) {
+	var claims = fosite_jwt.JWTClaims{}
+
+	//lecture du kid avant la vérification de la signature
+	unverified, err := jwt.ParseNoVerify([]byte(tokenString))
+	if err != nil || unverified.Header().KeyID == "" {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "mauvais jeton fournis ",
+			"success": false,
+		})
+		ctx.Abort()
+		return claims, false
+	}
+
+	publicKey, err := resolveKey(ctx.Request.Context(), unverified.Header().KeyID)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "clé de signature inconnue ",
+			"success": false,
+		})
+		ctx.Abort()
+		return claims, false
+	}
+
+	verifier, err := newVerifier(publicKey)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "erreur au niveau du serveur ",
+			"success": false,
+		})
+		ctx.Abort()
+		return claims, false
+	}
+	token, err := jwt.Parse([]byte(tokenString), verifier)
+
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "mauvais jeton fournis ",
+			"success": false,
+		})
+		ctx.Abort()
+		return claims, false
+	}
+
+	//les claims sont lus comme fosite les a écrits (scp, exp, ...)
+	var mapClaims map[string]interface{}
+	if err := json.Unmarshal(token.Claims(), &mapClaims); err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "mauvais jeton fournis ",
+			"success": false,
+		})
+		ctx.Abort()
+		return claims, false
+	}
+	claims.FromMap(mapClaims)
+
+	if !claims.ExpiresAt.IsZero() && time.Now().UTC().After(claims.ExpiresAt) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "jeton expiré ",
+			"success": false,
+		})
+		ctx.Abort()
+		return claims, false
+	}
+
+	//jeton révoqué (révocation, déconnexion, réinitialisation du mot de passe, suppression du compte)
+	parts := strings.Split(tokenString, ".")
+	if err := checkToken(ctx.Request.Context(), parts[len(parts)-1]); err != nil {
+		if !errors.Is(err, fosite.ErrNotFound) && !errors.Is(err, fosite.ErrInactiveToken) {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "erreur au niveau du serveur ",
+				"success": false,
+			})
+			ctx.Abort()
+			return claims, false
+		}
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "jeton révoqué ",
+			"success": false,
+		})
+		ctx.Abort()
+		return claims, false
+	}
+
+	//un jeton lié à un certificat exige le même certificat client (RFC 8705 section 3)
+	if !certificateMatches(ctx.Request, claims) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": "le certificat client ne correspond pas au jeton ",
+			"success": false,
+		})
+		ctx.Abort()
+		return claims, false
+	}
+
+	return claims, true
+}
+
+// vérification du claim cnf.x5t#S256 avec le certificat de la connexion TLS
+func certificateMatches(r *http.Request, claims fosite_jwt.JWTClaims) bool {
+	cnf, _ := claims.Extra["cnf"].(map[string]interface{})
+	x5t, _ := cnf["x5t#S256"].(string)
+	if x5t == "" {
+		return true
+	}
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return false
+	}
+	return utils.CertificateThumbprint(r.TLS.PeerCertificates[0]) == x5t
+}
+
+// thumbprint de la clé DPoP du claim cnf (vide si le jeton n'est pas lié)
+func confirmationKey(claims fosite_jwt.JWTClaims) string {
+	cnf, _ := claims.Extra["cnf"].(map[string]interface{})
+	jkt, _ := cnf["jkt"].(string)
+	return jkt
+}
+
+// vérificateur correspondant au type de la clé de signature
+func newVerifier(publicKey *jose.JSONWebKey) (jwt.Verifier, error) {
+	switch key := publicKey.Key.(type) {
+	case *rsa.PublicKey:
+		return jwt.NewVerifierRS(jwt.RS256, key)
+	case *ecdsa.PublicKey:
+		return jwt.NewVerifierES(jwt.ES256, key)
+	case ed25519.PublicKey:
+		return jwt.NewVerifierEdDSA(key)
+	}
+	return nil, errors.New("type de clé de signature non supporté")
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// enregistrement du jti d'une preuve DPoP jusqu'à exp
+// renvoie une erreur si la preuve a déjà été présentée
+type ProofReplayChecker func(ctx context.Context, jti string, exp time.Time) error
+
+// vérification des jetons d'accès liés par DPoP (RFC 9449 section 7)
+// les jetons non liés restent acceptés comme Bearer Token
+// baseURL est l'url publique du serveur de ressources (htu des preuves)
+func DPoPMiddleware(resolveKey KeyResolver, checkToken TokenChecker, checkReplay ProofReplayChecker, baseURL string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		authHeader := ctx.GetHeader("Authorization")
+		partsToken := strings.Split(authHeader, " ")
+		if len(partsToken) != 2 || (partsToken[0] != "Bearer" && partsToken[0] != "DPoP") {
+			ctx.Header("WWW-Authenticate", `DPoP algs="`+strings.Join(utils.DPoPSigningAlgorithms, " ")+`"`)
+			ctx.JSON(http.StatusUnauthorized, gin.H{
+				"message": "pas de jeton d'accès fournis ",
+				"success": false,
+			})
+			ctx.Abort()
+			return
+		}
+
+		claims, ok := verifyAccessToken(ctx, resolveKey, checkToken, partsToken[1])
+		if !ok {
+			return
+		}
+
+		jkt := confirmationKey(claims)
+		if partsToken[0] == "Bearer" {
+			if jkt != "" {
+				dpopError(ctx, "jeton lié par DPoP présenté comme Bearer Token ")
+				return
+			}
+			ctx.Set("claims", claims)
+			ctx.Next()
+			return
+		}
+
+		//le schéma DPoP exige un jeton lié et une preuve de la même clé
+		proofs := ctx.Request.Header.Values("DPoP")
+		if jkt == "" || len(proofs) != 1 {
+			dpopError(ctx, "preuve DPoP manquante ou jeton non lié ")
+			return
+		}
+
+		proof, err := utils.ParseDPoPProof(proofs[0], ctx.Request.Method, baseURL+ctx.Request.URL.Path, partsToken[1])
+		if err != nil {
+			dpopError(ctx, err.Error())
+			return
+		}
+		if proof.JKT != jkt {
+			dpopError(ctx, "la clé de la preuve DPoP ne correspond pas au jeton ")
+			return
+		}
+		if err := checkReplay(ctx.Request.Context(), proof.JTI, proof.ReplayUntil()); err != nil {
+			dpopError(ctx, "la preuve DPoP a déjà été utilisée ")
+			return
+		}
+
+		ctx.Set("claims", claims)
+		ctx.Next()
+	}
+}
+
+// refus d'une requête DPoP avec le challenge WWW-Authenticate
+func dpopError(ctx *gin.Context, message string) {
+	ctx.Header("WWW-Authenticate", `DPoP error="invalid_token", algs="`+strings.Join(utils.DPoPSigningAlgorithms, " ")+`"`)
+	ctx.JSON(http.StatusUnauthorized, gin.H{
+		"message": message,
+		"success": false,
+	})
+	ctx.Abort()
+}
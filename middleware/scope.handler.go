@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	fosite_jwt "github.com/ory/fosite/token/jwt"
+)
+
+// restriction d'une API aux jetons portant l'un des scopes donnés
+// à placer après AuthMiddleware ou DPoPMiddleware
+func ScopeMiddleware(scopes ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		value, _ := ctx.Get("claims")
+		claims, ok := value.(fosite_jwt.JWTClaims)
+		if !ok || !slices.ContainsFunc(scopes, func(scope string) bool {
+			return slices.Contains(claims.Scope, scope)
+		}) {
+			ctx.JSON(http.StatusForbidden, gin.H{
+				"message": "vous n'avez pas les autorisations nécessaires ",
+				"success": false,
+			})
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,213 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/token/jwt"
+)
+
+const (
+	//évènement back-channel logout du logout token
+	backchannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+	//durée de vie d'un logout token
+	logoutTokenLifespan = 2 * time.Minute
+	//fréquence de reprise des notifications en échec
+	BackchannelRetryInterval = 1 * time.Minute
+	//nombre maximal de tentatives d'envoi
+	backchannelMaxAttempts = 8
+	//nombre de notifications traitées par reprise
+	backchannelBatchSize = 50
+	//durée de réservation d'une notification pendant son envoi
+	backchannelClaimLease = 2 * time.Minute
+)
+
+// stockage des notifications de déconnexion back-channel
+type BackchannelLogoutStorage interface {
+	GetSessionClients(ctx context.Context, sessionID uuid.UUID) ([]models.Client, error)
+	SubjectFor(ctx context.Context, client fosite.Client, userID uuid.UUID, username string) (string, error)
+	CreateBackchannelLogouts(ctx context.Context, logouts []models.BackchannelLogout) error
+	ClaimBackchannelLogout(ctx context.Context, id uuid.UUID, lease time.Duration) (bool, error)
+	ClaimDueBackchannelLogouts(ctx context.Context, limit int, lease time.Duration) ([]models.BackchannelLogout, error)
+	SaveBackchannelLogout(ctx context.Context, logout *models.BackchannelLogout) error
+}
+
+// envoi des notifications de déconnexion back-channel aux clients
+// les notifications sont persistées et reprises en cas d'échec
+type BackchannelNotifier struct {
+	store  BackchannelLogoutStorage
+	signer jwt.Signer
+	issuer string
+	client *http.Client
+}
+
+func NewBackchannelNotifier(store BackchannelLogoutStorage, signer jwt.Signer, issuer string) *BackchannelNotifier {
+	return &BackchannelNotifier{
+		store:  store,
+		signer: signer,
+		issuer: issuer,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// planifie la notification des clients ayant reçu des jetons de la session
+// (à appeler avant la révocation de la session) puis tente un premier envoi
+// le sub du logout token est celui de l'utilisateur pour chaque client (public ou pairwise)
+func (b *BackchannelNotifier) Notify(ctx context.Context, session *models.Session) error {
+	sessionID := session.ID
+	clients, err := b.store.GetSessionClients(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	logouts := []models.BackchannelLogout{}
+	for _, client := range clients {
+		if client.GetBackchannelLogoutURI() == "" {
+			continue
+		}
+		subject := session.GetSubject()
+		if session.UserID != nil {
+			if subject, err = b.store.SubjectFor(ctx, &client, *session.UserID, session.Username); err != nil {
+				return err
+			}
+		}
+		logouts = append(logouts, models.BackchannelLogout{
+			ID:            uuid.New(),
+			State:         models.LOGOUT_STATE_PENDING,
+			SessionID:     sessionID,
+			Subject:       subject,
+			NextAttemptAt: now,
+			ClientID:      client.ID,
+			Client:        client,
+		})
+	}
+
+	if err := b.store.CreateBackchannelLogouts(ctx, logouts); err != nil {
+		return err
+	}
+
+	//premier envoi sans bloquer la réponse de déconnexion
+	// la notification est réservée pour ne pas être envoyée en même temps par la reprise
+	go func() {
+		ctx := context.Background()
+		for i := range logouts {
+			claimed, err := b.store.ClaimBackchannelLogout(ctx, logouts[i].ID, backchannelClaimLease)
+			if err != nil {
+				log.Printf("warning: %v", err)
+				continue
+			}
+			if claimed {
+				b.deliver(ctx, &logouts[i])
+			}
+		}
+	}()
+
+	return nil
+}
+
+// reprise des notifications en attente jusqu'à l'annulation du contexte
+func (b *BackchannelNotifier) StartRetry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		logouts, err := b.store.ClaimDueBackchannelLogouts(ctx, backchannelBatchSize, backchannelClaimLease)
+		if err != nil {
+			log.Printf("warning: reprise des déconnexions back-channel impossible: %v", err)
+			continue
+		}
+		for i := range logouts {
+			b.deliver(ctx, &logouts[i])
+		}
+	}
+}
+
+// tentative d'envoi d'une notification et mise à jour de son état
+func (b *BackchannelNotifier) deliver(ctx context.Context, logout *models.BackchannelLogout) {
+	logout.Attempts++
+	err := b.send(ctx, logout)
+
+	now := time.Now().UTC()
+	switch {
+	case err == nil:
+		logout.State = models.LOGOUT_STATE_DELIVERED
+		logout.DeliveredAt = &now
+		logout.LastError = ""
+	case logout.Attempts >= backchannelMaxAttempts:
+		logout.State = models.LOGOUT_STATE_FAILED
+		logout.LastError = err.Error()
+	default:
+		//attente exponentielle entre deux tentatives
+		logout.NextAttemptAt = now.Add(time.Duration(1<<logout.Attempts) * time.Minute)
+		logout.LastError = err.Error()
+	}
+
+	if err := b.store.SaveBackchannelLogout(ctx, logout); err != nil {
+		log.Printf("warning: %v", err)
+	}
+}
+
+// signature du logout token et POST vers le client
+func (b *BackchannelNotifier) send(ctx context.Context, logout *models.BackchannelLogout) error {
+	token, err := b.logoutToken(ctx, logout)
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{"logout_token": {token}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, logout.Client.GetBackchannelLogoutURI(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("le client a répondu %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// logout token signé comme les id_token (OpenID Connect Back-Channel Logout 1.0)
+func (b *BackchannelNotifier) logoutToken(ctx context.Context, logout *models.BackchannelLogout) (string, error) {
+	now := time.Now().UTC()
+	claims := jwt.MapClaims{
+		"iss":    b.issuer,
+		"sub":    logout.Subject,
+		"aud":    []string{logout.ClientID.String()},
+		"iat":    now.Unix(),
+		"exp":    now.Add(logoutTokenLifespan).Unix(),
+		"jti":    uuid.New().String(),
+		"sid":    logout.SessionID.String(),
+		"events": map[string]interface{}{backchannelLogoutEvent: map[string]interface{}{}},
+	}
+	header := &jwt.Headers{Extra: map[string]interface{}{
+		"typ": "logout+jwt",
+		"alg": logout.Client.GetRequestObjectSigningAlgorithm(),
+	}}
+
+	token, _, err := b.signer.Generate(ctx, claims, header)
+	return token, err
+}
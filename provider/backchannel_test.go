@@ -0,0 +1,267 @@
+package provider
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/token/jwt"
+)
+
+// stockage en mémoire des notifications de déconnexion
+type memoryLogoutStorage struct {
+	mu      sync.Mutex
+	clients []models.Client
+	logouts map[uuid.UUID]*models.BackchannelLogout
+	saved   chan models.BackchannelLogout
+}
+
+func newMemoryLogoutStorage(clients ...models.Client) *memoryLogoutStorage {
+	return &memoryLogoutStorage{
+		clients: clients,
+		logouts: map[uuid.UUID]*models.BackchannelLogout{},
+		saved:   make(chan models.BackchannelLogout, 16),
+	}
+}
+
+func (m *memoryLogoutStorage) GetSessionClients(ctx context.Context, sessionID uuid.UUID) ([]models.Client, error) {
+	return m.clients, nil
+}
+
+func (m *memoryLogoutStorage) SubjectFor(ctx context.Context, client fosite.Client, userID uuid.UUID, username string) (string, error) {
+	return "pairwise-" + username, nil
+}
+
+func (m *memoryLogoutStorage) CreateBackchannelLogouts(ctx context.Context, logouts []models.BackchannelLogout) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, logout := range logouts {
+		m.logouts[logout.ID] = &logout
+	}
+	return nil
+}
+
+func (m *memoryLogoutStorage) ClaimBackchannelLogout(ctx context.Context, id uuid.UUID, lease time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now().UTC()
+	logout, ok := m.logouts[id]
+	if !ok || logout.State != models.LOGOUT_STATE_PENDING || logout.NextAttemptAt.After(now) {
+		return false, nil
+	}
+	logout.NextAttemptAt = now.Add(lease)
+	return true, nil
+}
+
+func (m *memoryLogoutStorage) ClaimDueBackchannelLogouts(ctx context.Context, limit int, lease time.Duration) ([]models.BackchannelLogout, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now().UTC()
+	logouts := []models.BackchannelLogout{}
+	for _, logout := range m.logouts {
+		if len(logouts) == limit {
+			break
+		}
+		if logout.State != models.LOGOUT_STATE_PENDING || logout.NextAttemptAt.After(now) {
+			continue
+		}
+		logout.NextAttemptAt = now.Add(lease)
+		logouts = append(logouts, *logout)
+	}
+	return logouts, nil
+}
+
+func (m *memoryLogoutStorage) SaveBackchannelLogout(ctx context.Context, logout *models.BackchannelLogout) error {
+	m.mu.Lock()
+	stored := *logout
+	m.logouts[logout.ID] = &stored
+	m.mu.Unlock()
+	m.saved <- stored
+	return nil
+}
+
+// rend la notification immédiatement due pour la reprise
+func (m *memoryLogoutStorage) makeDue(id uuid.UUID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.logouts[id].NextAttemptAt = time.Now().UTC().Add(-time.Second)
+}
+
+func (m *memoryLogoutStorage) waitSaved(t *testing.T) models.BackchannelLogout {
+	t.Helper()
+	select {
+	case logout := <-m.saved:
+		return logout
+	case <-time.After(5 * time.Second):
+		t.Fatal("aucune tentative d'envoi enregistrée")
+		return models.BackchannelLogout{}
+	}
+}
+
+func testSigner(t *testing.T) (*jwt.DefaultSigner, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &jwt.DefaultSigner{GetPrivateKey: func(ctx context.Context) (interface{}, error) {
+		return key, nil
+	}}, key
+}
+
+func testLogoutSession() *models.Session {
+	userID := uuid.New()
+	return &models.Session{ID: uuid.New(), Username: "alice", Subject: "alice", UserID: &userID}
+}
+
+func TestBackchannelNotifyDeliversLogoutToken(t *testing.T) {
+	tokens := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/x-www-form-urlencoded" {
+			t.Errorf("content-type inattendu: %s", ct)
+		}
+		tokens <- r.PostFormValue("logout_token")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := models.Client{ID: uuid.New(), BackchannelLogoutURI: server.URL, RequestObjectSigningAlg: "RS256"}
+	store := newMemoryLogoutStorage(client)
+	signer, _ := testSigner(t)
+	notifier := NewBackchannelNotifier(store, signer, "https://issuer.test")
+
+	session := testLogoutSession()
+	if err := notifier.Notify(context.Background(), session); err != nil {
+		t.Fatal(err)
+	}
+
+	var raw string
+	select {
+	case raw = <-tokens:
+	case <-time.After(5 * time.Second):
+		t.Fatal("le client n'a reçu aucun logout token")
+	}
+	logout := store.waitSaved(t)
+	if logout.State != models.LOGOUT_STATE_DELIVERED || logout.Attempts != 1 || logout.DeliveredAt == nil {
+		t.Fatalf("notification non marquée comme remise: %+v", logout)
+	}
+
+	token, err := signer.Decode(context.Background(), raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ := token.Header["typ"]; typ != "logout+jwt" {
+		t.Errorf("typ = %v", typ)
+	}
+	claims := token.Claims
+	if claims["iss"] != "https://issuer.test" {
+		t.Errorf("iss = %v", claims["iss"])
+	}
+	if claims["sub"] != "pairwise-alice" {
+		t.Errorf("sub = %v", claims["sub"])
+	}
+	if claims["sid"] != session.ID.String() {
+		t.Errorf("sid = %v", claims["sid"])
+	}
+	if aud, ok := claims["aud"].([]interface{}); !ok || len(aud) != 1 || aud[0] != client.ID.String() {
+		t.Errorf("aud = %v", claims["aud"])
+	}
+	if jti, _ := claims["jti"].(string); jti == "" {
+		t.Error("jti manquant")
+	}
+	if _, ok := claims["nonce"]; ok {
+		t.Error("un logout token ne doit pas contenir de nonce")
+	}
+	events, ok := claims["events"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("events = %v", claims["events"])
+	}
+	if _, ok := events[backchannelLogoutEvent]; !ok {
+		t.Errorf("évènement back-channel logout absent: %v", events)
+	}
+}
+
+func TestBackchannelRetryAfterFailure(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	store := newMemoryLogoutStorage(models.Client{ID: uuid.New(), BackchannelLogoutURI: server.URL})
+	signer, _ := testSigner(t)
+	notifier := NewBackchannelNotifier(store, signer, "https://issuer.test")
+
+	if err := notifier.Notify(context.Background(), testLogoutSession()); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := store.waitSaved(t)
+	if failed.State != models.LOGOUT_STATE_PENDING || failed.Attempts != 1 || failed.LastError == "" {
+		t.Fatalf("échec non enregistré: %+v", failed)
+	}
+	if !failed.NextAttemptAt.After(time.Now()) {
+		t.Fatalf("la prochaine tentative doit être différée: %v", failed.NextAttemptAt)
+	}
+
+	store.makeDue(failed.ID)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go notifier.StartRetry(ctx, 10*time.Millisecond)
+
+	delivered := store.waitSaved(t)
+	if delivered.State != models.LOGOUT_STATE_DELIVERED || delivered.Attempts != 2 || delivered.LastError != "" {
+		t.Fatalf("la reprise n'a pas remis la notification: %+v", delivered)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("%d envois, attendu 2", n)
+	}
+}
+
+func TestBackchannelClaimPreventsDuplicateDelivery(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	store := newMemoryLogoutStorage(models.Client{ID: uuid.New(), BackchannelLogoutURI: server.URL})
+	signer, _ := testSigner(t)
+	notifier := NewBackchannelNotifier(store, signer, "https://issuer.test")
+
+	//la reprise tourne pendant le premier envoi
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go notifier.StartRetry(ctx, time.Millisecond)
+
+	if err := notifier.Notify(context.Background(), testLogoutSession()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	logout := store.waitSaved(t)
+	if logout.State != models.LOGOUT_STATE_DELIVERED {
+		t.Fatalf("notification non remise: %+v", logout)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("%d envois, attendu 1", n)
+	}
+}
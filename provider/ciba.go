@@ -0,0 +1,502 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/handler/oauth2"
+	"github.com/ory/fosite/handler/openid"
+)
+
+const (
+	//grant_type de l'authentification back-channel (CIBA)
+	CIBAGrantType = "urn:openid:params:grant-type:ciba"
+	//durée de vie maximale d'une demande (requested_expiry est borné par cette valeur)
+	CIBALifespan = 5 * time.Minute
+	//intervalle minimal de polling du token end-point (secondes)
+	CIBAPollInterval = 5
+
+	//modes de remise des jetons supportés
+	CIBADeliveryPoll = "poll"
+	CIBADeliveryPing = "ping"
+
+	bindingMessageMaxLength          = 64
+	clientNotificationTokenMaxLength = 1024
+
+	//fréquence de reprise des notifications ping en échec
+	CIBAPingRetryInterval = 1 * time.Minute
+	//nombre maximal de tentatives de notification ping
+	cibaPingMaxAttempts = 5
+	//durée de réservation d'une notification ping pendant son envoi
+	cibaPingClaimLease = 2 * time.Minute
+	//nombre de notifications ping traitées par reprise
+	cibaPingBatchSize = 50
+)
+
+// erreurs du back-channel authentication end-point (CIBA section 13)
+var (
+	ErrUnknownUserID = &fosite.RFC6749Error{
+		ErrorField:       "unknown_user_id",
+		DescriptionField: "The OpenID Provider is not able to identify which end-user the Client wishes to be authenticated by means of the hint provided in the request.",
+		CodeField:        http.StatusBadRequest,
+	}
+	ErrInvalidBindingMessage = &fosite.RFC6749Error{
+		ErrorField:       "invalid_binding_message",
+		DescriptionField: "The binding message is invalid or unacceptable for use in the context of the given request.",
+		CodeField:        http.StatusBadRequest,
+	}
+)
+
+// stockage des demandes d'authentification back-channel
+type BackchannelAuthStorage interface {
+	GetUserByLoginHint(ctx context.Context, hint string) (*models.User, error)
+	GetClientInfo(ctx context.Context, id string) (*models.InfoClient, error)
+	CreateBackchannelAuthSession(ctx context.Context, signature string, ticket string, authReqID []byte, expiresAt time.Time, interval int, userID uuid.UUID, request fosite.Requester) error
+	GetBackchannelAuthSession(ctx context.Context, signature string) (*models.BackchannelAuthRequest, fosite.Requester, error)
+	PollBackchannelAuth(ctx context.Context, id uuid.UUID, interval int) error
+	InvalidateBackchannelAuthSession(ctx context.Context, id uuid.UUID) error
+	ClaimBackchannelAuthPing(ctx context.Context, id uuid.UUID, lease time.Duration) (bool, error)
+	ClaimDueBackchannelAuthPings(ctx context.Context, limit int, lease time.Duration) ([]models.BackchannelAuthRequest, error)
+	SaveBackchannelAuthPing(ctx context.Context, auth *models.BackchannelAuthRequest) error
+}
+
+// réponse du back-channel authentication end-point (CIBA section 7.3)
+type CIBAResponse struct {
+	AuthReqID string `json:"auth_req_id"`
+	ExpiresIn int64  `json:"expires_in"`
+	Interval  int    `json:"interval,omitempty"`
+}
+
+// handler de l'authentification back-channel en modes poll et ping
+// fosite v0.49 ne fournit pas ce grant: le handler est composé comme le device grant
+type CIBAHandler struct {
+	*oauth2.HandleHelper
+	CIBAStorage          BackchannelAuthStorage
+	RefreshTokenStorage  oauth2.RefreshTokenStorage
+	RefreshTokenStrategy oauth2.RefreshTokenStrategy
+	IDTokenStrategy      openid.OpenIDConnectTokenStrategy
+	Config               fosite.Configurator
+
+	//envoi de la demande d'approbation à l'utilisateur
+	Notifier Notifier
+
+	//notification des clients en mode ping
+	client *http.Client
+}
+
+var _ fosite.TokenEndpointHandler = (*CIBAHandler)(nil)
+
+// factory compatible avec compose.Compose
+func CIBAGrantFactory(config fosite.Configurator, storage interface{}, strategy interface{}) interface{} {
+	return &CIBAHandler{
+		HandleHelper: &oauth2.HandleHelper{
+			AccessTokenStrategy: strategy.(oauth2.AccessTokenStrategy),
+			AccessTokenStorage:  storage.(oauth2.AccessTokenStorage),
+			Config:              config,
+		},
+		CIBAStorage:          storage.(BackchannelAuthStorage),
+		RefreshTokenStorage:  storage.(oauth2.RefreshTokenStorage),
+		RefreshTokenStrategy: strategy.(oauth2.RefreshTokenStrategy),
+		IDTokenStrategy:      strategy.(openid.OpenIDConnectTokenStrategy),
+		Config:               config,
+		Notifier:             NewNotifier(),
+		client:               &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// demande d'authentification back-channel (CIBA section 7.1)
+// le client confidentiel s'authentifie comme au token end-point
+// et désigne l'utilisateur par login_hint (nom d'utilisateur ou email)
+func (h *CIBAHandler) NewBackchannelAuthRequest(ctx context.Context, client fosite.Client, form url.Values) (fosite.Requester, *models.User, error) {
+	if !client.GetGrantTypes().Has(CIBAGrantType) {
+		return nil, nil, fosite.ErrUnauthorizedClient.WithHintf("le client n'est pas autorisé à utiliser le grant '%s'", CIBAGrantType)
+	}
+	if client.IsPublic() {
+		return nil, nil, fosite.ErrUnauthorizedClient.WithHint("l'authentification back-channel est réservée aux clients confidentiels")
+	}
+
+	if form.Get("login_hint_token") != "" || form.Get("id_token_hint") != "" {
+		return nil, nil, fosite.ErrInvalidRequest.WithHint("seul le paramètre 'login_hint' est supporté")
+	}
+	hint := form.Get("login_hint")
+	if hint == "" {
+		return nil, nil, fosite.ErrInvalidRequest.WithHint("le paramètre 'login_hint' est manquant")
+	}
+
+	if utf8.RuneCountInString(form.Get("binding_message")) > bindingMessageMaxLength {
+		return nil, nil, ErrInvalidBindingMessage.WithHintf("le binding_message ne doit pas dépasser %d caractères", bindingMessageMaxLength)
+	}
+	if expiry := form.Get("requested_expiry"); expiry != "" {
+		if seconds, err := strconv.Atoi(expiry); err != nil || seconds <= 0 {
+			return nil, nil, fosite.ErrInvalidRequest.WithHint("le paramètre 'requested_expiry' doit être un nombre de secondes positif")
+		}
+	}
+
+	//en mode ping le client fournit le jeton bearer de sa notification
+	if c, ok := client.(*models.Client); ok && c.GetBackchannelTokenDeliveryMode() == CIBADeliveryPing {
+		token := form.Get("client_notification_token")
+		if token == "" {
+			return nil, nil, fosite.ErrInvalidRequest.WithHint("le paramètre 'client_notification_token' est requis en mode ping")
+		}
+		if len(token) > clientNotificationTokenMaxLength {
+			return nil, nil, fosite.ErrInvalidRequest.WithHint("le paramètre 'client_notification_token' est trop long")
+		}
+	}
+
+	request := fosite.NewRequest()
+	request.Client = client
+	request.Form = form
+	request.RequestedScope = fosite.RemoveEmpty(strings.Split(form.Get("scope"), " "))
+	request.RequestedAudience = fosite.GetAudiences(form)
+
+	if !request.GetRequestedScopes().Has("openid") {
+		return nil, nil, fosite.ErrInvalidScope.WithHint("le scope 'openid' est requis")
+	}
+	for _, scope := range request.GetRequestedScopes() {
+		if !h.Config.GetScopeStrategy(ctx)(client.GetScopes(), scope) {
+			return nil, nil, fosite.ErrInvalidScope.WithHintf("le client n'est pas autorisé à demander le scope '%s'", scope)
+		}
+	}
+	if err := h.Config.GetAudienceStrategy(ctx)(client.GetAudience(), request.GetRequestedAudience()); err != nil {
+		return nil, nil, err
+	}
+
+	user, err := h.CIBAStorage.GetUserByLoginHint(ctx, hint)
+	if errors.Is(err, fosite.ErrNotFound) {
+		return nil, nil, ErrUnknownUserID.WithHint("aucun utilisateur ne correspond au login_hint")
+	} else if err != nil {
+		return nil, nil, fosite.ErrServerError.WithWrap(err)
+	}
+
+	return request, user, nil
+}
+
+// génération et enregistrement de l'auth_req_id puis envoi
+// du lien d'approbation à l'utilisateur par le notifieur
+func (h *CIBAHandler) NewBackchannelAuthResponse(ctx context.Context, request fosite.Requester, user *models.User, approvalURI string) (*CIBAResponse, error) {
+	lifespan := CIBALifespan
+	if seconds, err := strconv.Atoi(request.GetRequestForm().Get("requested_expiry")); err == nil && time.Duration(seconds)*time.Second < lifespan {
+		lifespan = time.Duration(seconds) * time.Second
+	}
+
+	authReqID, err := utils.GenerateToken(32)
+	if err != nil {
+		return nil, fosite.ErrServerError.WithWrap(err)
+	}
+	ticket, err := utils.GenerateToken(32)
+	if err != nil {
+		return nil, fosite.ErrServerError.WithWrap(err)
+	}
+
+	//seul le mode ping a besoin de l'auth_req_id en clair pour notifier le client
+	var encrypted []byte
+	if c, ok := request.GetClient().(*models.Client); ok && c.GetBackchannelTokenDeliveryMode() == CIBADeliveryPing {
+		if encrypted, err = utils.Encrypt([]byte(authReqID)); err != nil {
+			return nil, fosite.ErrServerError.WithWrap(err)
+		}
+	}
+
+	expiresAt := time.Now().UTC().Add(lifespan)
+	if err := h.CIBAStorage.CreateBackchannelAuthSession(ctx, utils.GenerateHash(authReqID), utils.GenerateHash(ticket), encrypted, expiresAt, CIBAPollInterval, user.ID, request); err != nil {
+		return nil, fosite.ErrServerError.WithWrap(err)
+	}
+
+	clientName := request.GetClient().GetID()
+	if info, err := h.CIBAStorage.GetClientInfo(ctx, clientName); err == nil && info.NameOrganization != "" {
+		clientName = info.NameOrganization
+	}
+
+	notification := CIBANotification{
+		User:           user,
+		ClientName:     clientName,
+		BindingMessage: request.GetRequestForm().Get("binding_message"),
+		Scopes:         request.GetRequestedScopes(),
+		ApprovalURI:    approvalURI + "?ticket=" + url.QueryEscape(ticket),
+		ExpiresAt:      expiresAt,
+	}
+	if err := h.Notifier.Notify(ctx, notification); err != nil {
+		return nil, fosite.ErrServerError.WithHint("impossible de notifier l'utilisateur").WithWrap(err)
+	}
+
+	return &CIBAResponse{
+		AuthReqID: authReqID,
+		ExpiresIn: int64(lifespan.Seconds()),
+		Interval:  CIBAPollInterval,
+	}, nil
+}
+
+// notification du client en mode ping une fois la demande approuvée ou refusée (CIBA section 10.2)
+// le client récupère ensuite le résultat au token end-point
+// la notification est réservée puis envoyée sans bloquer, les échecs sont repris par StartPingRetry
+func (h *CIBAHandler) NotifyClient(auth *models.BackchannelAuthRequest) {
+	if auth.Client.GetBackchannelTokenDeliveryMode() != CIBADeliveryPing {
+		return
+	}
+
+	go func() {
+		ctx := context.Background()
+		claimed, err := h.CIBAStorage.ClaimBackchannelAuthPing(ctx, auth.ID, cibaPingClaimLease)
+		if err != nil {
+			log.Printf("warning: %v", err)
+			return
+		}
+		if claimed {
+			h.deliverPing(ctx, auth)
+		}
+	}()
+}
+
+// reprise des notifications ping en attente jusqu'à l'annulation du contexte
+func (h *CIBAHandler) StartPingRetry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		auths, err := h.CIBAStorage.ClaimDueBackchannelAuthPings(ctx, cibaPingBatchSize, cibaPingClaimLease)
+		if err != nil {
+			log.Printf("warning: reprise des notifications ciba impossible: %v", err)
+			continue
+		}
+		for i := range auths {
+			h.deliverPing(ctx, &auths[i])
+		}
+	}
+}
+
+// tentative de notification ping et mise à jour de son état
+func (h *CIBAHandler) deliverPing(ctx context.Context, auth *models.BackchannelAuthRequest) {
+	auth.PingAttempts++
+	err := h.ping(ctx, auth)
+
+	now := time.Now().UTC()
+	switch {
+	case err == nil:
+		auth.PingedAt = &now
+		auth.PingNextAttemptAt = nil
+		auth.PingError = ""
+	case auth.PingAttempts >= cibaPingMaxAttempts:
+		log.Printf("warning: notification ciba du client %s abandonnée: %v", auth.ClientID, err)
+		auth.PingNextAttemptAt = nil
+		auth.PingError = err.Error()
+	default:
+		log.Printf("warning: notification ciba du client %s impossible: %v", auth.ClientID, err)
+		//attente exponentielle entre deux tentatives
+		next := now.Add(time.Duration(1<<auth.PingAttempts) * time.Second)
+		auth.PingNextAttemptAt = &next
+		auth.PingError = err.Error()
+	}
+
+	if err := h.CIBAStorage.SaveBackchannelAuthPing(ctx, auth); err != nil {
+		log.Printf("warning: %v", err)
+	}
+}
+
+// POST de l'auth_req_id vers l'url de notification du client
+func (h *CIBAHandler) ping(ctx context.Context, auth *models.BackchannelAuthRequest) error {
+	authReqID, err := utils.Decrypt(auth.AuthReqID)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]string{"auth_req_id": string(authReqID)})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, auth.Client.GetBackchannelClientNotificationEndpoint(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+auth.ClientNotificationToken)
+
+	response, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("notification ciba refusée par le client: statut %d", response.StatusCode)
+	}
+	return nil
+}
+
+// polling du token end-point avec l'auth_req_id (CIBA section 10.1)
+func (h *CIBAHandler) HandleTokenEndpointRequest(ctx context.Context, request fosite.AccessRequester) error {
+	if !h.CanHandleTokenEndpointRequest(ctx, request) {
+		return fosite.ErrUnknownRequest
+	}
+
+	client := request.GetClient()
+	if !client.GetGrantTypes().Has(CIBAGrantType) {
+		return fosite.ErrUnauthorizedClient.WithHintf("le client n'est pas autorisé à utiliser le grant '%s'", CIBAGrantType)
+	}
+
+	id := request.GetRequestForm().Get("auth_req_id")
+	if id == "" {
+		return fosite.ErrInvalidRequest.WithHint("le paramètre 'auth_req_id' est manquant")
+	}
+
+	auth, stored, err := h.CIBAStorage.GetBackchannelAuthSession(ctx, utils.GenerateHash(id))
+	if errors.Is(err, fosite.ErrNotFound) {
+		return fosite.ErrInvalidGrant.WithHint("auth_req_id inconnu")
+	} else if err != nil {
+		return fosite.ErrServerError.WithWrap(err)
+	}
+
+	if stored.GetClient().GetID() != client.GetID() {
+		return fosite.ErrInvalidGrant.WithHint("l'auth_req_id a été émis pour un autre client")
+	}
+	if auth.IsExpired() {
+		return ErrExpiredToken.WithHint("l'auth_req_id est expiré")
+	}
+
+	switch auth.State {
+	case models.CIBA_STATE_DENIED:
+		return fosite.ErrAccessDenied.WithHint("l'utilisateur a refusé l'accès")
+	case models.CIBA_STATE_USED:
+		return fosite.ErrInvalidGrant.WithHint("l'auth_req_id est déjà utilisé")
+	case models.CIBA_STATE_PENDING:
+		//le client doit respecter l'intervalle de polling, augmenté de 5 secondes à chaque excès
+		interval := auth.Interval
+		pollErr := ErrAuthorizationPending
+		if auth.LastPolledAt != nil && time.Now().UTC().Before(auth.LastPolledAt.Add(time.Duration(interval)*time.Second)) {
+			interval += CIBAPollInterval
+			pollErr = ErrSlowDown
+		}
+		if err := h.CIBAStorage.PollBackchannelAuth(ctx, auth.ID, interval); err != nil {
+			return fosite.ErrServerError.WithWrap(err)
+		}
+		return pollErr
+	}
+
+	//un seul polling concurrent peut échanger la demande approuvée
+	if err := h.CIBAStorage.InvalidateBackchannelAuthSession(ctx, auth.ID); errors.Is(err, fosite.ErrNotFound) {
+		return fosite.ErrInvalidGrant.WithHint("l'auth_req_id est déjà utilisé")
+	} else if err != nil {
+		return fosite.ErrServerError.WithWrap(err)
+	}
+
+	session, ok := stored.GetSession().(*models.Session)
+	if !ok {
+		return fosite.ErrServerError.WithHint("session de la demande introuvable")
+	}
+	session.SetClient(client)
+
+	request.SetID(stored.GetID())
+	request.SetSession(session)
+	request.SetRequestedScopes(stored.GetRequestedScopes())
+	request.SetRequestedAudience(stored.GetRequestedAudience())
+	for _, scope := range stored.GetGrantedScopes() {
+		request.GrantScope(scope)
+	}
+	for _, audience := range stored.GetGrantedAudience() {
+		request.GrantAudience(audience)
+	}
+
+	atLifespan := fosite.GetEffectiveLifespan(client, CIBAGrantType, fosite.AccessToken, h.Config.GetAccessTokenLifespan(ctx))
+	session.SetExpiresAt(fosite.AccessToken, time.Now().UTC().Add(atLifespan).Round(time.Second))
+
+	rtLifespan := fosite.GetEffectiveLifespan(client, CIBAGrantType, fosite.RefreshToken, h.Config.GetRefreshTokenLifespan(ctx))
+	if rtLifespan > -1 {
+		session.SetExpiresAt(fosite.RefreshToken, time.Now().UTC().Add(rtLifespan).Round(time.Second))
+	}
+
+	return nil
+}
+
+// émission des jetons pour la demande approuvée (CIBA section 10.1.1)
+func (h *CIBAHandler) PopulateTokenEndpointResponse(ctx context.Context, requester fosite.AccessRequester, responder fosite.AccessResponder) error {
+	if !h.CanHandleTokenEndpointRequest(ctx, requester) {
+		return fosite.ErrUnknownRequest
+	}
+
+	atLifespan := fosite.GetEffectiveLifespan(requester.GetClient(), CIBAGrantType, fosite.AccessToken, h.Config.GetAccessTokenLifespan(ctx))
+	accessSignature, err := h.IssueAccessToken(ctx, atLifespan, requester, responder)
+	if err != nil {
+		return err
+	}
+
+	if len(h.Config.GetRefreshTokenScopes(ctx)) == 0 || requester.GetGrantedScopes().HasOneOf(h.Config.GetRefreshTokenScopes(ctx)...) {
+		refresh, refreshSignature, err := h.RefreshTokenStrategy.GenerateRefreshToken(ctx, requester)
+		if err != nil {
+			return fosite.ErrServerError.WithWrap(err)
+		}
+		if err := h.RefreshTokenStorage.CreateRefreshTokenSession(ctx, refreshSignature, accessSignature, requester.Sanitize([]string{})); err != nil {
+			return fosite.ErrServerError.WithWrap(err)
+		}
+		responder.SetExtra("refresh_token", refresh)
+	}
+
+	idLifespan := fosite.GetEffectiveLifespan(requester.GetClient(), CIBAGrantType, fosite.IDToken, h.Config.GetIDTokenLifespan(ctx))
+	idToken, err := h.IDTokenStrategy.GenerateIDToken(ctx, idLifespan, requester)
+	if err != nil {
+		return err
+	}
+	responder.SetExtra("id_token", idToken)
+
+	return nil
+}
+
+func (h *CIBAHandler) CanSkipClientAuth(ctx context.Context, requester fosite.AccessRequester) bool {
+	return false
+}
+
+func (h *CIBAHandler) CanHandleTokenEndpointRequest(ctx context.Context, requester fosite.AccessRequester) bool {
+	return requester.GetGrantTypes().ExactOne(CIBAGrantType)
+}
+
+// demande d'authentification back-channel authentifiée par le client
+func (p *Provider) NewBackchannelAuthRequest(ctx context.Context, r *http.Request) (fosite.Requester, *models.User, error) {
+	if p.CIBA == nil {
+		return nil, nil, fosite.ErrUnsupportedGrantType.WithHint("l'authentification back-channel n'est pas activée")
+	}
+	if r.Method != http.MethodPost {
+		return nil, nil, fosite.ErrInvalidRequest.WithHint("la demande doit utiliser la méthode POST")
+	}
+	if err := r.ParseMultipartForm(1 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return nil, nil, fosite.ErrInvalidRequest.WithHint("formulaire invalide").WithWrap(err)
+	}
+
+	f, ok := p.OAuth2Provider.(*fosite.Fosite)
+	if !ok {
+		return nil, nil, fosite.ErrServerError.WithHint("fournisseur fosite invalide")
+	}
+	client, err := f.AuthenticateClient(ctx, r, r.PostForm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return p.CIBA.NewBackchannelAuthRequest(ctx, client, r.PostForm)
+}
+
+// réponse JSON du back-channel authentication end-point
+func (p *Provider) WriteBackchannelAuthResponse(ctx context.Context, rw http.ResponseWriter, response *CIBAResponse) {
+	rw.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Header().Set("Pragma", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(response)
+}
@@ -0,0 +1,396 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/ory/fosite"
+)
+
+// stockage en mémoire des demandes d'authentification back-channel
+type memoryCIBAStorage struct {
+	mu       sync.Mutex
+	user     models.User
+	auths    map[uuid.UUID]*models.BackchannelAuthRequest
+	requests map[uuid.UUID]*fosite.Request
+	pings    chan models.BackchannelAuthRequest
+}
+
+func newMemoryCIBAStorage() *memoryCIBAStorage {
+	user := models.User{}
+	user.ID = uuid.New()
+	user.UserName = "alice"
+	user.Email = "alice@easyclass.test"
+	return &memoryCIBAStorage{
+		user:     user,
+		auths:    map[uuid.UUID]*models.BackchannelAuthRequest{},
+		requests: map[uuid.UUID]*fosite.Request{},
+		pings:    make(chan models.BackchannelAuthRequest, 16),
+	}
+}
+
+func (m *memoryCIBAStorage) GetUserByLoginHint(ctx context.Context, hint string) (*models.User, error) {
+	if hint != m.user.UserName && hint != m.user.Email {
+		return nil, fosite.ErrNotFound
+	}
+	user := m.user
+	return &user, nil
+}
+
+func (m *memoryCIBAStorage) GetClientInfo(ctx context.Context, id string) (*models.InfoClient, error) {
+	return nil, fosite.ErrNotFound
+}
+
+func (m *memoryCIBAStorage) CreateBackchannelAuthSession(ctx context.Context, signature string, ticket string, authReqID []byte, expiresAt time.Time, interval int, userID uuid.UUID, request fosite.Requester) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	client := request.GetClient().(*models.Client)
+	auth := &models.BackchannelAuthRequest{
+		ID:                      uuid.New(),
+		Signature:               signature,
+		Ticket:                  ticket,
+		AuthReqID:               authReqID,
+		State:                   models.CIBA_STATE_PENDING,
+		ExpiresAt:               expiresAt,
+		Interval:                interval,
+		ClientNotificationToken: request.GetRequestForm().Get("client_notification_token"),
+		ClientID:                client.ID,
+		Client:                  *client,
+		UserID:                  userID,
+	}
+	m.auths[auth.ID] = auth
+	m.requests[auth.ID] = request.(*fosite.Request)
+	return nil
+}
+
+func (m *memoryCIBAStorage) GetBackchannelAuthSession(ctx context.Context, signature string) (*models.BackchannelAuthRequest, fosite.Requester, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, auth := range m.auths {
+		if auth.Signature == signature {
+			found := *auth
+			return &found, m.requests[id], nil
+		}
+	}
+	return nil, nil, fosite.ErrNotFound
+}
+
+func (m *memoryCIBAStorage) PollBackchannelAuth(ctx context.Context, id uuid.UUID, interval int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now().UTC()
+	m.auths[id].LastPolledAt = &now
+	m.auths[id].Interval = interval
+	return nil
+}
+
+func (m *memoryCIBAStorage) InvalidateBackchannelAuthSession(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.auths[id].State != models.CIBA_STATE_APPROVED {
+		return fosite.ErrNotFound
+	}
+	m.auths[id].State = models.CIBA_STATE_USED
+	return nil
+}
+
+// une notification est due pour une demande décidée et non expirée
+func (m *memoryCIBAStorage) pingDue(auth *models.BackchannelAuthRequest, now time.Time) bool {
+	decided := auth.State == models.CIBA_STATE_APPROVED || auth.State == models.CIBA_STATE_DENIED
+	return decided && auth.PingedAt == nil && auth.PingNextAttemptAt != nil && !auth.PingNextAttemptAt.After(now) && auth.ExpiresAt.After(now) && auth.Client.GetBackchannelTokenDeliveryMode() == CIBADeliveryPing
+}
+
+func (m *memoryCIBAStorage) ClaimBackchannelAuthPing(ctx context.Context, id uuid.UUID, lease time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now().UTC()
+	auth := m.auths[id]
+	if !m.pingDue(auth, now) {
+		return false, nil
+	}
+	next := now.Add(lease)
+	auth.PingNextAttemptAt = &next
+	return true, nil
+}
+
+func (m *memoryCIBAStorage) ClaimDueBackchannelAuthPings(ctx context.Context, limit int, lease time.Duration) ([]models.BackchannelAuthRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now().UTC()
+	auths := []models.BackchannelAuthRequest{}
+	for _, auth := range m.auths {
+		if len(auths) == limit || !m.pingDue(auth, now) {
+			continue
+		}
+		next := now.Add(lease)
+		auth.PingNextAttemptAt = &next
+		auths = append(auths, *auth)
+	}
+	return auths, nil
+}
+
+func (m *memoryCIBAStorage) SaveBackchannelAuthPing(ctx context.Context, auth *models.BackchannelAuthRequest) error {
+	m.mu.Lock()
+	stored := m.auths[auth.ID]
+	stored.PingAttempts = auth.PingAttempts
+	stored.PingNextAttemptAt = auth.PingNextAttemptAt
+	stored.PingedAt = auth.PingedAt
+	stored.PingError = auth.PingError
+	saved := *stored
+	m.mu.Unlock()
+	m.pings <- saved
+	return nil
+}
+
+// décision de l'utilisateur comme sur la page d'approbation
+func (m *memoryCIBAStorage) decide(t *testing.T, state string) *models.BackchannelAuthRequest {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, auth := range m.auths {
+		if auth.State != models.CIBA_STATE_PENDING {
+			continue
+		}
+		now := time.Now().UTC()
+		auth.State = state
+		auth.PingNextAttemptAt = &now
+		if state == models.CIBA_STATE_APPROVED {
+			request := m.requests[id]
+			request.Session = &models.Session{ID: uuid.New(), Username: m.user.UserName, Subject: m.user.UserName}
+			request.GrantScope("openid")
+		}
+		decided := *auth
+		return &decided
+	}
+	t.Fatal("aucune demande en attente")
+	return nil
+}
+
+// rend la notification ping immédiatement due pour la reprise
+func (m *memoryCIBAStorage) makePingDue(id uuid.UUID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	past := time.Now().UTC().Add(-time.Second)
+	m.auths[id].PingNextAttemptAt = &past
+}
+
+// repousse le dernier polling pour respecter l'intervalle
+func (m *memoryCIBAStorage) waitInterval(id uuid.UUID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	past := time.Now().UTC().Add(-time.Minute)
+	m.auths[id].LastPolledAt = &past
+}
+
+func (m *memoryCIBAStorage) waitPing(t *testing.T) models.BackchannelAuthRequest {
+	t.Helper()
+	select {
+	case auth := <-m.pings:
+		return auth
+	case <-time.After(5 * time.Second):
+		t.Fatal("aucune tentative de notification ping enregistrée")
+		return models.BackchannelAuthRequest{}
+	}
+}
+
+func newTestCIBAHandler(store BackchannelAuthStorage, notifier Notifier) *CIBAHandler {
+	return &CIBAHandler{
+		CIBAStorage: store,
+		Config:      &fosite.Config{},
+		Notifier:    notifier,
+		client:      &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func newTestCIBAClient(mode string, endpoint string) *models.Client {
+	return &models.Client{
+		ID:                                    uuid.New(),
+		Public:                                utils.PtrBool(false),
+		Grants:                                pq.StringArray{CIBAGrantType},
+		Scopes:                                pq.StringArray{"openid", "profile"},
+		BackchannelTokenDeliveryMode:          mode,
+		BackchannelClientNotificationEndpoint: endpoint,
+	}
+}
+
+// demande back-channel acceptée et auth_req_id remis au client
+func startCIBA(t *testing.T, h *CIBAHandler, client *models.Client, form url.Values) *CIBAResponse {
+	t.Helper()
+	form.Set("login_hint", "alice")
+	form.Set("scope", "openid profile")
+	form.Set("binding_message", "A1B2")
+
+	request, user, err := h.NewBackchannelAuthRequest(context.Background(), client, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := h.NewBackchannelAuthResponse(context.Background(), request, user, "https://issuer.test/oidc/bc-authorize/approve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.AuthReqID == "" || response.Interval != CIBAPollInterval || response.ExpiresIn <= 0 {
+		t.Fatalf("réponse invalide: %+v", response)
+	}
+	return response
+}
+
+// polling du token end-point avec l'auth_req_id
+func pollCIBA(h *CIBAHandler, client *models.Client, authReqID string) (*fosite.AccessRequest, error) {
+	request := fosite.NewAccessRequest(&models.Session{})
+	request.Client = client
+	request.GrantTypes = fosite.Arguments{CIBAGrantType}
+	request.Form = url.Values{"auth_req_id": {authReqID}}
+	return request, h.HandleTokenEndpointRequest(context.Background(), request)
+}
+
+func TestCIBAPollMode(t *testing.T) {
+	store := newMemoryCIBAStorage()
+	notifier := &StubNotifier{}
+	h := newTestCIBAHandler(store, notifier)
+	client := newTestCIBAClient(CIBADeliveryPoll, "")
+
+	response := startCIBA(t, h, client, url.Values{})
+
+	notifications := notifier.Notifications()
+	if len(notifications) != 1 {
+		t.Fatalf("%d notifications, attendu 1", len(notifications))
+	}
+	if n := notifications[0]; n.User.UserName != "alice" || n.BindingMessage != "A1B2" || !strings.Contains(n.ApprovalURI, "?ticket=") {
+		t.Fatalf("notification invalide: %+v", n)
+	}
+
+	//la demande est en attente, un polling trop rapide ralentit le client
+	if _, err := pollCIBA(h, client, response.AuthReqID); !errors.Is(err, ErrAuthorizationPending) {
+		t.Fatalf("authorization_pending attendu, obtenu %v", err)
+	}
+	if _, err := pollCIBA(h, client, response.AuthReqID); !errors.Is(err, ErrSlowDown) {
+		t.Fatalf("slow_down attendu, obtenu %v", err)
+	}
+
+	//un autre client ne peut pas utiliser l'auth_req_id
+	if _, err := pollCIBA(h, newTestCIBAClient(CIBADeliveryPoll, ""), response.AuthReqID); !errors.Is(err, fosite.ErrInvalidGrant) {
+		t.Fatalf("invalid_grant attendu, obtenu %v", err)
+	}
+
+	auth := store.decide(t, models.CIBA_STATE_APPROVED)
+	store.waitInterval(auth.ID)
+	request, err := pollCIBA(h, client, response.AuthReqID)
+	if err != nil {
+		t.Fatalf("demande approuvée refusée: %v", err)
+	}
+	if !request.GetGrantedScopes().Has("openid") {
+		t.Errorf("scopes accordés = %v", request.GetGrantedScopes())
+	}
+	if session, ok := request.GetSession().(*models.Session); !ok || session.GetUsername() != "alice" {
+		t.Errorf("session de la demande non reprise: %+v", request.GetSession())
+	}
+
+	//l'auth_req_id ne peut être échangé qu'une fois
+	if _, err := pollCIBA(h, client, response.AuthReqID); !errors.Is(err, fosite.ErrInvalidGrant) {
+		t.Fatalf("invalid_grant attendu, obtenu %v", err)
+	}
+}
+
+func TestCIBADenied(t *testing.T) {
+	store := newMemoryCIBAStorage()
+	h := newTestCIBAHandler(store, &StubNotifier{})
+	client := newTestCIBAClient(CIBADeliveryPoll, "")
+
+	response := startCIBA(t, h, client, url.Values{})
+	store.decide(t, models.CIBA_STATE_DENIED)
+
+	if _, err := pollCIBA(h, client, response.AuthReqID); !errors.Is(err, fosite.ErrAccessDenied) {
+		t.Fatalf("access_denied attendu, obtenu %v", err)
+	}
+}
+
+func TestCIBAUnknownUser(t *testing.T) {
+	h := newTestCIBAHandler(newMemoryCIBAStorage(), &StubNotifier{})
+	form := url.Values{"login_hint": {"inconnu"}, "scope": {"openid"}}
+	if _, _, err := h.NewBackchannelAuthRequest(context.Background(), newTestCIBAClient(CIBADeliveryPoll, ""), form); !errors.Is(err, ErrUnknownUserID) {
+		t.Fatalf("unknown_user_id attendu, obtenu %v", err)
+	}
+}
+
+func TestCIBAPingModeRetry(t *testing.T) {
+	type ping struct {
+		authorization string
+		authReqID     string
+	}
+	pings := make(chan ping, 4)
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		pings <- ping{authorization: r.Header.Get("Authorization"), authReqID: body["auth_req_id"]}
+		//le client est indisponible à la première notification
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	store := newMemoryCIBAStorage()
+	h := newTestCIBAHandler(store, &StubNotifier{})
+	client := newTestCIBAClient(CIBADeliveryPing, server.URL)
+
+	//le jeton de notification est requis en mode ping
+	if _, _, err := h.NewBackchannelAuthRequest(context.Background(), client, url.Values{"login_hint": {"alice"}, "scope": {"openid"}}); !errors.Is(err, fosite.ErrInvalidRequest) {
+		t.Fatalf("invalid_request attendu, obtenu %v", err)
+	}
+	response := startCIBA(t, h, client, url.Values{"client_notification_token": {"notif-token"}})
+
+	auth := store.decide(t, models.CIBA_STATE_APPROVED)
+	h.NotifyClient(auth)
+
+	failed := store.waitPing(t)
+	if failed.PingedAt != nil || failed.PingAttempts != 1 || failed.PingError == "" || failed.PingNextAttemptAt == nil {
+		t.Fatalf("échec de notification non enregistré: %+v", failed)
+	}
+
+	store.makePingDue(auth.ID)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.StartPingRetry(ctx, 10*time.Millisecond)
+
+	delivered := store.waitPing(t)
+	if delivered.PingedAt == nil || delivered.PingAttempts != 2 || delivered.PingError != "" {
+		t.Fatalf("la reprise n'a pas remis la notification: %+v", delivered)
+	}
+
+	for i := 0; i < 2; i++ {
+		p := <-pings
+		if p.authorization != "Bearer notif-token" {
+			t.Errorf("Authorization = %q", p.authorization)
+		}
+		if p.authReqID != response.AuthReqID {
+			t.Errorf("auth_req_id = %q, attendu %q", p.authReqID, response.AuthReqID)
+		}
+	}
+
+	//une notification remise n'est plus reprise
+	time.Sleep(50 * time.Millisecond)
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("%d notifications, attendu 2", n)
+	}
+
+	//le client récupère ensuite les jetons par le token end-point
+	if _, err := pollCIBA(h, client, response.AuthReqID); err != nil {
+		t.Fatalf("demande approuvée refusée: %v", err)
+	}
+}
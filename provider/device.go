@@ -0,0 +1,343 @@
+package provider
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/handler/oauth2"
+	"github.com/ory/fosite/handler/openid"
+)
+
+const (
+	//grant_type du device authorization grant (RFC 8628)
+	DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+	//durée de vie d'un device_code et de son user_code
+	DeviceCodeLifespan = 10 * time.Minute
+	//intervalle minimal de polling du token end-point (secondes)
+	DevicePollInterval = 5
+	//alphabet des user_code sans voyelle ni caractère ambigu (RFC 8628 section 6.1)
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+// erreurs du token end-point propres au device grant (RFC 8628 section 3.5)
+var (
+	ErrAuthorizationPending = &fosite.RFC6749Error{
+		ErrorField:       "authorization_pending",
+		DescriptionField: "The authorization request is still pending as the end user hasn't yet completed the user-interaction steps.",
+		CodeField:        http.StatusBadRequest,
+	}
+	ErrSlowDown = &fosite.RFC6749Error{
+		ErrorField:       "slow_down",
+		DescriptionField: "The authorization request is still pending and polling should continue, but the interval must be increased.",
+		CodeField:        http.StatusBadRequest,
+	}
+	ErrExpiredToken = &fosite.RFC6749Error{
+		ErrorField:       "expired_token",
+		DescriptionField: "The device_code has expired, and the device authorization session has concluded.",
+		CodeField:        http.StatusBadRequest,
+	}
+)
+
+// stockage des demandes d'autorisation d'appareil
+type DeviceCodeStorage interface {
+	CreateDeviceCodeSession(ctx context.Context, signature string, userCode string, expiresAt time.Time, interval int, request fosite.Requester) error
+	GetDeviceCodeSession(ctx context.Context, signature string) (*models.DeviceCode, fosite.Requester, error)
+	PollDeviceCode(ctx context.Context, id uuid.UUID, interval int) error
+	InvalidateDeviceCodeSession(ctx context.Context, id uuid.UUID) error
+}
+
+// réponse du device authorization end-point (RFC 8628 section 3.2)
+type DeviceResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// handler du device authorization grant
+// fosite v0.49 ne fournit pas ce grant: le handler est composé comme les autres
+type DeviceHandler struct {
+	*oauth2.HandleHelper
+	DeviceStorage        DeviceCodeStorage
+	RefreshTokenStorage  oauth2.RefreshTokenStorage
+	RefreshTokenStrategy oauth2.RefreshTokenStrategy
+	IDTokenStrategy      openid.OpenIDConnectTokenStrategy
+	Config               fosite.Configurator
+}
+
+var _ fosite.TokenEndpointHandler = (*DeviceHandler)(nil)
+
+// factory compatible avec compose.Compose
+func DeviceGrantFactory(config fosite.Configurator, storage interface{}, strategy interface{}) interface{} {
+	return &DeviceHandler{
+		HandleHelper: &oauth2.HandleHelper{
+			AccessTokenStrategy: strategy.(oauth2.AccessTokenStrategy),
+			AccessTokenStorage:  storage.(oauth2.AccessTokenStorage),
+			Config:              config,
+		},
+		DeviceStorage:        storage.(DeviceCodeStorage),
+		RefreshTokenStorage:  storage.(oauth2.RefreshTokenStorage),
+		RefreshTokenStrategy: strategy.(oauth2.RefreshTokenStrategy),
+		IDTokenStrategy:      strategy.(openid.OpenIDConnectTokenStrategy),
+		Config:               config,
+	}
+}
+
+// demande d'autorisation d'un appareil (RFC 8628 section 3.1)
+// le client s'authentifie comme au token end-point
+func (d *DeviceHandler) NewDeviceRequest(ctx context.Context, client fosite.Client, form url.Values) (fosite.Requester, error) {
+	if !client.GetGrantTypes().Has(DeviceCodeGrantType) {
+		return nil, fosite.ErrUnauthorizedClient.WithHintf("le client n'est pas autorisé à utiliser le grant '%s'", DeviceCodeGrantType)
+	}
+
+	request := fosite.NewRequest()
+	request.Client = client
+	request.Form = form
+	request.RequestedScope = fosite.RemoveEmpty(strings.Split(form.Get("scope"), " "))
+	request.RequestedAudience = fosite.GetAudiences(form)
+
+	for _, scope := range request.GetRequestedScopes() {
+		if !d.Config.GetScopeStrategy(ctx)(client.GetScopes(), scope) {
+			return nil, fosite.ErrInvalidScope.WithHintf("le client n'est pas autorisé à demander le scope '%s'", scope)
+		}
+	}
+	if err := d.Config.GetAudienceStrategy(ctx)(client.GetAudience(), request.GetRequestedAudience()); err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+// génération et enregistrement du device_code et du user_code
+func (d *DeviceHandler) NewDeviceResponse(ctx context.Context, request fosite.Requester, verificationURI string) (*DeviceResponse, error) {
+	deviceCode, err := utils.GenerateToken(32)
+	if err != nil {
+		return nil, fosite.ErrServerError.WithWrap(err)
+	}
+	userCode, err := generateUserCode()
+	if err != nil {
+		return nil, fosite.ErrServerError.WithWrap(err)
+	}
+
+	expiresAt := time.Now().UTC().Add(DeviceCodeLifespan)
+	if err := d.DeviceStorage.CreateDeviceCodeSession(ctx, utils.GenerateHash(deviceCode), userCode, expiresAt, DevicePollInterval, request); err != nil {
+		return nil, fosite.ErrServerError.WithWrap(err)
+	}
+
+	display := FormatUserCode(userCode)
+	return &DeviceResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                display,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?user_code=" + url.QueryEscape(display),
+		ExpiresIn:               int64(DeviceCodeLifespan.Seconds()),
+		Interval:                DevicePollInterval,
+	}, nil
+}
+
+// polling du token end-point avec le device_code (RFC 8628 section 3.4)
+func (d *DeviceHandler) HandleTokenEndpointRequest(ctx context.Context, request fosite.AccessRequester) error {
+	if !d.CanHandleTokenEndpointRequest(ctx, request) {
+		return fosite.ErrUnknownRequest
+	}
+
+	client := request.GetClient()
+	if !client.GetGrantTypes().Has(DeviceCodeGrantType) {
+		return fosite.ErrUnauthorizedClient.WithHintf("le client n'est pas autorisé à utiliser le grant '%s'", DeviceCodeGrantType)
+	}
+
+	code := request.GetRequestForm().Get("device_code")
+	if code == "" {
+		return fosite.ErrInvalidRequest.WithHint("le paramètre 'device_code' est manquant")
+	}
+
+	device, stored, err := d.DeviceStorage.GetDeviceCodeSession(ctx, utils.GenerateHash(code))
+	if errors.Is(err, fosite.ErrNotFound) {
+		return fosite.ErrInvalidGrant.WithHint("device_code inconnu")
+	} else if err != nil {
+		return fosite.ErrServerError.WithWrap(err)
+	}
+
+	if stored.GetClient().GetID() != client.GetID() {
+		return fosite.ErrInvalidGrant.WithHint("le device_code a été émis pour un autre client")
+	}
+	if device.IsExpired() {
+		return ErrExpiredToken
+	}
+
+	switch device.State {
+	case models.DEVICE_STATE_DENIED:
+		return fosite.ErrAccessDenied.WithHint("l'utilisateur a refusé l'accès")
+	case models.DEVICE_STATE_USED:
+		return fosite.ErrInvalidGrant.WithHint("le device_code est déjà utilisé")
+	case models.DEVICE_STATE_PENDING:
+		//l'appareil doit respecter l'intervalle de polling, augmenté de 5 secondes à chaque excès
+		interval := device.Interval
+		pollErr := ErrAuthorizationPending
+		if device.LastPolledAt != nil && time.Now().UTC().Before(device.LastPolledAt.Add(time.Duration(interval)*time.Second)) {
+			interval += DevicePollInterval
+			pollErr = ErrSlowDown
+		}
+		if err := d.DeviceStorage.PollDeviceCode(ctx, device.ID, interval); err != nil {
+			return fosite.ErrServerError.WithWrap(err)
+		}
+		return pollErr
+	}
+
+	//un seul polling concurrent peut échanger le device_code approuvé
+	if err := d.DeviceStorage.InvalidateDeviceCodeSession(ctx, device.ID); errors.Is(err, fosite.ErrNotFound) {
+		return fosite.ErrInvalidGrant.WithHint("le device_code est déjà utilisé")
+	} else if err != nil {
+		return fosite.ErrServerError.WithWrap(err)
+	}
+
+	session, ok := stored.GetSession().(*models.Session)
+	if !ok {
+		return fosite.ErrServerError.WithHint("session de l'appareil introuvable")
+	}
+	session.SetClient(client)
+
+	request.SetID(stored.GetID())
+	request.SetSession(session)
+	request.SetRequestedScopes(stored.GetRequestedScopes())
+	request.SetRequestedAudience(stored.GetRequestedAudience())
+	for _, scope := range stored.GetGrantedScopes() {
+		request.GrantScope(scope)
+	}
+	for _, audience := range stored.GetGrantedAudience() {
+		request.GrantAudience(audience)
+	}
+
+	atLifespan := fosite.GetEffectiveLifespan(client, DeviceCodeGrantType, fosite.AccessToken, d.Config.GetAccessTokenLifespan(ctx))
+	session.SetExpiresAt(fosite.AccessToken, time.Now().UTC().Add(atLifespan).Round(time.Second))
+
+	rtLifespan := fosite.GetEffectiveLifespan(client, DeviceCodeGrantType, fosite.RefreshToken, d.Config.GetRefreshTokenLifespan(ctx))
+	if rtLifespan > -1 {
+		session.SetExpiresAt(fosite.RefreshToken, time.Now().UTC().Add(rtLifespan).Round(time.Second))
+	}
+
+	return nil
+}
+
+// émission des jetons pour le device_code approuvé (RFC 8628 section 3.5)
+func (d *DeviceHandler) PopulateTokenEndpointResponse(ctx context.Context, requester fosite.AccessRequester, responder fosite.AccessResponder) error {
+	if !d.CanHandleTokenEndpointRequest(ctx, requester) {
+		return fosite.ErrUnknownRequest
+	}
+
+	atLifespan := fosite.GetEffectiveLifespan(requester.GetClient(), DeviceCodeGrantType, fosite.AccessToken, d.Config.GetAccessTokenLifespan(ctx))
+	accessSignature, err := d.IssueAccessToken(ctx, atLifespan, requester, responder)
+	if err != nil {
+		return err
+	}
+
+	if len(d.Config.GetRefreshTokenScopes(ctx)) == 0 || requester.GetGrantedScopes().HasOneOf(d.Config.GetRefreshTokenScopes(ctx)...) {
+		refresh, refreshSignature, err := d.RefreshTokenStrategy.GenerateRefreshToken(ctx, requester)
+		if err != nil {
+			return fosite.ErrServerError.WithWrap(err)
+		}
+		if err := d.RefreshTokenStorage.CreateRefreshTokenSession(ctx, refreshSignature, accessSignature, requester.Sanitize([]string{})); err != nil {
+			return fosite.ErrServerError.WithWrap(err)
+		}
+		responder.SetExtra("refresh_token", refresh)
+	}
+
+	if requester.GetGrantedScopes().Has("openid") {
+		idLifespan := fosite.GetEffectiveLifespan(requester.GetClient(), DeviceCodeGrantType, fosite.IDToken, d.Config.GetIDTokenLifespan(ctx))
+		idToken, err := d.IDTokenStrategy.GenerateIDToken(ctx, idLifespan, requester)
+		if err != nil {
+			return err
+		}
+		responder.SetExtra("id_token", idToken)
+	}
+
+	return nil
+}
+
+func (d *DeviceHandler) CanSkipClientAuth(ctx context.Context, requester fosite.AccessRequester) bool {
+	return false
+}
+
+func (d *DeviceHandler) CanHandleTokenEndpointRequest(ctx context.Context, requester fosite.AccessRequester) bool {
+	return requester.GetGrantTypes().ExactOne(DeviceCodeGrantType)
+}
+
+// demande d'autorisation d'appareil authentifiée par le client
+func (p *Provider) NewDeviceRequest(ctx context.Context, r *http.Request) (fosite.Requester, error) {
+	if p.Device == nil {
+		return nil, fosite.ErrUnsupportedGrantType.WithHint("le device grant n'est pas activé")
+	}
+	if r.Method != http.MethodPost {
+		return nil, fosite.ErrInvalidRequest.WithHint("la demande doit utiliser la méthode POST")
+	}
+	if err := r.ParseMultipartForm(1 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return nil, fosite.ErrInvalidRequest.WithHint("formulaire invalide").WithWrap(err)
+	}
+
+	f, ok := p.OAuth2Provider.(*fosite.Fosite)
+	if !ok {
+		return nil, fosite.ErrServerError.WithHint("fournisseur fosite invalide")
+	}
+	client, err := f.AuthenticateClient(ctx, r, r.PostForm)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.Device.NewDeviceRequest(ctx, client, r.PostForm)
+}
+
+// réponse JSON du device authorization end-point
+func (p *Provider) WriteDeviceResponse(ctx context.Context, rw http.ResponseWriter, response *DeviceResponse) {
+	rw.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Header().Set("Pragma", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(response)
+}
+
+// normalisation d'un user_code saisi par l'utilisateur (majuscules, sans séparateur)
+func NormalizeUserCode(code string) string {
+	var builder strings.Builder
+	for _, char := range strings.ToUpper(code) {
+		if strings.ContainsRune(userCodeAlphabet, char) {
+			builder.WriteRune(char)
+		}
+	}
+	return builder.String()
+}
+
+// affichage d'un user_code en deux groupes (BCDF-GHJK)
+func FormatUserCode(code string) string {
+	if len(code) != userCodeLength {
+		return code
+	}
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
+
+// génération aléatoire d'un user_code
+func generateUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
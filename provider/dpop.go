@@ -0,0 +1,66 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/ory/fosite"
+)
+
+// en-tête de la preuve et type des jetons liés par DPoP (RFC 9449)
+const (
+	DPoPHeader    = "DPoP"
+	DPoPTokenType = "DPoP"
+)
+
+// preuve DPoP invalide au token end-point (RFC 9449 section 5)
+var ErrInvalidDPoPProof = &fosite.RFC6749Error{
+	ErrorField:       "invalid_dpop_proof",
+	DescriptionField: "The DPoP proof is invalid.",
+	CodeField:        http.StatusBadRequest,
+}
+
+// validation de la preuve DPoP d'une requête au serveur
+// renvoie le thumbprint de sa clé, vide si la requête n'a pas de preuve
+func (p *Provider) ValidateDPoPProof(ctx context.Context, r *http.Request, accessToken string) (string, error) {
+	proofs := r.Header.Values(DPoPHeader)
+	if len(proofs) == 0 {
+		return "", nil
+	}
+	if len(proofs) > 1 {
+		return "", ErrInvalidDPoPProof.WithHint("une seule preuve DPoP est autorisée")
+	}
+
+	proof, err := utils.ParseDPoPProof(proofs[0], r.Method, utils.URL_Host+r.URL.Path, accessToken)
+	if err != nil {
+		return "", ErrInvalidDPoPProof.WithHint(err.Error())
+	}
+
+	if err := p.store.MarkDPoPProofUsed(ctx, proof.JTI, proof.ReplayUntil()); errors.Is(err, fosite.ErrJTIKnown) {
+		return "", ErrInvalidDPoPProof.WithHint("la preuve DPoP a déjà été utilisée")
+	} else if err != nil {
+		return "", fosite.ErrServerError.WithWrap(err)
+	}
+
+	return proof.JKT, nil
+}
+
+// liaison des jetons émis à la clé de la preuve DPoP
+// une session déjà liée (refresh token) exige une preuve de la même clé
+func BindDPoPKey(request fosite.AccessRequester, jkt string) error {
+	session, ok := request.GetSession().(*models.Session)
+	if !ok {
+		return fosite.ErrServerError.WithHint("session invalide")
+	}
+
+	if session.JKT != "" && session.JKT != jkt {
+		return ErrInvalidDPoPProof.WithHint("la preuve DPoP doit utiliser la clé liée aux jetons")
+	}
+	if jkt != "" {
+		session.JKT = jkt
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package provider
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/go-jose/go-jose/v3"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+)
+
+// stockage en mémoire du fournisseur
+type memoryProviderStorage struct {
+	mu        sync.Mutex
+	clients   map[string]*models.Client
+	jtis      map[string]time.Time
+	resources []models.ProtectedResource
+}
+
+func newMemoryProviderStorage(clients ...*models.Client) *memoryProviderStorage {
+	m := &memoryProviderStorage{clients: map[string]*models.Client{}, jtis: map[string]time.Time{}}
+	for _, client := range clients {
+		m.clients[client.GetID()] = client
+	}
+	return m
+}
+
+func (m *memoryProviderStorage) GetClient(ctx context.Context, id string) (fosite.Client, error) {
+	client, ok := m.clients[id]
+	if !ok {
+		return nil, fosite.ErrNotFound
+	}
+	return client, nil
+}
+
+func (m *memoryProviderStorage) markUsed(jti string, exp time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if until, ok := m.jtis[jti]; ok && time.Now().Before(until) {
+		return fosite.ErrJTIKnown
+	}
+	m.jtis[jti] = exp
+	return nil
+}
+
+func (m *memoryProviderStorage) MarkDPoPProofUsed(ctx context.Context, jti string, exp time.Time) error {
+	return m.markUsed("dpop:"+jti, exp)
+}
+
+func (m *memoryProviderStorage) MarkRequestObjectUsed(ctx context.Context, clientID string, jti string, exp time.Time) error {
+	return m.markUsed("jar:"+clientID+":"+jti, exp)
+}
+
+func (m *memoryProviderStorage) GetProtectedResources(ctx context.Context, identifiers []string) ([]models.ProtectedResource, error) {
+	resources := []models.ProtectedResource{}
+	for _, resource := range m.resources {
+		for _, identifier := range identifiers {
+			if resource.Identifier == identifier {
+				resources = append(resources, resource)
+			}
+		}
+	}
+	return resources, nil
+}
+
+// preuve DPoP signée par key avec l'en-tête typ
+func dpopProof(t *testing.T, key *ecdsa.PrivateKey, typ string, claims map[string]any) string {
+	t.Helper()
+	options := (&jose.SignerOptions{EmbedJWK: true}).WithType(jose.ContentType(typ))
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	object, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := object.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proof
+}
+
+func TestValidateDPoPProof(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk := jose.JSONWebKey{Key: &key.PublicKey}
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken := "access-token"
+	hash := sha256.Sum256([]byte(accessToken))
+	htu := utils.URL_Host + "/me"
+
+	claims := func(change func(map[string]any)) map[string]any {
+		c := map[string]any{
+			"jti": uuid.NewString(),
+			"htm": http.MethodGet,
+			"htu": htu,
+			"iat": time.Now().Unix(),
+			"ath": utils.Base64URL(hash[:]),
+		}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+
+	cases := []struct {
+		name  string
+		typ   string
+		claim func(map[string]any)
+		valid bool
+	}{
+		{"preuve valide", utils.DPoPProofType, nil, true},
+		{"htu avec query", utils.DPoPProofType, func(c map[string]any) { c["htu"] = htu + "?page=1" }, true},
+		{"type invalide", "JWT", nil, false},
+		{"sans jti", utils.DPoPProofType, func(c map[string]any) { delete(c, "jti") }, false},
+		{"méthode différente", utils.DPoPProofType, func(c map[string]any) { c["htm"] = http.MethodPost }, false},
+		{"uri différente", utils.DPoPProofType, func(c map[string]any) { c["htu"] = utils.URL_Host + "/oauth2/token" }, false},
+		{"preuve trop ancienne", utils.DPoPProofType, func(c map[string]any) { c["iat"] = time.Now().Add(-10 * time.Minute).Unix() }, false},
+		{"preuve dans le futur", utils.DPoPProofType, func(c map[string]any) { c["iat"] = time.Now().Add(5 * time.Minute).Unix() }, false},
+		{"hash du jeton différent", utils.DPoPProofType, func(c map[string]any) { c["ath"] = "autre" }, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &Provider{store: newMemoryProviderStorage()}
+			r := httptest.NewRequest(http.MethodGet, "/me", nil)
+			r.Header.Set(DPoPHeader, dpopProof(t, key, tc.typ, claims(tc.claim)))
+
+			jkt, err := p.ValidateDPoPProof(context.Background(), r, accessToken)
+			if !tc.valid {
+				if !errors.Is(err, ErrInvalidDPoPProof) {
+					t.Fatalf("erreur invalid_dpop_proof attendue, obtenu %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if jkt != utils.Base64URL(thumbprint) {
+				t.Fatalf("jkt = %q, attendu le thumbprint de la clé", jkt)
+			}
+		})
+	}
+}
+
+func TestValidateDPoPProofRequestHeaders(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Provider{store: newMemoryProviderStorage()}
+	proof := dpopProof(t, key, utils.DPoPProofType, map[string]any{
+		"jti": uuid.NewString(),
+		"htm": http.MethodPost,
+		"htu": utils.URL_Host + "/oauth2/token",
+		"iat": time.Now().Unix(),
+	})
+	newRequest := func(proofs ...string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/oauth2/token", nil)
+		for _, proof := range proofs {
+			r.Header.Add(DPoPHeader, proof)
+		}
+		return r
+	}
+
+	//sans preuve la requête n'est pas liée
+	if jkt, err := p.ValidateDPoPProof(context.Background(), newRequest(), ""); err != nil || jkt != "" {
+		t.Fatalf("jkt = %q, err = %v", jkt, err)
+	}
+	if _, err := p.ValidateDPoPProof(context.Background(), newRequest(proof, proof), ""); !errors.Is(err, ErrInvalidDPoPProof) {
+		t.Fatalf("erreur attendue pour deux preuves, obtenu %v", err)
+	}
+
+	//une preuve n'est acceptée qu'une fois
+	if _, err := p.ValidateDPoPProof(context.Background(), newRequest(proof), ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.ValidateDPoPProof(context.Background(), newRequest(proof), ""); !errors.Is(err, ErrInvalidDPoPProof) {
+		t.Fatalf("erreur attendue pour une preuve rejouée, obtenu %v", err)
+	}
+}
+
+func TestBindDPoPKey(t *testing.T) {
+	newRequest := func(session *models.Session) *fosite.AccessRequest {
+		return fosite.NewAccessRequest(session)
+	}
+
+	session := &models.Session{}
+	if err := BindDPoPKey(newRequest(session), "jkt-1"); err != nil {
+		t.Fatal(err)
+	}
+	if session.JKT != "jkt-1" {
+		t.Fatalf("jkt = %q", session.JKT)
+	}
+	cnf, _ := session.GetExtraClaims()["cnf"].(map[string]interface{})
+	if cnf["jkt"] != "jkt-1" {
+		t.Fatalf("claim cnf = %v", cnf)
+	}
+
+	//le rafraîchissement d'une session liée exige la même clé
+	if err := BindDPoPKey(newRequest(session), "jkt-1"); err != nil {
+		t.Errorf("même clé refusée: %v", err)
+	}
+	for _, jkt := range []string{"jkt-2", ""} {
+		if err := BindDPoPKey(newRequest(session), jkt); !errors.Is(err, ErrInvalidDPoPProof) {
+			t.Errorf("jkt %q: erreur invalid_dpop_proof attendue, obtenu %v", jkt, err)
+		}
+	}
+
+	//une session non liée reste non liée sans preuve
+	unbound := &models.Session{}
+	if err := BindDPoPKey(newRequest(unbound), ""); err != nil || unbound.JKT != "" {
+		t.Fatalf("jkt = %q, err = %v", unbound.JKT, err)
+	}
+}
@@ -0,0 +1,276 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/go-jose/go-jose/v3"
+	"github.com/ory/fosite"
+)
+
+const (
+	//type de contenu d'un objet de requête publié à une request_uri (RFC 9101 section 5.2)
+	RequestObjectContentType = "application/oauth-authz-req+jwt"
+	//taille maximale d'un objet de requête récupéré par request_uri
+	requestObjectMaxSize = 64 << 10
+	//durée de validité maximale d'un objet de requête (exp borne la conservation du jti)
+	requestObjectMaxLifespan = 60 * time.Minute
+)
+
+// claims JWT propres à l'objet de requête, non recopiés dans les paramètres
+var requestObjectRegisteredClaims = []string{"iss", "aud", "exp", "iat", "nbf", "jti"}
+
+// authorize end-point avec les objets de requête signés (JAR, RFC 9101)
+// et la validation des modes JARM, des authorization_details et des ressources
+func (p *Provider) NewAuthorizeRequest(ctx context.Context, r *http.Request) (fosite.AuthorizeRequester, error) {
+	if err := p.resolveRequestObject(ctx, r, false); err != nil {
+		return requestObjectError(r), err
+	}
+
+	request, err := p.OAuth2Provider.NewAuthorizeRequest(ctx, r)
+	if err != nil {
+		return request, err
+	}
+	if err := validateJARMResponseMode(request); err != nil {
+		return request, err
+	}
+	if _, err := ParseAuthorizationDetails(request.GetClient(), request.GetRequestForm()); err != nil {
+		return request, err
+	}
+	if _, err := p.ValidateResources(ctx, request.GetClient(), request.GetRequestForm()); err != nil {
+		return request, err
+	}
+	return request, nil
+}
+
+// PAR end-point avec les objets de requête signés (JAR, RFC 9101)
+// et la validation des authorization_details et des ressources
+func (p *Provider) NewPushedAuthorizeRequest(ctx context.Context, r *http.Request) (fosite.AuthorizeRequester, error) {
+	if r.Method == http.MethodPost {
+		if err := p.resolveRequestObject(ctx, r, true); err != nil {
+			return requestObjectError(r), err
+		}
+	}
+
+	request, err := p.OAuth2Provider.NewPushedAuthorizeRequest(ctx, r)
+	if err != nil {
+		return request, err
+	}
+	if _, err := ParseAuthorizationDetails(request.GetClient(), request.GetRequestForm()); err != nil {
+		return request, err
+	}
+	if _, err := p.ValidateResources(ctx, request.GetClient(), request.GetRequestForm()); err != nil {
+		return request, err
+	}
+	return request, nil
+}
+
+// remplace les paramètres de la requête par ceux de l'objet de requête signé
+// (paramètre request ou référence request_uri) après vérification de sa signature
+// avec les clés enregistrées du client
+func (p *Provider) resolveRequestObject(ctx context.Context, r *http.Request, isPAR bool) error {
+	if err := r.ParseMultipartForm(1 << 20); err != nil && err != http.ErrNotMultipart {
+		return fosite.ErrInvalidRequest.WithHint("le corps de la requête est mal formé").WithWrap(err)
+	}
+
+	object, location := r.Form.Get("request"), r.Form.Get("request_uri")
+	//la request_uri d'une requête PAR est traitée par fosite
+	if strings.HasPrefix(location, p.Config.GetPushedAuthorizeRequestURIPrefix(ctx)) || (isPAR && location != "") {
+		return nil
+	}
+	if object != "" && location != "" {
+		return fosite.ErrInvalidRequest.WithHint("les paramètres 'request' et 'request_uri' ne peuvent pas être utilisés ensemble")
+	}
+
+	//au PAR end-point le client peut s'identifier uniquement par l'authentification basic
+	clientID := r.Form.Get("client_id")
+	if id, _, ok := r.BasicAuth(); ok && clientID == "" {
+		clientID, _ = url.QueryUnescape(id)
+	}
+	if clientID == "" {
+		return nil
+	}
+	fc, err := p.store.GetClient(ctx, clientID)
+	if err != nil {
+		return fosite.ErrInvalidClient.WithHint("le client demandé n'existe pas").WithWrap(err)
+	}
+	client, ok := fc.(*models.Client)
+	if !ok {
+		return fosite.ErrServerError.WithHint("client invalide")
+	}
+
+	if object == "" && location == "" {
+		if client.RequiresSignedRequestObject() {
+			return fosite.ErrInvalidRequest.WithHint("le client doit transmettre un objet de requête signé")
+		}
+		return nil
+	}
+
+	if location != "" {
+		if !slices.Contains(client.GetRequestURIs(), location) {
+			return fosite.ErrInvalidRequestURI.WithHintf("la request_uri '%s' n'est pas enregistrée pour le client", location)
+		}
+		if object, err = p.fetchRequestObject(ctx, location); err != nil {
+			return err
+		}
+	}
+
+	claims, err := p.verifyRequestObject(ctx, client, object)
+	if err != nil {
+		return err
+	}
+
+	//les paramètres de l'objet remplacent ceux de la requête
+	for name, value := range claims {
+		if slices.Contains(requestObjectRegisteredClaims, name) {
+			continue
+		}
+		param, err := requestObjectParameter(value)
+		if err != nil {
+			return fosite.ErrInvalidRequestObject.WithHintf("le paramètre '%s' de l'objet de requête est invalide", name)
+		}
+		r.Form.Set(name, param)
+	}
+	r.Form.Del("request")
+	r.Form.Del("request_uri")
+
+	return nil
+}
+
+// vérification de la signature et des claims de l'objet de requête (RFC 9101 section 6)
+func (p *Provider) verifyRequestObject(ctx context.Context, client *models.Client, object string) (map[string]any, error) {
+	jws, err := jose.ParseSigned(object)
+	if err != nil || len(jws.Signatures) != 1 {
+		return nil, fosite.ErrInvalidRequestObject.WithHint("l'objet de requête doit être un JWT signé")
+	}
+
+	header := jws.Signatures[0].Protected
+	if !slices.Contains(SigningAlgorithms(), header.Algorithm) {
+		return nil, fosite.ErrInvalidRequestObject.WithHintf("algorithme de signature '%s' non supporté", header.Algorithm)
+	}
+	if alg := client.GetRequestObjectSigningAlgorithm(); alg != "" && alg != header.Algorithm {
+		return nil, fosite.ErrInvalidRequestObject.WithHintf("le client doit signer ses objets de requête avec '%s'", alg)
+	}
+
+	var payload []byte
+	for _, key := range client.GetJSONWebKeys().Keys {
+		if (header.KeyID != "" && key.KeyID != header.KeyID) || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		if payload, err = jws.Verify(key.Public()); err == nil {
+			break
+		}
+	}
+	if payload == nil {
+		return nil, fosite.ErrInvalidRequestObject.WithHint("la signature de l'objet de requête ne correspond à aucune clé du client")
+	}
+
+	claims := map[string]any{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fosite.ErrInvalidRequestObject.WithHint("claims de l'objet de requête invalides")
+	}
+
+	if iss, ok := claims["iss"]; ok && iss != client.GetID() {
+		return nil, fosite.ErrInvalidRequestObject.WithHint("l'émetteur de l'objet de requête doit être le client")
+	}
+	if id, ok := claims["client_id"]; ok && id != client.GetID() {
+		return nil, fosite.ErrInvalidRequestObject.WithHint("le client_id de l'objet de requête ne correspond pas à la requête")
+	}
+
+	issuer := p.Config.GetIDTokenIssuer(ctx)
+	switch aud := claims["aud"].(type) {
+	case string:
+		if aud != issuer {
+			return nil, fosite.ErrInvalidRequestObject.WithHint("l'audience de l'objet de requête doit être le serveur")
+		}
+	case []any:
+		if !slices.Contains(aud, any(issuer)) {
+			return nil, fosite.ErrInvalidRequestObject.WithHint("l'audience de l'objet de requête doit être le serveur")
+		}
+	default:
+		return nil, fosite.ErrInvalidRequestObject.WithHint("l'objet de requête doit contenir une audience")
+	}
+
+	now := time.Now().UTC().Unix()
+	exp, ok := claims["exp"].(float64)
+	if !ok || int64(exp) <= now {
+		return nil, fosite.ErrInvalidRequestObject.WithHint("l'objet de requête est expiré ou sans date d'expiration")
+	}
+	if int64(exp) > now+int64(requestObjectMaxLifespan.Seconds()) {
+		return nil, fosite.ErrInvalidRequestObject.WithHint("la date d'expiration de l'objet de requête est trop lointaine")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && int64(nbf) > now {
+		return nil, fosite.ErrInvalidRequestObject.WithHint("l'objet de requête n'est pas encore valide")
+	}
+
+	//un objet de requête n'est accepté qu'une fois: son jti est conservé jusqu'à exp
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return nil, fosite.ErrInvalidRequestObject.WithHint("l'objet de requête doit contenir un jti")
+	}
+	if err := p.store.MarkRequestObjectUsed(ctx, client.GetID(), jti, time.Unix(int64(exp), 0)); errors.Is(err, fosite.ErrJTIKnown) {
+		return nil, fosite.ErrInvalidRequestObject.WithHint("l'objet de requête a déjà été utilisé")
+	} else if err != nil {
+		return nil, fosite.ErrServerError.WithWrap(err)
+	}
+
+	return claims, nil
+}
+
+// récupération de l'objet de requête publié par le client à la request_uri
+func (p *Provider) fetchRequestObject(ctx context.Context, location string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return "", fosite.ErrInvalidRequestURI.WithHint("request_uri invalide").WithWrap(err)
+	}
+	req.Header.Set("Accept", RequestObjectContentType)
+
+	response, err := p.Config.GetHTTPClient(ctx).StandardClient().Do(req)
+	if err != nil {
+		return "", fosite.ErrInvalidRequestURI.WithHint("impossible de récupérer l'objet de requête").WithWrap(err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fosite.ErrInvalidRequestURI.WithHintf("la request_uri a répondu avec le statut %d", response.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, requestObjectMaxSize))
+	if err != nil {
+		return "", fosite.ErrInvalidRequestURI.WithHint("lecture de l'objet de requête impossible").WithWrap(err)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// valeur d'un claim de l'objet de requête sous forme de paramètre
+// les objets et tableaux (claims, authorization_details, ...) restent en JSON
+func requestObjectParameter(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	data, err := json.Marshal(value)
+	return string(data), err
+}
+
+// requête d'autorisation minimale pour écrire l'erreur d'un objet de requête refusé
+// sans redirection: la redirect_uri n'a pas pu être validée
+func requestObjectError(r *http.Request) fosite.AuthorizeRequester {
+	request := fosite.NewAuthorizeRequest()
+	request.Form = r.Form
+	request.State = r.Form.Get("state")
+	return request
+}
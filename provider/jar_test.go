@@ -0,0 +1,210 @@
+package provider
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/go-jose/go-jose/v3"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+)
+
+// objet de requête signé par key
+func requestObject(t *testing.T, key *ecdsa.PrivateKey, kid string, claims map[string]any) string {
+	t.Helper()
+	options := (&jose.SignerOptions{}).WithType("oauth-authz-req+jwt").WithHeader("kid", kid)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	object, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serialized, err := object.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serialized
+}
+
+type jarFixture struct {
+	provider *Provider
+	client   *models.Client
+	key      *ecdsa.PrivateKey
+}
+
+func newJARFixture(t *testing.T) *jarFixture {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &models.Client{
+		ID:                      uuid.New(),
+		RequestObjectSigningAlg: "ES256",
+		Keys: []models.ClientKey{{
+			JWK: models.JWKey(jose.JSONWebKey{Key: &key.PublicKey, KeyID: "jar", Use: "sig"}),
+		}},
+	}
+	return &jarFixture{
+		provider: &Provider{
+			store:  newMemoryProviderStorage(client),
+			Config: &fosite.Config{IDTokenIssuer: utils.URL_Host},
+		},
+		client: client,
+		key:    key,
+	}
+}
+
+// claims d'un objet de requête valide du client
+func (f *jarFixture) claims(change func(map[string]any)) map[string]any {
+	c := map[string]any{
+		"iss":           f.client.GetID(),
+		"client_id":     f.client.GetID(),
+		"aud":           utils.URL_Host,
+		"exp":           time.Now().Add(5 * time.Minute).Unix(),
+		"jti":           uuid.NewString(),
+		"response_type": "code",
+		"scope":         "openid profile",
+	}
+	if change != nil {
+		change(c)
+	}
+	return c
+}
+
+func TestVerifyRequestObject(t *testing.T) {
+	f := newJARFixture(t)
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name  string
+		key   *ecdsa.PrivateKey
+		claim func(map[string]any)
+		valid bool
+	}{
+		{"objet valide", f.key, nil, true},
+		{"audience en tableau", f.key, func(c map[string]any) { c["aud"] = []string{"https://other.test", utils.URL_Host} }, true},
+		{"clé inconnue", other, nil, false},
+		{"émetteur différent", f.key, func(c map[string]any) { c["iss"] = uuid.NewString() }, false},
+		{"client_id différent", f.key, func(c map[string]any) { c["client_id"] = uuid.NewString() }, false},
+		{"sans audience", f.key, func(c map[string]any) { delete(c, "aud") }, false},
+		{"audience différente", f.key, func(c map[string]any) { c["aud"] = "https://other.test" }, false},
+		{"sans expiration", f.key, func(c map[string]any) { delete(c, "exp") }, false},
+		{"expiré", f.key, func(c map[string]any) { c["exp"] = time.Now().Add(-time.Minute).Unix() }, false},
+		{"expiration trop lointaine", f.key, func(c map[string]any) { c["exp"] = time.Now().Add(2 * time.Hour).Unix() }, false},
+		{"pas encore valide", f.key, func(c map[string]any) { c["nbf"] = time.Now().Add(time.Minute).Unix() }, false},
+		{"sans jti", f.key, func(c map[string]any) { delete(c, "jti") }, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			object := requestObject(t, tc.key, "jar", f.claims(tc.claim))
+			claims, err := f.provider.verifyRequestObject(context.Background(), f.client, object)
+			if !tc.valid {
+				if !errors.Is(err, fosite.ErrInvalidRequestObject) {
+					t.Fatalf("erreur invalid_request_object attendue, obtenu %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims["scope"] != "openid profile" {
+				t.Fatalf("claims = %v", claims)
+			}
+		})
+	}
+
+	if _, err := f.provider.verifyRequestObject(context.Background(), f.client, "pas-un-jwt"); !errors.Is(err, fosite.ErrInvalidRequestObject) {
+		t.Fatalf("erreur attendue pour un objet non signé, obtenu %v", err)
+	}
+}
+
+func TestVerifyRequestObjectReplay(t *testing.T) {
+	f := newJARFixture(t)
+	object := requestObject(t, f.key, "jar", f.claims(nil))
+
+	if _, err := f.provider.verifyRequestObject(context.Background(), f.client, object); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.provider.verifyRequestObject(context.Background(), f.client, object); !errors.Is(err, fosite.ErrInvalidRequestObject) {
+		t.Fatalf("erreur attendue pour un objet rejoué, obtenu %v", err)
+	}
+
+	//le jti est propre au client
+	jti := f.claims(nil)["jti"]
+	f.provider.verifyRequestObject(context.Background(), f.client, requestObject(t, f.key, "jar", f.claims(func(c map[string]any) { c["jti"] = jti })))
+	second := newJARFixture(t)
+	claims := second.claims(func(c map[string]any) { c["jti"] = jti })
+	second.provider.store = f.provider.store
+	if _, err := second.provider.verifyRequestObject(context.Background(), second.client, requestObject(t, second.key, "jar", claims)); err != nil {
+		t.Fatalf("jti d'un autre client refusé: %v", err)
+	}
+}
+
+func TestResolveRequestObject(t *testing.T) {
+	f := newJARFixture(t)
+	object := requestObject(t, f.key, "jar", f.claims(func(c map[string]any) {
+		c["max_age"] = 300
+		c["claims"] = map[string]any{"id_token": map[string]any{"email": nil}}
+	}))
+
+	form := url.Values{
+		"client_id": {f.client.GetID()},
+		"request":   {object},
+		"scope":     {"openid admin"},
+	}
+	r := httptest.NewRequest(http.MethodPost, "/oauth2/auth", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := f.provider.resolveRequestObject(context.Background(), r, false); err != nil {
+		t.Fatal(err)
+	}
+
+	//les paramètres de l'objet remplacent ceux de la requête
+	expected := map[string]string{
+		"scope":         "openid profile",
+		"response_type": "code",
+		"max_age":       "300",
+		"claims":        `{"id_token":{"email":null}}`,
+		"request":       "",
+		"jti":           "",
+		"aud":           "",
+	}
+	for name, value := range expected {
+		if got := r.Form.Get(name); got != value {
+			t.Errorf("%s = %q, attendu %q", name, got, value)
+		}
+	}
+}
+
+func TestResolveRequestObjectRequired(t *testing.T) {
+	f := newJARFixture(t)
+	required := true
+	f.client.RequireSignedRequestObject = &required
+
+	form := url.Values{"client_id": {f.client.GetID()}, "scope": {"openid"}}
+	r := httptest.NewRequest(http.MethodGet, "/oauth2/auth?"+form.Encode(), nil)
+	if err := f.provider.resolveRequestObject(context.Background(), r, false); !errors.Is(err, fosite.ErrInvalidRequest) {
+		t.Fatalf("erreur invalid_request attendue sans objet de requête, obtenu %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/token/jwt"
+)
+
+// modes de réponse JARM (JWT Secured Authorization Response Mode)
+const (
+	ResponseModeJWT         = fosite.ResponseModeType("jwt")
+	ResponseModeQueryJWT    = fosite.ResponseModeType("query.jwt")
+	ResponseModeFragmentJWT = fosite.ResponseModeType("fragment.jwt")
+	ResponseModeFormPostJWT = fosite.ResponseModeType("form_post.jwt")
+
+	//durée de validité du jeton de réponse
+	JARMLifespan = 10 * time.Minute
+)
+
+// écriture des réponses d'autorisation signées
+// enregistré comme extension des modes de réponse de fosite:
+// WriteAuthorizeResponse et WriteAuthorizeError lui délèguent les modes JARM
+type JARMHandler struct {
+	Signer jwt.Signer
+	Config *fosite.Config
+}
+
+var _ fosite.ResponseModeHandler = (*JARMHandler)(nil)
+
+func NewJARMHandler(signer jwt.Signer, config *fosite.Config) *JARMHandler {
+	return &JARMHandler{Signer: signer, Config: config}
+}
+
+func (h *JARMHandler) ResponseModes() fosite.ResponseModeTypes {
+	return fosite.ResponseModeTypes{ResponseModeJWT, ResponseModeQueryJWT, ResponseModeFragmentJWT, ResponseModeFormPostJWT}
+}
+
+// réponse réussie: les paramètres (code, state, ...) sont les claims du jeton
+func (h *JARMHandler) WriteAuthorizeResponse(ctx context.Context, rw http.ResponseWriter, ar fosite.AuthorizeRequester, resp fosite.AuthorizeResponder) {
+	for name := range resp.GetHeader() {
+		rw.Header().Set(name, resp.GetHeader().Get(name))
+	}
+	h.write(ctx, rw, ar, resp.GetParameters())
+}
+
+// réponse d'erreur: signée si la redirect_uri est valide, sinon JSON comme fosite
+func (h *JARMHandler) WriteAuthorizeError(ctx context.Context, rw http.ResponseWriter, ar fosite.AuthorizeRequester, err error) {
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Header().Set("Pragma", "no-cache")
+
+	rfcerr := fosite.ErrorToRFC6749Error(err).WithExposeDebug(h.Config.GetSendDebugMessagesToClients(ctx))
+	if !ar.IsRedirectURIValid() {
+		rw.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		js, err := json.Marshal(rfcerr)
+		if err != nil {
+			http.Error(rw, `{"error":"server_error"}`, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(rfcerr.CodeField)
+		_, _ = rw.Write(js)
+		return
+	}
+
+	params := rfcerr.ToValues()
+	params.Set("state", ar.GetState())
+	h.write(ctx, rw, ar, params)
+}
+
+// signature des paramètres et envoi au client selon le mode demandé
+func (h *JARMHandler) write(ctx context.Context, rw http.ResponseWriter, ar fosite.AuthorizeRequester, params url.Values) {
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Header().Set("Pragma", "no-cache")
+
+	response, err := h.sign(ctx, ar, params)
+	if err != nil {
+		rw.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		rw.WriteHeader(http.StatusInternalServerError)
+		_, _ = rw.Write([]byte(`{"error":"server_error"}`))
+		return
+	}
+
+	redirectURI := *ar.GetRedirectURI()
+	redirectURI.Fragment = ""
+
+	switch JARMResponseMode(ar) {
+	case ResponseModeFormPostJWT:
+		template := h.Config.GetFormPostHTMLTemplate(ctx)
+		if template == nil {
+			template = fosite.DefaultFormPostTemplate
+		}
+		rw.Header().Set("Content-Type", "text/html;charset=UTF-8")
+		fosite.WriteAuthorizeFormPostResponse(redirectURI.String(), url.Values{"response": {response}}, template, rw)
+		return
+	case ResponseModeFragmentJWT:
+		rw.Header().Set("Location", redirectURI.String()+"#"+url.Values{"response": {response}}.Encode())
+	default:
+		query := redirectURI.Query()
+		query.Set("response", response)
+		redirectURI.RawQuery = query.Encode()
+		rw.Header().Set("Location", redirectURI.String())
+	}
+	rw.WriteHeader(http.StatusSeeOther)
+}
+
+// jeton de réponse signé avec la clé active de l'algorithme du client
+func (h *JARMHandler) sign(ctx context.Context, ar fosite.AuthorizeRequester, params url.Values) (string, error) {
+	now := time.Now().UTC()
+	claims := jwt.MapClaims{
+		"iss": h.Config.GetIDTokenIssuer(ctx),
+		"aud": ar.GetClient().GetID(),
+		"iat": now.Unix(),
+		"exp": now.Add(JARMLifespan).Unix(),
+	}
+	for name := range params {
+		claims[name] = params.Get(name)
+	}
+
+	alg := DefaultSigningAlg
+	if client, ok := ar.GetClient().(*models.Client); ok && client.GetAuthorizationSignedResponseAlg() != "" {
+		alg = client.GetAuthorizationSignedResponseAlg()
+	}
+
+	token, _, err := h.Signer.Generate(ctx, claims, &jwt.Headers{Extra: map[string]interface{}{"typ": "JWT", "alg": alg}})
+	if err != nil {
+		return "", fmt.Errorf("signature de la réponse d'autorisation impossible: %w", err)
+	}
+	return token, nil
+}
+
+// mode JARM effectif: "jwt" devient query.jwt pour le flux code
+// et fragment.jwt pour les flux implicite et hybride
+func JARMResponseMode(ar fosite.AuthorizeRequester) fosite.ResponseModeType {
+	mode := ar.GetResponseMode()
+	if mode != ResponseModeJWT {
+		return mode
+	}
+	if ar.GetDefaultResponseMode() == fosite.ResponseModeFragment || !ar.GetResponseTypes().ExactOne("code") {
+		return ResponseModeFragmentJWT
+	}
+	return ResponseModeQueryJWT
+}
+
+// query.jwt est interdit avec les flux qui renvoient des jetons (réponse non chiffrée)
+func validateJARMResponseMode(ar fosite.AuthorizeRequester) error {
+	if ar.GetResponseMode() == ResponseModeQueryJWT && !ar.GetResponseTypes().ExactOne("code") {
+		return fosite.ErrInvalidRequest.WithHint("le mode 'query.jwt' n'est autorisé qu'avec le response_type 'code'")
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/ory/fosite"
+)
+
+// requête d'autorisation du client avec le mode de réponse demandé
+func jarmRequest(mode fosite.ResponseModeType, responseTypes ...string) *fosite.AuthorizeRequest {
+	redirectURI, _ := url.Parse("https://client.easyclass.test/callback?from=jarm")
+	request := fosite.NewAuthorizeRequest()
+	request.Client = &models.Client{ID: uuid.New(), RedirectURIs: pq.StringArray{redirectURI.String()}}
+	request.RedirectURI = redirectURI
+	request.ResponseMode = mode
+	request.ResponseTypes = responseTypes
+	request.DefaultResponseMode = fosite.ResponseModeQuery
+	if len(responseTypes) != 1 || responseTypes[0] != "code" {
+		request.DefaultResponseMode = fosite.ResponseModeFragment
+	}
+	request.State = "state-jarm"
+	return request
+}
+
+func TestJARMResponseMode(t *testing.T) {
+	cases := []struct {
+		name          string
+		mode          fosite.ResponseModeType
+		responseTypes []string
+		expected      fosite.ResponseModeType
+		valid         bool
+	}{
+		{"jwt avec code", ResponseModeJWT, []string{"code"}, ResponseModeQueryJWT, true},
+		{"jwt avec le flux hybride", ResponseModeJWT, []string{"code", "id_token"}, ResponseModeFragmentJWT, true},
+		{"jwt avec le flux implicite", ResponseModeJWT, []string{"token"}, ResponseModeFragmentJWT, true},
+		{"query.jwt avec code", ResponseModeQueryJWT, []string{"code"}, ResponseModeQueryJWT, true},
+		{"query.jwt avec le flux implicite", ResponseModeQueryJWT, []string{"token"}, ResponseModeQueryJWT, false},
+		{"fragment.jwt avec code", ResponseModeFragmentJWT, []string{"code"}, ResponseModeFragmentJWT, true},
+		{"form_post.jwt avec le flux hybride", ResponseModeFormPostJWT, []string{"code", "token"}, ResponseModeFormPostJWT, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := jarmRequest(tc.mode, tc.responseTypes...)
+			if mode := JARMResponseMode(request); mode != tc.expected {
+				t.Fatalf("mode = %q, attendu %q", mode, tc.expected)
+			}
+			if err := validateJARMResponseMode(request); (err == nil) != tc.valid {
+				t.Fatalf("validation = %v, attendu valide = %v", err, tc.valid)
+			}
+		})
+	}
+}
+
+func TestJARMWriteAuthorizeResponse(t *testing.T) {
+	signer, _ := testSigner(t)
+	handler := NewJARMHandler(signer, &fosite.Config{IDTokenIssuer: utils.URL_Host})
+
+	cases := []struct {
+		name          string
+		mode          fosite.ResponseModeType
+		responseTypes []string
+	}{
+		{"query.jwt", ResponseModeQueryJWT, []string{"code"}},
+		{"fragment.jwt", ResponseModeFragmentJWT, []string{"code", "id_token"}},
+		{"form_post.jwt", ResponseModeFormPostJWT, []string{"code"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := jarmRequest(tc.mode, tc.responseTypes...)
+			response := fosite.NewAuthorizeResponse()
+			response.AddParameter("code", "authorization-code")
+			response.AddParameter("state", request.State)
+
+			rw := httptest.NewRecorder()
+			handler.WriteAuthorizeResponse(context.Background(), rw, request, response)
+
+			raw := jarmResponse(t, rw, tc.mode)
+			token, err := signer.Decode(context.Background(), raw)
+			if err != nil {
+				t.Fatalf("jeton de réponse invalide: %v", err)
+			}
+			claims := token.Claims
+			if claims["iss"] != utils.URL_Host || claims["aud"] != request.Client.GetID() {
+				t.Fatalf("iss = %v, aud = %v", claims["iss"], claims["aud"])
+			}
+			if claims["code"] != "authorization-code" || claims["state"] != "state-jarm" {
+				t.Fatalf("claims = %v", claims)
+			}
+			if rw.Header().Get("Cache-Control") != "no-store" {
+				t.Error("la réponse ne doit pas être mise en cache")
+			}
+		})
+	}
+}
+
+func TestJARMWriteAuthorizeError(t *testing.T) {
+	signer, _ := testSigner(t)
+	handler := NewJARMHandler(signer, &fosite.Config{IDTokenIssuer: utils.URL_Host})
+
+	//erreur signée et renvoyée au client
+	request := jarmRequest(ResponseModeQueryJWT, "code")
+	rw := httptest.NewRecorder()
+	handler.WriteAuthorizeError(context.Background(), rw, request, fosite.ErrAccessDenied)
+	token, err := signer.Decode(context.Background(), jarmResponse(t, rw, ResponseModeQueryJWT))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Claims["error"] != "access_denied" || token.Claims["state"] != "state-jarm" {
+		t.Fatalf("claims = %v", token.Claims)
+	}
+
+	//redirect_uri non enregistrée: erreur JSON sans redirection
+	request = jarmRequest(ResponseModeQueryJWT, "code")
+	request.RedirectURI, _ = url.Parse("https://attacker.test/callback")
+	rw = httptest.NewRecorder()
+	handler.WriteAuthorizeError(context.Background(), rw, request, fosite.ErrInvalidRequest)
+	if rw.Code != http.StatusBadRequest || rw.Header().Get("Location") != "" {
+		t.Fatalf("statut = %d, location = %q", rw.Code, rw.Header().Get("Location"))
+	}
+	if !strings.Contains(rw.Body.String(), `"error":"invalid_request"`) {
+		t.Fatalf("corps = %s", rw.Body.String())
+	}
+}
+
+// jeton de réponse transmis au client selon le mode
+func jarmResponse(t *testing.T, rw *httptest.ResponseRecorder, mode fosite.ResponseModeType) string {
+	t.Helper()
+	if mode == ResponseModeFormPostJWT {
+		body := rw.Body.String()
+		if !strings.Contains(body, `action="https://client.easyclass.test/callback?from=jarm"`) {
+			t.Fatalf("formulaire = %s", body)
+		}
+		start := strings.Index(body, `name="response" value="`)
+		if start < 0 {
+			t.Fatalf("paramètre response absent du formulaire: %s", body)
+		}
+		body = body[start+len(`name="response" value="`):]
+		return body[:strings.Index(body, `"`)]
+	}
+
+	if rw.Code != http.StatusSeeOther {
+		t.Fatalf("statut = %d, attendu %d", rw.Code, http.StatusSeeOther)
+	}
+	location, err := url.Parse(rw.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.Query().Get("from") != "jarm" {
+		t.Fatalf("paramètres de la redirect_uri perdus: %s", location)
+	}
+	values := location.Query()
+	if mode == ResponseModeFragmentJWT {
+		if values.Has("response") {
+			t.Fatal("la réponse doit être dans le fragment")
+		}
+		if values, err = url.ParseQuery(location.Fragment); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if values.Has("code") {
+		t.Fatal("les paramètres doivent être uniquement dans le jeton")
+	}
+	return values.Get("response")
+}
@@ -0,0 +1,265 @@
+package provider
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/dylEasydev/go-oauth2-easyclass/validators"
+	"github.com/go-jose/go-jose/v3"
+	"github.com/ory/fosite"
+)
+
+const (
+	//durée de vie d'une clé active avant sa rotation
+	KeyRotationInterval = 30 * 24 * time.Hour
+	//durée de publication d'une clé retirée
+	//(supérieure à la durée de vie des jetons signés)
+	KeyRetention = 48 * time.Hour
+	//fréquence de vérification de la rotation
+	keyRotationCheck = 1 * time.Hour
+	//durée de mise en cache de la clé active
+	keyCacheLifespan = 1 * time.Minute
+
+	//kid historique de la clé lue sur le disque
+	bootstrapKeyID = "easy-class"
+	//algorithme par défaut quand le client n'en a pas choisi
+	DefaultSigningAlg = "RS256"
+)
+
+// clé active mise en cache
+type cachedKey struct {
+	key      *jose.JSONWebKey
+	loadedAt time.Time
+}
+
+// stockage des clés de signature du serveur
+type SigningKeyStorage interface {
+	GetActiveSigningKey(ctx context.Context, alg string) (*models.SigningKey, error)
+	GetSigningKey(ctx context.Context, kid string) (*models.SigningKey, error)
+	GetPublishedSigningKeys(ctx context.Context) ([]models.SigningKey, error)
+	CreateSigningKey(ctx context.Context, key *models.SigningKey) error
+	RotateSigningKeys(ctx context.Context, next *models.SigningKey, retention time.Duration, interval time.Duration) (bool, error)
+}
+
+// gestionnaire des clés de signature du serveur
+// une clé active et une prochaine clé par algorithme supporté
+type KeyManager struct {
+	store SigningKeyStorage
+
+	mu     sync.RWMutex
+	active map[string]cachedKey
+}
+
+func NewKeyManager(store SigningKeyStorage) *KeyManager {
+	return &KeyManager{
+		store:  store,
+		active: make(map[string]cachedKey),
+	}
+}
+
+// algorithmes de signature supportés
+func SigningAlgorithms() []string {
+	return validators.SliceValidation["signingAlgValid"]
+}
+
+// initialisation des clés: la clé du disque devient la première clé active
+// de son algorithme si aucune clé n'existe, puis les clés manquantes sont générées
+func (k *KeyManager) Init(ctx context.Context, bootstrap crypto.Signer) error {
+	keys, err := k.store.GetPublishedSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	bootstrapAlg := ""
+	if bootstrap != nil {
+		if bootstrapAlg, err = utils.KeyAlgorithm(bootstrap.Public()); err != nil {
+			return err
+		}
+	}
+
+	for _, alg := range SigningAlgorithms() {
+		hasActive, hasNext := false, false
+		for _, key := range keys {
+			if key.Algorithm != alg {
+				continue
+			}
+			switch key.State {
+			case models.KEY_STATE_ACTIVE:
+				hasActive = true
+			case models.KEY_STATE_NEXT:
+				hasNext = true
+			}
+		}
+
+		if !hasActive {
+			var active *models.SigningKey
+			if alg == bootstrapAlg {
+				if active, err = models.NewSigningKey(bootstrap, alg, models.KEY_STATE_ACTIVE); err != nil {
+					return err
+				}
+				active.KeyID = bootstrapKeyID
+				active.PublicKey.KeyID = bootstrapKeyID
+			} else if active, err = newSigningKey(alg, models.KEY_STATE_ACTIVE); err != nil {
+				return err
+			}
+			if err := k.store.CreateSigningKey(ctx, active); err != nil {
+				return err
+			}
+		}
+
+		if !hasNext {
+			next, err := newSigningKey(alg, models.KEY_STATE_NEXT)
+			if err != nil {
+				return err
+			}
+			if err := k.store.CreateSigningKey(ctx, next); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// clé privé active de l'algorithme par défaut
+func (k *KeyManager) GetPrivateKey(ctx context.Context) (interface{}, error) {
+	return k.GetSigningKey(ctx, DefaultSigningAlg)
+}
+
+// clé privé active (avec son kid) d'un algorithme
+func (k *KeyManager) GetSigningKey(ctx context.Context, alg string) (*jose.JSONWebKey, error) {
+	if !slices.Contains(SigningAlgorithms(), alg) {
+		return nil, fmt.Errorf("algorithme de signature non supporté: %s", alg)
+	}
+
+	k.mu.RLock()
+	cached, ok := k.active[alg]
+	k.mu.RUnlock()
+	if ok && time.Since(cached.loadedAt) < keyCacheLifespan {
+		return cached.key, nil
+	}
+
+	key, err := k.store.GetActiveSigningKey(ctx, alg)
+	if err != nil {
+		return nil, fmt.Errorf("aucune clé de signature %s active: %w", alg, err)
+	}
+	jwk, err := key.JSONWebKey()
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	k.active[alg] = cachedKey{key: jwk, loadedAt: time.Now()}
+	k.mu.Unlock()
+
+	return jwk, nil
+}
+
+// clé public publiée correspondant à un kid
+func (k *KeyManager) PublicKey(ctx context.Context, kid string) (*jose.JSONWebKey, error) {
+	key, err := k.store.GetSigningKey(ctx, kid)
+	if err != nil {
+		return nil, err
+	}
+	if key.IsExpired() {
+		return nil, fosite.ErrNotFound.WithHint("la clé de signature a expiré")
+	}
+	public := jose.JSONWebKey(key.PublicKey)
+	return &public, nil
+}
+
+// ensemble des clés public publiées dans le JWKS
+func (k *KeyManager) PublicKeys(ctx context.Context) (*jose.JSONWebKeySet, error) {
+	keys, err := k.store.GetPublishedSigningKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &jose.JSONWebKeySet{Keys: []jose.JSONWebKey{}}
+	for _, key := range keys {
+		set.Keys = append(set.Keys, jose.JSONWebKey(key.PublicKey))
+	}
+	return set, nil
+}
+
+// rotation des clés d'un algorithme: la prochaine clé devient active
+func (k *KeyManager) Rotate(ctx context.Context, alg string) error {
+	_, err := k.rotate(ctx, alg, 0)
+	return err
+}
+
+// rotation des clés d'un algorithme si la clé active a plus de interval
+// (toujours effectuée avec un interval nul)
+func (k *KeyManager) rotate(ctx context.Context, alg string, interval time.Duration) (bool, error) {
+	next, err := newSigningKey(alg, models.KEY_STATE_NEXT)
+	if err != nil {
+		return false, err
+	}
+	rotated, err := k.store.RotateSigningKeys(ctx, next, KeyRetention, interval)
+	if err != nil || !rotated {
+		return false, err
+	}
+
+	//invalidation du cache de la clé active
+	k.mu.Lock()
+	delete(k.active, alg)
+	k.mu.Unlock()
+
+	return true, nil
+}
+
+// rotation planifiée des clés jusqu'à l'annulation du contexte
+func (k *KeyManager) StartRotation(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(keyRotationCheck)
+	defer ticker.Stop()
+
+	for {
+		for _, alg := range SigningAlgorithms() {
+			if err := k.rotateIfDue(ctx, alg, interval); err != nil {
+				log.Printf("warning: rotation des clés de signature %s impossible: %v", alg, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (k *KeyManager) rotateIfDue(ctx context.Context, alg string, interval time.Duration) error {
+	key, err := k.store.GetActiveSigningKey(ctx, alg)
+	if err != nil && !errors.Is(err, fosite.ErrNotFound) {
+		return err
+	}
+	if key != nil && !key.RotationDue(interval) {
+		return nil
+	}
+	//la rotation est de nouveau vérifiée sous verrou (plusieurs instances)
+	rotated, err := k.rotate(ctx, alg, interval)
+	if err != nil {
+		return err
+	}
+	if rotated {
+		log.Printf("info: rotation des clés de signature %s effectuée", alg)
+	}
+	return nil
+}
+
+// génération d'une nouvelle clé de signature
+func newSigningKey(alg string, state string) (*models.SigningKey, error) {
+	signer, err := utils.GenerateSigningKey(alg)
+	if err != nil {
+		return nil, fmt.Errorf("erreur de génération de la clé de signature: %w", err)
+	}
+	return models.NewSigningKey(signer, alg, state)
+}
@@ -0,0 +1,253 @@
+package provider
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/ory/fosite"
+)
+
+// stockage en mémoire des clés de signature
+type memoryKeyStorage struct {
+	mu   sync.Mutex
+	keys []*models.SigningKey
+	//clé active lue avant la rotation d'une autre instance
+	staleActive *models.SigningKey
+}
+
+func (m *memoryKeyStorage) GetActiveSigningKey(ctx context.Context, alg string) (*models.SigningKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.staleActive != nil && m.staleActive.Algorithm == alg {
+		return m.staleActive, nil
+	}
+	for _, key := range m.keys {
+		if key.Algorithm == alg && key.State == models.KEY_STATE_ACTIVE {
+			return key, nil
+		}
+	}
+	return nil, fosite.ErrNotFound
+}
+
+func (m *memoryKeyStorage) GetSigningKey(ctx context.Context, kid string) (*models.SigningKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range m.keys {
+		if key.KeyID == kid {
+			return key, nil
+		}
+	}
+	return nil, fosite.ErrNotFound
+}
+
+func (m *memoryKeyStorage) GetPublishedSigningKeys(ctx context.Context) ([]models.SigningKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := []models.SigningKey{}
+	for _, key := range m.keys {
+		if !key.IsExpired() {
+			keys = append(keys, *key)
+		}
+	}
+	return keys, nil
+}
+
+func (m *memoryKeyStorage) CreateSigningKey(ctx context.Context, key *models.SigningKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = append(m.keys, key)
+	return nil
+}
+
+// même règle que le stockage en base: la rotation est vérifiée sous verrou
+func (m *memoryKeyStorage) RotateSigningKeys(ctx context.Context, next *models.SigningKey, retention time.Duration, interval time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now().UTC()
+	expiresAt := now.Add(retention)
+
+	var active, previous *models.SigningKey
+	for _, key := range m.keys {
+		if key.Algorithm != next.Algorithm {
+			continue
+		}
+		switch key.State {
+		case models.KEY_STATE_ACTIVE:
+			active = key
+		case models.KEY_STATE_NEXT:
+			previous = key
+		}
+	}
+	if interval > 0 && active != nil && !active.RotationDue(interval) {
+		return false, nil
+	}
+
+	if active != nil {
+		active.State = models.KEY_STATE_RETIRED
+		active.RetiredAt = &now
+		active.ExpiresAt = &expiresAt
+	}
+	if previous != nil {
+		previous.State = models.KEY_STATE_ACTIVE
+		previous.ActivatedAt = &now
+	} else {
+		next.State = models.KEY_STATE_ACTIVE
+		next.ActivatedAt = &now
+	}
+	m.keys = append(m.keys, next)
+	return true, nil
+}
+
+// clés de l'algorithme par état
+func (m *memoryKeyStorage) states(alg string) map[string]int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	states := map[string]int{}
+	for _, key := range m.keys {
+		if key.Algorithm == alg {
+			states[key.State]++
+		}
+	}
+	return states
+}
+
+func TestKeyManagerInit(t *testing.T) {
+	bootstrap, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &memoryKeyStorage{}
+	keys := NewKeyManager(store)
+	ctx := context.Background()
+
+	if err := keys.Init(ctx, bootstrap); err != nil {
+		t.Fatal(err)
+	}
+	for _, alg := range SigningAlgorithms() {
+		if states := store.states(alg); states[models.KEY_STATE_ACTIVE] != 1 || states[models.KEY_STATE_NEXT] != 1 {
+			t.Errorf("%s: clés = %v, attendu une active et une prochaine", alg, states)
+		}
+	}
+
+	//la clé du disque devient la clé active de son algorithme
+	active, err := keys.GetSigningKey(ctx, "ES256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active.KeyID != bootstrapKeyID {
+		t.Errorf("kid = %q, attendu %q", active.KeyID, bootstrapKeyID)
+	}
+
+	//une seconde initialisation ne crée aucune clé
+	count := len(store.keys)
+	if err := keys.Init(ctx, bootstrap); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.keys) != count {
+		t.Fatalf("%d clés après la seconde initialisation, attendu %d", len(store.keys), count)
+	}
+
+	if _, err := keys.GetSigningKey(ctx, "HS256"); err == nil {
+		t.Error("un algorithme non supporté doit être refusé")
+	}
+}
+
+func TestKeyManagerRotate(t *testing.T) {
+	store := &memoryKeyStorage{}
+	keys := NewKeyManager(store)
+	ctx := context.Background()
+	if err := keys.Init(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := keys.GetSigningKey(ctx, "EdDSA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Rotate(ctx, "EdDSA"); err != nil {
+		t.Fatal(err)
+	}
+
+	//le cache de la clé active est invalidé
+	after, err := keys.GetSigningKey(ctx, "EdDSA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.KeyID == before.KeyID {
+		t.Fatal("la clé active n'a pas changé après la rotation")
+	}
+	if states := store.states("EdDSA"); states[models.KEY_STATE_ACTIVE] != 1 || states[models.KEY_STATE_NEXT] != 1 || states[models.KEY_STATE_RETIRED] != 1 {
+		t.Fatalf("clés = %v", states)
+	}
+
+	//la clé retirée reste publiée jusqu'à son expiration
+	if _, err := keys.PublicKey(ctx, before.KeyID); err != nil {
+		t.Fatalf("clé retirée non publiée: %v", err)
+	}
+	set, err := keys.PublicKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Key(before.KeyID)) != 1 {
+		t.Fatal("clé retirée absente du JWKS")
+	}
+
+	retired, _ := store.GetSigningKey(ctx, before.KeyID)
+	expired := time.Now().UTC().Add(-time.Minute)
+	retired.ExpiresAt = &expired
+	if _, err := keys.PublicKey(ctx, before.KeyID); !errors.Is(err, fosite.ErrNotFound) {
+		t.Fatalf("erreur not_found attendue pour une clé expirée, obtenu %v", err)
+	}
+}
+
+func TestKeyManagerRotateIfDue(t *testing.T) {
+	cases := []struct {
+		name      string
+		activated time.Duration
+		stale     bool
+		rotated   bool
+	}{
+		{"clé récente", time.Hour, false, false},
+		{"clé à remplacer", 31 * 24 * time.Hour, false, true},
+		{"rotation déjà effectuée par une autre instance", time.Hour, true, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := &memoryKeyStorage{}
+			keys := NewKeyManager(store)
+			ctx := context.Background()
+			if err := keys.Init(ctx, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			active, err := store.GetActiveSigningKey(ctx, "ES256")
+			if err != nil {
+				t.Fatal(err)
+			}
+			activatedAt := time.Now().UTC().Add(-tc.activated)
+			active.ActivatedAt = &activatedAt
+			if tc.stale {
+				//la vérification sans verrou lit encore l'ancienne clé active
+				old := *active
+				expired := time.Now().UTC().Add(-KeyRotationInterval - time.Hour)
+				old.ActivatedAt = &expired
+				store.staleActive = &old
+			}
+
+			if err := keys.rotateIfDue(ctx, "ES256", KeyRotationInterval); err != nil {
+				t.Fatal(err)
+			}
+			retired := store.states("ES256")[models.KEY_STATE_RETIRED]
+			if rotated := retired == 1; rotated != tc.rotated {
+				t.Fatalf("rotation = %v, attendu %v", rotated, tc.rotated)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package provider
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/dylEasydev/go-oauth2-easyclass/validators"
+	"github.com/ory/fosite/handler/oauth2"
+	"github.com/ory/fosite/handler/openid"
+	"github.com/ory/fosite/handler/pkce"
+	"github.com/ory/fosite/handler/rfc7523"
+)
+
+// noms des end-points publiés dans le document de découverte
+const (
+	AuthorizationEndpoint = "authorization_endpoint"
+	TokenEndpoint         = "token_endpoint"
+	RevocationEndpoint    = "revocation_endpoint"
+	IntrospectionEndpoint = "introspection_endpoint"
+	PAREndpoint           = "pushed_authorization_request_endpoint"
+	JWKSURI               = "jwks_uri"
+	UserinfoEndpoint      = "userinfo_endpoint"
+	EndSessionEndpoint    = "end_session_endpoint"
+	DeviceEndpoint        = "device_authorization_endpoint"
+	CIBAEndpoint          = "backchannel_authentication_endpoint"
+)
+
+// document de découverte OpenID Connect / RFC 8414
+type Metadata struct {
+	Issuer                                     string               `json:"issuer"`
+	AuthorizationEndpoint                      string               `json:"authorization_endpoint,omitempty"`
+	TokenEndpoint                              string               `json:"token_endpoint,omitempty"`
+	RevocationEndpoint                         string               `json:"revocation_endpoint,omitempty"`
+	IntrospectionEndpoint                      string               `json:"introspection_endpoint,omitempty"`
+	PushedAuthorizationRequestEndpoint         string               `json:"pushed_authorization_request_endpoint,omitempty"`
+	DeviceAuthorizationEndpoint                string               `json:"device_authorization_endpoint,omitempty"`
+	BackchannelAuthenticationEndpoint          string               `json:"backchannel_authentication_endpoint,omitempty"`
+	BackchannelTokenDeliveryModesSupported     []string             `json:"backchannel_token_delivery_modes_supported,omitempty"`
+	BackchannelUserCodeParameterSupported      bool                 `json:"backchannel_user_code_parameter_supported"`
+	RequirePushedAuthorizationRequests         bool                 `json:"require_pushed_authorization_requests"`
+	JWKSURI                                    string               `json:"jwks_uri,omitempty"`
+	UserinfoEndpoint                           string               `json:"userinfo_endpoint,omitempty"`
+	EndSessionEndpoint                         string               `json:"end_session_endpoint,omitempty"`
+	BackchannelLogoutSupported                 bool                 `json:"backchannel_logout_supported"`
+	BackchannelLogoutSessionSupported          bool                 `json:"backchannel_logout_session_supported"`
+	ScopesSupported                            []string             `json:"scopes_supported"`
+	ResponseTypesSupported                     []string             `json:"response_types_supported"`
+	ResponseModesSupported                     []string             `json:"response_modes_supported"`
+	GrantTypesSupported                        []string             `json:"grant_types_supported"`
+	SubjectTypesSupported                      []string             `json:"subject_types_supported,omitempty"`
+	IDTokenSigningAlgValuesSupported           []string             `json:"id_token_signing_alg_values_supported,omitempty"`
+	UserinfoSigningAlgValuesSupported          []string             `json:"userinfo_signing_alg_values_supported,omitempty"`
+	AuthorizationSigningAlgValuesSupported     []string             `json:"authorization_signing_alg_values_supported,omitempty"`
+	TokenEndpointAuthMethodsSupported          []string             `json:"token_endpoint_auth_methods_supported"`
+	TokenEndpointAuthSigningAlgValuesSupported []string             `json:"token_endpoint_auth_signing_alg_values_supported"`
+	RevocationEndpointAuthMethodsSupported     []string             `json:"revocation_endpoint_auth_methods_supported"`
+	IntrospectionEndpointAuthMethodsSupported  []string             `json:"introspection_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported              []string             `json:"code_challenge_methods_supported,omitempty"`
+	DPoPSigningAlgValuesSupported              []string             `json:"dpop_signing_alg_values_supported,omitempty"`
+	AuthorizationDetailsTypesSupported         []string             `json:"authorization_details_types_supported,omitempty"`
+	TLSClientCertificateBoundAccessTokens      bool                 `json:"tls_client_certificate_bound_access_tokens"`
+	MTLSEndpointAliases                        *MTLSEndpointAliases `json:"mtls_endpoint_aliases,omitempty"`
+	ClaimsSupported                            []string             `json:"claims_supported,omitempty"`
+	RequestParameterSupported                  bool                 `json:"request_parameter_supported"`
+	RequestObjectSigningAlgValuesSupported     []string             `json:"request_object_signing_alg_values_supported,omitempty"`
+	RequestURIParameterSupported               bool                 `json:"request_uri_parameter_supported"`
+}
+
+// end-points servis par le listener mTLS (RFC 8705 section 5)
+type MTLSEndpointAliases struct {
+	TokenEndpoint                      string `json:"token_endpoint,omitempty"`
+	RevocationEndpoint                 string `json:"revocation_endpoint,omitempty"`
+	IntrospectionEndpoint              string `json:"introspection_endpoint,omitempty"`
+	PushedAuthorizationRequestEndpoint string `json:"pushed_authorization_request_endpoint,omitempty"`
+	DeviceAuthorizationEndpoint        string `json:"device_authorization_endpoint,omitempty"`
+	BackchannelAuthenticationEndpoint  string `json:"backchannel_authentication_endpoint,omitempty"`
+	UserinfoEndpoint                   string `json:"userinfo_endpoint,omitempty"`
+}
+
+// génère le document de découverte à partir des end-points
+// enregistrés par les routeurs et des handlers composés par fosite
+// openid indique s'il s'agit du document OpenID Connect ou RFC 8414
+func (p *Provider) Metadata(ctx context.Context, endpoints map[string]string, openID bool) (*Metadata, error) {
+	scopes, err := supportedScopes()
+	if err != nil {
+		return nil, err
+	}
+
+	authMethods := validators.SliceValidation["authMethodValid"]
+
+	metadata := &Metadata{
+		Issuer:                                     p.Config.GetIDTokenIssuer(ctx),
+		AuthorizationEndpoint:                      endpointURL(endpoints, AuthorizationEndpoint),
+		TokenEndpoint:                              endpointURL(endpoints, TokenEndpoint),
+		RevocationEndpoint:                         endpointURL(endpoints, RevocationEndpoint),
+		IntrospectionEndpoint:                      endpointURL(endpoints, IntrospectionEndpoint),
+		PushedAuthorizationRequestEndpoint:         endpointURL(endpoints, PAREndpoint),
+		DeviceAuthorizationEndpoint:                endpointURL(endpoints, DeviceEndpoint),
+		RequirePushedAuthorizationRequests:         p.Config.EnforcePushedAuthorize(ctx),
+		JWKSURI:                                    endpointURL(endpoints, JWKSURI),
+		ScopesSupported:                            scopes,
+		ResponseTypesSupported:                     p.responseTypes(),
+		ResponseModesSupported:                     validators.SliceValidation["responseModes"],
+		GrantTypesSupported:                        p.grantTypes(),
+		TokenEndpointAuthMethodsSupported:          authMethods,
+		TokenEndpointAuthSigningAlgValuesSupported: []string{"RS256"},
+		RevocationEndpointAuthMethodsSupported:     authMethods,
+		IntrospectionEndpointAuthMethodsSupported:  authMethods,
+		CodeChallengeMethodsSupported:              p.codeChallengeMethods(),
+		AuthorizationSigningAlgValuesSupported:     SigningAlgorithms(),
+		DPoPSigningAlgValuesSupported:              utils.DPoPSigningAlgorithms,
+		AuthorizationDetailsTypesSupported:         slices.Sorted(maps.Keys(AuthorizationDetailTypes)),
+		TLSClientCertificateBoundAccessTokens:      true,
+		RequestParameterSupported:                  true,
+		RequestURIParameterSupported:               true,
+		RequestObjectSigningAlgValuesSupported:     SigningAlgorithms(),
+	}
+
+	//authentification back-channel si le handler est composé
+	if p.CIBA != nil {
+		metadata.BackchannelAuthenticationEndpoint = endpointURL(endpoints, CIBAEndpoint)
+		metadata.BackchannelTokenDeliveryModesSupported = validators.SliceValidation["deliveryModes"]
+	}
+
+	//les clients mTLS utilisent le listener dédié s'il est configuré
+	if host := utils.MTLSHost(); host != "" {
+		metadata.MTLSEndpointAliases = &MTLSEndpointAliases{
+			TokenEndpoint:                      mtlsEndpointURL(host, endpoints, TokenEndpoint),
+			RevocationEndpoint:                 mtlsEndpointURL(host, endpoints, RevocationEndpoint),
+			IntrospectionEndpoint:              mtlsEndpointURL(host, endpoints, IntrospectionEndpoint),
+			PushedAuthorizationRequestEndpoint: mtlsEndpointURL(host, endpoints, PAREndpoint),
+			DeviceAuthorizationEndpoint:        mtlsEndpointURL(host, endpoints, DeviceEndpoint),
+			UserinfoEndpoint:                   mtlsEndpointURL(host, endpoints, UserinfoEndpoint),
+		}
+		if p.CIBA != nil {
+			metadata.MTLSEndpointAliases.BackchannelAuthenticationEndpoint = mtlsEndpointURL(host, endpoints, CIBAEndpoint)
+		}
+	}
+
+	//champs propres à OpenID Connect
+	if openID {
+		metadata.SubjectTypesSupported = validators.SliceValidation["subjectTypes"]
+		metadata.IDTokenSigningAlgValuesSupported = SigningAlgorithms()
+		metadata.UserinfoEndpoint = endpointURL(endpoints, UserinfoEndpoint)
+		metadata.UserinfoSigningAlgValuesSupported = SigningAlgorithms()
+		metadata.EndSessionEndpoint = endpointURL(endpoints, EndSessionEndpoint)
+		metadata.BackchannelLogoutSupported = p.Backchannel != nil
+		metadata.BackchannelLogoutSessionSupported = p.Backchannel != nil
+		metadata.ClaimsSupported = []string{
+			"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "acr", "amr", "at_hash", "c_hash",
+			"preferred_username", "picture", "email", "email_verified", "role", "sid",
+		}
+	}
+
+	return metadata, nil
+}
+
+// url d'un end-point sur le listener mTLS s'il est enregistré
+func mtlsEndpointURL(host string, endpoints map[string]string, name string) string {
+	path, ok := endpoints[name]
+	if !ok {
+		return ""
+	}
+	return host + path
+}
+
+// url absolue d'un end-point s'il est enregistré
+func endpointURL(endpoints map[string]string, name string) string {
+	path, ok := endpoints[name]
+	if !ok {
+		return ""
+	}
+	return utils.URL_Host + path
+}
+
+// lecture des scopes de l'application dans ressources/scope_app.json
+func supportedScopes() ([]string, error) {
+	data, err := utils.ReadJSON[models.ScopeData]("scope_app")
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := append([]string{}, utils.OIDCScopes...)
+	for _, elem := range data.Data {
+		scopes = append(scopes, elem.ScopeName)
+	}
+	return scopes, nil
+}
+
+// grant_types supportés en fonction des handlers du token end-point
+func (p *Provider) grantTypes() []string {
+	grants := []string{}
+	for _, handler := range p.Config.TokenEndpointHandlers {
+		switch handler.(type) {
+		case *oauth2.AuthorizeExplicitGrantHandler:
+			grants = append(grants, "authorization_code")
+		case *oauth2.ClientCredentialsGrantHandler:
+			grants = append(grants, "client_credentials")
+		case *oauth2.RefreshTokenGrantHandler:
+			grants = append(grants, "refresh_token")
+		case *oauth2.ResourceOwnerPasswordCredentialsGrantHandler:
+			grants = append(grants, "password")
+		case *rfc7523.Handler:
+			grants = append(grants, "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		case *DeviceHandler:
+			grants = append(grants, DeviceCodeGrantType)
+		case *CIBAHandler:
+			grants = append(grants, CIBAGrantType)
+		case *TokenExchangeHandler:
+			grants = append(grants, TokenExchangeGrantType)
+		}
+	}
+
+	//le flux implicite ne passe pas par le token end-point
+	for _, handler := range p.Config.AuthorizeEndpointHandlers {
+		if _, ok := handler.(*oauth2.AuthorizeImplicitGrantTypeHandler); ok {
+			grants = append(grants, "implicit")
+		}
+	}
+
+	return compactStrings(grants)
+}
+
+// response_types supportés en fonction des handlers de l'authorize end-point
+func (p *Provider) responseTypes() []string {
+	responses := []string{}
+	for _, handler := range p.Config.AuthorizeEndpointHandlers {
+		switch handler.(type) {
+		case *oauth2.AuthorizeExplicitGrantHandler:
+			responses = append(responses, "code")
+		case *oauth2.AuthorizeImplicitGrantTypeHandler:
+			responses = append(responses, "token")
+		case *openid.OpenIDConnectImplicitHandler:
+			responses = append(responses, "id_token", "id_token token")
+		case *openid.OpenIDConnectHybridHandler:
+			responses = append(responses, "code id_token", "code token", "code id_token token")
+		}
+	}
+	return compactStrings(responses)
+}
+
+// méthodes PKCE supportées si le handler PKCE est composé
+func (p *Provider) codeChallengeMethods() []string {
+	for _, handler := range p.Config.AuthorizeEndpointHandlers {
+		if _, ok := handler.(*pkce.Handler); ok {
+			methods := []string{"S256"}
+			if p.Config.EnablePKCEPlainChallengeMethod {
+				methods = append(methods, "plain")
+			}
+			return methods
+		}
+	}
+	return nil
+}
+
+// suppression des doublons en conservant l'ordre
+func compactStrings(values []string) []string {
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if !slices.Contains(result, value) {
+			result = append(result, value)
+		}
+	}
+	return result
+}
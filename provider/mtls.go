@@ -0,0 +1,141 @@
+package provider
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/ory/fosite"
+)
+
+// fichier (key/client-ca.pem) des autorités qui signent les certificats tls_client_auth
+const ClientCAFile = "client-ca"
+
+// authentification des clients au token, PAR, introspection et révocation end-points
+// les clients mTLS (RFC 8705 section 2) sont authentifiés par le certificat
+// présenté lors de la poignée de main TLS, les autres par la stratégie de fosite
+func (p *Provider) authenticateClient(ctx context.Context, r *http.Request, form url.Values) (fosite.Client, error) {
+	fallback := p.OAuth2Provider.(*fosite.Fosite).DefaultClientAuthenticationStrategy
+
+	//le client mTLS s'identifie par le paramètre client_id
+	clientID := form.Get("client_id")
+	if clientID == "" || form.Get("client_assertion") != "" {
+		return fallback(ctx, r, form)
+	}
+	if _, _, basic := r.BasicAuth(); basic {
+		return fallback(ctx, r, form)
+	}
+
+	fc, err := p.store.GetClient(ctx, clientID)
+	if err != nil {
+		return fallback(ctx, r, form)
+	}
+	client, ok := fc.(*models.Client)
+	if !ok || !client.UsesTLSClientAuth() {
+		return fallback(ctx, r, form)
+	}
+
+	if err := p.authenticateTLSClient(client, r); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// vérification du certificat présenté par un client mTLS
+func (p *Provider) authenticateTLSClient(client *models.Client, r *http.Request) error {
+	cert := PeerCertificate(r)
+	if cert == nil {
+		return fosite.ErrInvalidClient.WithHint("le client doit présenter un certificat TLS")
+	}
+
+	switch client.GetTokenEndpointAuthMethod() {
+	case "tls_client_auth":
+		//certificat émis par une autorité de confiance pour le DN enregistré
+		if cert.Subject.String() != client.TLSClientAuthSubjectDN {
+			return fosite.ErrInvalidClient.WithHint("le sujet du certificat ne correspond pas au client")
+		}
+		if err := p.verifyClientCertificate(r); err != nil {
+			return fosite.ErrInvalidClient.WithHint("le certificat client n'est pas émis par une autorité de confiance").WithWrap(err)
+		}
+	case "self_signed_tls_client_auth":
+		//certificat auto-signé enregistré par son thumbprint ou dans le x5c d'une clé du client
+		if !selfSignedCertificateRegistered(client, cert) {
+			return fosite.ErrInvalidClient.WithHint("le certificat ne correspond à aucun certificat enregistré pour le client")
+		}
+	}
+	return nil
+}
+
+// recherche du certificat auto-signé parmi ceux enregistrés pour le client (RFC 8705 section 2.2.2)
+func selfSignedCertificateRegistered(client *models.Client, cert *x509.Certificate) bool {
+	if client.GetTLSClientCertThumbprints().Has(utils.CertificateThumbprint(cert)) {
+		return true
+	}
+	for _, key := range client.Keys {
+		if len(key.JWK.Certificates) > 0 && key.JWK.Certificates[0].Equal(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+// vérification de la chaîne du certificat client avec les autorités de key/client-ca.pem
+// la poignée de main TLS demande le certificat sans le vérifier (certificats auto-signés)
+func (p *Provider) verifyClientCertificate(r *http.Request) error {
+	if p.ClientCAs == nil {
+		return errors.New("aucune autorité de certification client configurée")
+	}
+
+	peers := r.TLS.PeerCertificates
+	intermediates := x509.NewCertPool()
+	for _, cert := range peers[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := peers[0].Verify(x509.VerifyOptions{
+		Roots:         p.ClientCAs,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err
+}
+
+// certificat client présenté lors de la poignée de main TLS (nil si absent)
+func PeerCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return r.TLS.PeerCertificates[0]
+}
+
+// liaison des jetons émis au certificat client (RFC 8705 section 3)
+// une session déjà liée (refresh token) exige le même certificat
+func BindCertificate(request fosite.AccessRequester, r *http.Request) error {
+	session, ok := request.GetSession().(*models.Session)
+	if !ok {
+		return fosite.ErrServerError.WithHint("session invalide")
+	}
+
+	thumbprint := ""
+	if cert := PeerCertificate(r); cert != nil {
+		thumbprint = utils.CertificateThumbprint(cert)
+	}
+
+	if session.X5TS256 != "" && session.X5TS256 != thumbprint {
+		return fosite.ErrInvalidGrant.WithHint("les jetons sont liés à un autre certificat client")
+	}
+
+	client, ok := request.GetClient().(*models.Client)
+	if !ok || !client.CertificateBoundAccessTokens() {
+		return nil
+	}
+	if thumbprint == "" {
+		return fosite.ErrInvalidRequest.WithHint("le client doit présenter un certificat TLS pour obtenir des jetons liés")
+	}
+	session.X5TS256 = thumbprint
+	return nil
+}
@@ -0,0 +1,184 @@
+package provider
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/go-jose/go-jose/v3"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/ory/fosite"
+)
+
+// certificat de test et sa clé privée
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// génère un certificat signé par parent (auto-signé si parent est nil)
+func newTestCert(t *testing.T, subject pkix.Name, isCA bool, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if isCA {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	}
+
+	signerCert, signerKey := template, key
+	if parent != nil {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+// requête reçue sur une connexion TLS avec le certificat client
+func tlsRequest(certs ...*x509.Certificate) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "https://localhost/oauth2/token", nil)
+	r.TLS = &tls.ConnectionState{PeerCertificates: certs}
+	return r
+}
+
+func TestTLSClientAuthSubject(t *testing.T) {
+	ca := newTestCert(t, pkix.Name{CommonName: "EasyClass Client CA"}, true, nil)
+	otherCA := newTestCert(t, pkix.Name{CommonName: "Other CA"}, true, nil)
+	subject := pkix.Name{CommonName: "client.easyclass.test", Organization: []string{"EasyClass"}}
+	clientCert := newTestCert(t, subject, false, ca)
+	foreignCert := newTestCert(t, subject, false, otherCA)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	p := &Provider{ClientCAs: pool}
+	client := &models.Client{
+		ID:                      uuid.New(),
+		TokenEndpointAuthMethod: "tls_client_auth",
+		TLSClientAuthSubjectDN:  clientCert.cert.Subject.String(),
+	}
+
+	if err := p.authenticateTLSClient(client, tlsRequest(clientCert.cert)); err != nil {
+		t.Fatalf("certificat valide refusé: %v", err)
+	}
+
+	cases := map[string]*http.Request{
+		"sans certificat":       tlsRequest(),
+		"autorité inconnue":     tlsRequest(foreignCert.cert),
+		"sujet différent":       tlsRequest(newTestCert(t, pkix.Name{CommonName: "intrus"}, false, ca).cert),
+		"certificat auto-signé": tlsRequest(newTestCert(t, subject, false, nil).cert),
+	}
+	for name, r := range cases {
+		if err := p.authenticateTLSClient(client, r); !errors.Is(err, fosite.ErrInvalidClient) {
+			t.Errorf("%s: erreur invalid_client attendue, obtenu %v", name, err)
+		}
+	}
+
+	//sans autorité configurée aucun certificat n'est accepté
+	if err := (&Provider{}).authenticateTLSClient(client, tlsRequest(clientCert.cert)); !errors.Is(err, fosite.ErrInvalidClient) {
+		t.Errorf("erreur invalid_client attendue sans autorité, obtenu %v", err)
+	}
+}
+
+func TestSelfSignedTLSClientAuth(t *testing.T) {
+	registered := newTestCert(t, pkix.Name{CommonName: "self-signed"}, false, nil)
+	other := newTestCert(t, pkix.Name{CommonName: "self-signed"}, false, nil)
+	p := &Provider{}
+
+	byThumbprint := &models.Client{
+		ID:                       uuid.New(),
+		TokenEndpointAuthMethod:  "self_signed_tls_client_auth",
+		TLSClientCertThumbprints: pq.StringArray{utils.CertificateThumbprint(registered.cert)},
+	}
+	byJWK := &models.Client{
+		ID:                      uuid.New(),
+		TokenEndpointAuthMethod: "self_signed_tls_client_auth",
+		Keys: []models.ClientKey{{
+			JWK: models.JWKey(jose.JSONWebKey{
+				Key:          &registered.key.PublicKey,
+				KeyID:        "mtls",
+				Certificates: []*x509.Certificate{registered.cert},
+			}),
+		}},
+	}
+
+	for name, client := range map[string]*models.Client{"thumbprint": byThumbprint, "jwk x5c": byJWK} {
+		if err := p.authenticateTLSClient(client, tlsRequest(registered.cert)); err != nil {
+			t.Errorf("%s: certificat enregistré refusé: %v", name, err)
+		}
+		if err := p.authenticateTLSClient(client, tlsRequest(other.cert)); !errors.Is(err, fosite.ErrInvalidClient) {
+			t.Errorf("%s: erreur invalid_client attendue, obtenu %v", name, err)
+		}
+	}
+}
+
+func TestBindCertificate(t *testing.T) {
+	cert := newTestCert(t, pkix.Name{CommonName: "bound"}, false, nil)
+	other := newTestCert(t, pkix.Name{CommonName: "other"}, false, nil)
+	client := &models.Client{ID: uuid.New(), TLSClientCertificateBoundAccessTokens: utils.PtrBool(true)}
+
+	newRequest := func(session *models.Session) *fosite.AccessRequest {
+		request := fosite.NewAccessRequest(session)
+		request.Client = client
+		return request
+	}
+
+	session := &models.Session{}
+	if err := BindCertificate(newRequest(session), tlsRequest(cert.cert)); err != nil {
+		t.Fatal(err)
+	}
+	thumbprint := utils.CertificateThumbprint(cert.cert)
+	if session.X5TS256 != thumbprint {
+		t.Fatalf("x5t#S256 = %q, attendu %q", session.X5TS256, thumbprint)
+	}
+	cnf, _ := session.GetExtraClaims()["cnf"].(map[string]interface{})
+	if cnf["x5t#S256"] != thumbprint {
+		t.Fatalf("claim cnf = %v", cnf)
+	}
+
+	//le rafraîchissement exige le même certificat
+	if err := BindCertificate(newRequest(session), tlsRequest(cert.cert)); err != nil {
+		t.Errorf("même certificat refusé: %v", err)
+	}
+	if err := BindCertificate(newRequest(session), tlsRequest(other.cert)); !errors.Is(err, fosite.ErrInvalidGrant) {
+		t.Errorf("erreur invalid_grant attendue, obtenu %v", err)
+	}
+
+	//un client lié doit présenter un certificat
+	if err := BindCertificate(newRequest(&models.Session{}), tlsRequest()); !errors.Is(err, fosite.ErrInvalidRequest) {
+		t.Errorf("erreur invalid_request attendue, obtenu %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package provider
+
+import (
+	"context"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+)
+
+// variable d'environnement du notifieur CIBA ("stub" pour les tests en local)
+const CIBANotifierEnv = "CIBA_NOTIFIER"
+
+// demande d'approbation transmise à l'utilisateur désigné par le login_hint
+type CIBANotification struct {
+	User           *models.User
+	ClientName     string
+	BindingMessage string
+	Scopes         []string
+	//lien de la page d'approbation de la demande
+	ApprovalURI string
+	ExpiresAt   time.Time
+}
+
+// envoi des demandes d'approbation CIBA à l'utilisateur (email, push, ...)
+type Notifier interface {
+	Notify(ctx context.Context, notification CIBANotification) error
+}
+
+// notifieur par défaut: email envoyé à l'adresse de l'utilisateur
+type EmailNotifier struct{}
+
+func (EmailNotifier) Notify(ctx context.Context, notification CIBANotification) error {
+	return utils.SendApprovalRequest(
+		notification.User.Email,
+		notification.User.UserName,
+		notification.ClientName,
+		notification.BindingMessage,
+		notification.ApprovalURI,
+		notification.ExpiresAt,
+	)
+}
+
+// notifieur en mémoire pour les tests en local
+// les demandes sont conservées et leur lien d'approbation journalisé
+type StubNotifier struct {
+	mu            sync.Mutex
+	notifications []CIBANotification
+}
+
+func (s *StubNotifier) Notify(ctx context.Context, notification CIBANotification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.notifications = append(s.notifications, notification)
+	log.Printf("ciba: demande de %s pour %s: %s", notification.ClientName, notification.User.UserName, notification.ApprovalURI)
+	return nil
+}
+
+// demandes reçues par le notifieur
+func (s *StubNotifier) Notifications() []CIBANotification {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.notifications)
+}
+
+// notifieur choisi par la variable d'environnement CIBA_NOTIFIER
+func NewNotifier() Notifier {
+	if os.Getenv(CIBANotifierEnv) == "stub" {
+		return &StubNotifier{}
+	}
+	return EmailNotifier{}
+}
@@ -2,22 +2,71 @@ package provider
 
 import (
 	"context"
-	"crypto/rsa"
+	"crypto"
+	"crypto/x509"
+	"log"
 	"os"
 	"time"
 
 	"github.com/dylEasydev/go-oauth2-easyclass/db"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
 	"github.com/ory/fosite"
 	"github.com/ory/fosite/compose"
+	"github.com/ory/fosite/handler/oauth2"
+	"github.com/ory/fosite/handler/openid"
 	"github.com/ory/fosite/token/jwt"
 )
 
-func InitProvider(store *db.Store, key *rsa.PrivateKey) fosite.OAuth2Provider {
-	keyGetter := func(context.Context) (interface{}, error) {
-		return key, nil
+// structure du fournisseur OIDC
+// regroupe le fournisseur fosite et sa configuration
+type Provider struct {
+	fosite.OAuth2Provider
+	Config *fosite.Config
+
+	//signataire des jetons émis par le serveur (userinfo, ...)
+	Signer jwt.Signer
+
+	//clés de signature du serveur
+	Keys *KeyManager
+
+	//notifications de déconnexion back-channel
+	Backchannel *BackchannelNotifier
+
+	//stockage (clients, rejeu des preuves DPoP et des objets de requête, ressources)
+	store ProviderStorage
+
+	//device authorization grant et url de la page de vérification
+	Device                *DeviceHandler
+	DeviceVerificationURI string
+
+	//authentification back-channel (CIBA) et url de la page d'approbation
+	CIBA            *CIBAHandler
+	CIBAApprovalURI string
+
+	//autorités de certification des clients tls_client_auth (nil si non configurées)
+	ClientCAs *x509.CertPool
+}
+
+// stockage utilisé directement par le fournisseur
+type ProviderStorage interface {
+	GetClient(ctx context.Context, id string) (fosite.Client, error)
+	MarkDPoPProofUsed(ctx context.Context, jti string, exp time.Time) error
+	MarkRequestObjectUsed(ctx context.Context, clientID string, jti string, exp time.Time) error
+	ProtectedResourceStorage
+}
+
+// key est la clé lue sur le disque, importée comme première clé active
+func InitProvider(store *db.Store, key crypto.Signer) (*Provider, error) {
+	keys := NewKeyManager(store)
+	if err := keys.Init(context.Background(), key); err != nil {
+		return nil, err
 	}
+
 	secret := []byte(os.Getenv("SECRET"))
 
+	// signe toujours avec la clé active courante (et son kid)
+	signer := NewKeySigner(keys)
+
 	conf := &fosite.Config{
 		GlobalSecret: secret,
 
@@ -33,25 +82,41 @@ func InitProvider(store *db.Store, key *rsa.PrivateKey) fosite.OAuth2Provider {
 		//methode plain quand le client n'as pas fournis
 		// le code_challenge_method
 		EnablePKCEPlainChallengeMethod: true,
-		IDTokenIssuer:                  "easy-class",
+		IDTokenIssuer:                  utils.URL_Host,
+		AccessTokenIssuer:              utils.URL_Host,
 		PushedAuthorizeContextLifespan: 5 * time.Minute,
 		SendDebugMessagesToClients:     true,
 		MinParameterEntropy:            8,
 	}
 
-	return compose.Compose(
+	oauth2Provider := compose.Compose(
 		conf,
 		store,
 		&compose.CommonStrategy{
-			CoreStrategy:               compose.NewOAuth2JWTStrategy(keyGetter, compose.NewOAuth2HMACStrategy(conf), conf),
-			OpenIDConnectTokenStrategy: compose.NewOpenIDConnectStrategy(keyGetter, conf),
-			Signer:                     &jwt.DefaultSigner{GetPrivateKey: keyGetter},
+			//format des jetons d'accès selon les ressources demandées (RFC 8707)
+			CoreStrategy: &ResourceTokenStrategy{
+				DefaultJWTStrategy: &oauth2.DefaultJWTStrategy{
+					Signer:          signer,
+					HMACSHAStrategy: compose.NewOAuth2HMACStrategy(conf),
+					Config:          conf,
+				},
+				Resources: store,
+			},
+			OpenIDConnectTokenStrategy: &openid.DefaultStrategy{
+				Signer: signer,
+				Config: conf,
+			},
+			Signer: signer,
 		},
 		compose.OAuth2AuthorizeExplicitFactory,
 		compose.OAuth2AuthorizeImplicitFactory,
 		compose.OAuth2ClientCredentialsGrantFactory,
 		compose.OAuth2RefreshTokenGrantFactory,
+		compose.OAuth2ResourceOwnerPasswordCredentialsFactory,
 		compose.RFC7523AssertionGrantFactory,
+		DeviceGrantFactory,
+		CIBAGrantFactory,
+		TokenExchangeFactory,
 
 		compose.OpenIDConnectExplicitFactory,
 		compose.OpenIDConnectImplicitFactory,
@@ -65,4 +130,37 @@ func InitProvider(store *db.Store, key *rsa.PrivateKey) fosite.OAuth2Provider {
 		compose.PushedAuthorizeHandlerFactory,
 		compose.OIDCUserinfoVerifiableCredentialFactory,
 	)
+
+	p := &Provider{
+		OAuth2Provider: oauth2Provider,
+		Config:         conf,
+		Signer:         signer,
+		Keys:           keys,
+		Backchannel:    NewBackchannelNotifier(store, signer, conf.IDTokenIssuer),
+		store:          store,
+	}
+
+	//le device authorization end-point et le back-channel authentication end-point
+	// utilisent les handlers composés
+	for _, handler := range conf.TokenEndpointHandlers {
+		switch h := handler.(type) {
+		case *DeviceHandler:
+			p.Device = h
+		case *CIBAHandler:
+			p.CIBA = h
+		}
+	}
+
+	//authentification des clients par certificat (RFC 8705)
+	if pool, err := utils.LoadCertPool(ClientCAFile); err == nil {
+		p.ClientCAs = pool
+	} else {
+		log.Printf("warning: tls_client_auth désactivé: %v", err)
+	}
+	conf.ClientAuthenticationStrategy = p.authenticateClient
+
+	//réponses d'autorisation signées (JARM)
+	conf.ResponseModeHandlerExtension = NewJARMHandler(signer, conf)
+
+	return p, nil
 }
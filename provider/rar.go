@@ -0,0 +1,112 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/ory/fosite"
+)
+
+// type d'autorisation détaillée sur un cours de la plateforme
+const CourseAuthorizationType = "easyclass_course"
+
+// authorization_details invalide ou non autorisé (RFC 9396 section 5)
+var ErrInvalidAuthorizationDetails = &fosite.RFC6749Error{
+	ErrorField:       "invalid_authorization_details",
+	DescriptionField: "The authorization details are invalid or not allowed for this client.",
+	CodeField:        http.StatusBadRequest,
+}
+
+// validation d'une entrée selon son type
+type AuthorizationDetailValidator func(detail models.AuthorizationDetail) error
+
+// types d'autorisation détaillée enregistrés
+var AuthorizationDetailTypes = map[string]AuthorizationDetailValidator{
+	CourseAuthorizationType: validateCourseDetail,
+}
+
+// actions possibles sur un cours
+var CourseActions = []string{"read", "enroll", "submit", "grade"}
+
+// un cours désigné par course_id et au moins une action connue
+func validateCourseDetail(detail models.AuthorizationDetail) error {
+	if detail.CourseID == "" {
+		return fmt.Errorf("'course_id' est requis pour le type '%s'", detail.Type)
+	}
+	if len(detail.Actions) == 0 {
+		return fmt.Errorf("'actions' est requis pour le type '%s'", detail.Type)
+	}
+	for _, action := range detail.Actions {
+		if !slices.Contains(CourseActions, action) {
+			return fmt.Errorf("action '%s' inconnue pour le type '%s'", action, detail.Type)
+		}
+	}
+	return nil
+}
+
+// lecture et validation du paramètre authorization_details d'une requête
+// chaque type doit être enregistré et autorisé pour le client
+func ParseAuthorizationDetails(client fosite.Client, form map[string][]string) (models.AuthorizationDetails, error) {
+	values := form["authorization_details"]
+	if len(values) == 0 || values[0] == "" {
+		return nil, nil
+	}
+
+	var details models.AuthorizationDetails
+	if err := json.Unmarshal([]byte(values[0]), &details); err != nil {
+		return nil, ErrInvalidAuthorizationDetails.WithHint("'authorization_details' doit être un tableau JSON d'objets")
+	}
+	if len(details) == 0 {
+		return nil, ErrInvalidAuthorizationDetails.WithHint("'authorization_details' ne doit pas être vide")
+	}
+
+	allowed := fosite.Arguments{}
+	if c, ok := client.(*models.Client); ok {
+		allowed = c.GetAuthorizationDetailsTypes()
+	}
+	for _, detail := range details {
+		validate, ok := AuthorizationDetailTypes[detail.Type]
+		if !ok {
+			return nil, ErrInvalidAuthorizationDetails.WithHintf("type d'autorisation '%s' inconnu", detail.Type)
+		}
+		if !allowed.Has(detail.Type) {
+			return nil, ErrInvalidAuthorizationDetails.WithHintf("le client n'est pas autorisé à demander le type '%s'", detail.Type)
+		}
+		if err := validate(detail); err != nil {
+			return nil, ErrInvalidAuthorizationDetails.WithHint(err.Error())
+		}
+	}
+
+	return details, nil
+}
+
+// autorisations détaillées des jetons émis au token end-point
+// les grants sans interaction de l'utilisateur accordent la demande,
+// les autres ne peuvent que restreindre les autorisations déjà accordées
+func GrantAuthorizationDetails(request fosite.AccessRequester) error {
+	session, ok := request.GetSession().(*models.Session)
+	if !ok {
+		return fosite.ErrServerError.WithHint("session invalide")
+	}
+
+	requested, err := ParseAuthorizationDetails(request.GetClient(), request.GetRequestForm())
+	if err != nil || requested == nil {
+		return err
+	}
+
+	grants := request.GetGrantTypes()
+	if grants.ExactOne("client_credentials") || grants.ExactOne("password") {
+		session.AuthorizationDetails = requested
+		return nil
+	}
+
+	if !session.AuthorizationDetails.Covers(requested) {
+		return ErrInvalidAuthorizationDetails.WithHint("les autorisations demandées dépassent celles accordées")
+	}
+	session.KeepRefreshGrant(request.GetGrantedScopes(), request.GetGrantedAudience())
+	session.AuthorizationDetails = requested
+	return nil
+}
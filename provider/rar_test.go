@@ -0,0 +1,109 @@
+package provider
+
+import (
+	"errors"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/ory/fosite"
+)
+
+func TestParseAuthorizationDetails(t *testing.T) {
+	client := &models.Client{ID: uuid.New(), AuthorizationDetailsTypes: pq.StringArray{CourseAuthorizationType}}
+
+	cases := []struct {
+		name    string
+		details string
+		count   int
+		valid   bool
+	}{
+		{"paramètre absent", "", 0, true},
+		{"cours valide", `[{"type":"easyclass_course","course_id":"algebre","actions":["read","submit"]}]`, 1, true},
+		{"json invalide", `{"type":"easyclass_course"}`, 0, false},
+		{"tableau vide", `[]`, 0, false},
+		{"type inconnu", `[{"type":"payment"}]`, 0, false},
+		{"cours sans course_id", `[{"type":"easyclass_course","actions":["read"]}]`, 0, false},
+		{"cours sans action", `[{"type":"easyclass_course","course_id":"algebre"}]`, 0, false},
+		{"action inconnue", `[{"type":"easyclass_course","course_id":"algebre","actions":["delete"]}]`, 0, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			details, err := ParseAuthorizationDetails(client, url.Values{"authorization_details": {tc.details}})
+			if !tc.valid {
+				if !errors.Is(err, ErrInvalidAuthorizationDetails) {
+					t.Fatalf("erreur invalid_authorization_details attendue, obtenu %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(details) != tc.count {
+				t.Fatalf("%d entrées, attendu %d", len(details), tc.count)
+			}
+		})
+	}
+
+	//le type doit être autorisé pour le client
+	other := &models.Client{ID: uuid.New()}
+	form := url.Values{"authorization_details": {`[{"type":"easyclass_course","course_id":"algebre","actions":["read"]}]`}}
+	if _, err := ParseAuthorizationDetails(other, form); !errors.Is(err, ErrInvalidAuthorizationDetails) {
+		t.Fatalf("erreur attendue pour un type non autorisé, obtenu %v", err)
+	}
+}
+
+func TestGrantAuthorizationDetails(t *testing.T) {
+	client := &models.Client{ID: uuid.New(), AuthorizationDetailsTypes: pq.StringArray{CourseAuthorizationType}}
+	granted := models.AuthorizationDetails{{Type: CourseAuthorizationType, CourseID: "algebre", Actions: []string{"read", "submit"}}}
+
+	cases := []struct {
+		name    string
+		grant   string
+		details string
+		actions []string
+		refresh bool
+		valid   bool
+	}{
+		{"restriction au rafraîchissement", "refresh_token", `[{"type":"easyclass_course","course_id":"algebre","actions":["read"]}]`, []string{"read"}, true, true},
+		{"extension au rafraîchissement", "refresh_token", `[{"type":"easyclass_course","course_id":"algebre","actions":["grade"]}]`, nil, false, false},
+		{"autre cours à l'échange du code", "authorization_code", `[{"type":"easyclass_course","course_id":"physique","actions":["read"]}]`, nil, false, false},
+		{"client_credentials accorde la demande", "client_credentials", `[{"type":"easyclass_course","course_id":"algebre","actions":["grade"]}]`, []string{"grade"}, false, true},
+		{"sans demande", "refresh_token", "", []string{"read", "submit"}, false, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			session := &models.Session{AuthorizationDetails: granted}
+			request := fosite.NewAccessRequest(session)
+			request.Client = client
+			request.GrantTypes = fosite.Arguments{tc.grant}
+			request.Form = url.Values{"authorization_details": {tc.details}}
+			request.GrantScope("openid")
+
+			err := GrantAuthorizationDetails(request)
+			if !tc.valid {
+				if !errors.Is(err, ErrInvalidAuthorizationDetails) {
+					t.Fatalf("erreur invalid_authorization_details attendue, obtenu %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(session.AuthorizationDetails) != 1 || !slices.Equal(session.AuthorizationDetails[0].Actions, tc.actions) {
+				t.Fatalf("autorisations = %v, actions attendues %v", session.AuthorizationDetails, tc.actions)
+			}
+
+			//le grant complet est conservé pour les rafraîchissements suivants
+			if (session.RefreshGrant != nil) != tc.refresh {
+				t.Fatalf("grant de rafraîchissement = %v", session.RefreshGrant)
+			}
+			if tc.refresh && !session.RefreshGrant.AuthorizationDetails.Covers(granted) {
+				t.Fatalf("grant conservé = %v", session.RefreshGrant.AuthorizationDetails)
+			}
+		})
+	}
+}
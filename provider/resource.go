@@ -0,0 +1,156 @@
+package provider
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/handler/oauth2"
+)
+
+// registre des ressources protégées
+type ProtectedResourceStorage interface {
+	GetProtectedResources(ctx context.Context, identifiers []string) ([]models.ProtectedResource, error)
+}
+
+// ressources demandées par le paramètre resource (RFC 8707 section 2)
+func RequestedResources(form url.Values) []string {
+	resources := []string{}
+	for _, resource := range fosite.RemoveEmpty(form["resource"]) {
+		if !slices.Contains(resources, resource) {
+			resources = append(resources, resource)
+		}
+	}
+	return resources
+}
+
+// validation des ressources demandées: uri absolue sans fragment,
+// enregistrée et autorisée pour le client (Client.Audience)
+func (p *Provider) ValidateResources(ctx context.Context, client fosite.Client, form url.Values) ([]models.ProtectedResource, error) {
+	identifiers := RequestedResources(form)
+	if len(identifiers) == 0 {
+		return nil, nil
+	}
+
+	for _, identifier := range identifiers {
+		uri, err := url.Parse(identifier)
+		if err != nil || !uri.IsAbs() || uri.Fragment != "" {
+			return nil, ErrInvalidTarget.WithHintf("la ressource '%s' doit être une uri absolue sans fragment", identifier)
+		}
+		if !client.GetAudience().Has(identifier) {
+			return nil, ErrInvalidTarget.WithHintf("le client n'est pas autorisé à demander la ressource '%s'", identifier)
+		}
+	}
+
+	resources, err := p.store.GetProtectedResources(ctx, identifiers)
+	if err != nil {
+		return nil, fosite.ErrServerError.WithWrap(err)
+	}
+	for _, identifier := range identifiers {
+		if !slices.ContainsFunc(resources, func(r models.ProtectedResource) bool { return r.Identifier == identifier }) {
+			return nil, ErrInvalidTarget.WithHintf("la ressource '%s' est inconnue", identifier)
+		}
+	}
+
+	return resources, nil
+}
+
+// restriction des jetons émis au token end-point aux ressources demandées
+// les grants sans interaction de l'utilisateur obtiennent les ressources demandées,
+// les autres ne peuvent demander que des ressources déjà accordées;
+// le refresh token garde le grant complet
+func (p *Provider) GrantResources(ctx context.Context, request fosite.AccessRequester) error {
+	grants := request.GetGrantTypes()
+	if grants.ExactOne(TokenExchangeGrantType) {
+		return nil
+	}
+
+	resources, err := p.ValidateResources(ctx, request.GetClient(), request.GetRequestForm())
+	if err != nil || resources == nil {
+		return err
+	}
+
+	accessRequest, ok := request.(*fosite.AccessRequest)
+	if !ok {
+		return fosite.ErrServerError.WithHint("requête invalide")
+	}
+	session, ok := request.GetSession().(*models.Session)
+	if !ok {
+		return fosite.ErrServerError.WithHint("session invalide")
+	}
+
+	identifiers := RequestedResources(request.GetRequestForm())
+	if !grants.ExactOne("client_credentials") && !grants.ExactOne("password") {
+		for _, identifier := range identifiers {
+			if !request.GetGrantedAudience().Has(identifier) {
+				return ErrInvalidTarget.WithHintf("la ressource '%s' n'a pas été accordée", identifier)
+			}
+		}
+		session.KeepRefreshGrant(request.GetGrantedScopes(), request.GetGrantedAudience())
+	}
+
+	accessRequest.GrantedAudience = fosite.Arguments(identifiers)
+	accessRequest.GrantedScope = p.resourceScopes(ctx, request.GetGrantedScopes(), resources)
+	return nil
+}
+
+// scopes accordés utilisables pour au moins une des ressources
+// les scopes OpenID Connect et ceux du refresh token sont conservés
+func (p *Provider) resourceScopes(ctx context.Context, granted fosite.Arguments, resources []models.ProtectedResource) fosite.Arguments {
+	allowed := append(slices.Clone(utils.OIDCScopes), p.Config.GetRefreshTokenScopes(ctx)...)
+	for _, resource := range resources {
+		if len(resource.Scopes) == 0 {
+			return granted
+		}
+		allowed = append(allowed, resource.Scopes...)
+	}
+
+	scopes := fosite.Arguments{}
+	for _, scope := range granted {
+		if slices.Contains(allowed, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// stratégie des jetons d'accès selon le format des ressources de leur audience
+// JWT par défaut, opaque (HMAC, vérifié par introspection) si une ressource l'exige
+type ResourceTokenStrategy struct {
+	*oauth2.DefaultJWTStrategy
+	Resources ProtectedResourceStorage
+}
+
+func (s *ResourceTokenStrategy) GenerateAccessToken(ctx context.Context, requester fosite.Requester) (string, string, error) {
+	resources, err := s.Resources.GetProtectedResources(ctx, requester.GetGrantedAudience())
+	if err != nil {
+		return "", "", err
+	}
+	if slices.ContainsFunc(resources, func(r models.ProtectedResource) bool { return r.IsOpaque() }) {
+		return s.HMACSHAStrategy.GenerateAccessToken(ctx, requester)
+	}
+	return s.DefaultJWTStrategy.GenerateAccessToken(ctx, requester)
+}
+
+func (s *ResourceTokenStrategy) AccessTokenSignature(ctx context.Context, token string) string {
+	if isJWT(token) {
+		return s.DefaultJWTStrategy.AccessTokenSignature(ctx, token)
+	}
+	return s.HMACSHAStrategy.AccessTokenSignature(ctx, token)
+}
+
+func (s *ResourceTokenStrategy) ValidateAccessToken(ctx context.Context, requester fosite.Requester, token string) error {
+	if isJWT(token) {
+		return s.DefaultJWTStrategy.ValidateAccessToken(ctx, requester, token)
+	}
+	return s.HMACSHAStrategy.ValidateAccessToken(ctx, requester, token)
+}
+
+// un JWT compact a trois parties, un jeton HMAC deux
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
@@ -0,0 +1,201 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/compose"
+	"github.com/ory/fosite/handler/oauth2"
+)
+
+const (
+	coursesAPI = "https://courses.easyclass.test"
+	gradesAPI  = "https://grades.easyclass.test"
+)
+
+func newResourceProvider() (*Provider, *models.Client) {
+	store := newMemoryProviderStorage()
+	store.resources = []models.ProtectedResource{
+		{Identifier: coursesAPI, Scopes: pq.StringArray{"courses"}},
+		{Identifier: gradesAPI, Scopes: pq.StringArray{"grades"}, TokenFormat: models.TOKEN_FORMAT_OPAQUE},
+		{Identifier: "https://unregistered.easyclass.test"},
+	}
+	client := &models.Client{
+		ID:       uuid.New(),
+		Scopes:   pq.StringArray{"openid", "offline_access", "courses", "grades"},
+		Audience: pq.StringArray{coursesAPI, gradesAPI, "https://unknown.easyclass.test"},
+	}
+	config := &fosite.Config{RefreshTokenScopes: []string{"offline_access"}}
+	return &Provider{store: store, Config: config}, client
+}
+
+func TestValidateResources(t *testing.T) {
+	p, client := newResourceProvider()
+
+	cases := []struct {
+		name      string
+		resources []string
+		count     int
+		valid     bool
+	}{
+		{"sans ressource", nil, 0, true},
+		{"ressource enregistrée", []string{coursesAPI}, 1, true},
+		{"ressource répétée", []string{coursesAPI, coursesAPI, gradesAPI}, 2, true},
+		{"uri relative", []string{"/courses"}, 0, false},
+		{"uri avec fragment", []string{coursesAPI + "#section"}, 0, false},
+		{"ressource non autorisée pour le client", []string{"https://unregistered.easyclass.test"}, 0, false},
+		{"ressource inconnue", []string{"https://unknown.easyclass.test"}, 0, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resources, err := p.ValidateResources(context.Background(), client, url.Values{"resource": tc.resources})
+			if !tc.valid {
+				if !errors.Is(err, ErrInvalidTarget) {
+					t.Fatalf("erreur invalid_target attendue, obtenu %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(resources) != tc.count {
+				t.Fatalf("%d ressources, attendu %d", len(resources), tc.count)
+			}
+		})
+	}
+}
+
+func TestGrantResources(t *testing.T) {
+	p, client := newResourceProvider()
+	granted := fosite.Arguments{"openid", "offline_access", "courses", "grades"}
+
+	cases := []struct {
+		name      string
+		grant     string
+		audience  fosite.Arguments
+		resources []string
+		scopes    fosite.Arguments
+		refresh   bool
+		valid     bool
+	}{
+		{"sans ressource", "refresh_token", fosite.Arguments{coursesAPI, gradesAPI}, nil, granted, false, true},
+		{"restriction au rafraîchissement", "refresh_token", fosite.Arguments{coursesAPI, gradesAPI}, []string{coursesAPI}, fosite.Arguments{"openid", "offline_access", "courses"}, true, true},
+		{"restriction à l'échange du code", "authorization_code", fosite.Arguments{coursesAPI, gradesAPI}, []string{gradesAPI}, fosite.Arguments{"openid", "offline_access", "grades"}, true, true},
+		{"ressource non accordée", "refresh_token", fosite.Arguments{coursesAPI}, []string{gradesAPI}, nil, false, false},
+		{"client_credentials obtient la ressource", "client_credentials", nil, []string{gradesAPI}, fosite.Arguments{"openid", "offline_access", "grades"}, false, true},
+		{"échange de jeton ignoré", TokenExchangeGrantType, fosite.Arguments{coursesAPI}, []string{"https://unknown.easyclass.test"}, granted, false, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			session := &models.Session{}
+			request := fosite.NewAccessRequest(session)
+			request.Client = client
+			request.GrantTypes = fosite.Arguments{tc.grant}
+			request.Form = url.Values{"resource": tc.resources}
+			request.GrantedScope = slices.Clone(granted)
+			request.GrantedAudience = slices.Clone(tc.audience)
+
+			err := p.GrantResources(context.Background(), request)
+			if !tc.valid {
+				if !errors.Is(err, ErrInvalidTarget) {
+					t.Fatalf("erreur invalid_target attendue, obtenu %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(request.GetGrantedScopes(), tc.scopes) {
+				t.Fatalf("scopes = %v, attendu %v", request.GetGrantedScopes(), tc.scopes)
+			}
+			if tc.resources != nil && tc.grant != TokenExchangeGrantType && !slices.Equal(request.GetGrantedAudience(), fosite.Arguments(tc.resources)) {
+				t.Fatalf("audience = %v, attendu %v", request.GetGrantedAudience(), tc.resources)
+			}
+
+			//le refresh token garde les scopes et l'audience du grant complet
+			if (session.RefreshGrant != nil) != tc.refresh {
+				t.Fatalf("grant de rafraîchissement = %v", session.RefreshGrant)
+			}
+			if tc.refresh && (!slices.Equal(session.RefreshGrant.Scopes, granted) || !slices.Equal(session.RefreshGrant.Audience, tc.audience)) {
+				t.Fatalf("grant conservé = %+v", session.RefreshGrant)
+			}
+		})
+	}
+}
+
+func TestResourceScopes(t *testing.T) {
+	p, _ := newResourceProvider()
+	granted := fosite.Arguments{"openid", "email", "offline_access", "courses", "grades", "admin"}
+
+	cases := []struct {
+		name      string
+		resources []models.ProtectedResource
+		expected  fosite.Arguments
+	}{
+		{"une ressource", []models.ProtectedResource{{Scopes: pq.StringArray{"courses"}}}, fosite.Arguments{"openid", "email", "offline_access", "courses"}},
+		{"plusieurs ressources", []models.ProtectedResource{{Scopes: pq.StringArray{"courses"}}, {Scopes: pq.StringArray{"grades"}}}, fosite.Arguments{"openid", "email", "offline_access", "courses", "grades"}},
+		{"ressource sans restriction", []models.ProtectedResource{{Scopes: pq.StringArray{"courses"}}, {}}, granted},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if scopes := p.resourceScopes(context.Background(), granted, tc.resources); !slices.Equal(scopes, tc.expected) {
+				t.Fatalf("scopes = %v, attendu %v", scopes, tc.expected)
+			}
+		})
+	}
+}
+
+func TestResourceTokenStrategy(t *testing.T) {
+	p, _ := newResourceProvider()
+	signer, _ := testSigner(t)
+	config := &fosite.Config{GlobalSecret: []byte("some-super-cool-secret-that-nobody-knows")}
+	strategy := &ResourceTokenStrategy{
+		DefaultJWTStrategy: &oauth2.DefaultJWTStrategy{
+			Signer:          signer,
+			HMACSHAStrategy: compose.NewOAuth2HMACStrategy(config),
+			Config:          config,
+		},
+		Resources: p.store,
+	}
+
+	cases := []struct {
+		name     string
+		audience fosite.Arguments
+		jwt      bool
+	}{
+		{"ressource jwt", fosite.Arguments{coursesAPI}, true},
+		{"ressource opaque", fosite.Arguments{gradesAPI}, false},
+		{"une ressource opaque suffit", fosite.Arguments{coursesAPI, gradesAPI}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			session := &models.Session{ID: uuid.New(), Subject: "alice"}
+			session.SetExpiresAt(fosite.AccessToken, time.Now().Add(time.Hour))
+			request := fosite.NewAccessRequest(session)
+			request.Client = &models.Client{ID: uuid.New()}
+			request.GrantedAudience = tc.audience
+
+			token, signature, err := strategy.GenerateAccessToken(context.Background(), request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if isJWT(token) != tc.jwt {
+				t.Fatalf("jeton JWT = %v, attendu %v", isJWT(token), tc.jwt)
+			}
+			if strategy.AccessTokenSignature(context.Background(), token) != signature {
+				t.Fatal("signature du jeton différente de celle générée")
+			}
+			if err := strategy.ValidateAccessToken(context.Background(), request, token); err != nil {
+				t.Fatalf("jeton invalide: %v", err)
+			}
+		})
+	}
+}
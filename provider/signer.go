@@ -0,0 +1,75 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ory/fosite/token/jwt"
+)
+
+// signataire des jetons basé sur les clés du KeyManager
+// signe avec la clé active et vérifie avec la clé désignée par le kid
+type KeySigner struct {
+	jwt.DefaultSigner
+	Keys *KeyManager
+}
+
+func NewKeySigner(keys *KeyManager) *KeySigner {
+	return &KeySigner{
+		DefaultSigner: jwt.DefaultSigner{GetPrivateKey: keys.GetPrivateKey},
+		Keys:          keys,
+	}
+}
+
+// signature avec la clé active de l'algorithme demandé dans l'entête
+// (choisi par le client), le kid de la clé est forcé dans l'entête
+func (s *KeySigner) Generate(ctx context.Context, claims jwt.MapClaims, header jwt.Mapper) (string, string, error) {
+	extra := map[string]interface{}{}
+	if header != nil {
+		for name, value := range header.ToMap() {
+			extra[name] = value
+		}
+	}
+
+	alg, _ := extra["alg"].(string)
+	if alg == "" {
+		alg = DefaultSigningAlg
+	}
+	jwk, err := s.Keys.GetSigningKey(ctx, alg)
+	if err != nil {
+		return "", "", err
+	}
+
+	delete(extra, "alg")
+	extra["kid"] = jwk.KeyID
+
+	signer := jwt.DefaultSigner{GetPrivateKey: func(context.Context) (interface{}, error) {
+		return jwk, nil
+	}}
+	return signer.Generate(ctx, claims, &jwt.Headers{Extra: extra})
+}
+
+// validation d'un jeton signé par une clé encore publiée
+func (s *KeySigner) Validate(ctx context.Context, token string) (string, error) {
+	if _, err := s.Decode(ctx, token); err != nil {
+		return "", err
+	}
+	return s.GetSignature(ctx, token)
+}
+
+// décodage d'un jeton avec la clé public correspondant au kid
+func (s *KeySigner) Decode(ctx context.Context, token string) (*jwt.Token, error) {
+	return jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
+		kid, ok := t.Header["kid"].(string)
+		if !ok || kid == "" {
+			return nil, errors.New("le jeton ne contient pas de kid")
+		}
+		public, err := s.Keys.PublicKey(ctx, kid)
+		if err != nil {
+			return nil, fmt.Errorf("clé de signature %s inconnue: %w", kid, err)
+		}
+		// la JWK (et non la clé brute) est acceptée par go-jose pour Ed25519
+		return public, nil
+	})
+}
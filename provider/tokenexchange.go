@@ -0,0 +1,238 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/google/uuid"
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/handler/oauth2"
+)
+
+const (
+	//grant_type de l'échange de jeton (RFC 8693)
+	TokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	//seul type de jeton accepté et émis par l'échange
+	AccessTokenType = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// audience ou ressource demandée non autorisée (RFC 8693 section 2.2.2, RFC 8707)
+var ErrInvalidTarget = &fosite.RFC6749Error{
+	ErrorField:       "invalid_target",
+	DescriptionField: "The requested audience or resource is invalid, unknown, or not allowed for this client.",
+	CodeField:        http.StatusBadRequest,
+}
+
+// sujet de l'utilisateur pour un client (public ou pairwise)
+type SubjectStorage interface {
+	SubjectFor(ctx context.Context, client fosite.Client, userID uuid.UUID, username string) (string, error)
+}
+
+// handler de l'échange de jeton
+// un service obtient un jeton pour le compte du sujet d'un jeton d'accès,
+// restreint aux audiences autorisées pour le client
+type TokenExchangeHandler struct {
+	*oauth2.HandleHelper
+	Subjects SubjectStorage
+	Config   fosite.Configurator
+}
+
+var _ fosite.TokenEndpointHandler = (*TokenExchangeHandler)(nil)
+
+// factory compatible avec compose.Compose
+func TokenExchangeFactory(config fosite.Configurator, storage interface{}, strategy interface{}) interface{} {
+	return &TokenExchangeHandler{
+		HandleHelper: &oauth2.HandleHelper{
+			AccessTokenStrategy: strategy.(oauth2.AccessTokenStrategy),
+			AccessTokenStorage:  storage.(oauth2.AccessTokenStorage),
+			Config:              config,
+		},
+		Subjects: storage.(SubjectStorage),
+		Config:   config,
+	}
+}
+
+// validation de la demande d'échange (RFC 8693 section 2.1)
+func (t *TokenExchangeHandler) HandleTokenEndpointRequest(ctx context.Context, request fosite.AccessRequester) error {
+	if !t.CanHandleTokenEndpointRequest(ctx, request) {
+		return fosite.ErrUnknownRequest
+	}
+
+	client := request.GetClient()
+	if !client.GetGrantTypes().Has(TokenExchangeGrantType) {
+		return fosite.ErrUnauthorizedClient.WithHintf("le client n'est pas autorisé à utiliser le grant '%s'", TokenExchangeGrantType)
+	}
+
+	form := request.GetRequestForm()
+	if requested := form.Get("requested_token_type"); requested != "" && requested != AccessTokenType {
+		return fosite.ErrInvalidRequest.WithHintf("le type de jeton demandé '%s' n'est pas supporté", requested)
+	}
+
+	subject, err := t.validateToken(ctx, form.Get("subject_token"), form.Get("subject_token_type"), "subject_token")
+	if err != nil {
+		return err
+	}
+	subjectSession, ok := subject.GetSession().(*models.Session)
+	if !ok {
+		return fosite.ErrServerError.WithHint("session du subject_token invalide")
+	}
+
+	//partie agissante: le porteur de l'actor_token, sinon le client lui-même
+	act := map[string]any{"sub": client.GetID(), "client_id": client.GetID()}
+	if form.Get("actor_token") != "" {
+		actor, err := t.validateToken(ctx, form.Get("actor_token"), form.Get("actor_token_type"), "actor_token")
+		if err != nil {
+			return err
+		}
+		act = map[string]any{"sub": actor.GetSession().GetSubject(), "client_id": actor.GetClient().GetID()}
+	} else if form.Get("actor_token_type") != "" {
+		return fosite.ErrInvalidRequest.WithHint("'actor_token_type' est fourni sans 'actor_token'")
+	}
+
+	extra := subjectSession.GetExtraClaims()
+	if extra == nil {
+		extra = map[string]any{}
+	}
+	//la liaison à une clé du subject_token n'est pas transmise
+	delete(extra, "cnf")
+	//chaîne de délégation: l'acteur précédent est imbriqué (RFC 8693 section 4.1)
+	if previous, ok := extra["act"]; ok {
+		act["act"] = previous
+	}
+	extra["act"] = act
+
+	//les audiences demandées doivent être autorisées pour le client
+	audiences := fosite.GetAudiences(form)
+	if len(audiences) == 0 {
+		return ErrInvalidTarget.WithHint("le paramètre 'audience' est requis")
+	}
+	allowed := fosite.Arguments{}
+	if c, ok := client.(*models.Client); ok {
+		allowed = c.GetExchangeAudiences()
+	}
+	for _, audience := range audiences {
+		if !allowed.Has(audience) {
+			return ErrInvalidTarget.WithHintf("le client n'est pas autorisé à obtenir un jeton pour l'audience '%s'", audience)
+		}
+	}
+
+	//les scopes ne peuvent que restreindre ceux du subject_token
+	//et doivent être autorisés pour le client qui obtient le jeton
+	scopeStrategy := t.Config.GetScopeStrategy(ctx)
+	scopes := fosite.Arguments(fosite.RemoveEmpty(strings.Split(form.Get("scope"), " ")))
+	if len(scopes) == 0 {
+		//sans scope demandé, le jeton hérite des scopes du subject_token autorisés pour le client
+		for _, scope := range subject.GetGrantedScopes() {
+			if scopeStrategy(client.GetScopes(), scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	for _, scope := range scopes {
+		if !subject.GetGrantedScopes().Has(scope) {
+			return fosite.ErrInvalidScope.WithHintf("le scope '%s' n'est pas accordé au subject_token", scope)
+		}
+		if !scopeStrategy(client.GetScopes(), scope) {
+			return fosite.ErrInvalidScope.WithHintf("le client n'est pas autorisé à demander le scope '%s'", scope)
+		}
+	}
+	extra["scopes"] = scopes
+
+	clientID, err := uuid.Parse(client.GetID())
+	if err != nil {
+		return fosite.ErrServerError.WithWrap(err)
+	}
+	//le sujet est celui de l'utilisateur pour le client qui obtient le jeton (public ou pairwise)
+	sub := subjectSession.Subject
+	if subjectSession.UserID != nil {
+		if sub, err = t.Subjects.SubjectFor(ctx, client, *subjectSession.UserID, subjectSession.Username); err != nil {
+			return fosite.ErrServerError.WithWrap(err)
+		}
+	}
+	session := &models.Session{
+		ID:          uuid.New(),
+		UserID:      subjectSession.UserID,
+		ClientID:    clientID,
+		Username:    subjectSession.Username,
+		Subject:     sub,
+		AuthTime:    subjectSession.AuthTime,
+		RequestedAt: subjectSession.RequestedAt,
+		//le jeton échangé est révoqué avec la session de connexion d'origine
+		LoginSessionID: subjectSession.LoginSessionID,
+	}
+	if err := session.SetExtra(extra); err != nil {
+		return fosite.ErrServerError.WithWrap(err)
+	}
+	session.SetClient(client)
+
+	//le jeton émis n'est pas valide plus longtemps que le subject_token
+	atLifespan := fosite.GetEffectiveLifespan(client, TokenExchangeGrantType, fosite.AccessToken, t.Config.GetAccessTokenLifespan(ctx))
+	expiresAt := time.Now().UTC().Add(atLifespan).Round(time.Second)
+	if subjectExp := subjectSession.GetExpiresAt(fosite.AccessToken); !subjectExp.IsZero() && subjectExp.Before(expiresAt) {
+		expiresAt = subjectExp
+	}
+	session.SetExpiresAt(fosite.AccessToken, expiresAt)
+
+	request.SetSession(session)
+	request.SetRequestedScopes(scopes)
+	request.SetRequestedAudience(audiences)
+	for _, scope := range scopes {
+		request.GrantScope(scope)
+	}
+	for _, audience := range audiences {
+		request.GrantAudience(audience)
+	}
+
+	return nil
+}
+
+// émission du jeton échangé (RFC 8693 section 2.2)
+func (t *TokenExchangeHandler) PopulateTokenEndpointResponse(ctx context.Context, requester fosite.AccessRequester, responder fosite.AccessResponder) error {
+	if !t.CanHandleTokenEndpointRequest(ctx, requester) {
+		return fosite.ErrUnknownRequest
+	}
+
+	atLifespan := time.Until(requester.GetSession().GetExpiresAt(fosite.AccessToken))
+	if _, err := t.IssueAccessToken(ctx, atLifespan, requester, responder); err != nil {
+		return err
+	}
+	responder.SetExtra("issued_token_type", AccessTokenType)
+
+	return nil
+}
+
+func (t *TokenExchangeHandler) CanSkipClientAuth(ctx context.Context, requester fosite.AccessRequester) bool {
+	return false
+}
+
+func (t *TokenExchangeHandler) CanHandleTokenEndpointRequest(ctx context.Context, requester fosite.AccessRequester) bool {
+	return requester.GetGrantTypes().ExactOne(TokenExchangeGrantType)
+}
+
+// validation d'un jeton d'accès présenté à l'échange (subject_token ou actor_token)
+func (t *TokenExchangeHandler) validateToken(ctx context.Context, token string, tokenType string, name string) (fosite.Requester, error) {
+	if token == "" {
+		return nil, fosite.ErrInvalidRequest.WithHintf("le paramètre '%s' est manquant", name)
+	}
+	if tokenType != AccessTokenType {
+		return nil, fosite.ErrInvalidRequest.WithHintf("le type de jeton '%s' n'est pas supporté pour '%s'", tokenType, name)
+	}
+
+	signature := t.AccessTokenStrategy.AccessTokenSignature(ctx, token)
+	request, err := t.AccessTokenStorage.GetAccessTokenSession(ctx, signature, new(models.Session))
+	if errors.Is(err, fosite.ErrNotFound) || errors.Is(err, fosite.ErrInactiveToken) {
+		return nil, fosite.ErrInvalidGrant.WithHintf("le '%s' est invalide ou révoqué", name)
+	} else if err != nil {
+		return nil, fosite.ErrServerError.WithWrap(err)
+	}
+
+	if err := t.AccessTokenStrategy.ValidateAccessToken(ctx, request, token); err != nil {
+		return nil, fosite.ErrInvalidGrant.WithHintf("le '%s' est invalide ou expiré", name).WithWrap(err)
+	}
+
+	return request, nil
+}
@@ -0,0 +1,253 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dylEasydev/go-oauth2-easyclass/db/models"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/ory/fosite"
+	"github.com/ory/fosite/compose"
+	"github.com/ory/fosite/handler/oauth2"
+	"github.com/ory/fosite/storage"
+)
+
+// stockage en mémoire des jetons d'accès pour l'échange
+type memoryExchangeStorage struct {
+	*storage.MemoryStore
+}
+
+func (m *memoryExchangeStorage) SubjectFor(ctx context.Context, client fosite.Client, userID uuid.UUID, username string) (string, error) {
+	return "pairwise-" + username, nil
+}
+
+// révocation des jetons des sessions ouvertes depuis une session de connexion
+// comme la déconnexion (GetLoginSessionSessions puis RevokeSession)
+func (m *memoryExchangeStorage) revokeLoginSession(loginID uuid.UUID) {
+	for signature, request := range m.AccessTokens {
+		session, ok := request.GetSession().(*models.Session)
+		if ok && session.LoginSessionID != nil && *session.LoginSessionID == loginID {
+			delete(m.AccessTokens, signature)
+		}
+	}
+}
+
+type exchangeFixture struct {
+	handler  *TokenExchangeHandler
+	store    *memoryExchangeStorage
+	strategy oauth2.AccessTokenStrategy
+	client   *models.Client
+	loginID  uuid.UUID
+}
+
+func newExchangeFixture(t *testing.T) *exchangeFixture {
+	t.Helper()
+	config := &fosite.Config{
+		GlobalSecret:        []byte("some-super-cool-secret-that-nobody-knows"),
+		AccessTokenLifespan: time.Hour,
+	}
+	store := &memoryExchangeStorage{MemoryStore: storage.NewMemoryStore()}
+	strategy := compose.NewOAuth2HMACStrategy(config)
+	return &exchangeFixture{
+		handler:  TokenExchangeFactory(config, store, strategy).(*TokenExchangeHandler),
+		store:    store,
+		strategy: strategy,
+		client: &models.Client{
+			ID:                uuid.New(),
+			Grants:            pq.StringArray{TokenExchangeGrantType},
+			Scopes:            pq.StringArray{"openid", "profile", "courses"},
+			ExchangeAudiences: pq.StringArray{"https://api.easyclass.test"},
+		},
+		loginID: uuid.New(),
+	}
+}
+
+// émission d'un jeton d'accès de l'utilisateur pour un autre client
+func (f *exchangeFixture) issue(t *testing.T, expiresAt time.Time, scopes []string, extra map[string]any) string {
+	t.Helper()
+	userID := uuid.New()
+	session := &models.Session{
+		ID:             uuid.New(),
+		UserID:         &userID,
+		ClientID:       uuid.New(),
+		Username:       "alice",
+		Subject:        "alice",
+		LoginSessionID: &f.loginID,
+	}
+	if extra != nil {
+		if err := session.SetExtra(extra); err != nil {
+			t.Fatal(err)
+		}
+	}
+	session.SetExpiresAt(fosite.AccessToken, expiresAt)
+
+	request := fosite.NewAccessRequest(session)
+	request.Client = &models.Client{ID: session.ClientID}
+	for _, scope := range scopes {
+		request.GrantScope(scope)
+	}
+	token, signature, err := f.strategy.GenerateAccessToken(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.store.CreateAccessTokenSession(context.Background(), signature, request); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func (f *exchangeFixture) request(subjectToken string, form url.Values) *fosite.AccessRequest {
+	request := fosite.NewAccessRequest(new(models.Session))
+	request.GrantTypes = fosite.Arguments{TokenExchangeGrantType}
+	request.Client = f.client
+	request.Form = url.Values{
+		"subject_token":      {subjectToken},
+		"subject_token_type": {AccessTokenType},
+	}
+	for key, values := range form {
+		request.Form[key] = values
+	}
+	return request
+}
+
+func TestTokenExchangeRejectsInvalidRequests(t *testing.T) {
+	f := newExchangeFixture(t)
+	token := f.issue(t, time.Now().Add(time.Hour), []string{"openid", "profile", "courses", "admin"}, nil)
+
+	cases := []struct {
+		name string
+		form url.Values
+		err  error
+	}{
+		{"audience absente", url.Values{}, ErrInvalidTarget},
+		{"audience non autorisée", url.Values{"audience": {"https://other.test"}}, ErrInvalidTarget},
+		{"scope absent du subject_token", url.Values{"audience": {"https://api.easyclass.test"}, "scope": {"email"}}, fosite.ErrInvalidScope},
+		{"scope non autorisé pour le client", url.Values{"audience": {"https://api.easyclass.test"}, "scope": {"admin"}}, fosite.ErrInvalidScope},
+		{"type de jeton demandé", url.Values{"audience": {"https://api.easyclass.test"}, "requested_token_type": {"urn:ietf:params:oauth:token-type:id_token"}}, fosite.ErrInvalidRequest},
+		{"actor_token_type seul", url.Values{"audience": {"https://api.easyclass.test"}, "actor_token_type": {AccessTokenType}}, fosite.ErrInvalidRequest},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := f.handler.HandleTokenEndpointRequest(context.Background(), f.request(token, tc.form))
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("erreur %v attendue, obtenu %v", tc.err, err)
+			}
+		})
+	}
+
+	if err := f.handler.HandleTokenEndpointRequest(context.Background(), f.request("inconnu", url.Values{"audience": {"https://api.easyclass.test"}})); !errors.Is(err, fosite.ErrInvalidGrant) {
+		t.Fatalf("erreur invalid_grant attendue pour un subject_token inconnu, obtenu %v", err)
+	}
+}
+
+func TestTokenExchangeInheritsAllowedScopes(t *testing.T) {
+	f := newExchangeFixture(t)
+	token := f.issue(t, time.Now().Add(time.Hour), []string{"openid", "courses", "admin"}, nil)
+
+	request := f.request(token, url.Values{"audience": {"https://api.easyclass.test"}})
+	if err := f.handler.HandleTokenEndpointRequest(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+	//le scope admin du subject_token n'est pas autorisé pour le client
+	granted := request.GetGrantedScopes()
+	if len(granted) != 2 || !granted.Has("openid", "courses") {
+		t.Fatalf("scopes accordés = %v", granted)
+	}
+	if audience := request.GetGrantedAudience(); len(audience) != 1 || audience[0] != "https://api.easyclass.test" {
+		t.Fatalf("audience accordée = %v", audience)
+	}
+}
+
+func TestTokenExchangeCapsExpiry(t *testing.T) {
+	f := newExchangeFixture(t)
+	cases := []struct {
+		name     string
+		subject  time.Duration
+		expected time.Duration
+	}{
+		{"subject_token plus court", 10 * time.Minute, 10 * time.Minute},
+		{"durée du client", 3 * time.Hour, time.Hour},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			subjectExp := time.Now().UTC().Add(tc.subject).Round(time.Second)
+			request := f.request(f.issue(t, subjectExp, []string{"openid"}, nil), url.Values{"audience": {"https://api.easyclass.test"}})
+			if err := f.handler.HandleTokenEndpointRequest(context.Background(), request); err != nil {
+				t.Fatal(err)
+			}
+			exp := request.GetSession().GetExpiresAt(fosite.AccessToken)
+			if diff := time.Until(exp) - tc.expected; diff > 2*time.Second || diff < -2*time.Second {
+				t.Fatalf("expiration dans %v, attendu %v", time.Until(exp), tc.expected)
+			}
+			if exp.After(subjectExp) {
+				t.Fatalf("le jeton échangé expire après le subject_token: %v > %v", exp, subjectExp)
+			}
+		})
+	}
+}
+
+func TestTokenExchangeNestsActorClaim(t *testing.T) {
+	f := newExchangeFixture(t)
+	previous := map[string]any{"sub": "gateway", "client_id": "gateway"}
+	token := f.issue(t, time.Now().Add(time.Hour), []string{"openid"}, map[string]any{
+		"act": previous,
+		"cnf": map[string]any{"jkt": "thumbprint"},
+	})
+
+	request := f.request(token, url.Values{"audience": {"https://api.easyclass.test"}})
+	if err := f.handler.HandleTokenEndpointRequest(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+	session := request.GetSession().(*models.Session)
+	extra := session.GetExtraClaims()
+	act, ok := extra["act"].(map[string]any)
+	if !ok || act["sub"] != f.client.ID.String() || act["client_id"] != f.client.ID.String() {
+		t.Fatalf("act = %v", extra["act"])
+	}
+	nested, ok := act["act"].(map[string]any)
+	if !ok || nested["sub"] != "gateway" {
+		t.Fatalf("acteur précédent non imbriqué: %v", act["act"])
+	}
+	if _, ok := extra["cnf"]; ok {
+		t.Error("la liaison du subject_token ne doit pas être transmise")
+	}
+	if session.Subject != "pairwise-alice" {
+		t.Errorf("sub = %q", session.Subject)
+	}
+}
+
+func TestTokenExchangeRevokedWithLoginSession(t *testing.T) {
+	f := newExchangeFixture(t)
+	token := f.issue(t, time.Now().Add(time.Hour), []string{"openid"}, nil)
+
+	ctx := context.Background()
+	request := f.request(token, url.Values{"audience": {"https://api.easyclass.test"}})
+	if err := f.handler.HandleTokenEndpointRequest(ctx, request); err != nil {
+		t.Fatal(err)
+	}
+	session := request.GetSession().(*models.Session)
+	if session.LoginSessionID == nil || *session.LoginSessionID != f.loginID {
+		t.Fatalf("session de connexion non reprise: %v", session.LoginSessionID)
+	}
+
+	response := fosite.NewAccessResponse()
+	if err := f.handler.PopulateTokenEndpointResponse(ctx, request, response); err != nil {
+		t.Fatal(err)
+	}
+	if response.GetExtra("issued_token_type") != AccessTokenType {
+		t.Fatalf("issued_token_type = %v", response.GetExtra("issued_token_type"))
+	}
+	exchanged := response.GetAccessToken()
+	if _, err := f.handler.validateToken(ctx, exchanged, AccessTokenType, "subject_token"); err != nil {
+		t.Fatalf("jeton échangé invalide: %v", err)
+	}
+
+	f.store.revokeLoginSession(f.loginID)
+	if _, err := f.handler.validateToken(ctx, exchanged, AccessTokenType, "subject_token"); !errors.Is(err, fosite.ErrInvalidGrant) {
+		t.Fatalf("le jeton échangé doit être révoqué avec la session de connexion, obtenu %v", err)
+	}
+}
@@ -0,0 +1,26 @@
+package router
+
+import (
+	"github.com/dylEasydev/go-oauth2-easyclass/middleware"
+)
+
+// end-points d'administration
+// à initialiser après OIDCRouter
+func (r *router) AdminRouter() {
+	if r.Provider == nil {
+		panic("le fournisseur OIDC doit être initialisé avant les end-points d'administration")
+	}
+	teacherGroup := r.Server.Group("/admin/teachers", middleware.AuthMiddleware(r.Provider.Keys.PublicKey, r.Store.CheckAccessToken), middleware.ScopeMiddleware("validated:teacher", "admin.*"))
+
+	{
+		teacherGroup.GET("", r.StoreRequest.ListWaitingTeachers)
+		teacherGroup.POST("/:id/approve", r.StoreRequest.ApproveTeacher)
+		teacherGroup.POST("/:id/reject", r.StoreRequest.RejectTeacher)
+	}
+
+	userGroup := r.Server.Group("/admin/users", middleware.AuthMiddleware(r.Provider.Keys.PublicKey, r.Store.CheckAccessToken), middleware.ScopeMiddleware("admin.*"))
+
+	{
+		userGroup.POST("/:id/export", r.StoreRequest.AdminDataExport)
+	}
+}
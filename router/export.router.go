@@ -0,0 +1,11 @@
+package router
+
+import (
+	"github.com/dylEasydev/go-oauth2-easyclass/controller"
+)
+
+// téléchargement des exports de données personnelles
+// le jeton du lien suffit, sans jeton d'accès
+func (r *router) ExportRouter() {
+	r.Server.GET(controller.ExportDownloadPath, r.StoreRequest.DownloadDataExport)
+}
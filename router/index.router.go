@@ -3,6 +3,7 @@ package router
 import (
 	"github.com/dylEasydev/go-oauth2-easyclass/controller"
 	"github.com/dylEasydev/go-oauth2-easyclass/db"
+	"github.com/dylEasydev/go-oauth2-easyclass/provider"
 	"github.com/gin-gonic/gin"
 )
 
@@ -10,6 +11,10 @@ type router struct {
 	Server       *gin.Engine
 	Store        *db.Store
 	StoreRequest *controller.StoreRequest
+	Provider     *provider.Provider
+
+	//chemins des end-points publiés dans le document de découverte
+	Endpoints map[string]string
 }
 
 func NewRouter(server *gin.Engine, store *db.Store) *router {
@@ -19,6 +24,7 @@ func NewRouter(server *gin.Engine, store *db.Store) *router {
 		StoreRequest: &controller.StoreRequest{
 			Store: store,
 		},
+		Endpoints: make(map[string]string),
 	}
 }
 
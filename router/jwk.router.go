@@ -2,13 +2,21 @@ package router
 
 import (
 	"github.com/dylEasydev/go-oauth2-easyclass/controller"
+	"github.com/dylEasydev/go-oauth2-easyclass/provider"
 )
 
+// à initialiser après OIDCRouter
 func (r *router) JWKRouter() {
+	if r.Provider == nil {
+		panic("le fournisseur OIDC doit être initialisé avant le JWKS")
+	}
+	discovery := controller.NewDiscovery(r.Provider, r.Endpoints)
 	jwkGroup := r.Server.Group("/keys")
 
 	{
 		jwkGroup.GET("/clients/jwks/:id", r.StoreRequest.ClientJWKHanler)
-		jwkGroup.GET("/jwks.json", controller.JWKHandler)
+		jwkGroup.GET("/jwks.json", discovery.JWKHandler)
 	}
+
+	r.Endpoints[provider.JWKSURI] = jwkGroup.BasePath() + "/jwks.json"
 }
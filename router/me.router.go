@@ -0,0 +1,38 @@
+package router
+
+import (
+	"github.com/dylEasydev/go-oauth2-easyclass/controller"
+	"github.com/dylEasydev/go-oauth2-easyclass/middleware"
+	"github.com/dylEasydev/go-oauth2-easyclass/utils"
+)
+
+// end-points de l'utilisateur connecté
+// à initialiser après OIDCRouter
+func (r *router) MeRouter() {
+	if r.Provider == nil {
+		panic("le fournisseur OIDC doit être initialisé avant les end-points de l'utilisateur")
+	}
+	//jetons Bearer ou liés par DPoP
+	meGroup := r.Server.Group("/me", middleware.DPoPMiddleware(r.Provider.Keys.PublicKey, r.Store.CheckAccessToken, r.Store.MarkDPoPProofUsed, utils.URL_Host))
+
+	{
+		meGroup.GET("", middleware.ScopeMiddleware("openid", "profile"), r.StoreRequest.GetProfile)
+		meGroup.POST("/export", r.StoreRequest.RequestDataExport)
+	}
+
+	//modification du profil et des consentements avec le scope updated:profil
+	profilGroup := meGroup.Group("", middleware.ScopeMiddleware("updated:profil"))
+
+	{
+		profilGroup.PATCH("/name", r.StoreRequest.UpdateUserName)
+		profilGroup.PATCH("/password", r.StoreRequest.ChangePassword)
+		profilGroup.POST("/email", r.StoreRequest.ChangeEmail)
+		profilGroup.POST("/email/verify", r.StoreRequest.ConfirmEmail)
+		profilGroup.GET("/consents", r.StoreRequest.ListConsents)
+		profilGroup.DELETE("/consents/:id", r.StoreRequest.RevokeConsent)
+	}
+
+	//les sessions sont terminées par le fournisseur avant la suppression
+	auth := controller.NewAuth(r.Provider, r.Store)
+	meGroup.DELETE("", middleware.ScopeMiddleware("deleted:profil"), auth.DeleteAccount)
+}
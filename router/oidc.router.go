@@ -1,6 +1,7 @@
 package router
 
 import (
+	"context"
 	"log"
 
 	"github.com/dylEasydev/go-oauth2-easyclass/controller"
@@ -14,8 +15,18 @@ func (r *router) OIDCRouter() {
 		log.Print(err)
 		panic("impossible de lire les clé de signature")
 	}
-	provider := provider.InitProvider(r.Store, privateKey)
-	auth := controller.NewAuth(provider, r.Store)
+	r.Provider, err = provider.InitProvider(r.Store, privateKey)
+	if err != nil {
+		log.Print(err)
+		panic("impossible d'initialiser les clés de signature")
+	}
+	go r.Provider.Keys.StartRotation(context.Background(), provider.KeyRotationInterval)
+	go r.Provider.Backchannel.StartRetry(context.Background(), provider.BackchannelRetryInterval)
+	if r.Provider.CIBA != nil {
+		go r.Provider.CIBA.StartPingRetry(context.Background(), provider.CIBAPingRetryInterval)
+	}
+
+	auth := controller.NewAuth(r.Provider, r.Store)
 	oidcGroup := r.Server.Group("/oidc")
 
 	{
@@ -25,5 +36,27 @@ func (r *router) OIDCRouter() {
 		oidcGroup.POST("/revoke", auth.RevokeHandler)
 		oidcGroup.POST("/par", auth.PARRequestHandler)
 		oidcGroup.POST("/introspect", auth.IntrospectionHandler)
+		oidcGroup.GET("/userinfo", auth.UserInfoHandler)
+		oidcGroup.POST("/userinfo", auth.UserInfoHandler)
+		oidcGroup.GET("/logout", auth.LogoutHandler)
+		oidcGroup.POST("/logout", auth.LogoutHandler)
+		oidcGroup.POST("/device/code", auth.DeviceCodeHandler)
+		oidcGroup.GET("/device", auth.DeviceVerificationHandler)
+		oidcGroup.POST("/device", auth.DeviceVerificationHandler)
+		oidcGroup.POST("/bc-authorize", auth.BackchannelAuthHandler)
+		oidcGroup.GET("/bc-authorize/approve", auth.BackchannelApprovalHandler)
+		oidcGroup.POST("/bc-authorize/approve", auth.BackchannelApprovalHandler)
 	}
+
+	r.Endpoints[provider.AuthorizationEndpoint] = oidcGroup.BasePath() + "/authorize"
+	r.Endpoints[provider.TokenEndpoint] = oidcGroup.BasePath() + "/token"
+	r.Endpoints[provider.RevocationEndpoint] = oidcGroup.BasePath() + "/revoke"
+	r.Endpoints[provider.PAREndpoint] = oidcGroup.BasePath() + "/par"
+	r.Endpoints[provider.IntrospectionEndpoint] = oidcGroup.BasePath() + "/introspect"
+	r.Endpoints[provider.UserinfoEndpoint] = oidcGroup.BasePath() + "/userinfo"
+	r.Endpoints[provider.EndSessionEndpoint] = oidcGroup.BasePath() + "/logout"
+	r.Endpoints[provider.DeviceEndpoint] = oidcGroup.BasePath() + "/device/code"
+	r.Provider.DeviceVerificationURI = utils.URL_Host + oidcGroup.BasePath() + "/device"
+	r.Endpoints[provider.CIBAEndpoint] = oidcGroup.BasePath() + "/bc-authorize"
+	r.Provider.CIBAApprovalURI = utils.URL_Host + oidcGroup.BasePath() + "/bc-authorize/approve"
 }
@@ -0,0 +1,20 @@
+package router
+
+import (
+	"github.com/dylEasydev/go-oauth2-easyclass/controller"
+)
+
+// end-points de réinitialisation du mot de passe
+// à initialiser après OIDCRouter
+func (r *router) PasswordRouter() {
+	if r.Provider == nil {
+		panic("le fournisseur OIDC doit être initialisé avant les end-points de réinitialisation")
+	}
+	auth := controller.NewAuth(r.Provider, r.Store)
+	passwordGroup := r.Server.Group("/password")
+
+	{
+		passwordGroup.POST("/forgot", auth.ForgotPasswordHandler)
+		passwordGroup.POST("/reset", auth.ResetPasswordHandler)
+	}
+}
@@ -2,19 +2,18 @@ package router
 
 import (
 	"github.com/dylEasydev/go-oauth2-easyclass/middleware"
-	"github.com/dylEasydev/go-oauth2-easyclass/utils"
 )
 
+// à initialiser après OIDCRouter
 func (r *router) SignRouter() {
-	signGroup := r.Server.Group("/sign")
-	publicKey, err := utils.LoadPublicKey("public")
-	if err != nil {
-		panic("impossible de lire la clé public")
+	if r.Provider == nil {
+		panic("le fournisseur OIDC doit être initialisé avant les end-points d'inscription")
 	}
+	signGroup := r.Server.Group("/sign")
 
 	{
 		signGroup.POST("/teacher", r.StoreRequest.SignTeacher)
 		signGroup.POST("/student", r.StoreRequest.SignStudent)
-		signGroup.POST("/admin", middleware.AuthMiddleware(publicKey), r.StoreRequest.SignStudent)
+		signGroup.POST("/admin", middleware.AuthMiddleware(r.Provider.Keys.PublicKey, r.Store.CheckAccessToken), r.StoreRequest.SignStudent)
 	}
 }
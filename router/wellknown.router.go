@@ -0,0 +1,18 @@
+package router
+
+import "github.com/dylEasydev/go-oauth2-easyclass/controller"
+
+// end-points de découverte
+// à initialiser après OIDCRouter et JWKRouter
+func (r *router) WellKnownRouter() {
+	if r.Provider == nil {
+		panic("le fournisseur OIDC doit être initialisé avant les end-points de découverte")
+	}
+	discovery := controller.NewDiscovery(r.Provider, r.Endpoints)
+	wellKnownGroup := r.Server.Group("/.well-known")
+
+	{
+		wellKnownGroup.GET("/openid-configuration", discovery.OpenIDConfigurationHandler)
+		wellKnownGroup.GET("/oauth-authorization-server", discovery.AuthorizationServerHandler)
+	}
+}
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// usages des clés dérivées du secret global du serveur
+const (
+	encryptionKeyInfo = "easyclass enc"
+	macKeyInfo        = "easyclass mac"
+)
+
+// clé de 256 bits dérivée (HKDF-SHA256) du secret global du serveur
+// une clé distincte par usage: chiffrement AES-GCM et signature HMAC
+func deriveKey(info string) []byte {
+	key, err := hkdf.Key(sha256.New, []byte(os.Getenv("SECRET")), nil, info, 32)
+	if err != nil {
+		panic(fmt.Sprintf("erreur de dérivation de la clé %s: %v", info, err))
+	}
+	return key
+}
+
+// ancienne clé de chiffrement (hash du secret), conservée pour
+// déchiffrer les données chiffrées avant la dérivation des clés
+func legacyCipherKey() []byte {
+	key := sha256.Sum256([]byte(os.Getenv("SECRET")))
+	return key[:]
+}
+
+// chiffrement AES-GCM (nonce préfixé au chiffré)
+func Encrypt(plain []byte) ([]byte, error) {
+	block, err := aes.NewCipher(deriveKey(encryptionKeyInfo))
+	if err != nil {
+		return nil, fmt.Errorf("erreur de chiffrement: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("erreur de chiffrement: %w", err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("erreur de chiffrement: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+// déchiffrement AES-GCM d'une donnée produite par Encrypt
+func Decrypt(data []byte) ([]byte, error) {
+	plain, err := decryptWith(deriveKey(encryptionKeyInfo), data)
+	if err != nil {
+		if legacy, legacyErr := decryptWith(legacyCipherKey(), data); legacyErr == nil {
+			return legacy, nil
+		}
+		return nil, err
+	}
+	return plain, nil
+}
+
+func decryptWith(key []byte, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("erreur de déchiffrement: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("erreur de déchiffrement: %w", err)
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("erreur de déchiffrement: donnée trop courte")
+	}
+	nonce, sealed := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("erreur de déchiffrement: %w", err)
+	}
+	return plain, nil
+}
+
+// encodage base64url sans padding
+func Base64URL(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// signature HMAC-SHA256 d'une valeur (cookies, liens)
+// au format valeur.signature
+func SignValue(value string) string {
+	mac := hmac.New(sha256.New, deriveKey(macKeyInfo))
+	mac.Write([]byte(value))
+	return value + "." + Base64URL(mac.Sum(nil))
+}
+
+// vérification d'une valeur signée par SignValue
+func VerifySignedValue(signed string) (string, bool) {
+	index := strings.LastIndex(signed, ".")
+	if index < 0 {
+		return "", false
+	}
+	value := signed[:index]
+	if !hmac.Equal([]byte(SignValue(value)), []byte(signed)) {
+		return "", false
+	}
+	return value, true
+}
@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+)
+
+const (
+	//type JWT d'une preuve DPoP
+	DPoPProofType = "dpop+jwt"
+	//âge maximal d'une preuve DPoP (iat)
+	DPoPProofMaxAge = 5 * time.Minute
+	//tolérance d'horloge pour une preuve émise dans le futur
+	dpopClockSkew = 30 * time.Second
+)
+
+// algorithmes acceptés pour signer les preuves DPoP
+var DPoPSigningAlgorithms = []string{"RS256", "ES256", "EdDSA"}
+
+// preuve DPoP vérifiée (RFC 9449 section 4.2)
+type DPoPProof struct {
+	JTI string `json:"jti"`
+	HTM string `json:"htm"`
+	HTU string `json:"htu"`
+	IAT int64  `json:"iat"`
+	ATH string `json:"ath,omitempty"`
+
+	//thumbprint SHA-256 de la clé public de la preuve (cnf.jkt)
+	JKT string `json:"-"`
+}
+
+// date jusqu'à laquelle le jti de la preuve doit être retenu contre le rejeu
+func (p *DPoPProof) ReplayUntil() time.Time {
+	return time.Unix(p.IAT, 0).UTC().Add(DPoPProofMaxAge)
+}
+
+// vérification d'une preuve DPoP (RFC 9449 section 4.3)
+// method et uri sont ceux de la requête, accessToken est vide au token end-point
+// le rejeu du jti est vérifié par l'appelant
+func ParseDPoPProof(proof string, method string, uri string, accessToken string) (*DPoPProof, error) {
+	jws, err := jose.ParseSigned(proof)
+	if err != nil {
+		return nil, fmt.Errorf("preuve DPoP mal formée")
+	}
+	if len(jws.Signatures) != 1 {
+		return nil, fmt.Errorf("la preuve DPoP doit avoir une seule signature")
+	}
+
+	header := jws.Signatures[0].Protected
+	if typ, _ := header.ExtraHeaders[jose.HeaderType].(string); typ != DPoPProofType {
+		return nil, fmt.Errorf("le type de la preuve DPoP doit être %s", DPoPProofType)
+	}
+	if !slices.Contains(DPoPSigningAlgorithms, header.Algorithm) {
+		return nil, fmt.Errorf("algorithme de preuve DPoP non supporté: %s", header.Algorithm)
+	}
+	if header.JSONWebKey == nil || !header.JSONWebKey.IsPublic() || !header.JSONWebKey.Valid() {
+		return nil, fmt.Errorf("la preuve DPoP doit contenir une clé public jwk")
+	}
+
+	payload, err := jws.Verify(header.JSONWebKey)
+	if err != nil {
+		return nil, fmt.Errorf("signature de la preuve DPoP invalide")
+	}
+
+	claims := &DPoPProof{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, fmt.Errorf("claims de la preuve DPoP invalides")
+	}
+	if claims.JTI == "" {
+		return nil, fmt.Errorf("la preuve DPoP doit contenir un jti")
+	}
+	if claims.HTM != method {
+		return nil, fmt.Errorf("la méthode htm de la preuve DPoP ne correspond pas à la requête")
+	}
+	if !sameHTU(claims.HTU, uri) {
+		return nil, fmt.Errorf("l'uri htu de la preuve DPoP ne correspond pas à la requête")
+	}
+
+	now := time.Now().UTC()
+	iat := time.Unix(claims.IAT, 0).UTC()
+	if claims.IAT == 0 || iat.After(now.Add(dpopClockSkew)) || iat.Add(DPoPProofMaxAge).Before(now) {
+		return nil, fmt.Errorf("la date iat de la preuve DPoP est hors de la fenêtre acceptée")
+	}
+
+	//la preuve présentée avec un jeton d'accès contient son hash
+	if accessToken != "" {
+		hash := sha256.Sum256([]byte(accessToken))
+		if claims.ATH != Base64URL(hash[:]) {
+			return nil, fmt.Errorf("le hash ath de la preuve DPoP ne correspond pas au jeton d'accès")
+		}
+	}
+
+	thumbprint, err := header.JSONWebKey.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("thumbprint de la clé DPoP impossible: %w", err)
+	}
+	claims.JKT = Base64URL(thumbprint)
+
+	return claims, nil
+}
+
+// comparaison de htu sans la query ni le fragment (RFC 9449 section 4.3)
+func sameHTU(htu string, uri string) bool {
+	a, errA := url.Parse(htu)
+	b, errB := url.Parse(uri)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Host, b.Host) && a.EscapedPath() == b.EscapedPath()
+}
@@ -40,3 +40,12 @@ func CompareHash(code, hashed string) bool {
 
 	return hmac.Equal(a, b)
 }
+
+// génération d'un jeton aléatoire encodé en base64url
+func GenerateToken(size int) (string, error) {
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return Base64URL(data), nil
+}
@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"slices"
+	"strings"
+)
+
+// langues des pages servies par le serveur (la première est celle par défaut)
+var Languages = []string{"fr", "en"}
+
+// choix de la langue à partir de ui_locales (OIDC) puis de l'entête Accept-Language
+func PreferredLanguage(uiLocales string, acceptLanguage string) string {
+	candidates := strings.Fields(uiLocales)
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		candidates = append(candidates, strings.TrimSpace(strings.Split(part, ";")[0]))
+	}
+
+	for _, candidate := range candidates {
+		lang := strings.ToLower(strings.Split(candidate, "-")[0])
+		if slices.Contains(Languages, lang) {
+			return lang
+		}
+	}
+	return Languages[0]
+}
+
+// lecture des textes d'une langue dans ressources/locales/
+func ReadLocale(lang string) (map[string]string, error) {
+	if !slices.Contains(Languages, lang) {
+		lang = Languages[0]
+	}
+	data, err := ReadJSON[map[string]string]("locales/" + lang)
+	if err != nil {
+		return nil, err
+	}
+	return *data, nil
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"html"
 	"os"
 	"time"
 
@@ -58,3 +59,205 @@ func SendVerificationCode(dest string, code string, name string) error {
 
 	return d.DialAndSend(m)
 }
+
+// SendApprovalRequest envoie par Gmail le lien d'approbation d'une demande de connexion
+// initiée par une application (CIBA)
+func SendApprovalRequest(dest string, name string, client string, bindingMessage string, link string, expiredAt time.Time) error {
+	from := os.Getenv("COMPANING_MAIl")
+	appPassword := os.Getenv("PASSWORD_MAIL")
+	smtpHost := "smtp.gmail.com"
+	smtpPort := 587
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", from)
+	m.SetHeader("To", dest)
+	m.SetHeader("Subject", "Demande de connexion à votre compte")
+
+	plain := fmt.Sprintf("Bonjour,\n\n%s demande à se connecter à votre compte.\n\nMessage : %s\n\nApprouvez ou refusez la demande : %s\n\nCe lien expirera : %s.\n", client, bindingMessage, link, expiredAt.UTC().Format("15h04"))
+
+	content := fmt.Sprintf(`
+	<!doctype html>
+	<html lang="fr">
+	<head>
+	  <meta charset="utf-8">
+	  <style>
+	    body { font-family: Arial, sans-serif; background:#f9f9f9; padding:20px; }
+	    .box { max-width:500px; margin:0 auto; background:white; padding:20px; border-radius:8px; box-shadow:0 2px 8px rgba(0,0,0,0.1);}
+	    h1 { color:#333; font-size:20px; }
+	    .code { font-size:20px; font-weight:bold; color:#2d89ef; margin:20px 0; }
+	    .button { display:inline-block; padding:10px 20px; background:#2d89ef; color:white; border-radius:4px; text-decoration:none; }
+	    p { color:#555; font-size:14px; }
+	  </style>
+	</head>
+	<body>
+	  <div class="box">
+	    <h1>Demande de connexion</h1>
+	    <p>Salut ,%s</p>
+	    <p>%s demande à se connecter à votre compte.</p>
+	    <div class="code">%s</div>
+	    <p><a class="button" href="%s">Approuver ou refuser</a></p>
+	    <p>Ce lien est valable jusqu'à %s  GMT.</p>
+	    <p style="margin-top:20px; font-size:12px; color:#888;">&copy; 2026 easy class</p>
+	  </div>
+	</body>
+	</html>`,
+		html.EscapeString(name), html.EscapeString(client), html.EscapeString(bindingMessage), html.EscapeString(link), expiredAt.UTC().Format("15h04"))
+
+	m.SetBody("text/plain", plain)
+	m.AddAlternative("text/html", content)
+
+	d := gomail.NewDialer(smtpHost, smtpPort, from, appPassword)
+
+	return d.DialAndSend(m)
+}
+
+// SendTeacherDecision informe par Gmail l'enseignant de la validation ou du refus de son compte
+func SendTeacherDecision(dest string, name string, subject string, approved bool, reason string) error {
+	from := os.Getenv("COMPANING_MAIl")
+	appPassword := os.Getenv("PASSWORD_MAIL")
+	smtpHost := "smtp.gmail.com"
+	smtpPort := 587
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", from)
+	m.SetHeader("To", dest)
+
+	var title, plain, message string
+	if approved {
+		title = "Votre compte enseignant est validé"
+		plain = fmt.Sprintf("Bonjour,\n\nVotre compte enseignant pour la matière %s a été validé.\n\nVous pouvez désormais vous connecter.\n", subject)
+		message = fmt.Sprintf("Votre compte enseignant pour la matière %s a été validé. Vous pouvez désormais vous connecter.", html.EscapeString(subject))
+	} else {
+		title = "Votre compte enseignant a été refusé"
+		plain = fmt.Sprintf("Bonjour,\n\nVotre demande de compte enseignant pour la matière %s a été refusée.\n\nMotif : %s\n", subject, reason)
+		message = fmt.Sprintf("Votre demande de compte enseignant pour la matière %s a été refusée.<br>Motif : %s", html.EscapeString(subject), html.EscapeString(reason))
+	}
+	m.SetHeader("Subject", title)
+
+	content := fmt.Sprintf(`
+	<!doctype html>
+	<html lang="fr">
+	<head>
+	  <meta charset="utf-8">
+	  <style>
+	    body { font-family: Arial, sans-serif; background:#f9f9f9; padding:20px; }
+	    .box { max-width:500px; margin:0 auto; background:white; padding:20px; border-radius:8px; box-shadow:0 2px 8px rgba(0,0,0,0.1);}
+	    h1 { color:#333; font-size:20px; }
+	    p { color:#555; font-size:14px; }
+	  </style>
+	</head>
+	<body>
+	  <div class="box">
+	    <h1>%s</h1>
+	    <p>Salut ,%s</p>
+	    <p>%s</p>
+	    <p style="margin-top:20px; font-size:12px; color:#888;">&copy; 2026 easy class</p>
+	  </div>
+	</body>
+	</html>`,
+		title, html.EscapeString(name), message)
+
+	m.SetBody("text/plain", plain)
+	m.AddAlternative("text/html", content)
+
+	d := gomail.NewDialer(smtpHost, smtpPort, from, appPassword)
+
+	return d.DialAndSend(m)
+}
+
+// SendPasswordReset envoie par Gmail le jeton de réinitialisation du mot de passe
+func SendPasswordReset(dest string, name string, token string, expiredAt time.Time) error {
+	from := os.Getenv("COMPANING_MAIl")
+	appPassword := os.Getenv("PASSWORD_MAIL")
+	smtpHost := "smtp.gmail.com"
+	smtpPort := 587
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", from)
+	m.SetHeader("To", dest)
+	m.SetHeader("Subject", "Réinitialisation de votre mot de passe")
+
+	plain := fmt.Sprintf("Bonjour,\n\nVotre jeton de réinitialisation du mot de passe est : %s\n\nCe jeton expirera : %s.\n\nSi vous n'êtes pas à l'origine de cette demande, ignorez ce message.\n", token, expiredAt.UTC().Format("15h04"))
+
+	content := fmt.Sprintf(`
+	<!doctype html>
+	<html lang="fr">
+	<head>
+	  <meta charset="utf-8">
+	  <style>
+	    body { font-family: Arial, sans-serif; background:#f9f9f9; padding:20px; }
+	    .box { max-width:500px; margin:0 auto; background:white; padding:20px; border-radius:8px; box-shadow:0 2px 8px rgba(0,0,0,0.1);}
+	    h1 { color:#333; font-size:20px; }
+	    .code { font-size:14px; font-weight:bold; color:#2d89ef; word-break:break-all; margin:20px 0; }
+	    p { color:#555; font-size:14px; }
+	  </style>
+	</head>
+	<body>
+	  <div class="box">
+	    <h1>Réinitialisation du mot de passe</h1>
+	    <p>Salut ,%s</p>
+	    <p>Voici votre jeton de réinitialisation :</p>
+	    <div class="code">%s</div>
+	    <p>Ce jeton est valable jusqu'à %s  GMT.</p>
+	    <p>Si vous n'êtes pas à l'origine de cette demande, ignorez ce message.</p>
+	    <p style="margin-top:20px; font-size:12px; color:#888;">&copy; 2026 easy class</p>
+	  </div>
+	</body>
+	</html>`,
+		html.EscapeString(name), html.EscapeString(token), expiredAt.UTC().Format("15h04"))
+
+	m.SetBody("text/plain", plain)
+	m.AddAlternative("text/html", content)
+
+	d := gomail.NewDialer(smtpHost, smtpPort, from, appPassword)
+
+	return d.DialAndSend(m)
+}
+
+// SendDataExport envoie par Gmail le lien de téléchargement de l'export des données personnelles
+func SendDataExport(dest string, name string, link string, expiredAt time.Time) error {
+	from := os.Getenv("COMPANING_MAIl")
+	appPassword := os.Getenv("PASSWORD_MAIL")
+	smtpHost := "smtp.gmail.com"
+	smtpPort := 587
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", from)
+	m.SetHeader("To", dest)
+	m.SetHeader("Subject", "Export de vos données personnelles")
+
+	plain := fmt.Sprintf("Bonjour,\n\nL'export de vos données personnelles est prêt.\n\nTéléchargez-le : %s\n\nCe lien expirera : %s.\n", link, expiredAt.UTC().Format("02/01/2006 15h04"))
+
+	content := fmt.Sprintf(`
+	<!doctype html>
+	<html lang="fr">
+	<head>
+	  <meta charset="utf-8">
+	  <style>
+	    body { font-family: Arial, sans-serif; background:#f9f9f9; padding:20px; }
+	    .box { max-width:500px; margin:0 auto; background:white; padding:20px; border-radius:8px; box-shadow:0 2px 8px rgba(0,0,0,0.1);}
+	    h1 { color:#333; font-size:20px; }
+	    .button { display:inline-block; padding:10px 20px; background:#2d89ef; color:white; border-radius:4px; text-decoration:none; }
+	    p { color:#555; font-size:14px; }
+	  </style>
+	</head>
+	<body>
+	  <div class="box">
+	    <h1>Export de vos données</h1>
+	    <p>Salut ,%s</p>
+	    <p>L'export de vos données personnelles est prêt.</p>
+	    <p><a class="button" href="%s">Télécharger l'export</a></p>
+	    <p>Ce lien est valable jusqu'au %s  GMT.</p>
+	    <p style="margin-top:20px; font-size:12px; color:#888;">&copy; 2026 easy class</p>
+	  </div>
+	</body>
+	</html>`,
+		html.EscapeString(name), html.EscapeString(link), expiredAt.UTC().Format("02/01/2006 15h04"))
+
+	m.SetBody("text/plain", plain)
+	m.AddAlternative("text/html", content)
+
+	d := gomail.NewDialer(smtpHost, smtpPort, from, appPassword)
+
+	return d.DialAndSend(m)
+}
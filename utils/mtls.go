@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path"
+)
+
+// url du listener mTLS (MTLS_URL, ex: https://127.0.0.1:3002), vide s'il n'y en a pas
+func MTLSHost() string {
+	return os.Getenv("MTLS_URL")
+}
+
+// thumbprint SHA-256 d'un certificat client (cnf.x5t#S256, RFC 8705 section 3.1)
+func CertificateThumbprint(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.Raw)
+	return Base64URL(hash[:])
+}
+
+// lecture des autorités de certification des clients (PEM)
+func LoadCertPool(fileName string) (*x509.CertPool, error) {
+	baseDir, _ := os.Getwd()
+	fullPath := path.Join(baseDir, "key/", fileName+".pem")
+	data, err := os.ReadFile(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("erreur ouverture fichier: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("aucun certificat valide dans %s", fileName)
+	}
+	return pool, nil
+}
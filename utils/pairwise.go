@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"os"
+)
+
+// sel du serveur pour les sujets pairwise
+// PAIRWISE_SALT, sinon dérivé du secret global
+func pairwiseSalt() []byte {
+	if salt := os.Getenv("PAIRWISE_SALT"); salt != "" {
+		return []byte(salt)
+	}
+	salt := sha256.Sum256([]byte("pairwise:" + os.Getenv("SECRET")))
+	return salt[:]
+}
+
+// identifiant pairwise stable d'un utilisateur pour un secteur (OIDC Core section 8.1)
+// deux clients d'un même secteur obtiennent le même sub, deux secteurs des sub non corrélables
+func PairwiseSubject(sector string, userID string) string {
+	mac := hmac.New(sha256.New, pairwiseSalt())
+	mac.Write([]byte(sector))
+	mac.Write([]byte{0})
+	mac.Write([]byte(userID))
+	return Base64URL(mac.Sum(nil))
+}
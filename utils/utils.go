@@ -1,21 +1,44 @@
 package utils
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
 	"os"
 	"path"
+	"slices"
+	"strings"
 )
 
+// scopes standards OpenID Connect accordés sans permission de rôle
+var OIDCScopes = []string{"openid", "profile", "email"}
+
 func PtrBool(val bool) *bool {
 	return &val
 }
 
+// ensemble de scopes normalisé (trié, sans doublon ni valeur vide)
+// utilisé comme clé des consentements
+func ScopeSet(scopes []string) string {
+	set := []string{}
+	for _, scope := range scopes {
+		if scope != "" && !slices.Contains(set, scope) {
+			set = append(set, scope)
+		}
+	}
+	slices.Sort(set)
+	return strings.Join(set, " ")
+}
+
 // intersection des scopes de client et d'utilisateur
 func IntersectScopes(clientScopes, userScopes []string) []string {
-	result := make([]string, len(clientScopes))
+	result := make([]string, 0, len(clientScopes))
 	setScopes := make(map[string]bool, len(userScopes))
 
 	for _, scope := range userScopes {
@@ -23,15 +46,15 @@ func IntersectScopes(clientScopes, userScopes []string) []string {
 	}
 
 	for _, scope := range clientScopes {
-		if scope == "openid" || setScopes[scope] {
+		if slices.Contains(OIDCScopes, scope) || setScopes[scope] {
 			result = append(result, scope)
 		}
 	}
 	return result
 }
 
-// lecture de clé privé RSA
-func LoadPrivateKey(fileName string) (*rsa.PrivateKey, error) {
+// lecture de clé privé PKCS8 (RSA, ECDSA P-256 ou Ed25519)
+func LoadPrivateKey(fileName string) (crypto.Signer, error) {
 	baseDir, _ := os.Getwd()
 	fullPath := path.Join(baseDir, "key/", fileName+".key")
 	data, err := os.ReadFile(fullPath)
@@ -46,16 +69,18 @@ func LoadPrivateKey(fileName string) (*rsa.PrivateKey, error) {
 	if err != nil {
 		return nil, err
 	}
-	switch priv := priv.(type) {
-	case *rsa.PrivateKey:
-		return priv, nil
-	default:
-		return nil, fmt.Errorf("la clé publicn'est pas de type RSA ")
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("type de clé privé non supporté")
+	}
+	if _, err := KeyAlgorithm(signer.Public()); err != nil {
+		return nil, err
 	}
+	return signer, nil
 }
 
-// lecture de clé public RSA
-func LoadPublicKey(fileName string) (*rsa.PublicKey, error) {
+// lecture de clé public PKIX (RSA, ECDSA P-256 ou Ed25519)
+func LoadPublicKey(fileName string) (crypto.PublicKey, error) {
 	baseDir, _ := os.Getwd()
 	fullPath := path.Join(baseDir, "key/", fileName+".key")
 	data, err := os.ReadFile(fullPath)
@@ -70,10 +95,40 @@ func LoadPublicKey(fileName string) (*rsa.PublicKey, error) {
 	if err != nil {
 		return nil, err
 	}
+	if _, err := KeyAlgorithm(pub); err != nil {
+		return nil, err
+	}
+	return pub, nil
+}
+
+// algorithme JWS correspondant au type de clé public
+func KeyAlgorithm(pub crypto.PublicKey) (string, error) {
 	switch pub := pub.(type) {
 	case *rsa.PublicKey:
-		return pub, nil
+		return "RS256", nil
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return "", fmt.Errorf("seule la courbe P-256 est supportée pour ECDSA")
+		}
+		return "ES256", nil
+	case ed25519.PublicKey:
+		return "EdDSA", nil
+	default:
+		return "", fmt.Errorf("type de clé non supporté: %T", pub)
+	}
+}
+
+// génération d'une clé de signature pour un algorithme JWS
+func GenerateSigningKey(alg string) (crypto.Signer, error) {
+	switch alg {
+	case "RS256":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "EdDSA":
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
 	default:
-		return nil, fmt.Errorf("la clé publicn'est pas de type RSA ")
+		return nil, fmt.Errorf("algorithme de signature non supporté: %s", alg)
 	}
 }
@@ -18,10 +18,15 @@ var Validate *validator.Validate
 var SliceValidation = map[string][]string{
 	"roles":           {"admin", "teacher", "student"},
 	"tableName":       {"user", "teacher_temp", "student_temps"},
-	"grantValid":      {"code", "token", "code token", "client_credentials", "password"},
+	"grantValid":      {"code", "token", "code token", "client_credentials", "password", "urn:ietf:params:oauth:grant-type:device_code", "urn:ietf:params:oauth:grant-type:token-exchange", "urn:openid:params:grant-type:ciba"},
 	"responsesValid":  {"code", "token", "code token", "implicit"},
+	"responseModes":   {"query", "fragment", "form_post", "query.jwt", "fragment.jwt", "form_post.jwt", "jwt"},
 	"nameAppValid":    {"web app", "mobil app", "desktop app"},
-	"authMethodValid": {"client_secret_basic", "client_secret_post", "none", "private_key_jwt"},
+	"authMethodValid": {"client_secret_basic", "client_secret_post", "none", "private_key_jwt", "tls_client_auth", "self_signed_tls_client_auth"},
+	"signingAlgValid": {"RS256", "ES256", "EdDSA"},
+	"tokenFormats":    {"jwt", "opaque"},
+	"deliveryModes":   {"poll", "ping"},
+	"subjectTypes":    {"public", "pairwise"},
 }
 
 // initialisation des tags du validateur V10
@@ -34,8 +39,13 @@ func init() {
 	Validate.RegisterValidation("grantallowed", ResponseValidator(SliceValidation["grantValid"]))
 	Validate.RegisterValidation("urlallowed", URLArrayValidator)
 	Validate.RegisterValidation("responseallowed", ResponseValidator(SliceValidation["responsesValid"]))
+	Validate.RegisterValidation("responsemodeallowed", ResponseValidator(SliceValidation["responseModes"]))
 	Validate.RegisterValidation("authmethodallowed", InSliceValidator(SliceValidation["authMethodValid"]))
 	Validate.RegisterValidation("appallowed", InSliceValidator(SliceValidation["nameAppValid"]))
+	Validate.RegisterValidation("signingalgallowed", InSliceValidator(SliceValidation["signingAlgValid"]))
+	Validate.RegisterValidation("tokenformatallowed", InSliceValidator(SliceValidation["tokenFormats"]))
+	Validate.RegisterValidation("deliverymodeallowed", InSliceValidator(SliceValidation["deliveryModes"]))
+	Validate.RegisterValidation("subjecttypeallowed", InSliceValidator(SliceValidation["subjectTypes"]))
 
 	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
 		v.RegisterValidation("password", PasswordValidator)
@@ -45,8 +55,13 @@ func init() {
 		v.RegisterValidation("urlallowed", URLArrayValidator)
 		v.RegisterValidation("tableName", InSliceValidator(SliceValidation["tableName"]))
 		v.RegisterValidation("responseallowed", ResponseValidator(SliceValidation["responsesValid"]))
+		v.RegisterValidation("responsemodeallowed", ResponseValidator(SliceValidation["responseModes"]))
 		v.RegisterValidation("authmethodallowed", InSliceValidator(SliceValidation["authMethodValid"]))
 		v.RegisterValidation("appallowed", InSliceValidator(SliceValidation["nameAppValid"]))
+		v.RegisterValidation("signingalgallowed", InSliceValidator(SliceValidation["signingAlgValid"]))
+		v.RegisterValidation("tokenformatallowed", InSliceValidator(SliceValidation["tokenFormats"]))
+		v.RegisterValidation("deliverymodeallowed", InSliceValidator(SliceValidation["deliveryModes"]))
+		v.RegisterValidation("subjecttypeallowed", InSliceValidator(SliceValidation["subjectTypes"]))
 	}
 }
 